@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hydradatabase/hydra/acceptance/shared"
+	"github.com/spf13/cobra"
+)
+
+// cleanupOptions are the flags of the cleanup command.
+type cleanupOptions struct {
+	RunID            string
+	ContainerRuntime string
+}
+
+func newCleanupCommand() *cobra.Command {
+	var opts cleanupOptions
+
+	cmd := &cobra.Command{
+		Use:     "cleanup",
+		Short:   "Remove the containers, networks and volumes of a run",
+		Example: `  hydra-acceptance cleanup --run-id cn3b8s2v0i9h6o1q2a7g`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cleanupRun(cmd.Context(), cmd.OutOrStdout(), opts)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&opts.RunID, "run-id", "", "ID of the run, as printed by run")
+	f.StringVar(&opts.ContainerRuntime, "container-runtime", "docker", "container runtime the run used, e.g. docker or podman")
+	_ = cmd.MarkFlagRequired("run-id")
+
+	return cmd
+}
+
+// cleanupRun removes the resources labelled with the run ID of opts, writing
+// what was removed to w.
+func cleanupRun(ctx context.Context, w io.Writer, opts cleanupOptions) error {
+	if opts.RunID == "" {
+		return errors.New("--run-id must not be empty")
+	}
+	if err := shared.ValidateRunID("--run-id", opts.RunID); err != nil {
+		return err
+	}
+
+	rt, err := shared.NewContainerRuntime(opts.ContainerRuntime)
+	if err != nil {
+		return fmt.Errorf("--container-runtime: %w", err)
+	}
+
+	resources, err := shared.FindResourcesOfRun(ctx, rt, opts.RunID)
+	if err != nil {
+		return err
+	}
+	if err := shared.RemoveResources(ctx, rt, resources); err != nil {
+		return err
+	}
+
+	for _, r := range resources {
+		fmt.Fprintf(w, "removed %s\n", r)
+	}
+	fmt.Fprintf(w, "removed %d resources of run %s\n", len(resources), opts.RunID)
+
+	return nil
+}
@@ -0,0 +1,57 @@
+// Command hydra-acceptance runs the acceptance suites against an image without
+// knowing the layout of the go tests, e.g.
+//
+//	hydra-acceptance run --image ghcr.io/hydradatabase/hydra:pr-123 --suite columnar
+//
+// It runs go test in the acceptance module, so it must be run from a checkout
+// of the repository with go installed. The output of the tests and the logs of
+// the containers are saved to the artifact directory. The exit code is 0 if
+// the suite passed, 1 if it failed and 2 if it could not be run.
+//
+// With --retries, the tests of failed cases are rerun, and the suite passes
+// if every failed case passes on a retry. The cases are listed as flaky or
+// failed in <suite>-flakes.json in the artifact directory.
+//
+// The containers, networks and volumes of a run are labelled with its run ID,
+// which is printed when the run starts, so that they can be removed with
+// cleanup if the run was interrupted.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of the command.
+const (
+	exitPassed = 0
+	exitFailed = 1
+	exitError  = 2
+)
+
+func main() {
+	root := &cobra.Command{
+		Use:           "hydra-acceptance",
+		Short:         "Run the Hydra acceptance suites against an image",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	root.AddCommand(newRunCommand(), newSuitesCommand(), newCleanupCommand())
+
+	err := root.Execute()
+	if err == nil {
+		os.Exit(exitPassed)
+	}
+
+	var failed *suiteFailedError
+	if errors.As(err, &failed) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitFailed)
+	}
+
+	fmt.Fprintf(os.Stderr, "hydra-acceptance: %s\n", err)
+	os.Exit(exitError)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Statuses of a case in a flake report.
+const (
+	statusFlaky  = "flaky"  // failed, then passed when retried
+	statusFailed = "failed" // failed on every attempt
+)
+
+// A flakeReport lists the cases of a suite that failed and whether they passed
+// when retried, so that CI can tell regressions from infrastructure noise.
+type flakeReport struct {
+	Suite   string       `json:"suite"`
+	Image   string       `json:"image"`
+	RunID   string       `json:"run_id"`
+	Retries int          `json:"retries"`
+	Cases   []flakeEntry `json:"cases"`
+}
+
+// A flakeEntry is a case of a flakeReport, named by the path of its subtest.
+type flakeEntry struct {
+	Name     string   `json:"name"`
+	Status   string   `json:"status"`
+	Attempts int      `json:"attempts"` // attempts until it passed, or every attempt if it failed
+	Logs     []string `json:"logs"`     // test logs of the attempts
+}
+
+// testResult matches the result line of a test in the output of go test -v.
+var testResult = regexp.MustCompile(`(?m)^\s*--- (PASS|FAIL): (\S+) \(`)
+
+// retrySuite reruns the tests of the cases that failed in the output of the
+// first run of t up to opts.Retries times, and writes a flake report to the
+// artifact directory. Failing cases are rerun with their top-level test, as a
+// case may depend on the cases and setup before it. It returns failed if any
+// case failed on every attempt, or nil if every failed case is flaky.
+func retrySuite(w io.Writer, t goTest, opts runOptions, artifactDir string, out []byte, failed *suiteFailedError) error {
+	pending := failedCases(out)
+	if len(pending) == 0 {
+		// nothing to retry, e.g. the package did not build
+		return failed
+	}
+
+	report := flakeReport{Suite: opts.Suite, Image: opts.Image, RunID: opts.RunID, Retries: opts.Retries}
+	logs := map[string][]string{}
+	for _, name := range pending {
+		logs[name] = []string{failed.Log}
+	}
+
+	for attempt := 1; attempt <= opts.Retries && len(pending) > 0; attempt++ {
+		fmt.Fprintf(w, "retrying %d failed cases, attempt %d of %d\n", len(pending), attempt, opts.Retries)
+
+		logPath := filepath.Join(artifactDir, fmt.Sprintf("%s.retry-%d.log", opts.Suite, attempt))
+		out, err := t.run(w, logPath, topLevelPattern(pending))
+		if err != nil && out == nil {
+			return err
+		}
+
+		passed := passedTests(out)
+		var next []string
+		for _, name := range pending {
+			logs[name] = append(logs[name], logPath)
+			if !passed[name] {
+				next = append(next, name)
+				continue
+			}
+			report.Cases = append(report.Cases, flakeEntry{Name: name, Status: statusFlaky, Attempts: attempt + 1, Logs: logs[name]})
+		}
+		pending = next
+	}
+
+	for _, name := range pending {
+		report.Cases = append(report.Cases, flakeEntry{Name: name, Status: statusFailed, Attempts: opts.Retries + 1, Logs: logs[name]})
+	}
+	sort.Slice(report.Cases, func(i, j int) bool {
+		return report.Cases[i].Name < report.Cases[j].Name
+	})
+
+	reportPath := filepath.Join(artifactDir, opts.Suite+"-flakes.json")
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode the flake report: %w", err)
+	}
+	if err := os.WriteFile(reportPath, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("unable to write the flake report: %w", err)
+	}
+
+	if len(pending) > 0 {
+		return failed
+	}
+	fmt.Fprintf(w, "suite %s passed with %d flaky cases, see %s\n", opts.Suite, len(report.Cases), reportPath)
+
+	return nil
+}
+
+// failedCases returns the names of the failed tests in out that have no failed
+// subtest, which are the failed cases, or the failed tests that did not get to
+// run their cases.
+func failedCases(out []byte) []string {
+	var failed []string
+	for _, m := range testResult.FindAllSubmatch(out, -1) {
+		if string(m[1]) == "FAIL" {
+			failed = append(failed, string(m[2]))
+		}
+	}
+
+	var cases []string
+	for _, name := range failed {
+		leaf := true
+		for _, other := range failed {
+			if strings.HasPrefix(other, name+"/") {
+				leaf = false
+				break
+			}
+		}
+		if leaf {
+			cases = append(cases, name)
+		}
+	}
+
+	return cases
+}
+
+// passedTests returns the names of the passed tests in out.
+func passedTests(out []byte) map[string]bool {
+	passed := map[string]bool{}
+	for _, m := range testResult.FindAllSubmatch(out, -1) {
+		if string(m[1]) == "PASS" {
+			passed[string(m[2])] = true
+		}
+	}
+
+	return passed
+}
+
+// topLevelPattern returns the go test -run pattern of the top-level tests of
+// the subtests called names.
+func topLevelPattern(names []string) string {
+	seen := map[string]bool{}
+	var tests []string
+	for _, name := range names {
+		test, _, _ := strings.Cut(name, "/")
+		if !seen[test] {
+			seen[test] = true
+			tests = append(tests, regexp.QuoteMeta(test))
+		}
+	}
+
+	return "^(" + strings.Join(tests, "|") + ")$"
+}
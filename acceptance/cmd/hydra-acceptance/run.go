@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hydradatabase/hydra/acceptance/shared"
+	"github.com/rs/xid"
+	"github.com/spf13/cobra"
+)
+
+// A suiteFailedError is returned when go test ran the suite and it failed.
+type suiteFailedError struct {
+	Suite    string
+	ExitCode int
+	Log      string
+}
+
+func (e *suiteFailedError) Error() string {
+	return fmt.Sprintf("suite %s failed with exit code %d, see %s", e.Suite, e.ExitCode, e.Log)
+}
+
+// runOptions are the flags of the run command.
+type runOptions struct {
+	Image            string
+	UpgradeFromImage string
+	PostgresVersion  string
+	Suite            string
+	Tags             []string
+	Only             []string
+	Skip             []string
+	ArtifactDir      string
+	ModuleDir        string
+	Run              string
+	Timeout          time.Duration
+	Seed             int64
+	DryRun           bool
+	LogLevel         string
+	Retries          int
+	RunID            string
+}
+
+func newRunCommand() *cobra.Command {
+	var opts runOptions
+
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Run a suite against an image",
+		Example: `  hydra-acceptance run --image ghcr.io/hydradatabase/hydra:pr-123 --suite columnar
+  hydra-acceptance run --image hydra:dev --suite postgres --tags '!destructive'`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSuite(cmd.OutOrStdout(), opts)
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&opts.Image, "image", "", "image under test, e.g. ghcr.io/hydradatabase/hydra:pr-123")
+	f.StringVar(&opts.UpgradeFromImage, "upgrade-from-image", "", "image to upgrade from in the upgrade tests, the image under test if empty")
+	f.StringVar(&opts.PostgresVersion, "postgres-version", "14", "expected major version of Postgres in the image")
+	f.StringVar(&opts.Suite, "suite", "postgres", "suite to run, one of "+strings.Join(suiteNames(), ", "))
+	f.StringSliceVar(&opts.Tags, "tags", nil, "additional expressions of the tag filter, e.g. smoke or !slow")
+	f.StringArrayVar(&opts.Only, "only", nil, "run only the shared case with this name, optionally followed by @ and a Postgres version")
+	f.StringArrayVar(&opts.Skip, "skip", nil, "skip the shared case with this name, optionally followed by @ and a Postgres version, e.g. partition@16")
+	f.StringVar(&opts.ArtifactDir, "artifact-dir", "hydra-acceptance-artifacts", "directory to save the test output and container logs to")
+	f.StringVar(&opts.ModuleDir, "module-dir", "", "directory of the acceptance module, found from the working directory if empty")
+	f.StringVar(&opts.Run, "run", "", "run only the tests matching the regular expression, as go test -run, instead of the tests of the suite")
+	f.DurationVar(&opts.Timeout, "timeout", 60*time.Minute, "timeout of the whole suite")
+	f.StringVar(&opts.LogLevel, "log-level", "info", "level of the harness log, debug also logs every docker command and SQL statement")
+	f.BoolVar(&opts.DryRun, "dry-run", false, "log the commands, compose files and SQL the suite would run instead of running them")
+	f.Int64Var(&opts.Seed, "seed", 0, "seed of the random data to reproduce a run, taken from the clock if 0")
+	f.StringVar(&opts.RunID, "run-id", "", "ID to label the containers, networks and volumes of the run with, generated if empty")
+	f.IntVar(&opts.Retries, "retries", 0, "rerun the tests of failed cases up to this many times, passing with a flake report if they pass")
+	_ = cmd.MarkFlagRequired("image")
+
+	return cmd
+}
+
+// runSuite runs the suite of opts with go test, writing its output to w and
+// to a log in the artifact directory.
+func runSuite(w io.Writer, opts runOptions) error {
+	s, err := lookupSuite(opts.Suite)
+	if err != nil {
+		return err
+	}
+
+	if err := shared.ValidateImage("--image", opts.Image); err != nil {
+		return err
+	}
+	if opts.UpgradeFromImage == "" {
+		opts.UpgradeFromImage = opts.Image
+	}
+	if err := shared.ValidateImage("--upgrade-from-image", opts.UpgradeFromImage); err != nil {
+		return err
+	}
+
+	tags := append(append([]string(nil), s.Tags...), opts.Tags...)
+	if _, err := shared.ParseTagFilter(tags); err != nil {
+		return fmt.Errorf("--tags: %w", err)
+	}
+
+	if _, err := shared.ParseCaseSelection(opts.Only, opts.Skip); err != nil {
+		return fmt.Errorf("--only or --skip: %w", err)
+	}
+	if _, err := shared.ParseLogLevel(opts.LogLevel); err != nil {
+		return fmt.Errorf("--log-level: %w", err)
+	}
+	if err := shared.ValidateRunID("--run-id", opts.RunID); err != nil {
+		return err
+	}
+	if opts.RunID == "" {
+		opts.RunID = xid.New().String()
+	}
+	if opts.Retries < 0 {
+		return fmt.Errorf("--retries must not be negative, got %d", opts.Retries)
+	}
+
+	moduleDir := opts.ModuleDir
+	if moduleDir == "" {
+		if moduleDir, err = findModuleDir(); err != nil {
+			return err
+		}
+	}
+
+	// the tests require an absolute artifact directory
+	artifactDir, err := filepath.Abs(opts.ArtifactDir)
+	if err != nil {
+		return fmt.Errorf("unable to resolve the artifact directory: %w", err)
+	}
+	if err := os.MkdirAll(artifactDir, 0o755); err != nil {
+		return fmt.Errorf("unable to create the artifact directory: %w", err)
+	}
+
+	env := map[string]string{
+		"ARTIFACT_DIR": artifactDir,
+		s.ImageEnv:     opts.Image,
+		s.UpgradeEnv:   opts.UpgradeFromImage,
+		"CASE_TAGS":    strings.Join(tags, ";"),
+		"LOG_LEVEL":    opts.LogLevel,
+		"ONLY_CASES":   strings.Join(opts.Only, ";"),
+		"SKIP_CASES":   strings.Join(opts.Skip, ";"),
+		"RUN_ID":       opts.RunID,
+	}
+	if opts.DryRun {
+		env["DRY_RUN"] = "true"
+	}
+	if opts.Seed != 0 {
+		env["SEED"] = strconv.FormatInt(opts.Seed, 10)
+	}
+	if s.VersionEnv != "" {
+		env[s.VersionEnv] = opts.PostgresVersion
+	}
+
+	t := goTest{
+		Dir:     moduleDir,
+		Package: s.Package,
+		Timeout: opts.Timeout,
+		Env:     env,
+	}
+
+	fmt.Fprintf(w, "running suite %s against %s as run %s, artifacts in %s\n", opts.Suite, opts.Image, opts.RunID, artifactDir)
+
+	if opts.Run == "" {
+		opts.Run = s.Run
+	}
+
+	logPath := filepath.Join(artifactDir, opts.Suite+".log")
+	out, err := t.run(w, logPath, opts.Run)
+	var failed *suiteFailedError
+	if errors.As(err, &failed) {
+		failed.Suite = opts.Suite
+		if opts.Retries > 0 {
+			return retrySuite(w, t, opts, artifactDir, out, failed)
+		}
+	}
+
+	return err
+}
+
+// goTest runs the tests of a package with go test.
+type goTest struct {
+	Dir     string
+	Package string
+	Timeout time.Duration
+	Env     map[string]string
+}
+
+// run runs the tests matching the regular expression pattern, or every test
+// if empty, writing the output to w and to the log at logPath. It returns the
+// output, and a suiteFailedError if the tests failed.
+func (t goTest) run(w io.Writer, logPath, pattern string) ([]byte, error) {
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the test log: %w", err)
+	}
+	defer logFile.Close()
+
+	args := []string{"test", t.Package, "-count=1", "-v", "-timeout", t.Timeout.String()}
+	if pattern != "" {
+		args = append(args, "-run", pattern)
+	}
+
+	cmd := exec.Command("go", args...)
+	cmd.Dir = t.Dir
+	cmd.Env = os.Environ()
+	for k, v := range t.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	var buf bytes.Buffer
+	out := io.MultiWriter(w, logFile, &buf)
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	var exitErr *exec.ExitError
+	if err := cmd.Run(); errors.As(err, &exitErr) {
+		return buf.Bytes(), &suiteFailedError{ExitCode: exitErr.ExitCode(), Log: logPath}
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to run go test: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// findModuleDir returns the directory of the acceptance module, looking for it
+// from the working directory up to the root of the repository.
+func findModuleDir() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		for _, candidate := range []string{dir, filepath.Join(dir, "acceptance")} {
+			b, err := os.ReadFile(filepath.Join(candidate, "go.mod"))
+			if err == nil && strings.HasPrefix(string(b), "module github.com/hydradatabase/hydra/acceptance\n") {
+				return candidate, nil
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("unable to find the acceptance module, run from a checkout of the repository or set --module-dir")
+		}
+		dir = parent
+	}
+}
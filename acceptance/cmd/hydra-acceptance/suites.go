@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hydradatabase/hydra/acceptance/shared"
+	"github.com/spf13/cobra"
+)
+
+// A suite is a package of acceptance tests, optionally restricted to some of
+// its tests and to the shared cases with some tags.
+type suite struct {
+	Description string
+	Package     string   // package of the tests relative to the acceptance module
+	ImageEnv    string   // environment variable of the image under test
+	UpgradeEnv  string   // environment variable of the image to upgrade from
+	VersionEnv  string   // environment variable of the expected major version of Postgres
+	Run         string   // regular expression of the tests to run, as go test -run, every test if empty
+	Tags        []string // expressions of the tag filter, see shared.ParseTagFilter
+}
+
+// suites are the suites that can be run by name.
+var suites = map[string]suite{
+	"postgres": {
+		Description: "every test of the postgres image",
+		Package:     "./postgres/...",
+		ImageEnv:    "POSTGRES_IMAGE",
+		UpgradeEnv:  "POSTGRES_UPGRADE_FROM_IMAGE",
+		VersionEnv:  "EXPECTED_POSTGRES_VERSION",
+	},
+	"smoke": {
+		Description: "the smoke cases of the acceptance test of the postgres image",
+		Package:     "./postgres/...",
+		ImageEnv:    "POSTGRES_IMAGE",
+		UpgradeEnv:  "POSTGRES_UPGRADE_FROM_IMAGE",
+		VersionEnv:  "EXPECTED_POSTGRES_VERSION",
+		Run:         "^Test_PostgresAcceptance$",
+		Tags:        []string{shared.TagSmoke},
+	},
+	"columnar": {
+		Description: "the columnar cases of the postgres image",
+		Package:     "./postgres/...",
+		ImageEnv:    "POSTGRES_IMAGE",
+		UpgradeEnv:  "POSTGRES_UPGRADE_FROM_IMAGE",
+		VersionEnv:  "EXPECTED_POSTGRES_VERSION",
+		Tags:        []string{shared.TagColumnar},
+	},
+	"spilo": {
+		Description: "every test of the spilo image",
+		Package:     "./spilo/...",
+		ImageEnv:    "SPILO_IMAGE",
+		UpgradeEnv:  "SPILO_UPGRADE_FROM_IMAGE",
+		VersionEnv:  "SPILO_POSTGRES_VERSION",
+	},
+	"kind": {
+		Description: "the postgres image deployed to a kind cluster",
+		Package:     "./kind/...",
+		ImageEnv:    "POSTGRES_IMAGE",
+		UpgradeEnv:  "POSTGRES_UPGRADE_FROM_IMAGE",
+	},
+}
+
+// suiteNames returns the names of the suites in order.
+func suiteNames() []string {
+	names := make([]string, 0, len(suites))
+	for name := range suites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// lookupSuite returns the suite name.
+func lookupSuite(name string) (suite, error) {
+	s, ok := suites[name]
+	if !ok {
+		return suite{}, fmt.Errorf("unknown suite %q, expected one of %s", name, strings.Join(suiteNames(), ", "))
+	}
+
+	return s, nil
+}
+
+func newSuitesCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "suites",
+		Short: "List the suites that can be run",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			for _, name := range suiteNames() {
+				fmt.Fprintf(cmd.OutOrStdout(), "%-10s %s\n", name, suites[name].Description)
+			}
+		},
+	}
+}
@@ -0,0 +1,502 @@
+package kind_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/hydradatabase/hydra/acceptance/shared"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type manifestData struct {
+	Namespace        string
+	RunID            string
+	Image            string
+	PostgresUser     string
+	PostgresPassword string
+	StartEverything  bool
+	MySQLFixture     string // fixtures/mysql.sql, mounted into the mysql pod if StartEverything
+}
+
+var (
+	manifestTmpl = template.Must(template.New("manifest.yml").Funcs(template.FuncMap{"indent": indent}).Parse(`
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: {{ .Namespace }}
+  labels:
+    io.hydra.acceptance.run-id: "{{ .RunID }}"
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: hydra
+  namespace: {{ .Namespace }}
+spec:
+  selector:
+    app: hydra
+  ports:
+    - port: 5432
+      targetPort: 5432
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: hydra
+  namespace: {{ .Namespace }}
+spec:
+  serviceName: hydra
+  replicas: 1
+  selector:
+    matchLabels:
+      app: hydra
+  template:
+    metadata:
+      labels:
+        app: hydra
+    spec:
+      containers:
+        - name: hydra
+          image: {{ .Image }}
+          imagePullPolicy: IfNotPresent
+          env:
+            - name: POSTGRES_USER
+              value: {{ .PostgresUser }}
+            - name: POSTGRES_PASSWORD
+              value: {{ .PostgresPassword }}
+            - name: PGDATA
+              value: /var/lib/postgresql/data/pgdata
+          ports:
+            - containerPort: 5432
+          readinessProbe:
+            exec:
+              command: ["pg_isready", "-h", "127.0.0.1", "-U", "{{ .PostgresUser }}"]
+            periodSeconds: 2
+          volumeMounts:
+            - name: pg-data
+              mountPath: /var/lib/postgresql/data
+  volumeClaimTemplates:
+    - metadata:
+        name: pg-data
+      spec:
+        accessModes: ["ReadWriteOnce"]
+        resources:
+          requests:
+            storage: 1Gi
+{{- if .StartEverything }}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: mysql-fixtures
+  namespace: {{ .Namespace }}
+data:
+  mysql.sql: |
+{{ indent 4 .MySQLFixture }}
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: mysql
+  namespace: {{ .Namespace }}
+spec:
+  selector:
+    app: mysql
+  ports:
+    - port: 3306
+      targetPort: 3306
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: mysql
+  namespace: {{ .Namespace }}
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app: mysql
+  template:
+    metadata:
+      labels:
+        app: mysql
+    spec:
+      containers:
+        - name: mysql
+          image: mysql:8.0.31
+          env:
+            - name: MYSQL_USER
+              value: mysql
+            - name: MYSQL_PASSWORD
+              value: mysql
+            - name: MYSQL_ROOT_PASSWORD
+              value: mysql
+          ports:
+            - containerPort: 3306
+          readinessProbe:
+            exec:
+              command: ["mysqladmin", "ping", "-h", "localhost"]
+            periodSeconds: 5
+          volumeMounts:
+            - name: fixtures
+              mountPath: /docker-entrypoint-initdb.d
+      volumes:
+        - name: fixtures
+          configMap:
+            name: mysql-fixtures
+{{- end }}
+`))
+)
+
+// indent indents every line of s by n spaces, e.g. to embed a file in a
+// literal block of the manifest.
+func indent(n int, s string) string {
+	prefix := strings.Repeat(" ", n)
+	return prefix + strings.ReplaceAll(strings.TrimRight(s, "\n"), "\n", "\n"+prefix)
+}
+
+type Config struct {
+	shared.EnvConfig
+
+	Image                string        `env:"POSTGRES_IMAGE,required"`
+	UpgradeFromImage     string        `env:"POSTGRES_UPGRADE_FROM_IMAGE,required"`
+	WaitForStartTimeout  time.Duration `env:"WAIT_FOR_START_TIMEOUT,default=120s"`
+	WaitForStartInterval time.Duration `env:"WAIT_FOR_START_INTERVAL,default=2s"`
+	KindCluster          string        `env:"KIND_CLUSTER,default=hydra-acceptance"`
+	KindLoadImages       bool          `env:"KIND_LOAD_IMAGES,default=true"`
+}
+
+// Validate reports every setting of c that is invalid.
+func (c Config) Validate() error {
+	return errors.Join(
+		c.EnvConfig.Validate(),
+		shared.ValidateImage("POSTGRES_IMAGE", c.Image),
+		shared.ValidateImage("POSTGRES_UPGRADE_FROM_IMAGE", c.UpgradeFromImage),
+		shared.ValidateTimeout("WAIT_FOR_START_TIMEOUT", c.WaitForStartTimeout),
+		shared.ValidateTimeout("WAIT_FOR_START_INTERVAL", c.WaitForStartInterval),
+	)
+}
+
+// with returns c with the images of sc, if set.
+func (c Config) with(sc shared.SuiteConfig) Config {
+	if sc.Image != "" {
+		c.Image = sc.Image
+	}
+	if sc.UpgradeFromImage != "" {
+		c.UpgradeFromImage = sc.UpgradeFromImage
+	}
+
+	return c
+}
+
+var (
+	config    Config
+	suiteFile *shared.SuiteFile
+)
+
+const (
+	pgusername = "hydra"
+	pgpassword = "hydra"
+)
+
+func TestMain(m *testing.M) {
+	if err := shared.ConfigFromEnv(&config); err != nil {
+		log.Fatal(err)
+	}
+
+	level, err := shared.ParseLogLevel(config.LogLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	shared.SetLogLevel(level)
+
+	modes, err := shared.ParseQueryExecModes(config.QueryExecModes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	shared.QueryExecModes = modes
+
+	tags, err := shared.ParseTagFilter(config.CaseTags)
+	if err != nil {
+		log.Fatal(err)
+	}
+	shared.CaseTagFilter = tags
+
+	selected, err := shared.ParseCaseSelection(config.OnlyCases, config.SkipCases)
+	if err != nil {
+		log.Fatal(err)
+	}
+	shared.SelectedCases = selected
+
+	shared.InitSeed(config.Seed)
+	shared.InitRunID(config.RunID)
+	shared.InitRunContext(config.RunTimeout)
+	shared.SetTimeouts(shared.Timeouts{
+		Startup:  config.WaitForStartTimeout,
+		Connect:  config.ConnectTimeout,
+		Query:    config.QueryTimeout,
+		LogFetch: config.LogFetchTimeout,
+	})
+
+	if config.SuiteFile != "" {
+		if suiteFile, err = shared.LoadSuiteFile(config.SuiteFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	shared.DryRun = config.DryRun
+
+	os.Exit(m.Run())
+}
+
+// kindAcceptanceCluster implements [shared.DockerComposeManager] by deploying
+// Hydra as a StatefulSet into a kind cluster and port-forwarding its Service.
+type kindAcceptanceCluster struct {
+	config   Config
+	settings map[string]string
+
+	namespace   string
+	port        int
+	portForward *exec.Cmd
+	pool        *pgxpool.Pool
+	appPool     *pgxpool.Pool
+}
+
+func (c *kindAcceptanceCluster) kubectl(ctx context.Context, args ...string) ([]byte, error) {
+	args = append([]string{"--context", "kind-" + c.config.KindCluster, "--namespace", c.namespace}, args...)
+	shared.LogCommand("kubectl", args...)
+	output, err := exec.CommandContext(ctx, "kubectl", args...).CombinedOutput()
+	if err != nil {
+		return output, fmt.Errorf("kubectl %s: %w: %s", args[4], err, output)
+	}
+
+	return output, nil
+}
+
+func (c *kindAcceptanceCluster) StartCompose(t *testing.T, ctx context.Context, img string, startEverything bool) {
+	// Only set the namespace and port on first start
+	if c.namespace == "" {
+		c.namespace = shared.UniqueName(t, "kind")
+		c.port = c.config.PostgresPort
+		if c.port == 0 {
+			c.port = shared.FreePort(t)
+		}
+	}
+
+	if c.config.KindLoadImages && !shared.DryRun {
+		args := []string{"load", "docker-image", img, "--name", c.config.KindCluster}
+		shared.LogCommand("kind", args...)
+		if o, err := exec.CommandContext(ctx, "kind", args...).CombinedOutput(); err != nil {
+			t.Fatalf("unable to load %s into kind: %s: %s", img, err, o)
+		}
+	}
+
+	data := manifestData{
+		Namespace:        c.namespace,
+		RunID:            shared.RunID,
+		Image:            img,
+		PostgresUser:     pgusername,
+		PostgresPassword: pgpassword,
+		StartEverything:  startEverything,
+	}
+
+	// the fixtures are part of the manifest, so that applying it again on a
+	// restart leaves them unchanged
+	if startEverything {
+		pwd, err := os.Getwd()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fixture, err := os.ReadFile(filepath.Join(pwd, "..", "fixtures", "mysql.sql"))
+		if err != nil {
+			t.Fatalf("unable to read mysql fixtures: %s", err)
+		}
+		data.MySQLFixture = string(fixture)
+	}
+
+	manifest := bytes.NewBuffer(nil)
+	if err := manifestTmpl.Execute(manifest, data); err != nil {
+		t.Fatal(err)
+	}
+
+	// ArtifactDir may be empty, in which case the system tmp directory is used
+	f, err := os.CreateTemp(shared.TestArtifactDir(t, string(c.config.ArtifactDir)), "manifest.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.WriteString(manifest.String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if shared.DryRun {
+		var commands []string
+		if c.config.KindLoadImages {
+			commands = append(commands, fmt.Sprintf("kind load docker-image %s --name %s", img, c.config.KindCluster))
+		}
+		commands = append(commands, fmt.Sprintf("kubectl --context kind-%s --namespace %s apply --filename %s", c.config.KindCluster, c.namespace, f.Name()))
+		shared.SkipDryRun(t, f.Name(), commands...)
+	}
+
+	t.Logf("Deploying %s to kind cluster %s with %s", c.namespace, c.config.KindCluster, f.Name())
+	if _, err := c.kubectl(ctx, "apply", "--filename", f.Name()); err != nil {
+		t.Fatalf("unable to deploy to kind: %s", err)
+	}
+
+	if startEverything {
+		if _, err := c.kubectl(ctx, "rollout", "status", "deployment/mysql", "--timeout", shared.TimeoutsFor(t).Startup.String()); err != nil {
+			t.Fatalf("mysql did not become ready: %s", err)
+		}
+	}
+
+	if _, err := c.kubectl(ctx, "rollout", "status", "statefulset/hydra", "--timeout", shared.TimeoutsFor(t).Startup.String()); err != nil {
+		t.Fatalf("hydra did not become ready: %s", err)
+	}
+
+	c.startPortForward(t)
+	c.WaitForContainerReady(t, ctx)
+}
+
+// startPortForward forwards the local port to the hydra Service. The
+// port-forward lives until TerminateCompose so it is not bound to ctx.
+func (c *kindAcceptanceCluster) startPortForward(t *testing.T) {
+	args := []string{
+		"--context", "kind-" + c.config.KindCluster, "--namespace", c.namespace,
+		"port-forward", "service/hydra", fmt.Sprintf("%d:5432", c.port),
+	}
+	shared.LogCommand("kubectl", args...)
+	c.portForward = exec.Command("kubectl", args...)
+	if err := c.portForward.Start(); err != nil {
+		t.Fatalf("unable to port-forward to hydra: %s", err)
+	}
+}
+
+func (c *kindAcceptanceCluster) stopPortForward() {
+	if c.portForward == nil {
+		return
+	}
+
+	_ = c.portForward.Process.Kill()
+	_ = c.portForward.Wait()
+	c.portForward = nil
+}
+
+func (c *kindAcceptanceCluster) WaitForContainerReady(t *testing.T, ctx context.Context) {
+	pool, err := shared.CreatePGPool(t, ctx, c.ConnSpec().With(shared.WithRetry(shared.RetryPolicy{
+		Deadline:   shared.TimeoutsFor(t).Startup,
+		MaxBackoff: c.config.WaitForStartInterval,
+	})))
+	if err != nil {
+		t.Fatalf("timed out waiting for pod to start after %s: %s", shared.TimeoutsFor(t).Startup, err)
+	}
+
+	c.pool = shared.PoolWithGUCs(t, ctx, pool, c.settings)
+	c.appPool = shared.CreateAppPool(t, ctx, pool, c.ConnSpec())
+}
+
+// TerminateCompose saves the hydra pod logs below the ArtifactDir and removes the
+// StatefulSet. The PersistentVolumeClaim is kept so that the next start reuses
+// the data unless kill is true, in which case the whole namespace is deleted.
+// Like [shared.ComposeStack.Down], it runs even if ctx is done, e.g. during the
+// cleanup of the test, with every step bounded by the [shared.Timeouts] of t,
+// and a failing step fails the test without skipping the remaining ones.
+func (c *kindAcceptanceCluster) TerminateCompose(t *testing.T, ctx context.Context, kill bool) {
+	// nothing is deployed in a dry run
+	if c.namespace == "" || shared.DryRun {
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	timeouts := shared.TimeoutsFor(t)
+
+	c.stopPortForward()
+
+	if dir := shared.ContainerArtifactDir(t, string(c.config.ArtifactDir), "hydra"); dir != "" {
+		logCtx, cancel := context.WithTimeout(ctx, timeouts.LogFetch)
+		defer cancel()
+
+		if logOutput, err := c.kubectl(logCtx, "logs", "statefulset/hydra"); err != nil {
+			t.Errorf("unable to fetch kind log: %s", err)
+		} else if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("logs-%s.log", time.Now().Format(time.RFC3339))), logOutput, 0644); err != nil {
+			t.Errorf("unable to write kind log: %s", err)
+		}
+	}
+
+	// deleting waits for the pod to stop and then for its volumes to be removed
+	ctx, cancel := context.WithTimeout(ctx, 2*timeouts.Shutdown)
+	defer cancel()
+
+	if kill {
+		if _, err := c.kubectl(ctx, "delete", "namespace", c.namespace, "--wait"); err != nil {
+			t.Errorf("unable to delete kind namespace: %s", err)
+		}
+
+		return
+	}
+
+	if _, err := c.kubectl(ctx, "delete", "statefulset", "hydra", "--wait"); err != nil {
+		t.Errorf("unable to delete hydra statefulset: %s", err)
+	}
+}
+
+func (c kindAcceptanceCluster) Image() string {
+	return c.config.Image
+}
+
+func (c kindAcceptanceCluster) UpgradeFromImage() string {
+	return c.config.UpgradeFromImage
+}
+
+func (c kindAcceptanceCluster) PGPool() *pgxpool.Pool {
+	return c.pool
+}
+
+func (c kindAcceptanceCluster) AppPool() *pgxpool.Pool {
+	return c.appPool
+}
+
+// ConnSpec connects through the port-forward, which listens on localhost.
+func (c kindAcceptanceCluster) ConnSpec() shared.ConnSpec {
+	return shared.NewConnSpec(pgusername, pgpassword, c.port, shared.WithHost("localhost"))
+}
+
+func Test_KindAcceptance(t *testing.T) {
+	shared.RunConfigurations(t, suiteFile, "kind", func(t *testing.T, sc shared.SuiteConfig) {
+		cfg := config.with(sc)
+
+		shared.RunAcceptanceTests(
+			t,
+			shared.Context(t),
+			&kindAcceptanceCluster{config: cfg, settings: sc.Settings},
+		)
+	})
+}
+
+func Test_KindUpgrade(t *testing.T) {
+	shared.SkipUnlessTagged(t, shared.TagColumnar, shared.TagDestructive)
+
+	shared.RunConfigurations(t, suiteFile, "kind", func(t *testing.T, sc shared.SuiteConfig) {
+		cfg := config.with(sc)
+
+		c := kindAcceptanceCluster{
+			config:   cfg,
+			settings: sc.Settings,
+		}
+
+		shared.RunUpgradeTests(t, shared.Context(t), &c)
+	})
+}
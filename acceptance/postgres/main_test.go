@@ -6,52 +6,79 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math/rand"
+	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
-	"text/template"
 	"time"
 
 	"github.com/hydradatabase/hydra/acceptance/shared"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/joeshaw/envdecode"
-	"github.com/rs/xid"
 )
 
 type dockerComposeData struct {
 	Image               string
 	PostgresUser        string
-	PostgresPassword    string
 	PostgresPort        int
 	StartEverything     bool
 	MySQLFixtureSQLPath string
+	Labels              map[string]string
+	ResourceLabels      map[string]string
+	Environment         map[string]string
+	Options             shared.ContainerOptions
+	ConfDir             string
 }
 
 var (
-	dockerComposeTmpl = template.Must(template.New("docker-compose.yml").Parse(`
+	dockerComposeTmpl = shared.NewComposeTemplate("docker-compose.yml", `
 version: "3.9"
 services:
   hydra:
     image: {{ .Image }}
+    {{- template "labels" .Labels }}
+    {{- template "containerOptions" .Options }}
     {{- if .StartEverything }}
     depends_on:
       mysql:
         condition: service_healthy
     {{- end }}
-    environment:
-      POSTGRES_USER: {{ .PostgresUser }}
-      POSTGRES_PASSWORD: {{ .PostgresPassword }}
-      PGDATA: /var/lib/postgresql/data/pgdata
+    {{- template "environment" .Environment }}
+    {{- if not .Options.SocketDir }}
     ports:
       - "{{ .PostgresPort }}:5432"
+    {{- end }}
+    {{- if .Options.DataTmpfs }}
+    tmpfs:
+      - /var/lib/postgresql/data/pgdata:size={{ .Options.DataTmpfs }}
+    {{- end }}
+    {{- if or (not .Options.DataTmpfs) .Options.SocketDir .ConfDir }}
     volumes:
+      {{- if not .Options.DataTmpfs }}
       - pg_data:/var/lib/postgresql/data/pgdata
+      {{- end }}
+      {{- with .Options.SocketDir }}
+      - {{ . }}:/var/run/postgresql
+      {{- end }}
+      {{- with .ConfDir }}
+      - {{ . }}:{{ confFragmentDir }}:ro
+      - {{ confIncludeScript . }}:/docker-entrypoint-initdb.d/hydra-acceptance.sh:ro
+      {{- end }}
+    {{- end }}
+    healthcheck:
+      # the temporary server used during initdb only listens on the socket, so
+      # check over TCP to avoid reporting healthy before the real server starts
+      test: ["CMD", "pg_isready", "-h", "127.0.0.1", "-U", "{{ .PostgresUser }}"]
+      interval: 2s
+      timeout: 5s
+      retries: 15
 {{- if .StartEverything }}
   mysql:
     image: mysql:8.0.31
+    {{- template "labels" .ResourceLabels }}
     environment:
       MYSQL_USER: mysql
       MYSQL_PASSWORD: mysql
@@ -61,7 +88,7 @@ services:
       - {{ .MySQLFixtureSQLPath }}:/docker-entrypoint-initdb.d/mysql.sql
     {{- end}}
     ports:
-      - "3306:3306"
+      - "3306"
     healthcheck:
       test: ["CMD", "mysqladmin" ,"ping", "-h", "localhost"]
       timeout: 10s
@@ -69,47 +96,199 @@ services:
 {{- end }}
 volumes:
   pg_data:
-`))
+    {{- if .Options.DataVolume }}
+    {{- template "dataVolume" .Options }}
+    {{- else }}
+    {{- template "labels" .ResourceLabels }}
+    {{- end }}
+networks:
+  default:
+    {{- template "labels" .ResourceLabels }}
+`)
 )
 
 type Config struct {
+	shared.EnvConfig
+
 	Image                   string        `env:"POSTGRES_IMAGE,required"`
 	UpgradeFromImage        string        `env:"POSTGRES_UPGRADE_FROM_IMAGE,required"`
-	ArtifactDir             string        `env:"ARTIFACT_DIR,default="`
 	WaitForStartTimeout     time.Duration `env:"WAIT_FOR_START_TIMEOUT,default=15s"`
 	WaitForStartInterval    time.Duration `env:"WAIT_FOR_START_INTERVAL,default=2s"`
-	PostgresPort            int           `env:"POSTGRES_PORT,default=5432"`
+	PostgresHost            string        `env:"POSTGRES_HOST,default="`
+	ContainerRuntime        string        `env:"CONTAINER_RUNTIME,default=docker"`
+	ReuseContainers         bool          `env:"REUSE_CONTAINERS,default=false"`
+	StopTimeout             time.Duration `env:"STOP_TIMEOUT,default=30s"`
+	VerifyCleanShutdown     bool          `env:"VERIFY_CLEAN_SHUTDOWN,default=false"`
+	StreamLogs              bool          `env:"STREAM_LOGS,default=false"`
+	StatsInterval           time.Duration `env:"STATS_INTERVAL,default=0s"`
+	DataTmpfs               string        `env:"DATA_TMPFS,default="`
+	Platform                string        `env:"PLATFORM,default="`
+	PullImages              bool          `env:"PULL_IMAGES,default=false"`
+	ImageDigest             string        `env:"POSTGRES_IMAGE_DIGEST,default="`
+	UpgradeFromImageDigest  string        `env:"POSTGRES_UPGRADE_FROM_IMAGE_DIGEST,default="`
+	BuildDir                string        `env:"POSTGRES_BUILD_DIR,default="`
+	BuildArgs               []string      `env:"POSTGRES_BUILD_ARGS,default="`
+	Dockerfile              string        `env:"POSTGRES_DOCKERFILE,default="`
 	ExpectedPostgresVersion string        `env:"EXPECTED_POSTGRES_VERSION,required"`
+	PgBouncerImage          string        `env:"PGBOUNCER_IMAGE,default=edoburu/pgbouncer:v1.23.1-p2"`
+}
+
+// Validate reports every setting of c that is invalid.
+func (c Config) Validate() error {
+	return errors.Join(
+		c.EnvConfig.Validate(),
+		shared.ValidateImage("POSTGRES_IMAGE", c.Image),
+		shared.ValidateImage("POSTGRES_UPGRADE_FROM_IMAGE", c.UpgradeFromImage),
+		shared.ValidateImage("PGBOUNCER_IMAGE", c.PgBouncerImage),
+		shared.ValidateTimeout("WAIT_FOR_START_TIMEOUT", c.WaitForStartTimeout),
+		shared.ValidateTimeout("WAIT_FOR_START_INTERVAL", c.WaitForStartInterval),
+		shared.ValidateTimeout("STOP_TIMEOUT", c.StopTimeout),
+		shared.ValidateContainerRuntime("CONTAINER_RUNTIME", c.ContainerRuntime),
+	)
+}
+
+// with returns c with the images and Postgres version of sc, if set.
+func (c Config) with(sc shared.SuiteConfig) Config {
+	if sc.Image != "" {
+		c.Image = sc.Image
+	}
+	if sc.UpgradeFromImage != "" {
+		c.UpgradeFromImage = sc.UpgradeFromImage
+	}
+	if sc.PostgresVersion != "" {
+		c.ExpectedPostgresVersion = sc.PostgresVersion
+	}
+
+	return c
 }
 
-var config Config
+var (
+	config           Config
+	containerRuntime shared.ContainerRuntime
+	suiteFile        *shared.SuiteFile
+)
 
 const (
 	pgusername = "hydra"
 	pgpassword = "hydra"
 )
 
+// allocatePort returns port, or a free port if port is 0.
+func allocatePort(t *testing.T, port int) int {
+	if port == 0 {
+		return shared.FreePort(t)
+	}
+
+	return port
+}
+
 func TestMain(m *testing.M) {
-	if err := envdecode.StrictDecode(&config); err != nil {
+	if err := shared.ConfigFromEnv(&config); err != nil {
+		log.Fatal(err)
+	}
+
+	level, err := shared.ParseLogLevel(config.LogLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	shared.SetLogLevel(level)
+
+	modes, err := shared.ParseQueryExecModes(config.QueryExecModes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	shared.QueryExecModes = modes
+
+	tags, err := shared.ParseTagFilter(config.CaseTags)
+	if err != nil {
+		log.Fatal(err)
+	}
+	shared.CaseTagFilter = tags
+
+	selected, err := shared.ParseCaseSelection(config.OnlyCases, config.SkipCases)
+	if err != nil {
+		log.Fatal(err)
+	}
+	shared.SelectedCases = selected
+
+	shared.InitSeed(config.Seed)
+	shared.InitRunID(config.RunID)
+	shared.InitRunContext(config.RunTimeout)
+	shared.SetTimeouts(shared.Timeouts{
+		Startup:  config.WaitForStartTimeout,
+		Connect:  config.ConnectTimeout,
+		Query:    config.QueryTimeout,
+		Shutdown: config.StopTimeout,
+		LogFetch: config.LogFetchTimeout,
+	})
+
+	if config.SuiteFile != "" {
+		if suiteFile, err = shared.LoadSuiteFile(config.SuiteFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	rt, err := shared.NewContainerRuntime(config.ContainerRuntime)
+	if err != nil {
 		log.Fatal(err)
 	}
+	containerRuntime = rt
+
+	// nothing is started in a dry run, so there is nothing to collect
+	if config.DryRun {
+		shared.DryRun = true
+		containerRuntime = shared.NewDryRunRuntime(rt)
+		os.Exit(m.Run())
+	}
 
-	shared.MustHaveValidArtifactDir(config.ArtifactDir)
+	// reused projects are left running on purpose, so they must not be
+	// collected
+	if config.ReuseContainers {
+		os.Exit(m.Run())
+	}
 
-	os.Exit(m.Run())
+	os.Exit(shared.RunWithGC(m, containerRuntime))
 }
 
 type postgresAcceptanceCompose struct {
-	config Config
+	config  Config
+	options shared.ContainerOptions
 
 	project string
+	port    int
 	pool    *pgxpool.Pool
+	appPool *pgxpool.Pool
 }
 
 func (c *postgresAcceptanceCompose) StartCompose(t *testing.T, ctx context.Context, img string, startEverything bool) {
-	// Only set the project on first start
+	// Only set the project and ports on first start
 	if c.project == "" {
-		c.project = fmt.Sprintf("postgres-%s", xid.New())
+		c.project = shared.UniqueName(t, "postgres")
+		// no port is published when connecting over the socket
+		if c.options.SocketDir == "" {
+			c.port = allocatePort(t, c.config.PostgresPort)
+		}
+	}
+
+	options := c.options
+	if options.Platform == "" {
+		options.Platform = c.config.Platform
+	}
+	if shared.IsEmulated(t, ctx, containerRuntime, options.Platform) {
+		t.Logf("Running %s images under emulation, expect it to be slow", options.Platform)
+	}
+
+	// the image under test is built from source if a build directory is
+	// configured, e.g. the repository root
+	if c.config.BuildDir != "" && img == c.config.Image {
+		shared.BuildImage(t, ctx, containerRuntime, c.config.BuildDir, img, shared.BuildOptions{
+			Dockerfile: c.config.Dockerfile,
+			Platform:   options.Platform,
+			BuildArgs:  c.config.BuildArgs,
+			LogDir:     string(c.config.ArtifactDir),
+		})
+	} else if c.config.PullImages {
+		shared.PullImage(t, ctx, containerRuntime, img, options.Platform, c.expectedDigest(img))
 	}
 
 	pwd, err := os.Getwd()
@@ -117,20 +296,58 @@ func (c *postgresAcceptanceCompose) StartCompose(t *testing.T, ctx context.Conte
 		t.Fatal(err)
 	}
 
-	dockerCompose := bytes.NewBuffer(nil)
-	if err := dockerComposeTmpl.Execute(dockerCompose, dockerComposeData{
+	data := dockerComposeData{
 		Image:               img,
 		PostgresUser:        pgusername,
-		PostgresPassword:    pgpassword,
-		PostgresPort:        c.config.PostgresPort,
+		PostgresPort:        c.port,
 		StartEverything:     startEverything,
 		MySQLFixtureSQLPath: filepath.Join(pwd, "..", "fixtures", "mysql.sql"),
-	}); err != nil {
+		Options:             options,
+		Environment: options.Environment(map[string]string{
+			"POSTGRES_USER":     pgusername,
+			"POSTGRES_PASSWORD": pgpassword,
+			"PGDATA":            "/var/lib/postgresql/data/pgdata",
+		}),
+	}
+
+	if len(options.Settings) > 0 {
+		data.ConfDir = shared.WriteConfFragment(t, options.Settings)
+	}
+
+	// ports and temporary directories are allocated per run so they do not
+	// identify a reusable project
+	reuseKey := data
+	reuseKey.PostgresPort = 0
+	reuseKey.ConfDir = ""
+	data.Labels = shared.ReuseLabels(img, reuseKey)
+
+	if c.config.ReuseContainers {
+		project, err := shared.FindReusableProject(ctx, containerRuntime, data.Labels)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if project != "" {
+			t.Logf("Reusing docker compose %s", project)
+			c.project = project
+			c.port = shared.PublishedPort(t, ctx, containerRuntime, c.hydraContainerID(t, ctx), 5432)
+			c.WaitForContainerReady(t, ctx)
+			return
+		}
+	}
+
+	data.ResourceLabels = shared.ResourceLabels()
+	for k, v := range data.ResourceLabels {
+		data.Labels[k] = v
+	}
+
+	dockerCompose := bytes.NewBuffer(nil)
+	if err := dockerComposeTmpl.Execute(dockerCompose, data); err != nil {
 		t.Fatal(err)
 	}
 
 	// ArtifactDir may be empty, in which case the system tmp directory is used
-	f, err := os.CreateTemp(c.config.ArtifactDir, "docker-compose.yml")
+	f, err := os.CreateTemp(shared.TestArtifactDir(t, string(c.config.ArtifactDir)), "docker-compose.yml")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -142,44 +359,77 @@ func (c *postgresAcceptanceCompose) StartCompose(t *testing.T, ctx context.Conte
 		t.Fatal(err)
 	}
 
-	runCmd := exec.CommandContext(ctx, "docker", "compose", "--project-name", c.project, "--file", f.Name(), "up", "--detach")
-
-	t.Logf("Starting docker compose %s with %s", c.project, f.Name())
-	if o, err := runCmd.CombinedOutput(); err != nil {
-		t.Fatalf("unable to start docker compose %s: %s", err, o)
-		return
-	}
+	c.composeStack(f.Name()).Up(t, ctx)
 
 	c.WaitForContainerReady(t, ctx)
 }
 
 func (c *postgresAcceptanceCompose) WaitForContainerReady(t *testing.T, ctx context.Context) {
-	done := make(chan bool, 1)
-	timeout := time.After(c.config.WaitForStartTimeout)
-	ticker := time.NewTicker(c.config.WaitForStartInterval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-done:
-			return
-		case <-ticker.C:
-			pool, err := shared.CreatePGPool(t, ctx, pgusername, pgpassword, c.config.PostgresPort)
-			if errors.Is(err, shared.ErrPgPoolConnect) {
-				continue
-			} else if err != nil {
-				t.Fatal(err)
-			}
-			c.pool = pool
-			done <- true
-		case <-timeout:
-			t.Fatalf("timed out waiting for container to start after %s", c.config.WaitForStartTimeout)
-		}
+	probe := shared.HealthCheckProbe(containerRuntime, c.project, "hydra")
+	shared.WaitForReadiness(t, ctx, probe, shared.TimeoutsFor(t).Startup, c.config.WaitForStartInterval)
+
+	pool, err := shared.CreatePGPool(t, ctx, c.ConnSpec().With(shared.WithRetry(shared.RetryPolicy{
+		Deadline:   shared.TimeoutsFor(t).Startup,
+		MaxBackoff: c.config.WaitForStartInterval,
+	})))
+	if err != nil {
+		t.Fatalf("timed out waiting for container to start after %s: %s", shared.TimeoutsFor(t).Startup, err)
 	}
+
+	c.pool = pool
+	c.appPool = shared.CreateAppPool(t, ctx, pool, c.ConnSpec())
 }
 
 func (c postgresAcceptanceCompose) TerminateCompose(t *testing.T, ctx context.Context, kill bool) {
-	shared.TerminateDockerComposeProject(t, ctx, c.project, c.config.ArtifactDir, kill)
+	// leave the project running so that the next run can reuse it
+	if c.config.ReuseContainers && kill {
+		t.Logf("Leaving docker compose %s running for reuse", c.project)
+		return
+	}
+
+	if c.project == "" {
+		return
+	}
+
+	if err := c.composeStack("").Down(t, ctx, shared.WithKill(kill)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c postgresAcceptanceCompose) hydraContainerID(t *testing.T, ctx context.Context) string {
+	id, err := containerRuntime.ContainerID(ctx, c.project, "hydra")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func (c postgresAcceptanceCompose) composeStack(file string) *shared.ComposeStack {
+	stack := shared.NewComposeStack(containerRuntime, c.project, file, "hydra")
+	stack.LogDir = string(c.config.ArtifactDir)
+	stack.CrashCheck = map[string]string{"hydra": "/var/lib/postgresql/data/pgdata"}
+	stack.StatsInterval = c.config.StatsInterval
+	if c.config.VerifyCleanShutdown {
+		stack.VerifyShutdown = []string{"hydra"}
+	}
+	if c.config.StreamLogs {
+		stack.StreamLogs = true
+	}
+
+	return stack
+}
+
+// expectedDigest returns the digest configured for img, if any.
+func (c postgresAcceptanceCompose) expectedDigest(img string) string {
+	switch img {
+	case c.config.Image:
+		return c.config.ImageDigest
+	case c.config.UpgradeFromImage:
+		return c.config.UpgradeFromImageDigest
+	default:
+		return ""
+	}
 }
 
 func (c postgresAcceptanceCompose) Image() string {
@@ -194,102 +444,1402 @@ func (c postgresAcceptanceCompose) PGPool() *pgxpool.Pool {
 	return c.pool
 }
 
+func (c postgresAcceptanceCompose) AppPool() *pgxpool.Pool {
+	return c.appPool
+}
+
+func (c postgresAcceptanceCompose) ConnSpec() shared.ConnSpec {
+	if c.options.SocketDir != "" {
+		return shared.NewConnSpec(pgusername, pgpassword, 0, shared.WithSocketDir(c.options.SocketDir))
+	}
+
+	// the docker host unless configured otherwise
+	return shared.NewConnSpec(pgusername, pgpassword, c.port, shared.WithHost(c.config.PostgresHost))
+}
+
 func Test_PostgresAcceptance(t *testing.T) {
-	shared.RunAcceptanceTests(
-		t,
-		context.Background(),
-		&postgresAcceptanceCompose{config: config},
-		[]shared.Case{
-			// http ext cases are only available to postgres build for now
-			// move this back to AcceptanceCases when they are ready
-			{
-				Name: "http ext available",
-				SQL: `
+	shared.RunConfigurations(t, suiteFile, "postgres", func(t *testing.T, sc shared.SuiteConfig) {
+		cfg := config.with(sc)
+
+		shared.RunAcceptanceTests(
+			t,
+			shared.Context(t),
+			&postgresAcceptanceCompose{
+				config:  cfg,
+				options: shared.ContainerOptions{DataTmpfs: cfg.DataTmpfs, Settings: sc.Settings},
+			},
+			[]shared.Case{
+				// http ext cases are only available to postgres build for now
+				// move this back to AcceptanceCases when they are ready
+				{
+					Name: "http ext available",
+					SQL: `
 SELECT count(1) FROM pg_available_extensions WHERE name = 'http';
 			`,
-				Validate: func(t *testing.T, row pgx.Row) {
-					var count int
-					if err := row.Scan(&count); err != nil {
-						t.Fatal(err)
-					}
-
-					if want, got := 1, count; want != got {
-						t.Errorf("columnar ext should exist")
-					}
+					Validate: func(t *testing.T, row pgx.Row) {
+						var count int
+						if err := row.Scan(&count); err != nil {
+							t.Fatal(err)
+						}
+
+						if want, got := 1, count; want != got {
+							t.Errorf("columnar ext should exist")
+						}
+					},
 				},
-			},
-			{
-				Name: "enable http ext",
-				SQL: `
+				{
+					Name: "enable http ext",
+					SQL: `
 CREATE EXTENSION http;
 			`,
-			},
-			{
-				Name: "http ext enabled",
-				SQL: `
+				},
+				{
+					Name: "http ext enabled",
+					SQL: `
 SELECT count(1) FROM pg_extension WHERE extname = 'http';
 			`,
-				Validate: func(t *testing.T, row pgx.Row) {
-					var count int
-					if err := row.Scan(&count); err != nil {
-						t.Fatal(err)
-					}
-
-					if want, got := 1, count; want != got {
-						t.Errorf("columnar ext should exist")
-					}
+					Validate: func(t *testing.T, row pgx.Row) {
+						var count int
+						if err := row.Scan(&count); err != nil {
+							t.Fatal(err)
+						}
+
+						if want, got := 1, count; want != got {
+							t.Errorf("columnar ext should exist")
+						}
+					},
 				},
-			},
-			{
-				Name: "http put",
-				SQL: `
+				{
+					Name: "http put",
+					SQL: `
 SELECT status, content_type, content::json->>'data' AS data
   FROM http_put('http://httpbun.com/put', 'some text', 'text/plain');
 			`,
-				Validate: func(t *testing.T, row pgx.Row) {
-					var result struct {
-						Status      int
-						ContentType string
-						Data        string
-					}
-
-					if err := row.Scan(&result.Status, &result.ContentType, &result.Data); err != nil {
-						t.Fatal(err)
-					}
-
-					if want, got := 200, result.Status; want != got {
-						t.Errorf("http put response status should match: want=%d got=%d", want, got)
-					}
-					if want, got := "application/json", result.ContentType; want != got {
-						t.Errorf("http put response content type should match: want=%s got=%s", want, got)
-					}
-					if want, got := "some text", result.Data; want != got {
-						t.Errorf("http put response data should match: want=%s got=%s", want, got)
-					}
+					Validate: func(t *testing.T, row pgx.Row) {
+						var result struct {
+							Status      int
+							ContentType string
+							Data        string
+						}
+
+						if err := row.Scan(&result.Status, &result.ContentType, &result.Data); err != nil {
+							t.Fatal(err)
+						}
+
+						if want, got := 200, result.Status; want != got {
+							t.Errorf("http put response status should match: want=%d got=%d", want, got)
+						}
+						if want, got := "application/json", result.ContentType; want != got {
+							t.Errorf("http put response content type should match: want=%s got=%s", want, got)
+						}
+						if want, got := "some text", result.Data; want != got {
+							t.Errorf("http put response data should match: want=%s got=%s", want, got)
+						}
+					},
+				},
+				{
+					Name: "started with the expected postgres version",
+					SQL:  `SHOW server_version;`,
+					Validate: func(t *testing.T, row pgx.Row) {
+						var version string
+						if err := row.Scan(&version); err != nil {
+							t.Fatal(err)
+						}
+
+						if !strings.HasPrefix(version, cfg.ExpectedPostgresVersion) {
+							t.Errorf("incorrect postgres version, got %s, expected major version %s", version, cfg.ExpectedPostgresVersion)
+						}
+					},
+				},
+			}...,
+		)
+	})
+}
+
+func Test_PostgresPreparedStatements(t *testing.T) {
+	shared.RunPreparedAcceptanceTests(
+		t,
+		shared.Context(t),
+		&postgresAcceptanceCompose{
+			config: config,
+			options: shared.ContainerOptions{
+				DataTmpfs: config.DataTmpfs,
+			},
+		},
+	)
+}
+
+func Test_PostgresConstrainedResources(t *testing.T) {
+	shared.SkipUnlessTagged(t, shared.TagColumnar, shared.TagSlow)
+	// the aggregate spills to disk under the memory limit
+	shared.OverrideTimeouts(t, shared.Timeouts{Query: 30 * time.Second})
+
+	shared.RunAcceptanceTests(
+		t,
+		shared.Context(t),
+		&postgresAcceptanceCompose{
+			config: config,
+			options: shared.ContainerOptions{
+				Memory: "512m",
+				CPUs:   "1",
+			},
+		},
+		shared.ConstrainedResourceCases...,
+	)
+}
+
+func Test_PostgresParallelQuery(t *testing.T) {
+	shared.SkipUnlessTagged(t, shared.TagColumnar, shared.TagSlow)
+	// the tables of the parallel cases are loaded with up to a million rows
+	shared.OverrideTimeouts(t, shared.Timeouts{Query: time.Minute})
+
+	shared.RunAcceptanceTests(
+		t,
+		shared.Context(t),
+		&postgresAcceptanceCompose{
+			config: config,
+			options: shared.ContainerOptions{
+				DataTmpfs: config.DataTmpfs,
+				ShmSize:   "1g",
+				Ulimits: []shared.Ulimit{
+					{Name: "nofile", Soft: 65536, Hard: 65536},
 				},
 			},
-			{
-				Name: "started with the expected postgres version",
-				SQL:  `SHOW server_version;`,
-				Validate: func(t *testing.T, row pgx.Row) {
-					var version string
-					if err := row.Scan(&version); err != nil {
-						t.Fatal(err)
-					}
-
-					if !strings.HasPrefix(version, config.ExpectedPostgresVersion) {
-						t.Errorf("incorrect postgres version, got %s, expected major version %s", version, config.ExpectedPostgresVersion)
-					}
+		},
+		append(append([]shared.Case{}, shared.ParallelQueryCases...), shared.ParallelScanCases(shared.ParallelScans...)...)...,
+	)
+}
+
+// Test_PostgresColumnar runs the columnar cases against a single container
+// without the test dependencies, each kind of them in a subtest.
+func Test_PostgresColumnar(t *testing.T) {
+	shared.SkipUnlessTagged(t, shared.TagColumnar)
+
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{config: config}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	t.Run("compression", func(t *testing.T) {
+		// zstd is slow to compress the dataset at the highest levels
+		shared.OverrideTimeouts(t, shared.Timeouts{Query: time.Minute})
+
+		shared.RunCases(t, ctx, c.pool, shared.CompressionCases(shared.Codecs...)...)
+	})
+
+	t.Run("sizing", func(t *testing.T) {
+		shared.RunCases(t, ctx, c.pool, shared.SizingCases(shared.Sizings...)...)
+	})
+
+	t.Run("vacuum", func(t *testing.T) {
+		shared.RunCases(t, ctx, c.pool, shared.VacuumCases()...)
+	})
+
+	t.Run("update and delete", func(t *testing.T) {
+		shared.RunCases(t, ctx, c.pool, shared.UpdateDeleteCases...)
+	})
+
+	t.Run("indexes", func(t *testing.T) {
+		shared.RunCases(t, ctx, c.pool, shared.IndexCases()...)
+	})
+
+	t.Run("partitions", func(t *testing.T) {
+		shared.RunCases(t, ctx, c.pool, shared.PartitionCases...)
+	})
+
+	t.Run("access methods", func(t *testing.T) {
+		shared.RunCases(t, ctx, c.pool, shared.AccessMethodCases...)
+	})
+
+	t.Run("large values", func(t *testing.T) {
+		// values of 16 MiB are generated, written and read back by each case
+		shared.OverrideTimeouts(t, shared.Timeouts{Query: 2 * time.Minute})
+
+		shared.RunCases(t, ctx, c.pool, shared.LargeValueCases(shared.LargeValues...)...)
+	})
+
+	t.Run("datatypes", func(t *testing.T) {
+		shared.RunCases(t, ctx, c.pool, shared.DatatypeCases(shared.Datatypes...)...)
+	})
+
+	t.Run("sparse columns", func(t *testing.T) {
+		shared.RunCases(t, ctx, c.pool, shared.SparseCases(shared.Sparsities...)...)
+	})
+
+	t.Run("settings", func(t *testing.T) {
+		shared.RunCases(t, ctx, c.pool, shared.ColumnarSettingCases(shared.ColumnarSettings...)...)
+	})
+
+	t.Run("chunk group filters", func(t *testing.T) {
+		shared.RunCases(t, ctx, c.pool, shared.ChunkGroupFilterCases(shared.ChunkGroupFilters...)...)
+	})
+
+	t.Run("isolation", func(t *testing.T) {
+		shared.RunCases(t, ctx, c.pool, shared.IsolationCases(shared.Isolations...)...)
+	})
+
+	t.Run("triggers", func(t *testing.T) {
+		shared.RunCases(t, ctx, c.pool, shared.TriggerCases(shared.Triggers...)...)
+	})
+
+	t.Run("constraints", func(t *testing.T) {
+		shared.RunCases(t, ctx, c.pool, shared.ConstraintCases(shared.Constraints...)...)
+	})
+
+	t.Run("ingestion", func(t *testing.T) {
+		shared.SkipUnlessTagged(t, shared.TagSlow)
+		// tens of millions of rows are copied and checksummed by each case
+		shared.OverrideTimeouts(t, shared.Timeouts{Query: 15 * time.Minute})
+
+		shared.RunCases(t, ctx, c.pool, shared.IngestionCases(shared.Ingestions...)...)
+	})
+}
+
+func Test_PostgresContainerOverrides(t *testing.T) {
+	shared.RunAcceptanceTests(
+		t,
+		shared.Context(t),
+		&postgresAcceptanceCompose{
+			config: config,
+			options: shared.ContainerOptions{
+				DataTmpfs: config.DataTmpfs,
+				Command:   []string{"postgres", "-c", "work_mem=12MB"},
+				Env: map[string]string{
+					"POSTGRES_INITDB_ARGS": "--data-checksums",
 				},
 			},
-		}...,
+		},
+		shared.Case{
+			Name: "command overrides settings",
+			SQL:  `SHOW work_mem;`,
+			Validate: func(t *testing.T, row pgx.Row) {
+				var workMem string
+				if err := row.Scan(&workMem); err != nil {
+					t.Fatal(err)
+				}
+
+				if want, got := "12MB", workMem; want != got {
+					t.Errorf("work_mem should match: want=%s got=%s", want, got)
+				}
+			},
+		},
+		shared.Case{
+			Name: "environment overrides initdb args",
+			SQL:  `SHOW data_checksums;`,
+			Validate: func(t *testing.T, row pgx.Row) {
+				var checksums string
+				if err := row.Scan(&checksums); err != nil {
+					t.Fatal(err)
+				}
+
+				if want, got := "on", checksums; want != got {
+					t.Errorf("data_checksums should match: want=%s got=%s", want, got)
+				}
+			},
+		},
 	)
 }
 
-func Test_PostgresUpgrade(t *testing.T) {
+func Test_PostgresConfSettings(t *testing.T) {
+	ctx := shared.Context(t)
+
+	settings := map[string]string{
+		"shared_buffers":       "64MB",
+		"max_parallel_workers": "2",
+		"columnar.compression": "pglz",
+	}
+
+	c := postgresAcceptanceCompose{
+		config:  config,
+		options: shared.ContainerOptions{Settings: settings},
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	for name, value := range settings {
+		var got string
+		if err := c.pool.QueryRow(ctx, "SELECT current_setting($1)", name).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+
+		if got != value {
+			t.Errorf("%s should be set from the start: want=%s got=%s", name, value, got)
+		}
+	}
+}
+
+func Test_PostgresUnixSocket(t *testing.T) {
+	shared.RunAcceptanceTests(
+		t,
+		shared.Context(t),
+		&postgresAcceptanceCompose{
+			config: config,
+			options: shared.ContainerOptions{
+				DataTmpfs: config.DataTmpfs,
+				SocketDir: shared.CreateSocketDir(t),
+			},
+		},
+		shared.Case{
+			Name: "connected over the unix socket",
+			SQL:  `SELECT inet_server_addr() IS NULL;`,
+			Validate: func(t *testing.T, row pgx.Row) {
+				var socket bool
+				if err := row.Scan(&socket); err != nil {
+					t.Fatal(err)
+				}
+
+				if !socket {
+					t.Errorf("connection should use the unix socket")
+				}
+			},
+		},
+	)
+}
+
+func Test_PostgresIPv6(t *testing.T) {
+	shared.RequireIPv6(t)
+
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{
+		config: config,
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	// a configured IPv6 host, e.g. of a remote daemon, or the loopback address
+	host := "::1"
+	if ip := net.ParseIP(c.config.PostgresHost); ip != nil && ip.To4() == nil {
+		host = c.config.PostgresHost
+	}
+
+	pool, err := shared.CreatePGPool(t, ctx, c.ConnSpec().With(
+		shared.WithHost(host),
+		shared.WithRetry(shared.RetryPolicy{Deadline: shared.TimeoutsFor(t).Startup}),
+	))
+	if err != nil {
+		t.Fatalf("unable to connect over IPv6: %s", err)
+	}
+
+	// the server may see the connection from the proxy of the published port
+	// over IPv4, so the address family is checked on the client
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.Conn().PgConn().Conn().RemoteAddr().(*net.TCPAddr)
+	conn.Release()
+
+	if addr.IP.To4() != nil {
+		t.Fatalf("connection should use IPv6, got %s", addr)
+	}
+
+	shared.RunCases(t, ctx, pool, shared.CRUDCases...)
+}
+
+func Test_PostgresAuth(t *testing.T) {
+	for _, method := range []string{shared.AuthMethodTrust, shared.AuthMethodMD5, shared.AuthMethodSCRAM} {
+		method := method
+		t.Run(method, func(t *testing.T) {
+			shared.RunAuthTests(t, shared.Context(t), &postgresAcceptanceCompose{
+				config: config,
+				options: shared.ContainerOptions{
+					DataTmpfs:  config.DataTmpfs,
+					AuthMethod: method,
+				},
+			}, method)
+		})
+	}
+}
+
+func Test_PostgresPersistence(t *testing.T) {
+	shared.SkipUnlessTagged(t, shared.TagColumnar, shared.TagDestructive)
+	// reused containers are left running instead of being killed, see
+	// TerminateCompose
+	if config.ReuseContainers {
+		t.Skip("Skipping test that kills the container, which REUSE_CONTAINERS keeps running")
+	}
+
+	ctx := shared.Context(t)
+
+	volume := shared.UniqueName(t, "postgres")
+	shared.CreateVolume(t, ctx, containerRuntime, volume)
+
+	shared.RunPersistenceTests(t, ctx, &postgresAcceptanceCompose{
+		config:  config,
+		options: shared.ContainerOptions{DataVolume: volume},
+	})
+}
+
+func Test_PostgresRestart(t *testing.T) {
+	shared.SkipUnlessTagged(t, shared.TagColumnar, shared.TagDestructive)
+	// the cases restart the container within their query timeout
+	shared.OverrideTimeouts(t, shared.Timeouts{Query: 2 * shared.TimeoutsFor(t).Startup})
+
+	ctx := shared.Context(t)
+
 	c := postgresAcceptanceCompose{
 		config: config,
+		options: shared.ContainerOptions{
+			Settings: map[string]string{"max_prepared_transactions": "10"},
+		},
 	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	// the groups restart the container, so they must not overlap
+	shared.RunCaseGroups(t, ctx, c.pool,
+		shared.CaseGroup{
+			Name:   "restart",
+			Serial: true,
+			Cases: []shared.Case{
+				{
+					Name: "columnar table survives a restart",
+					Tags: []string{shared.TagColumnar, shared.TagDestructive},
+					Run: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+						if _, err := pool.Exec(ctx, `
+CREATE TABLE restart_columnar (i int, t text) USING columnar;
+INSERT INTO restart_columnar SELECT i, md5(i::text) FROM generate_series(1, 10000) i;
+	`); err != nil {
+							t.Fatal(err)
+						}
+						t.Cleanup(func() {
+							if _, err := pool.Exec(context.Background(), "DROP TABLE IF EXISTS restart_columnar"); err != nil {
+								t.Errorf("unable to drop restart_columnar: %s", err)
+							}
+						})
+
+						shared.RestartContainer(
+							t,
+							ctx,
+							containerRuntime,
+							pool,
+							c.hydraContainerID(t, ctx),
+							shared.TimeoutsFor(t).Startup,
+							shared.RowCountCheck("restart_columnar"),
+							shared.ChecksumCheck("restart_columnar", "i"),
+						)
+						shared.CheckColumnarMetadata(t, ctx, pool, "restart_columnar")
+					},
+				},
+			},
+		},
+		shared.CaseGroup{
+			Name:   "two-phase commit",
+			Serial: true,
+			Cases: []shared.Case{
+				{
+					Name: "prepared columnar writes survive a restart",
+					Tags: []string{shared.TagColumnar, shared.TagDestructive},
+					Run: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+						shared.RunTwoPhaseCommit(t, ctx, containerRuntime, pool, c.hydraContainerID(t, ctx))
+					},
+				},
+			},
+		},
+	)
+}
+
+func Test_PostgresCrashRecovery(t *testing.T) {
+	shared.SkipUnlessTagged(t, shared.TagColumnar, shared.TagDestructive)
+	// reused containers are left running instead of being killed, see
+	// TerminateCompose
+	if config.ReuseContainers {
+		t.Skip("Skipping test that kills the container, which REUSE_CONTAINERS keeps running")
+	}
+
+	ctx := shared.Context(t)
+
+	volume := shared.UniqueName(t, "postgres")
+	shared.CreateVolume(t, ctx, containerRuntime, volume)
+
+	shared.RunCrashRecovery(t, ctx, &postgresAcceptanceCompose{
+		config:  config,
+		options: shared.ContainerOptions{DataVolume: volume},
+	}, shared.CrashWrites...)
+}
+
+func Test_PostgresReadiness(t *testing.T) {
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{
+		config: config,
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	spec := c.ConnSpec()
+	if status, err := shared.PingServer(ctx, spec); status != shared.ServerAccepting {
+		t.Fatalf("expected %s, got %s: %v", shared.ServerAccepting, status, err)
+	}
+
+	// like pg_isready, a failed authentication still means the server is up
+	if status, err := shared.PingServer(ctx, spec.With(shared.WithUser("nobody", "nobody"))); status != shared.ServerAccepting {
+		t.Errorf("expected %s with an unknown user, got %s: %v", shared.ServerAccepting, status, err)
+	}
+
+	if output, err := containerRuntime.Command(ctx, "restart", c.hydraContainerID(t, ctx)).CombinedOutput(); err != nil {
+		t.Fatalf("unable to restart: %s: %s", err, output)
+	}
+	shared.WaitForReady(t, ctx, spec, shared.TimeoutsFor(t).Startup)
+}
+
+func Test_PostgresTLS(t *testing.T) {
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{
+		config: config,
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	host, err := c.ConnSpec().HostAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs := shared.GenerateCertificates(t, pgusername, host, "localhost")
+	shared.EnableTLS(t, ctx, containerRuntime, c.pool, c.hydraContainerID(t, ctx), "postgres", certs)
+
+	pool, err := shared.CreatePGPool(t, ctx, c.ConnSpec().With(
+		shared.WithTLS(shared.TLSOptions{
+			SSLMode:     "verify-full",
+			SSLRootCert: certs.CACert,
+			SSLCert:     certs.ClientCert,
+			SSLKey:      certs.ClientKey,
+		}),
+		shared.WithRetry(shared.RetryPolicy{Deadline: shared.TimeoutsFor(t).Startup}),
+	))
+	if err != nil {
+		t.Fatalf("unable to connect over TLS: %s", err)
+	}
+
+	var ssl bool
+	if err := pool.QueryRow(ctx, "SELECT ssl FROM pg_stat_ssl WHERE pid = pg_backend_pid()").Scan(&ssl); err != nil {
+		t.Fatal(err)
+	}
+
+	if !ssl {
+		t.Errorf("connection should use TLS")
+	}
+}
+
+func Test_PostgresPause(t *testing.T) {
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{
+		config: config,
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	shared.PauseContainer(t, ctx, containerRuntime, c.hydraContainerID(t, ctx))
+
+	pausedCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if _, err := c.pool.Exec(pausedCtx, "SELECT 1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("query against paused container should time out, got: %v", err)
+	}
+
+	shared.UnpauseContainer(t, ctx, containerRuntime, c.hydraContainerID(t, ctx))
+
+	resumedCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := c.pool.Exec(resumedCtx, "SELECT 1"); err != nil {
+		t.Fatalf("query should succeed after unpausing: %s", err)
+	}
+}
+
+func Test_PostgresMultipleDatabases(t *testing.T) {
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{
+		config: config,
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	tenants := map[string]int{"tenant_a": 10, "tenant_b": 20}
+	for name, rows := range tenants {
+		shared.CreateDatabase(t, ctx, c.pool, name)
+
+		// the extension is only created in the default database by the image
+		pool := shared.PoolForDatabase(t, ctx, c.ConnSpec(), name)
+		if _, err := pool.Exec(ctx, "CREATE EXTENSION IF NOT EXISTS columnar"); err != nil {
+			t.Fatalf("unable to create columnar extension in %s: %s", name, err)
+		}
+		if _, err := pool.Exec(ctx, "CREATE TABLE tenant (id int) USING columnar"); err != nil {
+			t.Fatalf("unable to create columnar table in %s: %s", name, err)
+		}
+		if _, err := pool.Exec(ctx, "INSERT INTO tenant SELECT generate_series(1, $1)", rows); err != nil {
+			t.Fatalf("unable to insert into %s: %s", name, err)
+		}
+	}
+
+	for name, rows := range tenants {
+		pool := shared.PoolForDatabase(t, ctx, c.ConnSpec(), name)
+
+		var count int
+		if err := pool.QueryRow(ctx, "SELECT count(*) FROM tenant").Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+
+		if count != rows {
+			t.Errorf("%s should have %d rows, got %d", name, rows, count)
+		}
+	}
+}
+
+func Test_PostgresDumpRestore(t *testing.T) {
+	shared.SkipUnlessTagged(t, shared.TagColumnar)
+
+	ctx := shared.Context(t)
+
+	// the target is restored into from a container of its own, on any free
+	// port
+	targetConfig := config
+	targetConfig.PostgresPort = 0
+
+	source := postgresAcceptanceCompose{config: config}
+	target := postgresAcceptanceCompose{config: targetConfig}
+	t.Cleanup(func() {
+		source.TerminateCompose(t, ctx, true)
+		target.TerminateCompose(t, ctx, true)
+	})
+	source.StartCompose(t, ctx, source.Image(), false)
+	target.StartCompose(t, ctx, target.Image(), false)
+
+	shared.RunDumpRestore(
+		t,
+		ctx,
+		containerRuntime,
+		shared.Server{Container: source.hydraContainerID(t, ctx), Pool: source.pool, ConnSpec: source.ConnSpec()},
+		shared.Server{Container: target.hydraContainerID(t, ctx), Pool: target.pool, ConnSpec: target.ConnSpec()},
+		shared.DumpFormats...,
+	)
+}
+
+func Test_PostgresLogicalReplication(t *testing.T) {
+	shared.SkipUnlessTagged(t, shared.TagColumnar)
+
+	ctx := shared.Context(t)
+
+	// the subscriber runs in a container of its own, on any free port
+	subscriberConfig := config
+	subscriberConfig.PostgresPort = 0
+
+	publisher := postgresAcceptanceCompose{
+		config:  config,
+		options: shared.ContainerOptions{Settings: map[string]string{"wal_level": "logical"}},
+	}
+	subscriber := postgresAcceptanceCompose{config: subscriberConfig}
+	t.Cleanup(func() {
+		subscriber.TerminateCompose(t, ctx, true)
+		publisher.TerminateCompose(t, ctx, true)
+	})
+	publisher.StartCompose(t, ctx, publisher.Image(), false)
+	subscriber.StartCompose(t, ctx, subscriber.Image(), false)
+
+	shared.RunLogicalReplication(
+		t,
+		ctx,
+		containerRuntime,
+		shared.Server{Container: publisher.hydraContainerID(t, ctx), Pool: publisher.pool, ConnSpec: publisher.ConnSpec()},
+		shared.Server{Container: subscriber.hydraContainerID(t, ctx), Pool: subscriber.pool, ConnSpec: subscriber.ConnSpec()},
+	)
+}
+
+func Test_PostgresSchemas(t *testing.T) {
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{
+		config: config,
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	// the cases create the same tables, which only works in separate schemas
+	t.Run("group", func(t *testing.T) {
+		for _, name := range []string{"a", "b", "c"} {
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+
+				pool, _ := shared.PoolForSchema(t, ctx, c.pool)
+				shared.RunCases(t, ctx, pool, shared.CRUDCases...)
+			})
+		}
+	})
+
+	var count int
+	if err := c.pool.QueryRow(ctx, "SELECT count(*) FROM pg_tables WHERE tablename = 'crud_columnar'").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("the schemas should be dropped after the tests, found %d tables", count)
+	}
+}
+
+func Test_PostgresGUCMatrix(t *testing.T) {
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{
+		config: config,
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	shared.RunGUCMatrix(t, ctx, c.pool, shared.DefaultGUCMatrix, shared.CRUDCases...)
+}
+
+func Test_PostgresTransactions(t *testing.T) {
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{
+		config: config,
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	if _, err := c.pool.Exec(ctx, `
+CREATE TABLE tx_columnar (i int, t text) USING columnar;
+INSERT INTO tx_columnar SELECT i, md5(i::text) FROM generate_series(1, 1000) i;
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	checksum := func(t *testing.T, q interface {
+		QueryRow(context.Context, string, ...any) pgx.Row
+	}) string {
+		t.Helper()
+
+		var sum string
+		if err := q.QueryRow(ctx, "SELECT md5(coalesce(string_agg(i || t, ',' ORDER BY i), '')) FROM tx_columnar").Scan(&sum); err != nil {
+			t.Fatal(err)
+		}
+
+		return sum
+	}
+	fixture := checksum(t, c.pool)
+
+	for name, sql := range map[string]string{
+		"insert":   "INSERT INTO tx_columnar SELECT i, md5(i::text) FROM generate_series(1001, 2000) i",
+		"delete":   "DELETE FROM tx_columnar WHERE i % 2 = 0",
+		"update":   "UPDATE tx_columnar SET t = 'updated' WHERE i < 500",
+		"truncate": "TRUNCATE tx_columnar",
+	} {
+		t.Run(name, func(t *testing.T) {
+			shared.WithTx(t, ctx, c.pool, func(t *testing.T, tx pgx.Tx) {
+				if _, err := tx.Exec(ctx, sql); err != nil {
+					t.Fatal(err)
+				}
+				if checksum(t, tx) == fixture {
+					t.Errorf("%s should be visible in the transaction", name)
+				}
+			})
+
+			if checksum(t, c.pool) != fixture {
+				t.Errorf("%s should be rolled back", name)
+			}
+		})
+	}
+}
+
+func Test_PostgresRandomData(t *testing.T) {
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{
+		config: config,
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	r := shared.Rand(t)
+
+	if _, err := c.pool.Exec(ctx, "CREATE TABLE random_columnar (i int8) USING columnar"); err != nil {
+		t.Fatal(err)
+	}
+
+	var sum int64
+	rows := make([][]any, 1000)
+	for i := range rows {
+		v := r.Int63n(1_000_000)
+		sum += v
+		rows[i] = []any{v}
+	}
+	if _, err := c.pool.CopyFrom(ctx, pgx.Identifier{"random_columnar"}, []string{"i"}, pgx.CopyFromRows(rows)); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored int64
+	if err := c.pool.QueryRow(ctx, "SELECT sum(i) FROM random_columnar").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != sum {
+		t.Errorf("sum of random_columnar returned %d, expected %d", stored, sum)
+	}
+
+	conn, err := c.pool.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Release()
+
+	seed := r.Int63()
+	random := func() float64 {
+		shared.SeedSession(t, ctx, conn.Conn(), rand.New(rand.NewSource(seed)))
+
+		var f float64
+		if err := conn.QueryRow(ctx, "SELECT random()").Scan(&f); err != nil {
+			t.Fatal(err)
+		}
+
+		return f
+	}
+	if first, second := random(), random(); first != second {
+		t.Errorf("random() returned %f after reseeding, expected %f", second, first)
+	}
+}
+
+func Test_PostgresFixtures(t *testing.T) {
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{
+		config: config,
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	exec := func(t *testing.T, ctx context.Context, pool *pgxpool.Pool, sql string) {
+		t.Helper()
+
+		if _, err := pool.Exec(ctx, sql); err != nil {
+			t.Fatalf("unable to run %q: %s", sql, err)
+		}
+	}
+
+	// the dataset is loaded once, and each case sees it as loaded as the rows
+	// inserted by the previous case are deleted
+	ctx = shared.WithHooks(ctx, shared.Hooks{
+		BeforeSuite: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+			exec(t, ctx, pool, "CREATE TABLE fixture_columnar (i int8) USING columnar")
+			exec(t, ctx, pool, "INSERT INTO fixture_columnar SELECT generate_series(1, 1000)")
+		},
+		AfterSuite: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+			exec(t, ctx, pool, "DROP TABLE fixture_columnar")
+		},
+		AfterCase: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool, c shared.Case) {
+			exec(t, ctx, pool, "DELETE FROM fixture_columnar WHERE i > 1000")
+		},
+	})
+
+	insert := func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+		exec(t, ctx, pool, "INSERT INTO fixture_columnar SELECT generate_series(1001, 1010)")
+
+		var count int
+		if err := pool.QueryRow(ctx, "SELECT count(*) FROM fixture_columnar").Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if want, got := 1010, count; want != got {
+			t.Errorf("fixture_columnar has %d rows, expected %d", got, want)
+		}
+	}
+	shared.RunCases(t, ctx, c.pool,
+		shared.Case{Name: "insert into the dataset", Run: insert},
+		shared.Case{Name: "insert into the reset dataset", Run: insert},
+	)
+}
+
+func Test_PostgresRoles(t *testing.T) {
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{
+		config: config,
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	if _, err := c.pool.Exec(ctx, "CREATE TABLE restricted USING columnar AS SELECT generate_series(1, 10) AS id"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = c.pool.Exec(context.Background(), "DROP TABLE restricted")
+	})
+
+	reader := shared.Role{Name: "reader", Password: "reader"}
+	shared.CreateRole(t, ctx, c.pool, reader)
+	pool := shared.PoolForRole(t, ctx, c.ConnSpec(), reader)
+
+	assertPermissionDenied := func(t *testing.T, sql string) {
+		t.Helper()
+
+		// 42501 is insufficient_privilege
+		var pgErr *pgconn.PgError
+		if _, err := pool.Exec(ctx, sql); !errors.As(err, &pgErr) || pgErr.Code != "42501" {
+			t.Errorf("%s should be denied, got: %v", sql, err)
+		}
+	}
+
+	assertPermissionDenied(t, "SELECT count(*) FROM restricted")
+
+	shared.Grant(t, ctx, c.pool, "restricted", reader.Name, "SELECT")
+
+	var count int
+	if err := pool.QueryRow(ctx, "SELECT count(*) FROM restricted").Scan(&count); err != nil {
+		t.Fatalf("SELECT should be granted: %s", err)
+	}
+	if count != 10 {
+		t.Errorf("expected 10 rows, got %d", count)
+	}
+	assertPermissionDenied(t, "INSERT INTO restricted VALUES (11)")
+
+	shared.Revoke(t, ctx, c.pool, "restricted", reader.Name, "SELECT")
+
+	assertPermissionDenied(t, "SELECT count(*) FROM restricted")
+
+	t.Run("app role", func(t *testing.T) {
+		pool = c.AppPool()
+
+		var superuser bool
+		if err := pool.QueryRow(ctx, "SELECT rolsuper FROM pg_roles WHERE rolname = current_user").Scan(&superuser); err != nil {
+			t.Fatal(err)
+		}
+		if superuser {
+			t.Fatalf("%s should not be a superuser", shared.AppRole.Name)
+		}
+
+		if _, err := pool.Exec(ctx, "CREATE TABLE app_columnar (id int) USING columnar; DROP TABLE app_columnar"); err != nil {
+			t.Errorf("%s should be able to create columnar tables: %s", shared.AppRole.Name, err)
+		}
+
+		assertPermissionDenied(t, "ALTER SYSTEM SET columnar.compression = 'none'")
+		assertPermissionDenied(t, "SELECT count(*) FROM restricted")
+	})
+}
+
+func Test_PostgresHBA(t *testing.T) {
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{
+		config: config,
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	blocked := shared.Role{Name: "blocked", Password: "blocked"}
+	shared.CreateRole(t, ctx, c.pool, blocked)
+	spec := c.ConnSpec().With(shared.WithUser(blocked.Name, blocked.Password), shared.WithDatabase(c.ConnSpec().Username))
+
+	connect := func(t *testing.T) error {
+		t.Helper()
+
+		_, err := shared.CreatePGConn(t, ctx, spec)
+		return err
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		shared.SetHBA(t, ctx, containerRuntime, c.pool, c.hydraContainerID(t, ctx), shared.HBAEntry{
+			Type:     "host",
+			Database: "all",
+			User:     blocked.Name,
+			Address:  "all",
+			Method:   "reject",
+		})
+
+		// 28000 is invalid_authorization_specification
+		var pgErr *pgconn.PgError
+		if err := connect(t); !errors.As(err, &pgErr) || pgErr.Code != "28000" {
+			t.Errorf("%s should be rejected, got: %v", blocked.Name, err)
+		}
+
+		if err := c.pool.Ping(ctx); err != nil {
+			t.Errorf("other roles should still connect: %s", err)
+		}
+	})
+
+	if err := connect(t); err != nil {
+		t.Errorf("%s should connect once pg_hba.conf is restored: %s", blocked.Name, err)
+	}
+}
+
+func Test_PostgresConcurrentWriters(t *testing.T) {
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{
+		config: config,
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	const writers = 16
+
+	pool, err := shared.CreatePGPool(t, ctx, c.ConnSpec().With(shared.WithPool(shared.PoolOptions{
+		MaxConns:        writers,
+		MinConns:        writers / 2,
+		MaxConnLifetime: 10 * time.Second,
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.Exec(ctx, "CREATE TABLE concurrent_writes (writer int, i int) USING columnar"); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		go func(w int) {
+			_, err := pool.Exec(ctx, "INSERT INTO concurrent_writes SELECT $1, generate_series(1, 1000)", w)
+			errs <- err
+		}(w)
+	}
+	for w := 0; w < writers; w++ {
+		if err := <-errs; err != nil {
+			t.Errorf("writer failed: %s", err)
+		}
+	}
+
+	if max := pool.Stat().MaxConns(); max != writers {
+		t.Errorf("pool should allow %d connections, got %d", writers, max)
+	}
+
+	var count int
+	if err := pool.QueryRow(ctx, "SELECT count(*) FROM concurrent_writes").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+
+	if count != writers*1000 {
+		t.Errorf("expected %d rows, got %d", writers*1000, count)
+	}
+
+	shared.RunCases(t, ctx, c.pool, shared.ConcurrentWriteCases()...)
+}
+
+func Test_PostgresPgBouncer(t *testing.T) {
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{
+		config: config,
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	// compose attaches the services to the default network of the project
+	pool := shared.StartPgBouncer(t, ctx, containerRuntime, c.project+"_default", "hydra", c.ConnSpec(), shared.PgBouncerOptions{
+		Image:  c.config.PgBouncerImage,
+		LogDir: string(c.config.ArtifactDir),
+	})
+
+	shared.RunCases(t, ctx, pool, shared.PgBouncerCases...)
+}
+
+func Test_PostgresSession(t *testing.T) {
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{
+		config: config,
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	conn, err := shared.CreatePGConn(t, ctx, c.ConnSpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sql := range []string{
+		"SET columnar.compression = 'pglz'",
+		"CREATE TEMPORARY TABLE session_columnar (id int) USING columnar",
+		"INSERT INTO session_columnar SELECT generate_series(1, 1000)",
+		"SELECT pg_advisory_lock(42)",
+	} {
+		if _, err := conn.Exec(ctx, sql); err != nil {
+			t.Fatalf("unable to execute %s: %s", sql, err)
+		}
+	}
+
+	var compression string
+	var count int
+	if err := conn.QueryRow(ctx, "SELECT current_setting('columnar.compression'), count(*) FROM session_columnar").Scan(&compression, &count); err != nil {
+		t.Fatal(err)
+	}
+	if compression != "pglz" || count != 1000 {
+		t.Errorf("session state should be kept, got compression %s and %d rows", compression, count)
+	}
+
+	var locked bool
+	if err := c.pool.QueryRow(ctx, "SELECT pg_try_advisory_lock(42)").Scan(&locked); err != nil {
+		t.Fatal(err)
+	}
+	if locked {
+		t.Errorf("advisory lock should be held by the session")
+	}
+}
+
+func Test_PostgresNotify(t *testing.T) {
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{
+		config: config,
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	listener, err := shared.CreatePGConn(t, ctx, c.ConnSpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared.Listen(t, ctx, listener, "columnar_loaded")
+
+	if _, err := c.pool.Exec(ctx, `
+CREATE TABLE notify_columnar (id int) USING columnar;
+CREATE FUNCTION notify_columnar_loaded() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+	PERFORM pg_notify('columnar_loaded', TG_TABLE_NAME);
+	RETURN NULL;
+END;
+$$;
+CREATE TRIGGER notify_columnar_loaded AFTER INSERT ON notify_columnar
+	FOR EACH STATEMENT EXECUTE FUNCTION notify_columnar_loaded();
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.pool.Exec(ctx, "INSERT INTO notify_columnar SELECT generate_series(1, 1000000)"); err != nil {
+		t.Fatal(err)
+	}
+	shared.AssertNotification(t, ctx, listener, "columnar_loaded", "notify_columnar", 10*time.Second)
+
+	// notifications are only delivered once the transaction commits
+	tx, err := c.pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(ctx, "INSERT INTO notify_columnar SELECT generate_series(1, 1000); NOTIFY columnar_loaded, 'committed'"); err != nil {
+		t.Fatal(err)
+	}
+	if n := shared.WaitForNotification(t, ctx, listener, time.Second); n != nil {
+		t.Errorf("notification should not be delivered before commit, got %s on %s", n.Payload, n.Channel)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	shared.AssertNotification(t, ctx, listener, "columnar_loaded", "committed", 10*time.Second)
+}
+
+func Test_PostgresCopy(t *testing.T) {
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{
+		config: config,
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	if _, err := c.pool.Exec(ctx, "CREATE TABLE copy_columnar (id int, t text) USING columnar"); err != nil {
+		t.Fatal(err)
+	}
+
+	const rows = 200000
+
+	data := bytes.NewBuffer(nil)
+	for i := 1; i <= rows; i++ {
+		fmt.Fprintf(data, "%d\trow %d\n", i, i)
+	}
+
+	loaded, loadedChecksum := shared.CopyFromText(t, ctx, c.pool, "copy_columnar", data)
+	if loaded != rows {
+		t.Errorf("expected %d rows to be loaded, got %d", rows, loaded)
+	}
+
+	exported, exportedChecksum := shared.CopyTo(t, ctx, c.pool, "SELECT * FROM copy_columnar ORDER BY id", nil)
+	if exported != rows {
+		t.Errorf("expected %d rows to be exported, got %d", rows, exported)
+	}
+	if loadedChecksum != exportedChecksum {
+		t.Errorf("exported rows should match the loaded rows: loaded=%s exported=%s", loadedChecksum, exportedChecksum)
+	}
+
+	if n := shared.CopyFrom(t, ctx, c.pool, "copy_columnar", []string{"id", "t"}, pgx.CopyFromRows([][]any{
+		{int32(rows + 1), "binary"},
+		{int32(rows + 2), nil},
+	})); n != 2 {
+		t.Errorf("expected 2 rows to be copied, got %d", n)
+	}
+}
+
+func Test_PostgresSettings(t *testing.T) {
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{
+		config: config,
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	setting := func(t *testing.T, name string) string {
+		t.Helper()
+
+		var value string
+		if err := c.pool.QueryRow(ctx, "SELECT current_setting($1)", name).Scan(&value); err != nil {
+			t.Fatal(err)
+		}
+
+		return value
+	}
+
+	compression, workMem := setting(t, "columnar.compression"), setting(t, "work_mem")
+
+	t.Run("set", func(t *testing.T) {
+		shared.SetGUC(t, ctx, c.pool, "columnar.compression", "pglz")
+		shared.AlterSystemSet(t, ctx, c.pool, "work_mem", "12MB")
+
+		if value := setting(t, "columnar.compression"); value != "pglz" {
+			t.Errorf("columnar.compression should be set for new sessions, got %s", value)
+		}
+
+		// the reload is signaled to the sessions asynchronously
+		deadline := time.Now().Add(5 * time.Second)
+		for setting(t, "work_mem") != "12MB" {
+			if time.Now().After(deadline) {
+				t.Fatalf("work_mem should be set after the reload, got %s", setting(t, "work_mem"))
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	})
+
+	if value := setting(t, "columnar.compression"); value != compression {
+		t.Errorf("columnar.compression should be restored to %s, got %s", compression, value)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for setting(t, "work_mem") != workMem {
+		if time.Now().After(deadline) {
+			t.Fatalf("work_mem should be restored to %s, got %s", workMem, setting(t, "work_mem"))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func Test_PostgresCancellation(t *testing.T) {
+	ctx := shared.Context(t)
+
+	c := postgresAcceptanceCompose{
+		config: config,
+	}
+	t.Cleanup(func() {
+		c.TerminateCompose(t, ctx, true)
+	})
+	c.StartCompose(t, ctx, c.Image(), false)
+
+	if _, err := c.pool.Exec(ctx, `
+CREATE TABLE cancel_columnar (i int, t text) USING columnar;
+INSERT INTO cancel_columnar SELECT g, md5(g::text) FROM generate_series(1, 100000) g;
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	// a columnar scan that runs far longer than any of the tests
+	const sql = "SELECT count(*) FROM cancel_columnar a, cancel_columnar b WHERE a.t < b.t"
+
+	t.Run("cancel request", func(t *testing.T) {
+		shared.CheckLeaks(t, c.pool)
+		q := shared.StartQuery(t, ctx, c.pool, sql)
+		q.CancelRequest(t, ctx)
+		shared.AssertSQLState(t, q.Wait(t, 10*time.Second), shared.SQLStateQueryCanceled)
+	})
+
+	t.Run("pg_cancel_backend", func(t *testing.T) {
+		shared.CheckLeaks(t, c.pool)
+		q := shared.StartQuery(t, ctx, c.pool, sql)
+		q.CancelBackend(t, ctx, c.pool)
+		shared.AssertSQLState(t, q.Wait(t, 10*time.Second), shared.SQLStateQueryCanceled)
+	})
+
+	t.Run("context", func(t *testing.T) {
+		shared.CheckLeaks(t, c.pool)
+		q := shared.StartQuery(t, ctx, c.pool, sql)
+		q.CancelContext()
+		if err := q.Wait(t, 10*time.Second); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got: %v", err)
+		}
+	})
+
+	t.Run("statement_timeout", func(t *testing.T) {
+		pool, err := shared.CreatePGPool(t, ctx, c.ConnSpec().With(shared.WithParam("statement_timeout", "500")))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = pool.Exec(ctx, sql)
+		shared.AssertSQLState(t, err, shared.SQLStateQueryCanceled)
+	})
+
+	// the canceled scans must not leave the table unreadable
+	var count int
+	if err := c.pool.QueryRow(ctx, "SELECT count(*) FROM cancel_columnar").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 100000 {
+		t.Errorf("expected 100000 rows after the cancellations, got %d", count)
+	}
+}
+
+func Test_PostgresUpgrade(t *testing.T) {
+	shared.SkipUnlessTagged(t, shared.TagColumnar, shared.TagDestructive)
+
+	shared.RunConfigurations(t, suiteFile, "postgres", func(t *testing.T, sc shared.SuiteConfig) {
+		cfg := config.with(sc)
+
+		c := postgresAcceptanceCompose{
+			config:  cfg,
+			options: shared.ContainerOptions{Settings: sc.Settings},
+		}
 
-	shared.RunUpgradeTests(t, context.Background(), &c)
+		shared.RunUpgradeTests(t, shared.Context(t), &c)
+	})
 }
@@ -0,0 +1,69 @@
+package shared
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// abortTimeout bounds the time spent cleaning up after an interrupt, so that
+// a hung runtime does not keep the test binary alive.
+const abortTimeout = time.Minute
+
+var (
+	abortMu       sync.Mutex
+	abortHandlers = map[string]func(ctx context.Context) error{}
+	abortOnce     sync.Once
+)
+
+// onAbort registers fn under key to run when the test binary receives SIGINT
+// or SIGTERM, e.g. when go test is interrupted or times out in CI, in which
+// case no test cleanup runs. Registering under an existing key replaces its
+// handler.
+func onAbort(key string, fn func(ctx context.Context) error) {
+	abortOnce.Do(func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+		go func() {
+			sig := <-signals
+			// a second signal terminates the binary right away
+			signal.Reset(os.Interrupt, syscall.SIGTERM)
+
+			Logger.Warn("saving logs and removing containers", "signal", sig)
+			runAbortHandlers()
+			os.Exit(1)
+		}()
+	})
+
+	abortMu.Lock()
+	defer abortMu.Unlock()
+
+	abortHandlers[key] = fn
+}
+
+// clearAbort removes the handler registered under key, once the resources it
+// cleans up are gone.
+func clearAbort(key string) {
+	abortMu.Lock()
+	defer abortMu.Unlock()
+
+	delete(abortHandlers, key)
+}
+
+func runAbortHandlers() {
+	ctx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+
+	abortMu.Lock()
+	defer abortMu.Unlock()
+
+	for key, fn := range abortHandlers {
+		if err := fn(ctx); err != nil {
+			Logger.Error("unable to clean up", "project", key, "err", err)
+		}
+	}
+}
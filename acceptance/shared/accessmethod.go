@@ -0,0 +1,190 @@
+package shared
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// conversionTable creates and loads the heap table %[1]s converted by the
+// AccessMethodCases, with %[1]s_checksum the checksum of its rows and
+// %[1]s_sizes its size for each access method it was converted to. Its rows
+// are repetitive, so that they compress well as columnar.
+const conversionTable = `
+CREATE TABLE %[1]s (id INT8 PRIMARY KEY, grp INT4, val TEXT) USING heap;
+CREATE INDEX %[1]s_grp ON %[1]s (grp);
+INSERT INTO %[1]s SELECT i, i %% 100, 'value of group ' || i %% 100 FROM generate_series(1, 50000) i;
+CREATE TABLE %[1]s_checksum AS SELECT md5(string_agg(concat_ws(',', id, grp, val), ';' ORDER BY id)) AS checksum FROM %[1]s;
+CREATE TABLE %[1]s_sizes AS SELECT 'heap'::text AS amname, pg_relation_size('%[1]s') AS size;
+`
+
+// conversionState returns the state of the converted table %[1]s: its access
+// method, whether its rows match their checksum before the first conversion,
+// its valid indexes, %[2]s, e.g. the rows of a view of the table, and whether
+// its size changed as expected since the last conversion, smaller as columnar
+// and larger as heap.
+const conversionState = `
+SELECT am.amname::text,
+       (SELECT md5(string_agg(concat_ws(',', id, grp, val), ';' ORDER BY id)) FROM %[1]s) = (SELECT checksum FROM %[1]s_checksum),
+       (SELECT count(*) FROM pg_index WHERE indrelid = c.oid AND indisvalid),
+       %[2]s,
+       CASE am.amname
+           WHEN 'columnar' THEN pg_relation_size(c.oid) < (SELECT size FROM %[1]s_sizes WHERE amname = 'heap')
+           ELSE pg_relation_size(c.oid) > (SELECT size FROM %[1]s_sizes WHERE amname = 'columnar')
+       END
+FROM pg_class c JOIN pg_am am ON am.oid = c.relam
+WHERE c.oid = '%[1]s'::regclass;
+`
+
+// conversionSize records the size of the converted table %[1]s as its current
+// access method.
+const conversionSize = `
+INSERT INTO %[1]s_sizes
+SELECT am.amname, pg_relation_size(c.oid) FROM pg_class c JOIN pg_am am ON am.oid = c.relam WHERE c.oid = '%[1]s'::regclass;
+`
+
+// AccessMethodCases describe the conversion of populated tables from heap to
+// columnar and back, with ALTER TABLE ... SET ACCESS METHOD as of Postgres 15,
+// which keeps the views depending on the table, and with
+// columnar.alter_table_set_access_method, which recreates the table and only
+// supports tables without dependent views. The rows, indexes and views of the
+// tables must be intact after each conversion, and their size must shrink as
+// columnar.
+var AccessMethodCases = []Case{
+	{
+		Name:             "create heap table to alter access method",
+		Tags:             []string{TagColumnar},
+		TargetPGVersions: []PGVersion{PGVersion15, PGVersion16},
+		SQL: fmt.Sprintf(conversionTable, "am_alter") + `
+CREATE VIEW am_alter_view AS SELECT grp, count(*) AS n FROM am_alter GROUP BY grp;
+			`,
+	},
+	{
+		Name:             "alter access method of heap table to columnar",
+		Tags:             []string{TagColumnar},
+		TargetPGVersions: []PGVersion{PGVersion15, PGVersion16},
+		SQL: `
+ALTER TABLE am_alter SET ACCESS METHOD columnar;
+			`,
+	},
+	{
+		Name:             "table altered to columnar intact",
+		Tags:             []string{TagColumnar},
+		TargetPGVersions: []PGVersion{PGVersion15, PGVersion16},
+		SQL:              fmt.Sprintf(conversionState, "am_alter", "(SELECT sum(n)::int8 FROM am_alter_view)"),
+		Validate:         validateConversion("columnar", 2, 50000),
+	},
+	{
+		Name:             "alter access method of columnar table to heap",
+		Tags:             []string{TagColumnar},
+		TargetPGVersions: []PGVersion{PGVersion15, PGVersion16},
+		SQL: fmt.Sprintf(conversionSize, "am_alter") + `
+ALTER TABLE am_alter SET ACCESS METHOD heap;
+			`,
+	},
+	{
+		Name:             "table altered to heap intact",
+		Tags:             []string{TagColumnar},
+		TargetPGVersions: []PGVersion{PGVersion15, PGVersion16},
+		SQL:              fmt.Sprintf(conversionState, "am_alter", "(SELECT sum(n)::int8 FROM am_alter_view)"),
+		Validate:         validateConversion("heap", 2, 50000),
+	},
+	{
+		Name:             "drop table altered access method",
+		Tags:             []string{TagColumnar},
+		TargetPGVersions: []PGVersion{PGVersion15, PGVersion16},
+		SQL: `
+DROP TABLE am_alter CASCADE;
+DROP TABLE am_alter_checksum, am_alter_sizes;
+			`,
+	},
+	{
+		Name: "create heap table to convert access method",
+		Tags: []string{TagColumnar},
+		SQL:  fmt.Sprintf(conversionTable, "am_convert"),
+	},
+	{
+		Name: "convert heap table to columnar",
+		Tags: []string{TagColumnar},
+		SQL: `
+SELECT columnar.alter_table_set_access_method('am_convert', 'columnar');
+			`,
+		Validate: validateConverted,
+	},
+	{
+		Name:     "table converted to columnar intact",
+		Tags:     []string{TagColumnar},
+		SQL:      fmt.Sprintf(conversionState, "am_convert", "(SELECT count(*) FROM am_convert WHERE grp = 7)"),
+		Validate: validateConversion("columnar", 2, 500),
+	},
+	{
+		Name: "record size of converted columnar table",
+		Tags: []string{TagColumnar},
+		SQL:  fmt.Sprintf(conversionSize, "am_convert"),
+	},
+	{
+		Name: "convert columnar table to heap",
+		Tags: []string{TagColumnar},
+		SQL: `
+SELECT columnar.alter_table_set_access_method('am_convert', 'heap');
+			`,
+		Validate: validateConverted,
+	},
+	{
+		Name:     "table converted to heap intact",
+		Tags:     []string{TagColumnar},
+		SQL:      fmt.Sprintf(conversionState, "am_convert", "(SELECT count(*) FROM am_convert WHERE grp = 7)"),
+		Validate: validateConversion("heap", 2, 500),
+	},
+	{
+		Name: "drop table converted access method",
+		Tags: []string{TagColumnar},
+		SQL: `
+DROP TABLE am_convert, am_convert_checksum, am_convert_sizes;
+			`,
+	},
+}
+
+// validateConverted validates that columnar.alter_table_set_access_method
+// converted the table, as it only warns if it could not.
+func validateConverted(t *testing.T, row pgx.Row) {
+	var converted bool
+	if err := row.Scan(&converted); err != nil {
+		t.Fatal(err)
+	}
+
+	if !converted {
+		t.Error("table should be converted, see the warnings of alter_table_set_access_method")
+	}
+}
+
+// validateConversion returns a validation of the conversionState of a table
+// converted to am, with indexes its valid indexes and rows the value of the
+// rows expression.
+func validateConversion(am string, indexes, rows int64) func(t *testing.T, row pgx.Row) {
+	return func(t *testing.T, row pgx.Row) {
+		var gotAM string
+		var intact, resized bool
+		var gotIndexes, gotRows int64
+		if err := row.Scan(&gotAM, &intact, &gotIndexes, &gotRows, &resized); err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := am, gotAM; want != got {
+			t.Errorf("access method should match: want=%s got=%s", want, got)
+		}
+		if !intact {
+			t.Error("rows should match their checksum before the conversion")
+		}
+		if want, got := indexes, gotIndexes; want != got {
+			t.Errorf("valid index count should match: want=%d got=%d", want, got)
+		}
+		if want, got := rows, gotRows; want != got {
+			t.Errorf("rows should match: want=%d got=%d", want, got)
+		}
+		if !resized {
+			t.Errorf("size as %s should have changed since the conversion", am)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ErrInvalidLogDir is used when a directory cannot keep the logs and other
+// artifacts of the tests.
+var ErrInvalidLogDir = errors.New("invalid log directory")
+
+// A LogDir is the root directory of the logs and other artifacts of the tests,
+// see [TestArtifactDir]. A relative path is relative to the root of the
+// repository rather than to the directory of the suite that go test runs the
+// tests in. The zero value keeps no artifacts.
+type LogDir string
+
+// NewLogDir validates dir with [ValidateContainerLogDir] and returns it as a
+// LogDir, absolute if it is included.
+func NewLogDir(dir string) (LogDir, error) {
+	if err := ValidateContainerLogDir(dir); err != nil {
+		return "", err
+	}
+
+	abs, err := resolveLogDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	return LogDir(abs), nil
+}
+
+// Decode implements envdecode.Decoder to resolve a relative ARTIFACT_DIR. An
+// invalid directory is kept as is and reported by [EnvConfig.Validate].
+func (d *LogDir) Decode(s string) error {
+	dir, err := NewLogDir(s)
+	if err != nil {
+		dir = LogDir(s)
+	}
+	*d = dir
+
+	return nil
+}
+
+// ValidateContainerLogDir reports whether dir can keep the logs of the
+// containers and the other artifacts of the tests. It is valid if it is empty,
+// or an absolute path or a path relative to the root of the repository that is
+// not an existing file, see [LogDir].
+func ValidateContainerLogDir(dir string) error {
+	abs, err := resolveLogDir(dir)
+	if err != nil || abs == "" {
+		return err
+	}
+
+	if fi, err := os.Stat(abs); err == nil && !fi.IsDir() {
+		return fmt.Errorf("%w: %s is not a directory", ErrInvalidLogDir, abs)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %w", ErrInvalidLogDir, err)
+	}
+
+	return nil
+}
+
+// resolveLogDir returns dir resolved against the root of the repository if it
+// is relative.
+func resolveLogDir(dir string) (string, error) {
+	if dir == "" || filepath.IsAbs(dir) {
+		return filepath.Clean(dir), nil
+	}
+
+	root, err := repoRoot()
+	if err != nil {
+		return "", fmt.Errorf("%w: unable to resolve %s: %w", ErrInvalidLogDir, dir, err)
+	}
+
+	return filepath.Join(root, dir), nil
+}
+
+// repoRoot returns the root of the repository, the parent of the acceptance
+// module that contains the working directory of the tests.
+func repoRoot() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		b, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+		if err == nil && strings.HasPrefix(string(b), "module github.com/hydradatabase/hydra/acceptance\n") {
+			return filepath.Dir(dir), nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("not in the acceptance module")
+		}
+		dir = parent
+	}
+}
+
+// TestArtifactDir returns the directory below root that keeps the artifacts of
+// t, root/<TestName>, creating it if needed. Subtests are nested below the
+// directory of their parent. It returns an empty string if root is empty, in
+// which case no artifacts are kept.
+func TestArtifactDir(t *testing.T, root string) string {
+	t.Helper()
+
+	return mkArtifactDir(t, artifactDir(root, t.Name(), ""))
+}
+
+// ContainerArtifactDir returns the directory below root that keeps the
+// artifacts of container in t, root/<TestName>/<container>, e.g. its logs and
+// inspect output, creating it if needed. It returns an empty string if root is
+// empty.
+func ContainerArtifactDir(t *testing.T, root, container string) string {
+	t.Helper()
+
+	return mkArtifactDir(t, artifactDir(root, t.Name(), container))
+}
+
+// WriteArtifact writes data as the artifact name of t, e.g. an SQL trace or a
+// query plan, to the [TestArtifactDir] below root. Nothing is written if root
+// is empty.
+func WriteArtifact(t *testing.T, root, name string, data []byte) {
+	t.Helper()
+
+	dir := TestArtifactDir(t, root)
+	if dir == "" {
+		return
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		t.Fatalf("unable to write artifact %s: %s", name, err)
+	}
+}
+
+// artifactDir returns the artifact directory of container in the test called
+// testName, or of the test itself if container is empty.
+func artifactDir(root, testName, container string) string {
+	if root == "" {
+		return ""
+	}
+
+	elems := []string{root}
+	for _, name := range strings.Split(testName, "/") {
+		elems = append(elems, unsafeFileChars.ReplaceAllString(name, "_"))
+	}
+	if container != "" {
+		elems = append(elems, unsafeFileChars.ReplaceAllString(container, "_"))
+	}
+
+	return filepath.Join(elems...)
+}
+
+func mkArtifactDir(t *testing.T, dir string) string {
+	t.Helper()
+
+	if dir == "" {
+		return ""
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("unable to create artifact directory %s: %s", dir, err)
+	}
+
+	return dir
+}
@@ -0,0 +1,85 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// The authentication methods of host connections a postgres container can be
+// started with, see [ContainerOptions.AuthMethod] and [RunAuthTests].
+const (
+	AuthMethodTrust = "trust"
+	AuthMethodMD5   = "md5"
+	AuthMethodSCRAM = "scram-sha-256"
+)
+
+// RunAuthTests starts cm, which must have been configured to authenticate host
+// connections with method, and verifies that connecting succeeds or fails as
+// expected for it: trust accepts any password, md5 accepts passwords stored
+// with md5 or SCRAM, and scram-sha-256 only accepts passwords stored with
+// SCRAM.
+func RunAuthTests(t *testing.T, ctx context.Context, cm DockerComposeManager, method string) {
+	t.Cleanup(func() {
+		cm.TerminateCompose(t, ctx, true)
+	})
+	cm.StartCompose(t, ctx, cm.Image(), false)
+
+	md5 := Role{Name: "md5_user", Password: "md5_password", PasswordEncryption: "md5"}
+	scram := Role{Name: "scram_user", Password: "scram_password", PasswordEncryption: "scram-sha-256"}
+	CreateRole(t, ctx, cm.PGPool(), md5)
+	CreateRole(t, ctx, cm.PGPool(), scram)
+
+	// the roles connect to the database of the suite rather than their own
+	spec := cm.ConnSpec()
+	database := spec.Database
+	if database == "" {
+		database = spec.Username
+	}
+
+	for _, c := range []struct {
+		name    string
+		spec    ConnSpec
+		succeed bool
+	}{
+		{
+			name:    "correct password",
+			spec:    spec,
+			succeed: true,
+		},
+		{
+			name:    "wrong password",
+			spec:    spec.With(WithUser(spec.Username, "wrong")),
+			succeed: method == AuthMethodTrust,
+		},
+		{
+			name:    "password stored with md5",
+			spec:    spec.With(WithUser(md5.Name, md5.Password), WithDatabase(database)),
+			succeed: method != AuthMethodSCRAM,
+		},
+		{
+			name:    "password stored with scram-sha-256",
+			spec:    spec.With(WithUser(scram.Name, scram.Password), WithDatabase(database)),
+			succeed: true,
+		},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			_, err := CreatePGPool(t, ctx, c.spec)
+			if c.succeed {
+				if err != nil {
+					t.Errorf("connecting with %s should succeed: %s", method, err)
+				}
+				return
+			}
+
+			// 28P01 is invalid_password
+			var pgErr *pgconn.PgError
+			if !errors.As(err, &pgErr) || pgErr.Code != "28P01" {
+				t.Errorf("connecting with %s should fail with an invalid password, got: %v", method, err)
+			}
+		})
+	}
+}
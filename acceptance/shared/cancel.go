@@ -0,0 +1,132 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SQLStateQueryCanceled is the SQLSTATE query_canceled of queries canceled by
+// a cancel request, pg_cancel_backend or statement_timeout.
+const SQLStateQueryCanceled = "57014"
+
+// A RunningQuery is a query started with [StartQuery].
+type RunningQuery struct {
+	PID    uint32 // process ID of the backend running the query
+	conn   *pgconn.PgConn
+	cancel context.CancelFunc
+	done   chan error
+}
+
+// StartQuery starts running sql on a connection of pool in the background,
+// e.g. a long-running scan of a columnar table, and returns once the query is
+// active on the server.
+func StartQuery(t *testing.T, ctx context.Context, pool *pgxpool.Pool, sql string) *RunningQuery {
+	t.Helper()
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("unable to acquire connection: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	q := &RunningQuery{
+		PID:    conn.Conn().PgConn().PID(),
+		conn:   conn.Conn().PgConn(),
+		cancel: cancel,
+		done:   make(chan error, 1),
+	}
+
+	go func() {
+		defer conn.Release()
+
+		_, err := conn.Exec(ctx, sql)
+		q.done <- err
+	}()
+
+	t.Cleanup(func() {
+		// the query may still be running if the test failed
+		cancel()
+		<-q.done
+	})
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var active bool
+		if err := pool.QueryRow(ctx, "SELECT state = 'active' FROM pg_stat_activity WHERE pid = $1", q.PID).Scan(&active); err != nil {
+			t.Fatalf("unable to query the state of %d: %s", q.PID, err)
+		}
+		if active {
+			return q
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("query did not start on %d: %s", q.PID, sql)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// CancelContext cancels the context of the query. pgx does not wait for the
+// server and closes the connection instead, so the query fails with
+// context.Canceled rather than an error of the server.
+func (q *RunningQuery) CancelContext() {
+	q.cancel()
+}
+
+// CancelRequest cancels the query with a cancel request of the protocol, as
+// sent by psql on Ctrl+C.
+func (q *RunningQuery) CancelRequest(t *testing.T, ctx context.Context) {
+	t.Helper()
+
+	if err := q.conn.CancelRequest(ctx); err != nil {
+		t.Fatalf("unable to send cancel request to %d: %s", q.PID, err)
+	}
+}
+
+// CancelBackend cancels the query with pg_cancel_backend using pool.
+func (q *RunningQuery) CancelBackend(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+	t.Helper()
+
+	var canceled bool
+	if err := pool.QueryRow(ctx, "SELECT pg_cancel_backend($1)", q.PID).Scan(&canceled); err != nil {
+		t.Fatalf("unable to cancel %d: %s", q.PID, err)
+	}
+	if !canceled {
+		t.Fatalf("backend %d is not running", q.PID)
+	}
+}
+
+// Wait waits up to timeout for the query to finish and returns its error.
+func (q *RunningQuery) Wait(t *testing.T, timeout time.Duration) error {
+	t.Helper()
+
+	select {
+	case err := <-q.done:
+		// the error is kept for the cleanup
+		q.done <- err
+		return err
+	case <-time.After(timeout):
+		t.Fatalf("query on %d did not finish within %s", q.PID, timeout)
+		return nil
+	}
+}
+
+// AssertSQLState fails the test unless err is an error of the server with the
+// SQLSTATE code, e.g. [SQLStateQueryCanceled].
+func AssertSQLState(t *testing.T, err error, code string) {
+	t.Helper()
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		t.Fatalf("expected an error with SQLSTATE %s, got: %v", code, err)
+	}
+
+	if pgErr.Code != code {
+		t.Errorf("expected SQLSTATE %s, got %s: %s", code, pgErr.Code, pgErr.Message)
+	}
+}
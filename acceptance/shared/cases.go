@@ -27,50 +27,80 @@ type Case struct {
 	Name             string                          // name of the test
 	SQL              string                          // SQL to run during the test
 	Validate         func(t *testing.T, row pgx.Row) // optional validation function
+	Settings         map[string]string               // optional settings applied to the case only, e.g. max_parallel_workers_per_gather
 	Skip             bool                            // whether this case should be skipped
 	TargetPGVersions []PGVersion                     // target PG version
+	Tags             []string                        // tags selecting the case with a TagFilter, e.g. TagSmoke
+	Run              CaseFunc                        // optional function run instead of the SQL, without the Settings, see RegisterCase
 }
 
-// AcceptanceCases describe the shared acceptance criteria for any Hydra-based
-// images.
-func AcceptanceCases() []Case {
-	cases := []Case{
-		// columnar
+// casesOf returns the case of each of xs, e.g. of the fixtures of a kind of
+// test, in order.
+func casesOf[T interface{ Case() Case }](xs ...T) []Case {
+	cases := make([]Case, 0, len(xs))
+	for _, x := range xs {
+		cases = append(cases, x.Case())
+	}
+
+	return cases
+}
+
+// AcceptanceCaseGroups describe the shared acceptance criteria for any
+// Hydra-based images, grouped by the extension they cover. The cases of a
+// group depend on each other. The groups creating extensions and foreign
+// servers are Serial, as those are shared by the whole database, see
+// [RunCaseGroups].
+func AcceptanceCaseGroups() []CaseGroup {
+	var (
+		awsAccessKey             = os.Getenv("TEST_AWS_ACCESS_KEY_ID")
+		awsSecretKey             = os.Getenv("TEST_AWS_SECRET_ACCESS_KEY")
+		awsRegion                = os.Getenv("TEST_AWS_REGION")
+		awsS3Bucket              = os.Getenv("TEST_AWS_S3_BUCKET")
+		shouldSkipParquetS3Tests = awsAccessKey == "" || awsSecretKey == "" || awsRegion == "" || awsS3Bucket == ""
+	)
+
+	return []CaseGroup{
 		{
-			Name: "columnar ext available",
-			SQL: `
+			Name: "columnar",
+			Cases: []Case{
+				{
+					Name: "columnar ext available",
+					Tags: []string{TagSmoke, TagColumnar},
+					SQL: `
 SELECT count(1) FROM pg_available_extensions WHERE name = 'columnar';
 			`,
-			Validate: func(t *testing.T, row pgx.Row) {
-				var count int
-				if err := row.Scan(&count); err != nil {
-					t.Fatal(err)
-				}
-
-				if want, got := 1, count; want != got {
-					t.Error("columnar ext should exist")
-				}
-			},
-		},
-		{
-			Name: "columnar ext enabled",
-			SQL: `
+					Validate: func(t *testing.T, row pgx.Row) {
+						var count int
+						if err := row.Scan(&count); err != nil {
+							t.Fatal(err)
+						}
+
+						if want, got := 1, count; want != got {
+							t.Error("columnar ext should exist")
+						}
+					},
+				},
+				{
+					Name: "columnar ext enabled",
+					Tags: []string{TagSmoke, TagColumnar},
+					SQL: `
 SELECT count(1) FROM pg_extension WHERE extname = 'columnar';
 			`,
-			Validate: func(t *testing.T, row pgx.Row) {
-				var count int
-				if err := row.Scan(&count); err != nil {
-					t.Fatal(err)
-				}
-
-				if want, got := 1, count; want != got {
-					t.Error("columnar ext should exist")
-				}
-			},
-		},
-		{
-			Name: "using a columnar table",
-			SQL: `
+					Validate: func(t *testing.T, row pgx.Row) {
+						var count int
+						if err := row.Scan(&count); err != nil {
+							t.Fatal(err)
+						}
+
+						if want, got := 1, count; want != got {
+							t.Error("columnar ext should exist")
+						}
+					},
+				},
+				{
+					Name: "using a columnar table",
+					Tags: []string{TagSmoke, TagColumnar},
+					SQL: `
 CREATE TABLE my_columnar_table
 (
     id INT,
@@ -80,10 +110,11 @@ CREATE TABLE my_columnar_table
     t TEXT
 ) USING columnar;
 			`,
-		},
-		{
-			Name: "convert between row and columnar",
-			SQL: `
+				},
+				{
+					Name: "convert between row and columnar",
+					Tags: []string{TagSmoke, TagColumnar},
+					SQL: `
 		CREATE TABLE my_table(i INT8 DEFAULT '7');
 		INSERT INTO my_table VALUES(1);
 		-- convert to columnar
@@ -91,18 +122,20 @@ CREATE TABLE my_columnar_table
 		-- back to row
 		SELECT columnar.alter_table_set_access_method('my_table', 'heap');
 		`,
-		},
-		{
-			Name: "convert by copying",
-			SQL: `
+				},
+				{
+					Name: "convert by copying",
+					Tags: []string{TagSmoke, TagColumnar},
+					SQL: `
 CREATE TABLE table_heap (i INT8);
 CREATE TABLE table_columnar (LIKE table_heap) USING columnar;
 INSERT INTO table_columnar SELECT * FROM table_heap;
 			`,
-		},
-		{
-			Name: "partition",
-			SQL: `
+				},
+				{
+					Name: "partition",
+					Tags: []string{TagSmoke, TagColumnar},
+					SQL: `
 CREATE TABLE parent(ts timestamptz, i int, n numeric, s text)
   PARTITION BY RANGE (ts);
 
@@ -126,58 +159,64 @@ CREATE INDEX p2_ts_idx ON p2 (ts);
 CREATE UNIQUE INDEX p2_i_unique ON p2 (i);
 ALTER TABLE p2 ADD UNIQUE (n);
 			`,
-		},
-		{
-			Name: "options",
-			SQL: `
+				},
+				{
+					Name: "options",
+					Tags: []string{TagSmoke, TagColumnar},
+					SQL: `
 SELECT columnar.alter_columnar_table_set(
     'my_columnar_table',
     compression => 'none',
     stripe_row_limit => 10000);
 			`,
+				},
+			},
 		},
-		// mysql_fdw
 		{
-			Name: "mysql_fdw available",
-			SQL: `
+			Name:   "mysql_fdw",
+			Serial: true,
+			Cases: []Case{
+				{
+					Name: "mysql_fdw available",
+					SQL: `
 SELECT count(1) FROM pg_available_extensions WHERE name = 'mysql_fdw';
 			`,
-			Validate: func(t *testing.T, row pgx.Row) {
-				var count int
-				if err := row.Scan(&count); err != nil {
-					t.Fatal(err)
-				}
-
-				if want, got := 1, count; want != got {
-					t.Errorf("columnar ext should exist")
-				}
-			},
-		},
-		{
-			Name: "enable mysql_fdw ext",
-			SQL: `
+					Validate: func(t *testing.T, row pgx.Row) {
+						var count int
+						if err := row.Scan(&count); err != nil {
+							t.Fatal(err)
+						}
+
+						if want, got := 1, count; want != got {
+							t.Errorf("columnar ext should exist")
+						}
+					},
+				},
+				{
+					Name: "enable mysql_fdw ext",
+					SQL: `
 CREATE EXTENSION mysql_fdw;
 			`,
-		},
-		{
-			Name: "mysql_fdw enabled",
-			SQL: `
+				},
+				{
+					Name: "mysql_fdw enabled",
+					SQL: `
 SELECT count(1) FROM pg_extension WHERE extname = 'mysql_fdw';
 			`,
-			Validate: func(t *testing.T, row pgx.Row) {
-				var count int
-				if err := row.Scan(&count); err != nil {
-					t.Fatal(err)
-				}
-
-				if want, got := 1, count; want != got {
-					t.Errorf("columnar ext should exist")
-				}
-			},
-		},
-		{
-			Name: "create mysql_fdw foreign table",
-			SQL: `
+					Validate: func(t *testing.T, row pgx.Row) {
+						var count int
+						if err := row.Scan(&count); err != nil {
+							t.Fatal(err)
+						}
+
+						if want, got := 1, count; want != got {
+							t.Errorf("columnar ext should exist")
+						}
+					},
+				},
+				{
+					Name: "create mysql_fdw foreign table",
+					SQL: `
 CREATE SERVER mysql_server
 	FOREIGN DATA WRAPPER mysql_fdw
 	OPTIONS (host 'mysql', port '3306');
@@ -195,86 +234,91 @@ CREATE FOREIGN TABLE warehouse
 	SERVER mysql_server
 	OPTIONS (dbname 'test', table_name 'warehouse');
 			`,
-		},
-		{
-			Name: "insert data to mysql_fdw foreign table",
-			SQL: `
+				},
+				{
+					Name: "insert data to mysql_fdw foreign table",
+					SQL: `
 INSERT INTO warehouse values (1, 'UPS', current_date);
 INSERT INTO warehouse values (2, 'TV', current_date);
 INSERT INTO warehouse values (3, 'Table', current_date);
 		`,
-		},
-		{
-			Name: "validate mysql_fdw foreign table",
-			SQL: `
+				},
+				{
+					Name: "validate mysql_fdw foreign table",
+					SQL: `
 SELECT * FROM warehouse ORDER BY warehouse_id LIMIT 1;
 		`,
-			Validate: func(t *testing.T, row pgx.Row) {
-				var result struct {
-					WarehouseID      int
-					WarehouseName    string
-					WarehouseCreated time.Time
-				}
-				if err := row.Scan(&result.WarehouseID, &result.WarehouseName, &result.WarehouseCreated); err != nil {
-					t.Fatal(err)
-				}
-
-				if want, got := 1, result.WarehouseID; want != got {
-					t.Errorf("warehouse ID should equal")
-				}
-				if want, got := "UPS", result.WarehouseName; want != got {
-					t.Errorf("warehouse name should equal")
-				}
-				if result.WarehouseCreated.IsZero() {
-					t.Errorf("warehouse created time should not be zero value")
-				}
+					Validate: func(t *testing.T, row pgx.Row) {
+						var result struct {
+							WarehouseID      int
+							WarehouseName    string
+							WarehouseCreated time.Time
+						}
+						if err := row.Scan(&result.WarehouseID, &result.WarehouseName, &result.WarehouseCreated); err != nil {
+							t.Fatal(err)
+						}
+
+						if want, got := 1, result.WarehouseID; want != got {
+							t.Errorf("warehouse ID should equal")
+						}
+						if want, got := "UPS", result.WarehouseName; want != got {
+							t.Errorf("warehouse name should equal")
+						}
+						if result.WarehouseCreated.IsZero() {
+							t.Errorf("warehouse created time should not be zero value")
+						}
+					},
+				},
 			},
 		},
-		// multicorn
 		{
-			Name: "multicorn ext available",
-			SQL: `
+			Name:   "multicorn",
+			Serial: true,
+			Cases: []Case{
+				{
+					Name: "multicorn ext available",
+					SQL: `
 SELECT count(1) FROM pg_available_extensions WHERE name = 'multicorn';
 			`,
-			TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
-			Validate: func(t *testing.T, row pgx.Row) {
-				var count int
-				if err := row.Scan(&count); err != nil {
-					t.Fatal(err)
-				}
-
-				if want, got := 1, count; want != got {
-					t.Errorf("columnar ext should exist")
-				}
-			},
-		},
-		{
-			Name: "enable multicorn ext",
-			SQL: `
+					TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
+					Validate: func(t *testing.T, row pgx.Row) {
+						var count int
+						if err := row.Scan(&count); err != nil {
+							t.Fatal(err)
+						}
+
+						if want, got := 1, count; want != got {
+							t.Errorf("columnar ext should exist")
+						}
+					},
+				},
+				{
+					Name: "enable multicorn ext",
+					SQL: `
 CREATE EXTENSION multicorn;
 			`,
-			TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
-		},
-		{
-			Name: "multicorn ext enabled",
-			SQL: `
+					TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
+				},
+				{
+					Name: "multicorn ext enabled",
+					SQL: `
 SELECT count(1) FROM pg_extension WHERE extname = 'multicorn';
 			`,
-			Validate: func(t *testing.T, row pgx.Row) {
-				var count int
-				if err := row.Scan(&count); err != nil {
-					t.Fatal(err)
-				}
-
-				if want, got := 1, count; want != got {
-					t.Errorf("columnar ext should exist")
-				}
-			},
-			TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
-		},
-		{
-			Name: "create multicorn s3 ext foreign table",
-			SQL: `
+					Validate: func(t *testing.T, row pgx.Row) {
+						var count int
+						if err := row.Scan(&count); err != nil {
+							t.Fatal(err)
+						}
+
+						if want, got := 1, count; want != got {
+							t.Errorf("columnar ext should exist")
+						}
+					},
+					TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
+				},
+				{
+					Name: "create multicorn s3 ext foreign table",
+					SQL: `
 CREATE SERVER multicorn_s3 FOREIGN DATA WRAPPER multicorn
 options (
   wrapper 's3fdw.s3fdw.S3Fdw'
@@ -290,11 +334,11 @@ create foreign table s3 (
   filename 'test.csv'
 );
 		`,
-			TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
-		},
-		{
-			Name: "create multicorn gspreadsheet ext foreign table",
-			SQL: `
+					TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
+				},
+				{
+					Name: "create multicorn gspreadsheet ext foreign table",
+					SQL: `
 CREATE SERVER multicorn_gspreadsheet FOREIGN DATA WRAPPER multicorn
 options (
   wrapper 'gspreadsheet_fdw.GspreadsheetFdw' );
@@ -307,36 +351,41 @@ CREATE FOREIGN TABLE test_spreadsheet (
   serviceaccount '{}'
 );
 		`,
-			TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
+					TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
+				},
+			},
 		},
-		// parquest_s3_fdw
 		{
-			Name: "parquet_s3_fdw available",
-			SQL: `
+			Name:   "parquet_s3_fdw",
+			Serial: true,
+			Cases: []Case{
+				{
+					Name: "parquet_s3_fdw available",
+					SQL: `
 SELECT count(1) FROM pg_available_extensions WHERE name = 'parquet_s3_fdw';
 			`,
-			Validate: func(t *testing.T, row pgx.Row) {
-				var count int
-				if err := row.Scan(&count); err != nil {
-					t.Fatal(err)
-				}
-
-				if want, got := 1, count; want != got {
-					t.Errorf("parquet_s3_fdw ext should exist")
-				}
-			},
-			TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
-		},
-		{
-			Name: "enable parquet_s3_fdw ext",
-			SQL: `
+					Validate: func(t *testing.T, row pgx.Row) {
+						var count int
+						if err := row.Scan(&count); err != nil {
+							t.Fatal(err)
+						}
+
+						if want, got := 1, count; want != got {
+							t.Errorf("parquet_s3_fdw ext should exist")
+						}
+					},
+					TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
+				},
+				{
+					Name: "enable parquet_s3_fdw ext",
+					SQL: `
 CREATE EXTENSION parquet_s3_fdw;
 			`,
-			TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
-		},
-		{
-			Name: "create parquet_s3_fdw foreign table with no aws creds",
-			SQL: `
+					TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
+				},
+				{
+					Name: "create parquet_s3_fdw foreign table with no aws creds",
+					SQL: `
 CREATE SERVER parquet_s3_srv_1 FOREIGN DATA WRAPPER parquet_s3_fdw OPTIONS (aws_region 'us-east-1');
 CREATE USER MAPPING FOR public SERVER parquet_s3_srv_1;
 CREATE FOREIGN TABLE userdata_1 (
@@ -347,28 +396,28 @@ OPTIONS (
 	dirname 's3://FAKE'
 );
 			`,
-			TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
-		},
-		{
-			Name: "parquet_s3_fdw foreign table with no aws creds raises error",
-			SQL: `
+					TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
+				},
+				{
+					Name: "parquet_s3_fdw foreign table with no aws creds raises error",
+					SQL: `
 SELECT count(*) FROM userdata_1;
 			`,
-			TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
-			Validate: func(t *testing.T, row pgx.Row) {
-				err := row.Scan()
-				if err == nil {
-					t.Error("parquet_s3_fdw should raise error")
-				}
-
-				if !strings.Contains(err.Error(), "password is required") {
-					t.Errorf("parquet_s3_fdw error should contain password required error: %s", err.Error())
-				}
-			},
-		},
-		{
-			Name: "create parquet_s3_fdw foreign table with empty aws creds",
-			SQL: `
+					TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
+					Validate: func(t *testing.T, row pgx.Row) {
+						err := row.Scan()
+						if err == nil {
+							t.Error("parquet_s3_fdw should raise error")
+						}
+
+						if !strings.Contains(err.Error(), "password is required") {
+							t.Errorf("parquet_s3_fdw error should contain password required error: %s", err.Error())
+						}
+					},
+				},
+				{
+					Name: "create parquet_s3_fdw foreign table with empty aws creds",
+					SQL: `
 CREATE SERVER parquet_s3_srv_2 FOREIGN DATA WRAPPER parquet_s3_fdw OPTIONS (aws_region 'us-east-1');
 CREATE USER MAPPING FOR public SERVER parquet_s3_srv_2 OPTIONS (user '', password '');
 CREATE FOREIGN TABLE userdata_2 (
@@ -379,142 +428,454 @@ OPTIONS (
 	dirname 's3://FAKE'
 );
 			`,
-			TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
-		},
-		{
-			Name: "parquet_s3_fdw foreign table with empty aws creds raises error",
-			SQL: `
+					TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
+				},
+				{
+					Name: "parquet_s3_fdw foreign table with empty aws creds raises error",
+					SQL: `
 SELECT count(*) FROM userdata_2;
 			`,
-			TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
-			Validate: func(t *testing.T, row pgx.Row) {
-				err := row.Scan()
-				if err == nil {
-					t.Error("parquet_s3_fdw should raise error")
-				}
-
-				if !strings.Contains(err.Error(), "password is required") {
-					t.Errorf("parquet_s3_fdw error should contain password required error: %s", err.Error())
-				}
+					TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
+					Validate: func(t *testing.T, row pgx.Row) {
+						err := row.Scan()
+						if err == nil {
+							t.Error("parquet_s3_fdw should raise error")
+						}
+
+						if !strings.Contains(err.Error(), "password is required") {
+							t.Errorf("parquet_s3_fdw error should contain password required error: %s", err.Error())
+						}
+					},
+				},
+				{
+					Name: "create parquet_s3_fdw happy path",
+					Skip: shouldSkipParquetS3Tests,
+					SQL: fmt.Sprintf(`
+CREATE SERVER parquet_s3_srv_3 FOREIGN DATA WRAPPER parquet_s3_fdw OPTIONS (aws_region '%s');
+CREATE USER MAPPING FOR public SERVER parquet_s3_srv_3 OPTIONS (user '%s', password '%s');
+CREATE FOREIGN TABLE userdata_3 (
+    id int
+)
+SERVER parquet_s3_srv_3
+OPTIONS (
+	dirname 's3://%s/parquet'
+);
+			`, awsRegion, awsAccessKey, awsSecretKey, awsS3Bucket),
+					TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
+				},
+				{
+					Name: "validate parquet_s3_fdw happy path",
+					Skip: shouldSkipParquetS3Tests,
+					SQL: `
+SELECT count(*) FROM userdata_3;
+			`,
+					TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
+					Validate: func(t *testing.T, row pgx.Row) {
+						var count int
+						if err := row.Scan(&count); err != nil {
+							t.Fatal(err)
+						}
+
+						if count == 0 {
+							t.Error("number of parquet rows should be more than 0")
+						}
+					},
+				},
 			},
 		},
-		// pgvector
 		{
-			Name: "pg_vector available",
-			SQL: `
-SELECT count(1) FROM pg_available_extensions WHERE name = 'vector';
-			`,
-			Validate: func(t *testing.T, row pgx.Row) {
-				err := row.Scan()
-				if err == nil {
-					t.Error("pg_vector should exist")
-				}
-			},
+			Name:  "differential",
+			Cases: DifferentialCases(),
 		},
 		{
-			Name: "enable pg_vector",
-			SQL: `
+			Name:   "pg_vector",
+			Serial: true,
+			Cases: []Case{
+				{
+					Name: "pg_vector available",
+					SQL: `
+SELECT count(1) FROM pg_available_extensions WHERE name = 'vector';
+			`,
+					Validate: func(t *testing.T, row pgx.Row) {
+						err := row.Scan()
+						if err == nil {
+							t.Error("pg_vector should exist")
+						}
+					},
+				},
+				{
+					Name: "enable pg_vector",
+					SQL: `
 CREATE EXTENSION vector;
 			`,
-		},
-		{
-			Name: "pg_vector ext enabled",
-			SQL: `
+				},
+				{
+					Name: "pg_vector ext enabled",
+					SQL: `
 SELECT count(1) FROM pg_extension WHERE extname = 'vector';
 			`,
-			Validate: func(t *testing.T, row pgx.Row) {
-				var count int
-				if err := row.Scan(&count); err != nil {
-					t.Fatal(err)
-				}
-
-				if want, got := 1, count; want != got {
-					t.Errorf("pg_vector ext should exist")
-				}
-			},
-		},
-		{
-			Name: "create pg_vector column",
-			SQL: `
+					Validate: func(t *testing.T, row pgx.Row) {
+						var count int
+						if err := row.Scan(&count); err != nil {
+							t.Fatal(err)
+						}
+
+						if want, got := 1, count; want != got {
+							t.Errorf("pg_vector ext should exist")
+						}
+					},
+				},
+				{
+					Name: "create pg_vector column",
+					SQL: `
 CREATE TABLE items (id bigserial PRIMARY KEY, embedding vector(3));
 		`,
-		},
-		{
-			Name: "insert pg_vector data",
-			SQL: `
+				},
+				{
+					Name: "insert pg_vector data",
+					SQL: `
 INSERT INTO items (embedding) VALUES ('[1,2,3]'), ('[4,5,6]');
 		`,
-		},
-		{
-			Name: "validate pg_vector data",
-			SQL: `
+				},
+				{
+					Name: "validate pg_vector data",
+					SQL: `
 SELECT * FROM items ORDER BY embedding <-> '[3,1,2]' LIMIT 1;
 		`,
-			Validate: func(t *testing.T, row pgx.Row) {
-				var result struct {
-					ID        int
-					Embedding string
-				}
-				if err := row.Scan(&result.ID, &result.Embedding); err != nil {
-					t.Fatal(err)
-				}
-
-				if want, got := 1, result.ID; want != got {
-					t.Errorf("item ID should equal")
-				}
-				if want, got := "[1,2,3]", result.Embedding; want != got {
-					t.Errorf("item embedding should equal: want=%s, got=%s", want, got)
-				}
+					Validate: func(t *testing.T, row pgx.Row) {
+						var result struct {
+							ID        int
+							Embedding string
+						}
+						if err := row.Scan(&result.ID, &result.Embedding); err != nil {
+							t.Fatal(err)
+						}
+
+						if want, got := 1, result.ID; want != got {
+							t.Errorf("item ID should equal")
+						}
+						if want, got := "[1,2,3]", result.Embedding; want != got {
+							t.Errorf("item embedding should equal: want=%s, got=%s", want, got)
+						}
+					},
+				},
 			},
 		},
 	}
+}
 
-	var (
-		awsAccessKey             = os.Getenv("TEST_AWS_ACCESS_KEY_ID")
-		awsSecretKey             = os.Getenv("TEST_AWS_SECRET_ACCESS_KEY")
-		awsRegion                = os.Getenv("TEST_AWS_REGION")
-		awsS3Bucket              = os.Getenv("TEST_AWS_S3_BUCKET")
-		shouldSkipParquetS3Tests = awsAccessKey == "" || awsSecretKey == "" || awsRegion == "" || awsS3Bucket == ""
-	)
+// Differentials describe the queries whose results from columnar tables are
+// compared to heap tables, see [RunDifferential].
+var Differentials = []Differential{
+	{
+		Name:   "differential scans and filters",
+		Tags:   []string{TagSmoke},
+		Tables: []DiffTable{{Name: "items", Columns: "id INT8, grp INT, price NUMERIC(12, 2), name TEXT, created DATE, flag BOOL"}},
+		Load: `
+INSERT INTO items
+  SELECT i,
+         i % 17,
+         CASE WHEN i % 11 = 0 THEN NULL ELSE (i * 7 % 1000) / 10.0 END,
+         CASE WHEN i % 13 = 0 THEN NULL ELSE 'item ' || i END,
+         DATE '2020-01-01' + i % 366,
+         CASE i % 3 WHEN 0 THEN true WHEN 1 THEN false END
+  FROM generate_series(1, 20000) i;
+`,
+		Queries: []string{
+			"SELECT count(*), count(price), count(name), count(flag) FROM items",
+			"SELECT * FROM items WHERE id BETWEEN 1000 AND 1100",
+			"SELECT id, name FROM items WHERE name LIKE 'item 12%'",
+			"SELECT id FROM items WHERE flag IS NULL AND grp = 3",
+			"SELECT id, price FROM items WHERE price > 99.5 OR price IS NULL AND id < 200",
+			"SELECT created, grp FROM items WHERE created >= DATE '2020-12-25' AND NOT flag",
+		},
+	},
+	{
+		Name:   "differential aggregates",
+		Tables: []DiffTable{{Name: "sales", Columns: "id INT8, region TEXT, amount NUMERIC(12, 2), qty INT"}},
+		Load: `
+INSERT INTO sales
+  SELECT i,
+         (ARRAY['north', 'south', 'east', 'west', NULL])[i % 5 + 1],
+         (i * 31 % 10000) / 100.0,
+         i % 50
+  FROM generate_series(1, 50000) i;
+`,
+		Queries: []string{
+			"SELECT sum(amount), avg(amount), min(amount), max(amount), sum(qty), count(DISTINCT qty) FROM sales",
+			"SELECT region, count(*), sum(amount), max(qty) FROM sales GROUP BY region",
+			"SELECT qty, count(*) FROM sales GROUP BY qty HAVING sum(amount) > 5000",
+			"SELECT region, string_agg(id::text, ',' ORDER BY id) FROM sales WHERE id % 997 = 0 GROUP BY region",
+			"SELECT region, qty, sum(amount) FROM sales WHERE qty < 3 GROUP BY ROLLUP (region, qty)",
+		},
+	},
+	{
+		Name: "differential joins",
+		Tables: []DiffTable{
+			{Name: "customers", Columns: "id INT, name TEXT, country TEXT"},
+			{Name: "orders", Columns: "id INT8, customer_id INT, total NUMERIC(10, 2)"},
+		},
+		Load: `
+INSERT INTO customers SELECT i, 'customer ' || i, (ARRAY['de', 'fr', 'us'])[i % 3 + 1] FROM generate_series(1, 1000) i;
+INSERT INTO orders SELECT i, i % 1200, (i % 500) + 0.99 FROM generate_series(1, 30000) i;
+`,
+		Queries: []string{
+			"SELECT c.country, count(*), sum(o.total) FROM orders o JOIN customers c ON c.id = o.customer_id GROUP BY c.country",
+			"SELECT c.id, count(o.id) FROM customers c LEFT JOIN orders o ON o.customer_id = c.id WHERE c.id % 50 = 0 GROUP BY c.id",
+			"SELECT count(*) FROM customers c WHERE EXISTS (SELECT 1 FROM orders o WHERE o.customer_id = c.id AND o.total > 499)",
+			"SELECT o.customer_id, count(*) FROM orders o WHERE NOT EXISTS (SELECT 1 FROM customers c WHERE c.id = o.customer_id) GROUP BY o.customer_id",
+		},
+	},
+	{
+		Name:   "differential window functions",
+		Tables: []DiffTable{{Name: "events", Columns: "id INT8, device INT, reading INT"}},
+		Load: `
+INSERT INTO events SELECT i, i % 20, i * 37 % 1000 FROM generate_series(1, 10000) i;
+`,
+		Queries: []string{
+			"SELECT id, row_number() OVER (PARTITION BY device ORDER BY id) FROM events WHERE device < 3",
+			"SELECT id, reading - lag(reading) OVER (PARTITION BY device ORDER BY id) FROM events WHERE id < 500",
+			"SELECT device, rank() OVER (ORDER BY sum(reading) DESC) FROM events GROUP BY device",
+		},
+	},
+	{
+		Name:   "differential after changes",
+		Tables: []DiffTable{{Name: "accounts", Columns: "id INT, balance NUMERIC(12, 2), note TEXT"}},
+		Load: `
+INSERT INTO accounts SELECT i, i * 10, 'account ' || i FROM generate_series(1, 10000) i;
+`,
+		Changes: []string{
+			"DELETE FROM accounts WHERE id % 7 = 0",
+			"UPDATE accounts SET balance = balance * 2, note = NULL WHERE id % 5 = 0",
+			"INSERT INTO accounts SELECT i, 0, 'reopened' FROM generate_series(7, 700, 7) i",
+		},
+		Queries: []string{
+			"SELECT count(*), sum(balance), count(note) FROM accounts",
+			"SELECT * FROM accounts WHERE id % 35 = 0",
+			"SELECT note, count(*) FROM accounts GROUP BY note",
+		},
+	},
+}
 
-	cases = append(cases, []Case{
-		{
-			Name: "create parquet_s3_fdw happy path",
-			Skip: shouldSkipParquetS3Tests,
-			SQL: fmt.Sprintf(`
-CREATE SERVER parquet_s3_srv_3 FOREIGN DATA WRAPPER parquet_s3_fdw OPTIONS (aws_region '%s');
-CREATE USER MAPPING FOR public SERVER parquet_s3_srv_3 OPTIONS (user '%s', password '%s');
-CREATE FOREIGN TABLE userdata_3 (
-    id int
-)
-SERVER parquet_s3_srv_3
-OPTIONS (
-	dirname 's3://%s/parquet'
-);
-			`, awsRegion, awsAccessKey, awsSecretKey, awsS3Bucket),
-			TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
-		},
-		{
-			Name: "validate parquet_s3_fdw happy path",
-			Skip: shouldSkipParquetS3Tests,
-			SQL: `
-SELECT count(*) FROM userdata_3;
+// AcceptanceCases returns the cases of the [AcceptanceCaseGroups] in order.
+func AcceptanceCases() []Case {
+	var cases []Case
+	for _, g := range AcceptanceCaseGroups() {
+		cases = append(cases, g.Cases...)
+	}
+
+	return cases
+}
+
+// ConstrainedResourceCases describe cases that are run in addition to the
+// [AcceptanceCases] when the container is started with resource limits, see
+// [ContainerOptions].
+var ConstrainedResourceCases = []Case{
+	{
+		Name: "columnar aggregate under constrained memory",
+		Tags: []string{TagColumnar, TagSlow},
+		SQL: `
+CREATE TABLE constrained_columnar (id INT8, grp INT, payload TEXT) USING columnar;
+INSERT INTO constrained_columnar
+  SELECT i, i % 1000, repeat(md5(i::text), 4) FROM generate_series(1, 500000) i;
 			`,
-			TargetPGVersions: []PGVersion{PGVersion13, PGVersion14, PGVersion15},
-			Validate: func(t *testing.T, row pgx.Row) {
-				var count int
-				if err := row.Scan(&count); err != nil {
-					t.Fatal(err)
-				}
+	},
+	{
+		Name: "validate columnar aggregate under constrained memory",
+		Tags: []string{TagColumnar, TagSlow},
+		SQL: `
+SELECT count(DISTINCT grp), sum(id), count(*) FROM constrained_columnar;
+			`,
+		Validate: func(t *testing.T, row pgx.Row) {
+			var groups, sum, count int64
+			if err := row.Scan(&groups, &sum, &count); err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := int64(1000), groups; want != got {
+				t.Errorf("distinct groups should match: want=%d got=%d", want, got)
+			}
+			if want, got := int64(500000)*500001/2, sum; want != got {
+				t.Errorf("sum of ids should match: want=%d got=%d", want, got)
+			}
+			if want, got := int64(500000), count; want != got {
+				t.Errorf("row count should match: want=%d got=%d", want, got)
+			}
+		},
+	},
+}
 
-				if count == 0 {
-					t.Error("number of parquet rows should be more than 0")
-				}
-			},
-		},
-	}...)
+// ParallelQueryCases describe cases that are run in addition to the
+// [AcceptanceCases] to verify that parallel workers are able to allocate their
+// shared memory, which requires a larger /dev/shm than the runtime default, see
+// [ContainerOptions.ShmSize].
+var ParallelQueryCases = []Case{
+	{
+		Name: "create columnar table for parallel query",
+		Tags: []string{TagColumnar, TagSlow},
+		SQL: `
+CREATE TABLE parallel_columnar (id INT8, grp INT) USING columnar;
+INSERT INTO parallel_columnar SELECT i, i % 100 FROM generate_series(1, 500000) i;
+ANALYZE parallel_columnar;
+			`,
+	},
+	{
+		Name: "columnar parallel hash join",
+		Tags: []string{TagColumnar, TagSlow},
+		SQL: `
+SELECT count(*), sum(a.id) FROM parallel_columnar a JOIN parallel_columnar b USING (id);
+			`,
+		Settings: map[string]string{
+			"max_parallel_workers_per_gather": "4",
+			"parallel_setup_cost":             "0",
+			"parallel_tuple_cost":             "0",
+			"min_parallel_table_scan_size":    "0",
+			"enable_parallel_hash":            "on",
+			"work_mem":                        "64MB",
+		},
+		Validate: func(t *testing.T, row pgx.Row) {
+			var count, sum int64
+			if err := row.Scan(&count, &sum); err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := int64(500000), count; want != got {
+				t.Errorf("row count should match: want=%d got=%d", want, got)
+			}
+			if want, got := int64(500000)*500001/2, sum; want != got {
+				t.Errorf("sum of ids should match: want=%d got=%d", want, got)
+			}
+		},
+	},
+}
 
-	return cases
+// CRUDCases describe basic create, read, update and delete operations on a
+// columnar table, e.g. to verify a connection path such as IPv6 end to end.
+var CRUDCases = []Case{
+	{
+		Name: "create columnar table for crud",
+		Tags: []string{TagSmoke, TagColumnar},
+		SQL: `
+CREATE TABLE crud_columnar (id INT, name TEXT) USING columnar;
+			`,
+	},
+	{
+		Name: "insert into columnar table for crud",
+		Tags: []string{TagSmoke, TagColumnar},
+		SQL: `
+INSERT INTO crud_columnar VALUES (1, 'one'), (2, 'two'), (3, 'three');
+			`,
+	},
+	{
+		Name: "update columnar table for crud",
+		Tags: []string{TagSmoke, TagColumnar},
+		SQL: `
+UPDATE crud_columnar SET name = 'deux' WHERE id = 2;
+			`,
+	},
+	{
+		Name: "delete from columnar table for crud",
+		Tags: []string{TagSmoke, TagColumnar},
+		SQL: `
+DELETE FROM crud_columnar WHERE id = 3;
+			`,
+	},
+	{
+		Name: "read columnar table for crud",
+		Tags: []string{TagSmoke, TagColumnar},
+		SQL: `
+SELECT string_agg(id || '=' || name, ',' ORDER BY id) FROM crud_columnar;
+			`,
+		Validate: func(t *testing.T, row pgx.Row) {
+			var rows string
+			if err := row.Scan(&rows); err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := "1=one,2=deux", rows; want != got {
+				t.Errorf("rows should match: want=%s got=%s", want, got)
+			}
+		},
+	},
+	{
+		Name: "drop columnar table for crud",
+		Tags: []string{TagSmoke, TagColumnar},
+		SQL: `
+DROP TABLE crud_columnar;
+			`,
+	},
+}
+
+// PgBouncerCases describe columnar workloads run through PgBouncer in
+// transaction pooling mode, see [StartPgBouncer], where consecutive
+// statements may be served by different server connections.
+var PgBouncerCases = []Case{
+	{
+		Name: "create columnar table through pgbouncer",
+		Tags: []string{TagColumnar},
+		SQL: `
+CREATE TABLE pgbouncer_columnar (id INT8, t TEXT) USING columnar;
+			`,
+	},
+	{
+		Name: "insert into columnar table through pgbouncer",
+		Tags: []string{TagColumnar},
+		SQL: `
+INSERT INTO pgbouncer_columnar SELECT i, md5(i::text) FROM generate_series(1, 100000) i;
+			`,
+	},
+	{
+		Name: "insert with transaction-scoped stripe row limit through pgbouncer",
+		Tags: []string{TagColumnar},
+		SQL: `
+INSERT INTO pgbouncer_columnar SELECT i, md5(i::text) FROM generate_series(100001, 105000) i;
+			`,
+		Settings: map[string]string{
+			"columnar.stripe_row_limit": "1000",
+		},
+	},
+	{
+		Name: "stripe row limit applied to its transaction only",
+		Tags: []string{TagColumnar},
+		SQL: `
+SELECT count(*), current_setting('columnar.stripe_row_limit') FROM columnar.stats('pgbouncer_columnar'::regclass);
+			`,
+		Validate: func(t *testing.T, row pgx.Row) {
+			var stripes int
+			var limit string
+			if err := row.Scan(&stripes, &limit); err != nil {
+				t.Fatal(err)
+			}
+
+			// one stripe for the first insert and five for the second
+			if want, got := 6, stripes; want != got {
+				t.Errorf("stripe count should match: want=%d got=%d", want, got)
+			}
+			if want, got := "150000", limit; want != got {
+				t.Errorf("stripe row limit should not leak to other transactions: want=%s got=%s", want, got)
+			}
+		},
+	},
+	{
+		Name: "aggregate columnar table through pgbouncer",
+		Tags: []string{TagColumnar},
+		SQL: `
+SELECT count(*), sum(id) FROM pgbouncer_columnar;
+			`,
+		Validate: func(t *testing.T, row pgx.Row) {
+			var count, sum int64
+			if err := row.Scan(&count, &sum); err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := int64(105000), count; want != got {
+				t.Errorf("row count should match: want=%d got=%d", want, got)
+			}
+			if want, got := int64(105000)*105001/2, sum; want != got {
+				t.Errorf("sum of ids should match: want=%d got=%d", want, got)
+			}
+		},
+	},
 }
 
 // These describe the shared setup and validation cases that occur to validate
@@ -523,6 +884,7 @@ var (
 	BeforeUpgradeCases = []Case{
 		{
 			Name: "create columnar table",
+			Tags: []string{TagColumnar, TagDestructive},
 			SQL: `
 CREATE TABLE columnar_table
 (
@@ -536,6 +898,7 @@ CREATE TABLE columnar_table
 		},
 		{
 			Name: "insert into columnar table",
+			Tags: []string{TagColumnar, TagDestructive},
 			SQL: `
 INSERT INTO columnar_table (id, i1, i2, n, t)
 VALUES ('75372aac-d74a-4e5a-8bf3-43cdaf9011de', 2, 3, 100.1, 'hydra');
@@ -545,12 +908,14 @@ VALUES ('75372aac-d74a-4e5a-8bf3-43cdaf9011de', 2, 3, 100.1, 'hydra');
 	AfterUpgradeCases = []Case{
 		{
 			Name: "force upgrade columnar ext",
+			Tags: []string{TagColumnar, TagDestructive},
 			SQL: `
 ALTER EXTENSION columnar UPDATE;
 			`,
 		},
 		{
 			Name: "create another columnar table",
+			Tags: []string{TagColumnar, TagDestructive},
 			SQL: `
 CREATE TABLE columnar_table2
 (
@@ -563,41 +928,60 @@ CREATE TABLE columnar_table2
 		`,
 		},
 		{
-			Name: "validate columnar data",
-			SQL:  "SELECT id, i1, i2, n, t FROM columnar_table LIMIT 1;",
-			Validate: func(t *testing.T, row pgx.Row) {
-				var result struct {
-					ID uuid.UUID
-					I1 int
-					I2 int
-					N  float32
-					T  string
-				}
-
-				if err := row.Scan(&result.ID, &result.I1, &result.I2, &result.N, &result.T); err != nil {
-					t.Fatal(err)
-				}
-
-				if result.ID != uuid.MustParse("75372aac-d74a-4e5a-8bf3-43cdaf9011de") {
-					t.Errorf("id returned %s after upgrade, expected 75372aac-d74a-4e5a-8bf3-43cdaf9011de", result.ID)
-				}
-
-				if result.I1 != 2 {
-					t.Errorf("i1 returned %d after upgrade, expected 2", result.I1)
-				}
-
-				if result.I2 != 3 {
-					t.Errorf("i2 returned %d after upgrade, expected 3", result.I2)
-				}
-
-				if result.N != 100.1 {
-					t.Errorf("n returned %f after upgrade, expected 100.1", result.N)
-				}
-
-				if result.T != "hydra" {
-					t.Errorf("t returned %s after upgrade, expected hydra", result.T)
-				}
-			},
+			Name:     "validate columnar data",
+			Tags:     []string{TagColumnar, TagDestructive},
+			SQL:      "SELECT id, i1, i2, n, t FROM columnar_table LIMIT 1;",
+			Validate: validateColumnarTableRow,
 		},
 	}
 )
+
+// These describe the shared cases that validate that columnar data survives
+// replacing the container while keeping its data volume.
+var (
+	BeforeRestartCases = BeforeUpgradeCases
+	AfterRestartCases  = []Case{
+		{
+			Name:     "validate columnar data",
+			Tags:     []string{TagColumnar, TagDestructive},
+			SQL:      "SELECT id, i1, i2, n, t FROM columnar_table LIMIT 1;",
+			Validate: validateColumnarTableRow,
+		},
+	}
+)
+
+// validateColumnarTableRow validates the row inserted into columnar_table by
+// the BeforeUpgradeCases.
+func validateColumnarTableRow(t *testing.T, row pgx.Row) {
+	var result struct {
+		ID uuid.UUID
+		I1 int
+		I2 int
+		N  float32
+		T  string
+	}
+
+	if err := row.Scan(&result.ID, &result.I1, &result.I2, &result.N, &result.T); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.ID != uuid.MustParse("75372aac-d74a-4e5a-8bf3-43cdaf9011de") {
+		t.Errorf("id returned %s, expected 75372aac-d74a-4e5a-8bf3-43cdaf9011de", result.ID)
+	}
+
+	if result.I1 != 2 {
+		t.Errorf("i1 returned %d, expected 2", result.I1)
+	}
+
+	if result.I2 != 3 {
+		t.Errorf("i2 returned %d, expected 3", result.I2)
+	}
+
+	if result.N != 100.1 {
+		t.Errorf("n returned %f, expected 100.1", result.N)
+	}
+
+	if result.T != "hydra" {
+		t.Errorf("t returned %s, expected hydra", result.T)
+	}
+}
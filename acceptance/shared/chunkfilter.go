@@ -0,0 +1,165 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A ChunkGroupFilter is a selective predicate of the sorted columnar table of
+// the [ChunkGroupFilterCases], with 100 chunk groups of 1000 rows, whose
+// chunk group filters must skip the chunk groups whose min and max values
+// exclude its rows.
+type ChunkGroupFilter struct {
+	Name       string
+	Predicate  string            // condition on id, ts and key, sorted, or grp, uncorrelated
+	Rows       int64             // rows that match the predicate
+	MinRemoved int64             // chunk groups that must be removed by the filters at least
+	MaxRemoved *int64            // chunk groups that may be removed by the filters at most, unbounded if nil, see AtMost
+	Settings   map[string]string // settings of the query, e.g. columnar.qual_pushdown_correlation_threshold
+}
+
+// Case returns f as a [Case] that runs it with [RunChunkGroupFilter].
+func (f ChunkGroupFilter) Case() Case {
+	return Case{
+		Name: "columnar chunk group filter " + f.Name,
+		Tags: []string{TagColumnar},
+		Run: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+			RunChunkGroupFilter(t, ctx, pool, f)
+		},
+	}
+}
+
+// AtMost returns n as the MaxRemoved of a [ChunkGroupFilter].
+func AtMost(n int64) *int64 {
+	return &n
+}
+
+// chunkFilterTable is the columnar table of the [ChunkGroupFilterCases].
+const chunkFilterTable = "chunk_filter_columnar"
+
+// ChunkGroupFilters are the predicates covered by the [ChunkGroupFilterCases].
+// A predicate of a range of 1000 rows spans at most two chunk groups.
+var ChunkGroupFilters = []ChunkGroupFilter{
+	{
+		Name:       "range of ids",
+		Predicate:  "id BETWEEN 5000 AND 5999",
+		Rows:       1000,
+		MinRemoved: 98,
+	},
+	{
+		Name:       "first ids",
+		Predicate:  "id < 1000",
+		Rows:       999,
+		MinRemoved: 99,
+	},
+	{
+		Name:       "last ids",
+		Predicate:  "id > 99000",
+		Rows:       1000,
+		MinRemoved: 99,
+	},
+	{
+		Name:       "range of timestamps",
+		Predicate:  "ts >= TIMESTAMP '2020-01-01' + INTERVAL '5000 minutes' AND ts < TIMESTAMP '2020-01-01' + INTERVAL '6000 minutes'",
+		Rows:       1000,
+		MinRemoved: 98,
+	},
+	{
+		Name:       "text equality",
+		Predicate:  "key = '042424'",
+		Rows:       1,
+		MinRemoved: 99,
+	},
+	{
+		Name:       "conjunction",
+		Predicate:  "id BETWEEN 10001 AND 20000 AND key < '015001'",
+		Rows:       5000,
+		MinRemoved: 95,
+	},
+	{
+		Name:       "no match",
+		Predicate:  "id > 200000",
+		Rows:       0,
+		MinRemoved: 100,
+	},
+	{
+		// every chunk group has every group, so that none can be skipped even
+		// when the filter is pushed down
+		Name:       "uncorrelated values",
+		Predicate:  "grp = 7",
+		Rows:       1000,
+		MaxRemoved: AtMost(0),
+		Settings:   map[string]string{"columnar.qual_pushdown_correlation_threshold": "0"},
+	},
+}
+
+// ChunkGroupFilterCases returns a case that creates the table of the
+// [ChunkGroupFilters], followed by a case for each of filters.
+func ChunkGroupFilterCases(filters ...ChunkGroupFilter) []Case {
+	cases := []Case{
+		{
+			Name: "create columnar table for chunk group filters",
+			Tags: []string{TagColumnar},
+			SQL: fmt.Sprintf(`
+CREATE TABLE %[1]s (id INT8, ts TIMESTAMP, key TEXT, grp INT4) USING columnar;
+INSERT INTO %[1]s
+SELECT i, TIMESTAMP '2020-01-01' + i * INTERVAL '1 minute', lpad(i::text, 6, '0'), i %% 100
+FROM generate_series(1, 100000) i;
+ANALYZE %[1]s;
+				`, chunkFilterTable),
+			Settings: map[string]string{"columnar.chunk_group_row_limit": "1000"},
+		},
+	}
+
+	cases = append(cases, casesOf(filters...)...)
+
+	return cases
+}
+
+// RunChunkGroupFilter counts the rows of the predicate of f with EXPLAIN
+// ANALYZE, whose columnar scan must have removed the chunk groups of f, and
+// compares the count with and without qual pushdown. Parallel execution is
+// disabled, so that a single scan removes and reports every chunk group.
+func RunChunkGroupFilter(t *testing.T, ctx context.Context, pool *pgxpool.Pool, f ChunkGroupFilter) {
+	t.Helper()
+
+	sql := fmt.Sprintf("SELECT count(*) FROM %s WHERE %s", pgx.Identifier{chunkFilterTable}.Sanitize(), f.Predicate)
+	settings := map[string]string{"columnar.enable_parallel_execution": "off"}
+	for name, value := range f.Settings {
+		settings[name] = value
+	}
+
+	checks := []PlanCheck{
+		UsesColumnarScan(chunkFilterTable),
+		HasProperty("Columnar Chunk Group Filters"),
+		ChunkGroupsRemovedAtLeast(f.MinRemoved),
+	}
+	if f.MaxRemoved != nil {
+		checks = append(checks, ChunkGroupsRemovedAtMost(*f.MaxRemoved))
+	}
+
+	var filtered []string
+	queryWithSettings(t, ctx, pool, settings, func(conn *pgx.Conn) {
+		CheckPlan(t, Explain(t, ctx, conn, "ANALYZE, COSTS OFF, TIMING OFF", sql), checks...)
+		filtered = queryTextRows(t, ctx, conn, sql)
+	})
+
+	settings["columnar.enable_qual_pushdown"] = "off"
+	var unfiltered []string
+	queryWithSettings(t, ctx, pool, settings, func(conn *pgx.Conn) {
+		unfiltered = queryTextRows(t, ctx, conn, sql)
+	})
+
+	want := []string{strconv.FormatInt(f.Rows, 10)}
+	if diff := diffRows(want, filtered); diff != "" {
+		t.Errorf("%s should count the rows with chunk group filters: %s", sql, diff)
+	}
+	if diff := diffRows(want, unfiltered); diff != "" {
+		t.Errorf("%s should count the rows without chunk group filters: %s", sql, diff)
+	}
+}
@@ -0,0 +1,325 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	// ErrComposeStop is used when the containers of a stack cannot be stopped
+	// or killed.
+	ErrComposeStop = errors.New("docker compose did not stop")
+	// ErrComposeRemove is used when the containers, network or volumes of a
+	// stack cannot be removed.
+	ErrComposeRemove = errors.New("docker compose was not removed")
+	// ErrSaveLogs is used when the logs of a container cannot be fetched or
+	// saved.
+	ErrSaveLogs = errors.New("logs were not saved")
+)
+
+// A ComposeStack is a multi-container topology, e.g. Hydra with a connection
+// pooler and a load generator, started from a single compose file.
+type ComposeStack struct {
+	Runtime        ContainerRuntime  // runtime used to manage the stack
+	Project        string            // compose project name
+	File           string            // path to the compose file
+	Services       []string          // services that are waited on and have their logs captured
+	StopTimeout    time.Duration     // time to wait for containers to stop before killing them, the Shutdown timeout if zero, see [TimeoutsFor]
+	VerifyShutdown []string          // Postgres services that must shut down cleanly when stopped, see [VerifyCleanShutdown]
+	CrashCheck     map[string]string // data directory of Postgres services that are checked for crashes when brought down, see [CheckForCrash]
+	StreamLogs     bool              // whether to stream the logs of Services into t.Log while the stack runs, see [StreamLogs]
+	StatsInterval  time.Duration     // interval to sample the resource usage of Services at if LogDir is included, see [SampleStats], disabled if zero
+	LogDir         string            // root directory to stream the logs to, and to save them to if the run is interrupted, if included, see [ContainerArtifactDir]
+}
+
+// NewComposeStack returns a [ComposeStack] for the project described by file.
+// services lists the services of interest in the compose file.
+func NewComposeStack(rt ContainerRuntime, project, file string, services ...string) *ComposeStack {
+	return &ComposeStack{
+		Runtime:  rt,
+		Project:  project,
+		File:     file,
+		Services: services,
+	}
+}
+
+// Up starts every service in the stack. If the test binary is interrupted
+// with SIGINT or SIGTERM before the stack is brought down with [Down], the logs
+// of the stack are saved to LogDir and the stack is removed.
+func (s *ComposeStack) Up(t *testing.T, ctx context.Context) {
+	t.Helper()
+
+	t.Logf("Starting docker compose %s with %s using %s", s.Project, s.File, s.Runtime.Name())
+	if err := s.Runtime.Start(ctx, s.Project, s.File); err != nil {
+		t.Fatalf("unable to start docker compose: %s", err)
+	}
+	if DryRun {
+		SkipDryRun(t, s.File)
+	}
+
+	testName := t.Name()
+	onAbort(s.Project, func(ctx context.Context) error {
+		return s.abort(ctx, testName)
+	})
+
+	if s.StreamLogs {
+		s.streamLogs(t, ctx)
+	}
+	if s.StatsInterval > 0 && s.LogDir != "" {
+		s.sampleStats(t, ctx)
+	}
+}
+
+func (s *ComposeStack) sampleStats(t *testing.T, ctx context.Context) {
+	now := time.Now().Format(time.RFC3339)
+	for _, service := range s.Services {
+		id, err := s.Runtime.ContainerID(ctx, s.Project, service)
+		if err != nil {
+			t.Fatalf("unable to find container for %s: %s", service, err)
+		}
+
+		path := filepath.Join(ContainerArtifactDir(t, s.LogDir, service), fmt.Sprintf("stats-%s.jsonl", now))
+		SampleStats(t, ctx, s.Runtime, id, path, s.StatsInterval)
+	}
+}
+
+// abort saves the logs and inspect output of the stack and removes it when the
+// run is interrupted before the stack is brought down.
+func (s *ComposeStack) abort(ctx context.Context, testName string) error {
+	return errors.Join(
+		s.Runtime.Kill(ctx, s.Project),
+		s.saveLogs(ctx, s.LogDir, testName),
+		s.saveInspect(ctx, s.LogDir, testName),
+		s.Runtime.Remove(ctx, s.Project, true),
+	)
+}
+
+func (s *ComposeStack) streamLogs(t *testing.T, ctx context.Context) {
+	now := time.Now().Format(time.RFC3339)
+	for _, service := range s.Services {
+		id, err := s.Runtime.ContainerID(ctx, s.Project, service)
+		if err != nil {
+			t.Fatalf("unable to find container for %s: %s", service, err)
+		}
+
+		var w io.Writer
+		if dir := ContainerArtifactDir(t, s.LogDir, service); dir != "" {
+			f, err := os.Create(filepath.Join(dir, fmt.Sprintf("stream-%s.log", now)))
+			if err != nil {
+				t.Fatalf("unable to create stream log for %s: %s", service, err)
+			}
+			// registered before streaming starts so that the file is closed
+			// after streaming stopped
+			t.Cleanup(func() {
+				_ = f.Close()
+			})
+			w = f
+		}
+
+		StreamLogs(t, ctx, s.Runtime, id, w)
+	}
+}
+
+// WaitHealthy blocks until every service of the stack reports healthy, or
+// running for services without a healthcheck, polling every interval. The test
+// fails if that does not happen within timeout.
+func (s *ComposeStack) WaitHealthy(t *testing.T, ctx context.Context, timeout, interval time.Duration) {
+	t.Helper()
+
+	ctx, cancel := WithOperation(ctx, "wait for "+s.Project+" to become healthy", timeout)
+	defer cancel()
+
+	for _, service := range s.Services {
+		deadline, _ := ctx.Deadline()
+		WaitForReadiness(t, ctx, HealthCheckProbe(s.Runtime, s.Project, service), time.Until(deadline), interval)
+	}
+}
+
+// Down terminates the stack and saves the logs of every service to its
+// [ContainerArtifactDir] below the LogDir of the stack, if included. If the
+// test failed the inspect output of every service, e.g. its mounts,
+// environment, exit code and OOM status, is saved next to the logs. The
+// services in CrashCheck are checked for crashes first. Unless opts include
+// [WithKill] the containers are stopped, and the services in VerifyShutdown
+// are checked for a clean shutdown. Otherwise they are killed and volumes are
+// also deleted. opts override the LogDir and StopTimeout of the stack.
+//
+// Down continues after failing to stop the containers or to save the logs, so
+// that the project is removed regardless, and returns the errors joined,
+// wrapping ErrComposeStop, ErrSaveLogs or ErrComposeRemove. The caller decides
+// whether they fail the test, e.g. not during a best-effort cleanup. The checks
+// of the crashes and shutdown are reported on t.
+//
+// Down runs even if ctx is done, e.g. as the run exceeded its deadline, so
+// that the logs are saved, with every step bounded by the [Timeouts] of t.
+func (s *ComposeStack) Down(t *testing.T, ctx context.Context, opts ...Option) error {
+	t.Helper()
+
+	cfg := NewConfig(WithLogDir(s.LogDir), WithStopTimeout(s.StopTimeout)).With(opts...)
+	ctx = context.WithoutCancel(ctx)
+	timeouts := TimeoutsFor(t)
+
+	if DryRun {
+		// nothing was started, so there are no logs to save or checks to run
+		_ = s.Runtime.Remove(ctx, s.Project, cfg.Kill)
+		return nil
+	}
+
+	// checked before the containers are stopped so that an earlier exit is
+	// told apart from the stop, and core files can be listed
+	for service, dataDir := range s.CrashCheck {
+		CheckForCrash(t, ctx, s.Runtime, s.Project, service, dataDir, WithLogDir(cfg.LogDir))
+	}
+
+	var errs []error
+	if cfg.Kill {
+		if err := s.kill(ctx, timeouts.Shutdown); err != nil {
+			errs = append(errs, fmt.Errorf("%w: unable to kill %s: %w", ErrComposeStop, s.Project, err))
+		}
+	} else {
+		if err := s.stop(ctx, cfg.stopTimeout(t), timeouts.Shutdown); err != nil {
+			errs = append(errs, fmt.Errorf("%w: unable to stop %s: %w", ErrComposeStop, s.Project, err))
+		}
+	}
+	stopped := len(errs) == 0
+
+	// logs are written once the containers exited so that they include the
+	// shutdown
+	if err := s.writeLogs(t, ctx, cfg.LogDir); err != nil {
+		errs = append(errs, err)
+	}
+	if t.Failed() || len(errs) > 0 {
+		s.writeInspect(t, ctx, cfg.LogDir)
+	}
+
+	// a shutdown that did not complete cannot be verified
+	if !cfg.Kill && stopped {
+		for _, service := range s.VerifyShutdown {
+			VerifyCleanShutdown(t, ctx, s.Runtime, s.Project, service)
+		}
+	}
+
+	// always remove the project to clean up the containers and network, but
+	// only remove the volumes if killing the containers
+	if err := s.remove(ctx, cfg.Kill, timeouts.Shutdown); err != nil {
+		errs = append(errs, fmt.Errorf("%w: unable to remove %s: %w", ErrComposeRemove, s.Project, err))
+	} else {
+		clearAbort(s.Project)
+	}
+
+	return errors.Join(errs...)
+}
+
+// kill kills the containers of the stack within timeout.
+func (s *ComposeStack) kill(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := WithOperation(ctx, "kill "+s.Project, timeout)
+	defer cancel()
+
+	return ContextError(ctx, s.Runtime.Kill(ctx, s.Project))
+}
+
+// stop stops the containers of the stack, giving them stopTimeout to exit
+// before they are killed, which must complete within a further timeout.
+func (s *ComposeStack) stop(ctx context.Context, stopTimeout, timeout time.Duration) error {
+	ctx, cancel := WithOperation(ctx, "stop "+s.Project, stopTimeout+timeout)
+	defer cancel()
+
+	return ContextError(ctx, s.Runtime.Stop(ctx, s.Project, stopTimeout))
+}
+
+// remove removes the containers and network of the stack, and the volumes if
+// removeVolumes, within timeout.
+func (s *ComposeStack) remove(ctx context.Context, removeVolumes bool, timeout time.Duration) error {
+	ctx, cancel := WithOperation(ctx, "remove "+s.Project, timeout)
+	defer cancel()
+
+	return ContextError(ctx, s.Runtime.Remove(ctx, s.Project, removeVolumes))
+}
+
+// writeLogs saves the logs of the stack for t within the LogFetch timeout,
+// returning a wrapped ErrSaveLogs if they cannot be saved.
+func (s *ComposeStack) writeLogs(t *testing.T, ctx context.Context, logDir string) error {
+	ctx, cancel := WithOperation(ctx, "save logs of "+s.Project, TimeoutsFor(t).LogFetch)
+	defer cancel()
+
+	if err := s.saveLogs(ctx, logDir, t.Name()); err != nil {
+		return fmt.Errorf("%w: %w", ErrSaveLogs, ContextError(ctx, err))
+	}
+
+	return nil
+}
+
+func (s *ComposeStack) writeInspect(t *testing.T, ctx context.Context, logDir string) {
+	if err := s.saveInspect(ctx, logDir, t.Name()); err != nil {
+		t.Error(err)
+	}
+}
+
+// saveLogs saves the logs of every service to its artifact directory of the
+// test testName below logDir, if included. It continues with the remaining
+// services if one fails and returns the errors joined.
+func (s *ComposeStack) saveLogs(ctx context.Context, logDir, testName string) error {
+	if logDir == "" {
+		return nil
+	}
+
+	var errs []error
+	now := time.Now().Format(time.RFC3339)
+	for _, service := range s.Services {
+		logOutput, err := s.Runtime.Logs(ctx, s.Project, service)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to fetch docker compose log for %s: %w", service, err))
+			continue
+		}
+
+		if err := writeServiceArtifact(logDir, testName, service, fmt.Sprintf("logs-%s.log", now), logOutput); err != nil {
+			errs = append(errs, fmt.Errorf("unable to write docker compose log for %s: %w", service, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// saveInspect saves the inspect output of every service like [saveLogs].
+func (s *ComposeStack) saveInspect(ctx context.Context, logDir, testName string) error {
+	if logDir == "" {
+		return nil
+	}
+
+	var errs []error
+	now := time.Now().Format(time.RFC3339)
+	for _, service := range s.Services {
+		id, err := s.Runtime.ContainerID(ctx, s.Project, service)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to find container for %s: %w", service, err))
+			continue
+		}
+
+		output, err := s.Runtime.Command(ctx, "inspect", id).Output()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to inspect container for %s: %w", service, err))
+			continue
+		}
+
+		if err := writeServiceArtifact(logDir, testName, service, fmt.Sprintf("inspect-%s.json", now), output); err != nil {
+			errs = append(errs, fmt.Errorf("unable to write inspect output for %s: %w", service, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func writeServiceArtifact(logDir, testName, service, name string, data []byte) error {
+	dir := artifactDir(logDir, testName, service)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, name), data, 0644)
+}
@@ -0,0 +1,193 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A Codec is a compression of columnar tables, set by columnar.compression and
+// columnar.compression_level.
+type Codec struct {
+	Compression string // none, pglz, lz4 or zstd
+	Level       int    // level of zstd from 1 to 19, the default if zero
+}
+
+// Name returns the name of the codec, e.g. zstd_3, which is also the suffix of
+// its table in the [CompressionCases].
+func (c Codec) Name() string {
+	if c.Level == 0 {
+		return c.Compression
+	}
+
+	return c.Compression + "_" + strconv.Itoa(c.Level)
+}
+
+// Codecs are the codecs of columnar tables covered by the [CompressionCases].
+var Codecs = []Codec{
+	{Compression: "none"},
+	{Compression: "pglz"},
+	{Compression: "lz4"},
+	{Compression: "zstd", Level: 1},
+	{Compression: "zstd", Level: 3},
+	{Compression: "zstd", Level: 12},
+	{Compression: "zstd", Level: 19},
+}
+
+// columnarDataset generates the rows loaded into the columnar tables of the
+// compression and sizing cases, a mix of sequential, repetitive and random
+// looking values.
+const columnarDataset = `
+SELECT i AS id,
+       i % 100 AS grp,
+       md5(i::text) AS hash,
+       repeat('x', i % 50) AS pad,
+       TIMESTAMP '2020-01-01' + i * INTERVAL '1 minute' AS ts
+FROM generate_series(1, 100000) i`
+
+// datasetChecksum returns the row count and a checksum of the rows of the
+// dataset in from that match the condition.
+const datasetChecksum = `
+SELECT count(*), md5(string_agg(concat_ws(',', id, grp, hash, pad, ts), ';' ORDER BY id)) FROM %s WHERE %s`
+
+// CompressionCases returns cases for each of codecs that load the same dataset
+// into a columnar table created with the codec and verify that its options
+// name the codec and that the rows read back match the dataset. The last case
+// logs the size of every table and its compression ratio compared to the
+// codec none, which must be the first of codecs for the ratios to be reported.
+func CompressionCases(codecs ...Codec) []Case {
+	var cases []Case
+	for _, codec := range codecs {
+		codec := codec
+		table := pgx.Identifier{"compression_" + codec.Name()}.Sanitize()
+
+		settings := map[string]string{"columnar.compression": codec.Compression}
+		if codec.Level != 0 {
+			settings["columnar.compression_level"] = strconv.Itoa(codec.Level)
+		}
+
+		cases = append(cases,
+			Case{
+				Name:     "columnar compression " + codec.Name(),
+				Tags:     []string{TagColumnar},
+				Settings: settings,
+				SQL:      fmt.Sprintf("CREATE TABLE %s USING columnar AS %s", table, columnarDataset),
+			},
+			Case{
+				Name: "columnar compression " + codec.Name() + " options",
+				Tags: []string{TagColumnar},
+				SQL:  fmt.Sprintf("SELECT compression::text FROM columnar.options WHERE regclass = %s::regclass", quoteLiteral(table)),
+				Validate: func(t *testing.T, row pgx.Row) {
+					var compression string
+					if err := row.Scan(&compression); err != nil {
+						t.Fatalf("unable to query the options of %s: %s", table, err)
+					}
+					if compression != codec.Compression {
+						t.Errorf("%s should be compressed with %s, got %s", table, codec.Compression, compression)
+					}
+				},
+			},
+			Case{
+				Name:     "columnar compression " + codec.Name() + " rows",
+				Tags:     []string{TagColumnar},
+				SQL:      datasetCheckSQL(table, ""),
+				Validate: validateDataset(table),
+			},
+		)
+	}
+
+	cases = append(cases, Case{
+		Name: "columnar compression ratios",
+		Tags: []string{TagColumnar},
+		Run: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+			reportCompressionRatios(t, ctx, pool, codecs)
+		},
+	})
+
+	return cases
+}
+
+// reportCompressionRatios logs the size of the table of every codec, and its
+// compression ratio compared to the first codec if that is none.
+func reportCompressionRatios(t *testing.T, ctx context.Context, pool *pgxpool.Pool, codecs []Codec) {
+	t.Helper()
+
+	var baseline int64
+	for i, codec := range codecs {
+		table := pgx.Identifier{"compression_" + codec.Name()}.Sanitize()
+
+		var size int64
+		if err := pool.QueryRow(ctx, "SELECT pg_total_relation_size($1::text::regclass)", table).Scan(&size); err != nil {
+			t.Fatalf("unable to query the size of %s: %s", table, err)
+		}
+
+		if i == 0 && codec.Compression == "none" {
+			baseline = size
+		}
+		if baseline == 0 || size == 0 {
+			t.Logf("compression %s: %d bytes", codec.Name(), size)
+			continue
+		}
+		t.Logf("compression %s: %d bytes, ratio %.2f", codec.Name(), size, float64(baseline)/float64(size))
+	}
+}
+
+// createColumnar is the format of the statement creating the columnar table
+// %[1]s from the columnar dataset, e.g. for uniqueTable with the settings it
+// defaults to, such as columnar.compression.
+var createColumnar = "CREATE TABLE %[1]s USING columnar AS " + strings.ReplaceAll(columnarDataset, "%", "%%")
+
+// execWithSettings runs sql with the settings applied to its transaction only,
+// see inTxWithSettings, e.g. to create a columnar table with the options they
+// default to.
+func execWithSettings(ctx context.Context, pool *pgxpool.Pool, sql string, settings map[string]string) error {
+	return inTxWithSettings(ctx, pool, settings, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, sql)
+		return err
+	})
+}
+
+// datasetCheckSQL returns the query of the row counts and checksums of the
+// columnar dataset and of table, e.g. columnar, both of the rows that match
+// the condition, e.g. "id % 2 = 0" if the others were deleted, or of every row
+// if it is empty, as validated by validateDataset.
+func datasetCheckSQL(table, condition string) string {
+	if condition == "" {
+		condition = "true"
+	}
+
+	return fmt.Sprintf("SELECT * FROM (%s) want, (%s) got",
+		fmt.Sprintf(datasetChecksum, "("+columnarDataset+") dataset", condition),
+		fmt.Sprintf(datasetChecksum, table, condition),
+	)
+}
+
+// validateDataset returns a Validate function of a [Case] failing the test if
+// the rows of table do not match the dataset, see datasetCheckSQL.
+func validateDataset(table string) func(t *testing.T, row pgx.Row) {
+	return func(t *testing.T, row pgx.Row) {
+		t.Helper()
+
+		var wantCount, gotCount int64
+		var wantSum, gotSum string
+		if err := row.Scan(&wantCount, &wantSum, &gotCount, &gotSum); err != nil {
+			t.Fatalf("unable to checksum %s and the dataset: %s", table, err)
+		}
+		if wantCount != gotCount || wantSum != gotSum {
+			t.Errorf("%s should read back the dataset: want=%d rows %s got=%d rows %s", table, wantCount, wantSum, gotCount, gotSum)
+		}
+	}
+}
+
+// checkDataset fails the test if the rows of table do not match the rows of
+// the columnar dataset that match the condition, see datasetCheckSQL.
+func checkDataset(t *testing.T, ctx context.Context, pool *pgxpool.Pool, table, condition string) {
+	t.Helper()
+
+	validateDataset(table)(t, pool.QueryRow(ctx, datasetCheckSQL(table, condition)))
+}
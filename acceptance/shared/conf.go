@@ -0,0 +1,78 @@
+package shared
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// ConfFragmentDir is the directory in the container that the directory
+// returned by [WriteConfFragment] is mounted to.
+const ConfFragmentDir = "/etc/postgresql/hydra-acceptance"
+
+const (
+	confFragmentName = "hydra-acceptance.conf"
+	confIncludeName  = "include.sh"
+)
+
+// RenderConf renders settings as postgresql.conf lines, in the order of their
+// names.
+func RenderConf(settings map[string]string) []byte {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + " = " + quoteLiteral(settings[name]) + "\n")
+	}
+
+	return []byte(b.String())
+}
+
+// WriteConfFragment writes settings, e.g. the Settings of [ContainerOptions],
+// as a postgresql.conf fragment to a temporary directory that is removed when
+// the test completes, together with an init script that includes the fragment
+// in the postgresql.conf of the data directory. Mounting the directory to
+// [ConfFragmentDir] and the script, [ConfIncludeScript] of the directory, into
+// /docker-entrypoint-initdb.d of the postgres image applies the settings from
+// the first start of Postgres after initdb, so that settings which can only be
+// changed at server start, e.g. shared_buffers, take effect. The settings
+// override those of the image, e.g. shared_preload_libraries.
+func WriteConfFragment(t *testing.T, settings map[string]string) string {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "hydra-conf-")
+	if err != nil {
+		t.Fatalf("unable to create conf directory: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	// Postgres runs as another user in the container
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatalf("unable to change the mode of %s: %s", dir, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, confFragmentName), RenderConf(settings), 0644); err != nil {
+		t.Fatalf("unable to write conf fragment: %s", err)
+	}
+
+	include := "#!/bin/sh\necho \"include '" + ConfFragmentDir + "/" + confFragmentName + "'\" >> \"$PGDATA/postgresql.conf\"\n"
+	if err := os.WriteFile(filepath.Join(dir, confIncludeName), []byte(include), 0755); err != nil {
+		t.Fatalf("unable to write conf include script: %s", err)
+	}
+
+	return dir
+}
+
+// ConfIncludeScript returns the path of the init script in dir, a directory
+// returned by [WriteConfFragment].
+func ConfIncludeScript(dir string) string {
+	return filepath.Join(dir, confIncludeName)
+}
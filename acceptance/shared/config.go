@@ -0,0 +1,65 @@
+package shared
+
+import (
+	"testing"
+	"time"
+)
+
+// Config holds the settings shared by the helpers that manage containers, so
+// that new settings do not change their signatures. Helpers take a list of
+// [Option]s and start from the zero Config, or from the settings of the value
+// they are called on, e.g. a [ComposeStack].
+type Config struct {
+	LogDir      string        // root directory to save artifacts to, if included, see [ContainerArtifactDir]
+	StopTimeout time.Duration // time to wait for containers to stop before killing them, the Shutdown timeout if zero, see [TimeoutsFor]
+	Kill        bool          // whether to kill containers and delete their volumes instead of stopping them
+}
+
+// An Option modifies a [Config].
+type Option func(*Config)
+
+// NewConfig returns the Config modified by opts.
+func NewConfig(opts ...Option) Config {
+	return Config{}.With(opts...)
+}
+
+// With returns a copy of c modified by opts.
+func (c Config) With(opts ...Option) Config {
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return c
+}
+
+// WithLogDir saves artifacts below dir.
+func WithLogDir(dir string) Option {
+	return func(c *Config) {
+		c.LogDir = dir
+	}
+}
+
+// WithStopTimeout waits up to timeout for containers to stop.
+func WithStopTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.StopTimeout = timeout
+	}
+}
+
+// WithKill kills containers and deletes their volumes instead of stopping
+// them if kill is true.
+func WithKill(kill bool) Option {
+	return func(c *Config) {
+		c.Kill = kill
+	}
+}
+
+// stopTimeout returns the StopTimeout, or the Shutdown timeout of t if it is
+// zero.
+func (c Config) stopTimeout(t *testing.T) time.Duration {
+	if c.StopTimeout == 0 {
+		return TimeoutsFor(t).Shutdown
+	}
+
+	return c.StopTimeout
+}
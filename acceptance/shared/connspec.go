@@ -0,0 +1,259 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A ConnSpec describes how to connect to a database and renders the connection
+// URL with [ConnSpec.DSN]. Use [NewConnSpec] to create one and derive variants
+// of it with [ConnSpec.With].
+type ConnSpec struct {
+	Username        string
+	Password        string
+	Host            string            // host name or IPv4 or IPv6 address, or the directory of a Unix socket if absolute; the address of [DockerHostAddress] if empty
+	Port            int               // the port published for the container; the default port of the socket if zero
+	Database        string            // the database named after the user if empty
+	ApplicationName string            // reported in pg_stat_activity
+	SearchPath      []string          // schemas to search for unqualified names
+	ConnectTimeout  time.Duration     // time to establish a connection, rounded up to whole seconds
+	Params          map[string]string // further connection parameters or run-time settings, e.g. statement_timeout
+	TLS             TLSOptions
+	Retry           RetryPolicy // how [CreatePGPool] retries connecting
+	Pool            PoolOptions
+}
+
+// PoolOptions tune the pools created by [CreatePGPool], e.g. for load-oriented
+// tests. Zero fields keep the pgxpool defaults.
+type PoolOptions struct {
+	MaxConns          int32         // maximum size of the pool, the greater of 4 and the number of CPUs by default
+	MinConns          int32         // minimum size of the pool kept open by the health check
+	MaxConnLifetime   time.Duration // time after which a connection is closed
+	MaxConnIdleTime   time.Duration // time after which an idle connection is closed
+	HealthCheckPeriod time.Duration // interval of the health check of idle connections
+}
+
+func (o PoolOptions) apply(config *pgxpool.Config) {
+	if o.MaxConns > 0 {
+		config.MaxConns = o.MaxConns
+	}
+	if o.MinConns > 0 {
+		config.MinConns = o.MinConns
+	}
+	if o.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = o.MaxConnLifetime
+	}
+	if o.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = o.MaxConnIdleTime
+	}
+	if o.HealthCheckPeriod > 0 {
+		config.HealthCheckPeriod = o.HealthCheckPeriod
+	}
+}
+
+// A ConnOption modifies a [ConnSpec].
+type ConnOption func(*ConnSpec)
+
+// NewConnSpec returns the spec for connecting as username with password to the
+// port published for a container, modified by opts. Connecting is attempted
+// once unless changed with [WithRetry].
+func NewConnSpec(username, password string, port int, opts ...ConnOption) ConnSpec {
+	return ConnSpec{
+		Username: username,
+		Password: password,
+		Port:     port,
+		Retry:    RetryPolicy{Attempts: 1},
+	}.With(opts...)
+}
+
+// With returns a copy of s modified by opts.
+func (s ConnSpec) With(opts ...ConnOption) ConnSpec {
+	s.SearchPath = append([]string(nil), s.SearchPath...)
+	s.Params = maps.Clone(s.Params)
+
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	return s
+}
+
+// WithUser connects as username with password.
+func WithUser(username, password string) ConnOption {
+	return func(s *ConnSpec) {
+		s.Username, s.Password = username, password
+	}
+}
+
+// WithHost connects to host instead of the address of [DockerHostAddress].
+func WithHost(host string) ConnOption {
+	return func(s *ConnSpec) {
+		s.Host = host
+	}
+}
+
+// WithPort connects to port.
+func WithPort(port int) ConnOption {
+	return func(s *ConnSpec) {
+		s.Port = port
+	}
+}
+
+// WithContainerIP connects to the container directly at its address on
+// network and the port Postgres listens on inside the container rather than
+// through the published port, e.g. for a test running in a container attached
+// to the same network.
+func WithContainerIP(t *testing.T, ctx context.Context, rt ContainerRuntime, network, container string) ConnOption {
+	t.Helper()
+
+	ip := ContainerIP(t, ctx, rt, network, container)
+	return func(s *ConnSpec) {
+		s.Host, s.Port = ip, 5432
+	}
+}
+
+// WithSocketDir connects over the Unix socket in dir instead of TCP, see
+// [ContainerOptions.SocketDir].
+func WithSocketDir(dir string) ConnOption {
+	return func(s *ConnSpec) {
+		s.Host, s.Port = dir, 0
+	}
+}
+
+// WithDatabase connects to the database name.
+func WithDatabase(name string) ConnOption {
+	return func(s *ConnSpec) {
+		s.Database = name
+	}
+}
+
+// WithApplicationName sets the application_name of the connections.
+func WithApplicationName(name string) ConnOption {
+	return func(s *ConnSpec) {
+		s.ApplicationName = name
+	}
+}
+
+// WithSearchPath sets the search_path of the connections to schemas.
+func WithSearchPath(schemas ...string) ConnOption {
+	return func(s *ConnSpec) {
+		s.SearchPath = schemas
+	}
+}
+
+// WithConnectTimeout limits the time to establish a connection.
+func WithConnectTimeout(d time.Duration) ConnOption {
+	return func(s *ConnSpec) {
+		s.ConnectTimeout = d
+	}
+}
+
+// WithParam sets the connection parameter or run-time setting key to value.
+func WithParam(key, value string) ConnOption {
+	return func(s *ConnSpec) {
+		if s.Params == nil {
+			s.Params = map[string]string{}
+		}
+		s.Params[key] = value
+	}
+}
+
+// WithTLS connects according to tls, e.g. to verify the certificate
+// provisioned with [EnableTLS].
+func WithTLS(tls TLSOptions) ConnOption {
+	return func(s *ConnSpec) {
+		s.TLS = tls
+	}
+}
+
+// WithRetry retries connecting according to policy, e.g. while a container is
+// starting.
+func WithRetry(policy RetryPolicy) ConnOption {
+	return func(s *ConnSpec) {
+		s.Retry = policy
+	}
+}
+
+// WithPool tunes the pool according to opts.
+func WithPool(opts PoolOptions) ConnOption {
+	return func(s *ConnSpec) {
+		s.Pool = opts
+	}
+}
+
+// HostAddress returns the host s connects to, resolving an empty host to the
+// address of [DockerHostAddress], e.g. to name the host in a certificate.
+func (s ConnSpec) HostAddress() (string, error) {
+	if s.Host != "" {
+		// IPv6 addresses may be bracketed as in URLs, e.g. [::1]
+		return strings.TrimSuffix(strings.TrimPrefix(s.Host, "["), "]"), nil
+	}
+
+	host, err := DockerHostAddress()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve docker host: %w", err)
+	}
+
+	return host, nil
+}
+
+// DSN returns the connection URL of s. It returns an error if the host is
+// empty and the address of the docker host cannot be resolved.
+func (s ConnSpec) DSN() (string, error) {
+	params := s.TLS.params()
+	for k, v := range s.Params {
+		params[k] = v
+	}
+	if s.ApplicationName != "" {
+		params["application_name"] = s.ApplicationName
+	}
+	if len(s.SearchPath) > 0 {
+		params["search_path"] = strings.Join(s.SearchPath, ",")
+	}
+	if s.ConnectTimeout > 0 {
+		params["connect_timeout"] = strconv.Itoa(int((s.ConnectTimeout + time.Second - 1) / time.Second))
+	}
+
+	var hostPort string
+	switch {
+	case filepath.IsAbs(s.Host):
+		// sockets are passed as a parameter as they cannot be part of the URL
+		params["host"] = s.Host
+		if s.Port != 0 {
+			params["port"] = strconv.Itoa(s.Port)
+		}
+	default:
+		host, err := s.HostAddress()
+		if err != nil {
+			return "", err
+		}
+		hostPort = net.JoinHostPort(host, strconv.Itoa(s.Port))
+	}
+
+	query := url.Values{}
+	for k, v := range params {
+		query.Set(k, v)
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(s.Username, s.Password),
+		Host:     hostPort,
+		RawQuery: query.Encode(),
+	}
+	if s.Database != "" {
+		u.Path = "/" + s.Database
+	}
+
+	return u.String(), nil
+}
@@ -0,0 +1,220 @@
+package shared
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SQLSTATEs of the violations of constraints.
+const (
+	SQLStateNotNullViolation = "23502"
+	SQLStateUniqueViolation  = "23505"
+	SQLStateCheckViolation   = "23514"
+)
+
+// A Constraint defines constraints on the columnar table
+// {schema}.constraint_columnar (id, grp, val) of ids 1 to 10, with grp id % 3
+// and val v1 to v10, and runs statements that must either succeed or violate
+// them. The heap table {schema}.constraint_heap has a primary key of ids 1 to
+// 10. Constraints that columnar tables do not support, e.g. foreign keys,
+// must fail to be defined instead.
+type Constraint struct {
+	Name     string
+	Define   []string // statements defining the constraints, with {schema} the schema of the tables
+	SQLState string   // SQLSTATE the last of Define must fail with, e.g. SQLStateFeatureNotSupported
+	Checks   []ConstraintCheck
+	IDs      string // ids of the columnar table after Checks, in order and separated by commas
+}
+
+// A ConstraintCheck is a statement on the tables of a [Constraint], with
+// {schema} their schema, that must fail with SQLState if set, or succeed.
+type ConstraintCheck struct {
+	SQL      string
+	SQLState string // e.g. SQLStateUniqueViolation
+}
+
+// Case returns c as a [Case] that runs it with [RunConstraint].
+func (c Constraint) Case() Case {
+	return Case{
+		Name: "columnar constraint " + c.Name,
+		Tags: []string{TagColumnar},
+		Run: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+			RunConstraint(t, ctx, pool, c)
+		},
+	}
+}
+
+// ConstraintCases returns a case for each of constraints.
+func ConstraintCases(constraints ...Constraint) []Case {
+	return casesOf(constraints...)
+}
+
+// constraintSchema creates the tables of a [Constraint].
+const constraintSchema = `
+CREATE TABLE {schema}.constraint_columnar (id INT8, grp INT4, val TEXT) USING columnar;
+INSERT INTO {schema}.constraint_columnar SELECT i, i % 3, 'v' || i FROM generate_series(1, 10) i;
+CREATE TABLE {schema}.constraint_heap (id INT8 PRIMARY KEY) USING heap;
+INSERT INTO {schema}.constraint_heap SELECT i FROM generate_series(1, 10) i;
+`
+
+// Constraints are the constraints covered by the [ConstraintCases].
+var Constraints = []Constraint{
+	{
+		Name:   "primary key",
+		Define: []string{"ALTER TABLE {schema}.constraint_columnar ADD PRIMARY KEY (id)"},
+		Checks: []ConstraintCheck{
+			{SQL: "INSERT INTO {schema}.constraint_columnar VALUES (11, 2, 'v11')"},
+			{SQL: "INSERT INTO {schema}.constraint_columnar VALUES (5, 2, 'again')", SQLState: SQLStateUniqueViolation},
+			{SQL: "INSERT INTO {schema}.constraint_columnar VALUES (NULL, 2, 'null')", SQLState: SQLStateNotNullViolation},
+			{SQL: "UPDATE {schema}.constraint_columnar SET id = 2 WHERE id = 1", SQLState: SQLStateUniqueViolation},
+			// the key of a deleted row can be inserted again
+			{SQL: "DELETE FROM {schema}.constraint_columnar WHERE id = 3"},
+			{SQL: "INSERT INTO {schema}.constraint_columnar VALUES (3, 0, 'again')"},
+		},
+		IDs: "1,2,3,4,5,6,7,8,9,10,11",
+	},
+	{
+		Name:   "unique constraint",
+		Define: []string{"ALTER TABLE {schema}.constraint_columnar ADD UNIQUE (val)"},
+		Checks: []ConstraintCheck{
+			{SQL: "INSERT INTO {schema}.constraint_columnar VALUES (11, 2, 'v1')", SQLState: SQLStateUniqueViolation},
+			{SQL: "UPDATE {schema}.constraint_columnar SET val = 'v2' WHERE id = 1", SQLState: SQLStateUniqueViolation},
+			// NULLs are distinct
+			{SQL: "INSERT INTO {schema}.constraint_columnar VALUES (11, 2, NULL)"},
+			{SQL: "INSERT INTO {schema}.constraint_columnar VALUES (12, 0, NULL)"},
+		},
+		IDs: "1,2,3,4,5,6,7,8,9,10,11,12",
+	},
+	{
+		Name:   "unique constraint on existing duplicates",
+		Define: []string{"ALTER TABLE {schema}.constraint_columnar ADD UNIQUE (grp)"},
+		// the index of the constraint cannot be built
+		SQLState: SQLStateUniqueViolation,
+		Checks: []ConstraintCheck{
+			{SQL: "INSERT INTO {schema}.constraint_columnar VALUES (11, 2, 'v11')"},
+		},
+		IDs: "1,2,3,4,5,6,7,8,9,10,11",
+	},
+	{
+		Name:   "check constraint",
+		Define: []string{"ALTER TABLE {schema}.constraint_columnar ADD CHECK (grp >= 0)"},
+		Checks: []ConstraintCheck{
+			{SQL: "INSERT INTO {schema}.constraint_columnar VALUES (11, -1, 'v11')", SQLState: SQLStateCheckViolation},
+			{SQL: "UPDATE {schema}.constraint_columnar SET grp = -1 WHERE id = 1", SQLState: SQLStateCheckViolation},
+			{SQL: "INSERT INTO {schema}.constraint_columnar VALUES (11, 5, 'v11')"},
+		},
+		IDs: "1,2,3,4,5,6,7,8,9,10,11",
+	},
+	{
+		Name:     "check constraint on existing violations",
+		Define:   []string{"ALTER TABLE {schema}.constraint_columnar ADD CHECK (id < 5)"},
+		SQLState: SQLStateCheckViolation,
+		Checks: []ConstraintCheck{
+			{SQL: "INSERT INTO {schema}.constraint_columnar VALUES (11, 2, 'v11')"},
+		},
+		IDs: "1,2,3,4,5,6,7,8,9,10,11",
+	},
+	{
+		Name:   "not null",
+		Define: []string{"ALTER TABLE {schema}.constraint_columnar ALTER COLUMN val SET NOT NULL"},
+		Checks: []ConstraintCheck{
+			{SQL: "INSERT INTO {schema}.constraint_columnar VALUES (11, 2, NULL)", SQLState: SQLStateNotNullViolation},
+			{SQL: "UPDATE {schema}.constraint_columnar SET val = NULL WHERE id = 1", SQLState: SQLStateNotNullViolation},
+		},
+		IDs: "1,2,3,4,5,6,7,8,9,10",
+	},
+	{
+		Name:   "on conflict do nothing",
+		Define: []string{"ALTER TABLE {schema}.constraint_columnar ADD PRIMARY KEY (id)"},
+		Checks: []ConstraintCheck{
+			{SQL: "INSERT INTO {schema}.constraint_columnar VALUES (5, 2, 'again'), (11, 2, 'v11') ON CONFLICT DO NOTHING"},
+		},
+		IDs: "1,2,3,4,5,6,7,8,9,10,11",
+	},
+	{
+		// foreign keys are enforced by AFTER ... FOR EACH ROW triggers, which
+		// columnar tables do not support, so that no key is checked
+		Name:     "foreign key referencing heap table is not supported",
+		Define:   []string{"ALTER TABLE {schema}.constraint_columnar ADD FOREIGN KEY (id) REFERENCES {schema}.constraint_heap (id)"},
+		SQLState: SQLStateFeatureNotSupported,
+		Checks: []ConstraintCheck{
+			{SQL: "INSERT INTO {schema}.constraint_columnar VALUES (42, 0, 'unreferenced')"},
+		},
+		IDs: "1,2,3,4,5,6,7,8,9,10,42",
+	},
+	{
+		Name:     "columnar table with a foreign key is not supported",
+		Define:   []string{"CREATE TABLE {schema}.constraint_referencing (id INT8 REFERENCES {schema}.constraint_heap (id)) USING columnar"},
+		SQLState: SQLStateFeatureNotSupported,
+		IDs:      "1,2,3,4,5,6,7,8,9,10",
+	},
+	{
+		Name: "foreign key of heap table referencing columnar table is not supported",
+		Define: []string{
+			"ALTER TABLE {schema}.constraint_columnar ADD PRIMARY KEY (id)",
+			"CREATE TABLE {schema}.constraint_referencing (id INT8 REFERENCES {schema}.constraint_columnar (id)) USING heap",
+		},
+		SQLState: SQLStateFeatureNotSupported,
+		Checks: []ConstraintCheck{
+			{SQL: "DELETE FROM {schema}.constraint_columnar WHERE id = 10"},
+		},
+		IDs: "1,2,3,4,5,6,7,8,9",
+	},
+}
+
+// RunConstraint runs c against the database of pool, in a schema of its own
+// that is dropped when the test completes.
+func RunConstraint(t *testing.T, ctx context.Context, pool *pgxpool.Pool, c Constraint) {
+	t.Helper()
+
+	name := uniqueIdent(t, "k")
+	CreateSchema(t, ctx, pool, name)
+	schema := pgx.Identifier{name}.Sanitize()
+	inSchema := func(sql string) string {
+		return strings.ReplaceAll(sql, "{schema}", schema)
+	}
+
+	if _, err := pool.Exec(ctx, inSchema(constraintSchema), pgx.QueryExecModeSimpleProtocol); err != nil {
+		t.Fatalf("unable to create the tables: %s", err)
+	}
+
+	for i, sql := range c.Define {
+		_, err := pool.Exec(ctx, inSchema(sql))
+		if i == len(c.Define)-1 && c.SQLState != "" {
+			if err == nil {
+				t.Fatalf("%s should fail with SQLSTATE %s", sql, c.SQLState)
+			}
+			AssertSQLState(t, err, c.SQLState)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unable to define the constraint with %s: %s", sql, err)
+		}
+	}
+
+	for _, check := range c.Checks {
+		_, err := pool.Exec(ctx, inSchema(check.SQL))
+		switch {
+		case check.SQLState != "":
+			if err == nil {
+				t.Errorf("%s should fail with SQLSTATE %s", check.SQL, check.SQLState)
+				continue
+			}
+			AssertSQLState(t, err, check.SQLState)
+		case err != nil:
+			t.Errorf("%s should succeed: %s", check.SQL, err)
+		}
+	}
+
+	var ids string
+	if err := pool.QueryRow(ctx, inSchema("SELECT coalesce(string_agg(id::text, ',' ORDER BY id), '') FROM {schema}.constraint_columnar")).Scan(&ids); err != nil {
+		t.Fatalf("unable to query the ids of constraint_columnar: %s", err)
+	}
+	if ids != c.IDs {
+		t.Errorf("constraint_columnar should have the ids left by the checks: want=%s got=%s", c.IDs, ids)
+	}
+}
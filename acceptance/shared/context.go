@@ -0,0 +1,147 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	// ErrRunDeadline is the cause of the contexts of [Context] once the run
+	// exceeded its deadline, see [InitRunContext].
+	ErrRunDeadline = errors.New("run deadline exceeded")
+	// ErrOperationTimeout is the cause of the context of an operation once it
+	// exceeded its timeout, see [WithOperation].
+	ErrOperationTimeout = errors.New("operation timed out")
+)
+
+var (
+	// runCtx bounds every test of the run, it is released when the test binary
+	// exits
+	runCtx    = context.Background()
+	runCancel context.CancelFunc
+
+	operationsMu sync.Mutex
+	operations   = map[*operation]struct{}{}
+)
+
+// An operation is a docker command, query or wait in flight, see
+// WithOperation.
+type operation struct {
+	name  string
+	start time.Time
+}
+
+// InitRunContext bounds every test of the run by timeout from now, e.g. to
+// fail with diagnostics before the CI kills the job, or leaves the run
+// unbounded if timeout is 0. Once the deadline is exceeded the operations in
+// flight are logged. It is called from TestMain of each suite.
+func InitRunContext(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+
+	runCtx, runCancel = context.WithTimeoutCause(context.Background(), timeout, fmt.Errorf("%w: RUN_TIMEOUT of %s", ErrRunDeadline, timeout))
+	context.AfterFunc(runCtx, func() {
+		logOperations(context.Cause(runCtx))
+	})
+
+	Logger.Info("run deadline", "timeout", timeout)
+}
+
+// Context returns the context of t, which helpers are passed to bound what
+// they run. It is done when the run exceeds its deadline, see
+// [InitRunContext], ahead of the deadline of go test -timeout, so that the
+// test fails with the operations in flight logged and has time to save the
+// logs of its containers during cleanup, and once t completes.
+func Context(t *testing.T) context.Context {
+	t.Helper()
+
+	ctx := runCtx
+	if deadline, ok := t.Deadline(); ok {
+		// leave time for the containers to stop and their logs to be saved,
+		// but no more than a quarter of the remaining time
+		timeouts := TimeoutsFor(t)
+		grace := timeouts.Shutdown + timeouts.LogFetch
+		if remaining := time.Until(deadline); grace > remaining/4 {
+			grace = remaining / 4
+		}
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadlineCause(ctx, deadline.Add(-grace), fmt.Errorf("%w: go test -timeout, leaving %s to clean up", ErrRunDeadline, grace))
+		t.Cleanup(cancel)
+		context.AfterFunc(ctx, func() {
+			if cause := context.Cause(ctx); errors.Is(cause, ErrRunDeadline) {
+				logOperations(cause)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+
+	return ctx
+}
+
+// WithOperation returns a child of ctx for the operation name, e.g. "stop
+// docker compose", that times out after timeout with an [ErrOperationTimeout]
+// cause naming it. The operation is logged if it times out, or if the run
+// exceeds its deadline while it is in flight. cancel must be called once the
+// operation completed.
+func WithOperation(ctx context.Context, name string, timeout time.Duration) (context.Context, context.CancelFunc) {
+	op := &operation{name: name, start: time.Now()}
+	operationsMu.Lock()
+	operations[op] = struct{}{}
+	operationsMu.Unlock()
+
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, fmt.Errorf("%w: %s took longer than %s", ErrOperationTimeout, name, timeout))
+	stop := context.AfterFunc(ctx, func() {
+		if errors.Is(context.Cause(ctx), ErrOperationTimeout) {
+			Logger.Warn("operation timed out", "op", name, "timeout", timeout)
+		}
+	})
+
+	return ctx, func() {
+		stop()
+		cancel()
+
+		operationsMu.Lock()
+		delete(operations, op)
+		operationsMu.Unlock()
+	}
+}
+
+// ContextError returns err annotated with the cause of ctx if ctx is done,
+// e.g. the operation that timed out, as errors of pgx and exec only report
+// that the context was done.
+func ContextError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+
+	cause := context.Cause(ctx)
+	if errors.Is(err, cause) {
+		return err
+	}
+
+	return fmt.Errorf("%w: %w", err, cause)
+}
+
+// logOperations logs the operations in flight as the run exceeded its
+// deadline with cause.
+func logOperations(cause error) {
+	operationsMu.Lock()
+	var inFlight []string
+	for op := range operations {
+		inFlight = append(inFlight, fmt.Sprintf("%s (%s)", op.name, time.Since(op.start).Round(time.Millisecond)))
+	}
+	operationsMu.Unlock()
+	sort.Strings(inFlight)
+
+	Logger.Error("deadline exceeded", "cause", cause, "in_flight", strings.Join(inFlight, ", "))
+}
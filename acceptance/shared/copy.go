@@ -0,0 +1,57 @@
+package shared
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// CopyToContainer copies hostPath, a file or directory, to containerPath inside
+// the container name. Copied files are owned by root inside the container.
+func CopyToContainer(t *testing.T, ctx context.Context, rt ContainerRuntime, hostPath, name, containerPath string) {
+	t.Helper()
+
+	if output, err := rt.Command(ctx, "cp", hostPath, name+":"+containerPath).CombinedOutput(); err != nil {
+		t.Fatalf("unable to copy %s to %s:%s: %s: %s", hostPath, name, containerPath, err, output)
+	}
+}
+
+// CopyFromContainer copies containerPath, a file or directory, from the
+// container name to hostPath.
+func CopyFromContainer(t *testing.T, ctx context.Context, rt ContainerRuntime, name, containerPath, hostPath string) {
+	t.Helper()
+
+	if output, err := rt.Command(ctx, "cp", name+":"+containerPath, hostPath).CombinedOutput(); err != nil {
+		t.Fatalf("unable to copy %s:%s to %s: %s: %s", name, containerPath, hostPath, err, output)
+	}
+}
+
+// WriteFileToContainer writes data to containerPath inside the container name,
+// e.g. to inject a SQL fixture or a postgresql.conf fragment.
+func WriteFileToContainer(t *testing.T, ctx context.Context, rt ContainerRuntime, name, containerPath string, data []byte) {
+	t.Helper()
+
+	hostPath := filepath.Join(t.TempDir(), filepath.Base(containerPath))
+	if err := os.WriteFile(hostPath, data, 0644); err != nil {
+		t.Fatalf("unable to write %s: %s", hostPath, err)
+	}
+
+	CopyToContainer(t, ctx, rt, hostPath, name, containerPath)
+}
+
+// ReadFileFromContainer returns the contents of containerPath inside the
+// container name, e.g. to verify a generated pg_dump file.
+func ReadFileFromContainer(t *testing.T, ctx context.Context, rt ContainerRuntime, name, containerPath string) []byte {
+	t.Helper()
+
+	hostPath := filepath.Join(t.TempDir(), filepath.Base(containerPath))
+	CopyFromContainer(t, ctx, rt, name, containerPath, hostPath)
+
+	data, err := os.ReadFile(hostPath)
+	if err != nil {
+		t.Fatalf("unable to read %s: %s", hostPath, err)
+	}
+
+	return data
+}
@@ -0,0 +1,134 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// crashLogLines are logged by Postgres when a backend crashes and the
+// postmaster restarts the remaining processes.
+var crashLogLines = [][]byte{
+	[]byte("was terminated by signal"),
+	[]byte("terminating any other active server processes"),
+	[]byte("PANIC:"),
+}
+
+// crashExcerptLines is the number of log lines kept before and after each
+// crash in the excerpt.
+const crashExcerptLines = 20
+
+// CheckForCrash fails the test if Postgres in the container of a service in a
+// compose project crashed: either its container exited with a non-zero code
+// before it was stopped, or the logs show a backend that was terminated by a
+// signal. The log excerpt around the crash and any core files in dataDir, the
+// data directory Postgres runs in, are saved to the [ContainerArtifactDir] of
+// the service below the [WithLogDir] of opts. Core files are only written to dataDir if the core
+// ulimit of the container allows it and kernel.core_pattern of the host is a
+// relative path, e.g. core. It reports whether a crash was found.
+func CheckForCrash(t *testing.T, ctx context.Context, rt ContainerRuntime, project, service, dataDir string, opts ...Option) bool {
+	t.Helper()
+
+	cfg := NewConfig(opts...)
+
+	// errors are not fatal so that the caller still brings the container down
+	state, err := rt.State(ctx, project, service)
+	if err != nil {
+		t.Errorf("unable to inspect %s: %s", service, err)
+		return false
+	}
+
+	logs, err := rt.Logs(ctx, project, service)
+	if err != nil {
+		t.Errorf("unable to fetch logs for %s: %s", service, err)
+		return false
+	}
+
+	excerpt := crashExcerpt(logs)
+	exited := state.Status == "exited" && state.ExitCode != 0
+	if excerpt == nil && !exited {
+		return false
+	}
+
+	dir := ContainerArtifactDir(t, cfg.LogDir, service)
+	if dir == "" {
+		t.Errorf("postgres in %s crashed (exit code %d, oom killed: %t):\n%s", service, state.ExitCode, state.OOMKilled, excerpt)
+		return true
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("crash-%s.log", now)), excerpt, 0644); err != nil {
+		t.Errorf("unable to write crash log for %s: %s", service, err)
+	}
+
+	if id, err := rt.ContainerID(ctx, project, service); err == nil {
+		collectCoreFiles(t, ctx, rt, id, dataDir, filepath.Join(dir, "cores-"+now), state.Status == "running")
+	}
+
+	t.Errorf("postgres in %s crashed (exit code %d, oom killed: %t), see %s", service, state.ExitCode, state.OOMKilled, dir)
+
+	return true
+}
+
+// crashExcerpt returns the lines of logs around every crash, or nil if there
+// was none.
+func crashExcerpt(logs []byte) []byte {
+	lines := bytes.Split(logs, []byte("\n"))
+
+	keep := make([]bool, len(lines))
+	found := false
+	for i, line := range lines {
+		for _, crash := range crashLogLines {
+			if !bytes.Contains(line, crash) {
+				continue
+			}
+
+			found = true
+			for j := max(0, i-crashExcerptLines); j <= min(len(lines)-1, i+crashExcerptLines); j++ {
+				keep[j] = true
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	var excerpt bytes.Buffer
+	for i, line := range lines {
+		if keep[i] {
+			excerpt.Write(line)
+			excerpt.WriteByte('\n')
+		}
+	}
+
+	return excerpt.Bytes()
+}
+
+// collectCoreFiles copies the core files in dataDir of the container name to
+// hostDir. Core files can only be listed while the container is running,
+// otherwise only dataDir/core is tried.
+func collectCoreFiles(t *testing.T, ctx context.Context, rt ContainerRuntime, name, dataDir, hostDir string, running bool) {
+	t.Helper()
+
+	cores := []string{path.Join(dataDir, "core")}
+	if running {
+		result := ExecInContainer(t, ctx, rt, name, "sh", "-c", fmt.Sprintf("ls -1d %s/core* 2>/dev/null", dataDir))
+		cores = strings.Fields(result.Stdout)
+	}
+
+	for _, core := range cores {
+		if err := os.MkdirAll(hostDir, 0755); err != nil {
+			t.Errorf("unable to create %s: %s", hostDir, err)
+			return
+		}
+
+		// a missing core file is expected, so failures are not reported
+		_ = rt.Command(ctx, "cp", name+":"+core, filepath.Join(hostDir, path.Base(core))).Run()
+	}
+}
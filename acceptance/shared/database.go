@@ -0,0 +1,47 @@
+package shared
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// CreateDatabase creates the database name using pool, which must be connected
+// as a user allowed to create databases, and drops it when the test completes.
+// Connect to it with [PoolForDatabase].
+func CreateDatabase(t *testing.T, ctx context.Context, pool *pgxpool.Pool, name string) {
+	t.Helper()
+
+	ident := pgx.Identifier{name}.Sanitize()
+	if _, err := pool.Exec(ctx, "CREATE DATABASE "+ident); err != nil {
+		t.Fatalf("unable to create database %s: %s", name, err)
+	}
+
+	t.Cleanup(func() {
+		// the test context may already be done during cleanup, and pools that are
+		// not closed yet would keep the database from being dropped
+		ctx := context.Background()
+		if _, err := pool.Exec(ctx, "SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = $1 AND pid <> pg_backend_pid()", name); err != nil {
+			t.Errorf("unable to disconnect from database %s: %s", name, err)
+		}
+		if _, err := pool.Exec(ctx, "DROP DATABASE IF EXISTS "+ident); err != nil {
+			t.Errorf("unable to drop database %s: %s", name, err)
+		}
+	})
+}
+
+// PoolForDatabase connects to the database name as described by spec, e.g.
+// [DockerComposeManager.ConnSpec], and closes the pool when the test
+// completes.
+func PoolForDatabase(t *testing.T, ctx context.Context, spec ConnSpec, name string) *pgxpool.Pool {
+	t.Helper()
+
+	pool, err := CreatePGPool(t, ctx, spec.With(WithDatabase(name)))
+	if err != nil {
+		t.Fatalf("unable to connect to database %s: %s", name, err)
+	}
+
+	return pool
+}
@@ -0,0 +1,199 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A Datatype is a type of column covered by the [DatatypeCases] with
+// representative values, as SQL literals of the type. {schema} stands for the
+// schema of the case in Type and Values, e.g. to refer to an enum it creates.
+type Datatype struct {
+	Type       string
+	Values     []string
+	MinVersion int // server_version_num the type was introduced in, e.g. 140000
+}
+
+// datatypeSchema creates the user defined types of the Datatypes in {schema}.
+const datatypeSchema = `
+CREATE TYPE {schema}.mood AS ENUM ('sad', 'ok', 'happy');
+CREATE TYPE {schema}.pair AS (a INT4, b TEXT);
+CREATE DOMAIN {schema}.positive AS INT4 CHECK (VALUE > 0);
+`
+
+// Datatypes are the types of columns covered by the [DatatypeCases], most of
+// the built-in types of Postgres, arrays and user defined types. The values
+// include the edge cases of each type, e.g. its limits or special values.
+var Datatypes = []Datatype{
+	{Type: "BOOLEAN", Values: []string{"true", "false"}},
+	{Type: "INT2", Values: []string{"-32768", "0", "32767"}},
+	{Type: "INT4", Values: []string{"-2147483648", "0", "2147483647"}},
+	{Type: "INT8", Values: []string{"-9223372036854775808", "0", "9223372036854775807"}},
+	{Type: "NUMERIC", Values: []string{"'0'", "'-1.5'", "'123456789012345678901234567890.123456789'", "'NaN'"}},
+	{Type: "NUMERIC(10,3)", Values: []string{"'1234567.891'", "'-0.001'"}},
+	{Type: "FLOAT4", Values: []string{"'1.5'", "'-0'", "'Infinity'", "'NaN'", "'3.4e38'"}},
+	{Type: "FLOAT8", Values: []string{"'3.141592653589793'", "'-0'", "'-Infinity'", "'NaN'", "'1e308'"}},
+	{Type: "MONEY", Values: []string{"'12.34'", "'-92233720368547758.08'"}},
+	{Type: "CHAR(5)", Values: []string{"'ab'", "'abcde'"}},
+	{Type: "VARCHAR(10)", Values: []string{"''", "'héllo wörld'"}},
+	{Type: "TEXT", Values: []string{"''", "'multi\nline'", "'unicode ✓ 日本'", "repeat('x', 10000)"}},
+	{Type: "BYTEA", Values: []string{"'\\x'", "'\\x00ff10'", "decode(repeat('ab', 5000), 'hex')"}},
+	{Type: "DATE", Values: []string{"'4713-01-01 BC'", "'2000-02-29'", "'infinity'", "'5874897-12-31'"}},
+	{Type: "TIME", Values: []string{"'00:00'", "'23:59:59.999999'", "'24:00'"}},
+	{Type: "TIMETZ", Values: []string{"'12:00+05:30'", "'00:00-14:59'"}},
+	{Type: "TIMESTAMP", Values: []string{"'2000-01-01 00:00'", "'1900-06-30 12:34:56.789'", "'-infinity'"}},
+	{Type: "TIMESTAMPTZ", Values: []string{"'2021-03-14 02:30:00 America/New_York'", "'2021-06-01 12:00:00+05:30'", "'1969-12-31 23:59:59.999999 UTC'", "'2000-01-01 00:00 Asia/Kathmandu'", "'infinity'"}},
+	{Type: "INTERVAL", Values: []string{"'1 year 2 months 3 days 04:05:06.789'", "'-178000000 years'", "'0'"}},
+	{Type: "UUID", Values: []string{"'00000000-0000-0000-0000-000000000000'", "'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11'"}},
+	{Type: "INET", Values: []string{"'192.168.0.1/24'", "'::1'", "'2001:db8::ff00:42:8329/64'"}},
+	{Type: "CIDR", Values: []string{"'10.0.0.0/8'", "'2001:db8::/32'"}},
+	{Type: "MACADDR", Values: []string{"'08:00:2b:01:02:03'"}},
+	{Type: "MACADDR8", Values: []string{"'08:00:2b:01:02:03:04:05'"}},
+	{Type: "BIT(8)", Values: []string{"B'10101010'", "B'00000000'"}},
+	{Type: "VARBIT", Values: []string{"B''", "B'1011001110001'"}},
+	{Type: "JSON", Values: []string{"'{\"b\": 1,  \"a\": [true, null]}'", "'\"string\"'"}},
+	{Type: "JSONB", Values: []string{"'{\"b\": 1, \"a\": [true, null, 1.50]}'", "'[]'", "'{\"nested\": {\"deep\": {\"er\": \"✓\"}}}'"}},
+	{Type: "POINT", Values: []string{"'(1.5,-2)'"}},
+	{Type: "LINE", Values: []string{"'{1,-1,0}'"}},
+	{Type: "LSEG", Values: []string{"'[(0,0),(1,1)]'"}},
+	{Type: "BOX", Values: []string{"'(1,1),(0,0)'"}},
+	{Type: "PATH", Values: []string{"'[(0,0),(1,1),(2,0)]'", "'((0,0),(1,1),(2,0))'"}},
+	{Type: "POLYGON", Values: []string{"'((0,0),(1,1),(2,0))'"}},
+	{Type: "CIRCLE", Values: []string{"'<(0,0),2.5>'"}},
+	{Type: "TSVECTOR", Values: []string{"to_tsvector('english', 'The quick brown foxes jumped')"}},
+	{Type: "TSQUERY", Values: []string{"'fox & (jump | run)'"}},
+	{Type: "INT4RANGE", Values: []string{"'[1,10)'", "'empty'", "'(,5]'"}},
+	{Type: "INT8RANGE", Values: []string{"'[-9223372036854775808,0)'"}},
+	{Type: "NUMRANGE", Values: []string{"'[1.5,2.5]'"}},
+	{Type: "DATERANGE", Values: []string{"'[2020-01-01,infinity)'"}},
+	{Type: "TSRANGE", Values: []string{"'[2020-01-01 00:00,2020-01-02 00:00)'"}},
+	{Type: "TSTZRANGE", Values: []string{"'[2020-01-01 00:00+00,2020-01-01 00:00 America/Los_Angeles)'"}},
+	{Type: "INT4MULTIRANGE", Values: []string{"'{[1,3), [5,7)}'", "'{}'"}, MinVersion: 140000},
+	{Type: "OID", Values: []string{"0", "4294967295"}},
+	{Type: "PG_LSN", Values: []string{"'0/0'", "'FFFFFFFF/FFFFFFFF'"}},
+	{Type: "INT4[]", Values: []string{"'{}'", "'{1,NULL,3}'", "'{{1,2},{3,4}}'", "'[0:1]={7,8}'"}},
+	{Type: "TEXT[]", Values: []string{"'{\"a b\",\"c,d\",NULL,\"\"}'"}},
+	{Type: "NUMERIC[]", Values: []string{"'{1.1,NaN,-0.0}'"}},
+	{Type: "TIMESTAMPTZ[]", Values: []string{"ARRAY['2020-01-01 00:00+14', '2020-01-01 00:00-12']::TIMESTAMPTZ[]"}},
+	{Type: "UUID[]", Values: []string{"ARRAY[gen_random_uuid(), NULL]"}, MinVersion: 130000},
+	{Type: "{schema}.mood", Values: []string{"'sad'", "'happy'"}},
+	{Type: "{schema}.mood[]", Values: []string{"'{ok,happy}'"}},
+	{Type: "{schema}.pair", Values: []string{"ROW(1, 'one')", "ROW(NULL, NULL)"}},
+	{Type: "{schema}.pair[]", Values: []string{"ARRAY[ROW(1, 'a'), ROW(2, NULL)]::{schema}.pair[]"}},
+	{Type: "{schema}.positive", Values: []string{"1", "2147483647"}},
+}
+
+// DatatypeCases returns a case that stores the values of datatypes in a
+// columnar table, see [RunDatatypes].
+func DatatypeCases(datatypes ...Datatype) []Case {
+	return []Case{
+		{
+			Name: "columnar round trip of datatypes",
+			Tags: []string{TagColumnar},
+			Run: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+				RunDatatypes(t, ctx, pool, datatypes)
+			},
+		},
+	}
+}
+
+// RunDatatypes creates a heap and a columnar table in a schema of its own,
+// which is dropped when the test completes, with a column for each of
+// datatypes that the server supports, and a row for each of their values and
+// a row of NULLs. Columns with fewer values than others repeat them. The rows
+// are loaded into the heap table and copied to the columnar table, whose
+// values must then be byte for byte those of the heap table, compared with
+// the *= operator of their binary images.
+func RunDatatypes(t *testing.T, ctx context.Context, pool *pgxpool.Pool, datatypes []Datatype) {
+	t.Helper()
+
+	name := uniqueIdent(t, "d")
+	CreateSchema(t, ctx, pool, name)
+	schema := pgx.Identifier{name}.Sanitize()
+	inSchema := func(sql string) string {
+		return strings.ReplaceAll(sql, "{schema}", schema)
+	}
+
+	// the types are created with the simple protocol, as they are made of
+	// several statements
+	if _, err := pool.Exec(ctx, inSchema(datatypeSchema), pgx.QueryExecModeSimpleProtocol); err != nil {
+		t.Fatalf("unable to create the types: %s", err)
+	}
+
+	var version int
+	if err := pool.QueryRow(ctx, "SELECT current_setting('server_version_num')::int").Scan(&version); err != nil {
+		t.Fatalf("unable to query the server version: %s", err)
+	}
+
+	var supported []Datatype
+	rows := 0
+	for _, d := range datatypes {
+		if d.MinVersion > version {
+			t.Logf("skipping %s, introduced in %d", d.Type, d.MinVersion)
+			continue
+		}
+		supported = append(supported, d)
+		rows = max(rows, len(d.Values))
+	}
+
+	columns := make([]string, len(supported))
+	for i, d := range supported {
+		columns[i] = fmt.Sprintf("c%d %s", i, inSchema(d.Type))
+	}
+	for _, using := range []string{"heap", "columnar"} {
+		sql := fmt.Sprintf("CREATE TABLE %s.dt_%s (id INT4, %s) USING %s", schema, using, strings.Join(columns, ", "), using)
+		if _, err := pool.Exec(ctx, sql); err != nil {
+			t.Fatalf("unable to create the %s table: %s", using, err)
+		}
+	}
+
+	for row := 0; row <= rows; row++ {
+		values := []string{fmt.Sprint(row)}
+		for _, d := range supported {
+			// the last row is made of NULLs
+			value := "NULL"
+			if row < rows {
+				value = d.Values[row%len(d.Values)]
+			}
+			values = append(values, fmt.Sprintf("(%s)::%s", inSchema(value), inSchema(d.Type)))
+		}
+
+		if _, err := pool.Exec(ctx, fmt.Sprintf("INSERT INTO %s.dt_heap VALUES (%s)", schema, strings.Join(values, ", "))); err != nil {
+			t.Fatalf("unable to insert row %d: %s", row, err)
+		}
+	}
+	if _, err := pool.Exec(ctx, fmt.Sprintf("INSERT INTO %[1]s.dt_columnar SELECT * FROM %[1]s.dt_heap", schema)); err != nil {
+		t.Fatalf("unable to copy the rows to the columnar table: %s", err)
+	}
+
+	// the rows of each column whose binary image differs from the heap table,
+	// NULLs included
+	mismatches := make([]string, len(supported))
+	for i := range supported {
+		mismatches[i] = fmt.Sprintf("count(*) FILTER (WHERE NOT ROW(h.c%[1]d) *= ROW(c.c%[1]d))", i)
+	}
+	sql := fmt.Sprintf("SELECT count(*), %[2]s FROM %[1]s.dt_heap h JOIN %[1]s.dt_columnar c USING (id)", schema, strings.Join(mismatches, ", "))
+
+	counts := make([]int64, len(supported)+1)
+	dest := make([]any, len(counts))
+	for i := range counts {
+		dest[i] = &counts[i]
+	}
+	if err := pool.QueryRow(ctx, sql).Scan(dest...); err != nil {
+		t.Fatalf("unable to compare the tables: %s", err)
+	}
+
+	if want, got := int64(rows+1), counts[0]; want != got {
+		t.Errorf("row count should match: want=%d got=%d", want, got)
+	}
+	for i, d := range supported {
+		if n := counts[i+1]; n > 0 {
+			t.Errorf("%d values of %s should round trip byte for byte", n, inSchema(d.Type))
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A DiffTable is a table of a [Differential].
+type DiffTable struct {
+	Name    string
+	Columns string // column definitions, e.g. "id INT8, t TEXT"
+}
+
+// A Differential checks the columnar access method against heap. Its Tables
+// are created twice, as heap and as columnar tables, with the same rows, and
+// every query must return the same rows from both. The SQL must be
+// deterministic, e.g. not use random(), and should not aggregate floating
+// point values, whose rounding depends on the order they are summed in.
+type Differential struct {
+	Name    string
+	Tables  []DiffTable
+	Load    string   // SQL loading the heap tables, whose rows are then copied to the columnar tables
+	Changes []string // optional statements run against both after loading, e.g. UPDATE or DELETE
+	Queries []string // queries returning the same rows from both, in any order
+	Tags    []string // tags of the case in addition to TagColumnar, see Case
+}
+
+// Case returns d as a [Case] that runs it with [RunDifferential].
+func (d Differential) Case() Case {
+	return Case{
+		Name: d.Name,
+		Tags: append([]string{TagColumnar}, d.Tags...),
+		Run: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+			RunDifferential(t, ctx, pool, d)
+		},
+	}
+}
+
+// DifferentialCases returns the [Differentials] as cases.
+func DifferentialCases() []Case {
+	return casesOf(Differentials...)
+}
+
+// RunDifferential runs d against the database of pool. The heap and the
+// columnar tables are created with the same names in two schemas of their own,
+// which are dropped when the test completes, and the SQL of d runs with the
+// search_path set to each of them in turn, so that it refers to the tables
+// unqualified. The test fails for every query that returns different rows,
+// compared in their text representation regardless of their order.
+func RunDifferential(t *testing.T, ctx context.Context, pool *pgxpool.Pool, d Differential) {
+	t.Helper()
+
+	heap := uniqueIdent(t, "h")
+	columnar := uniqueIdent(t, "c")
+	CreateSchema(t, ctx, pool, heap)
+	CreateSchema(t, ctx, pool, columnar)
+
+	for _, table := range d.Tables {
+		for schema, using := range map[string]string{heap: "heap", columnar: "columnar"} {
+			sql := fmt.Sprintf("CREATE TABLE %s (%s) USING %s", pgx.Identifier{schema, table.Name}.Sanitize(), table.Columns, using)
+			if _, err := pool.Exec(ctx, sql); err != nil {
+				t.Fatalf("unable to create %s table %s: %s", using, table.Name, err)
+			}
+		}
+	}
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("unable to acquire connection: %s", err)
+	}
+
+	// the search_path of the connection may have been set once connected,
+	// e.g. by PoolForSchema, so that RESET would not restore it
+	var searchPath string
+	if err := conn.QueryRow(ctx, "SELECT current_setting('search_path')").Scan(&searchPath); err != nil {
+		conn.Release()
+		t.Fatalf("unable to read search_path: %s", err)
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), "SELECT set_config('search_path', $1, false)", searchPath); err != nil {
+			t.Errorf("unable to restore search_path: %s", err)
+		}
+		conn.Release()
+	}()
+
+	inSchema := func(schema string) {
+		t.Helper()
+
+		if _, err := conn.Exec(ctx, "SET search_path TO "+pgx.Identifier{schema}.Sanitize()+", public"); err != nil {
+			t.Fatalf("unable to set search_path to %s: %s", schema, err)
+		}
+	}
+
+	inSchema(heap)
+	if _, err := conn.Exec(ctx, d.Load); err != nil {
+		t.Fatalf("unable to load the heap tables: %s", err)
+	}
+	for _, table := range d.Tables {
+		sql := fmt.Sprintf("INSERT INTO %s SELECT * FROM %s", pgx.Identifier{columnar, table.Name}.Sanitize(), pgx.Identifier{heap, table.Name}.Sanitize())
+		if _, err := conn.Exec(ctx, sql); err != nil {
+			t.Fatalf("unable to copy %s to the columnar table: %s", table.Name, err)
+		}
+	}
+
+	for _, sql := range d.Changes {
+		for _, schema := range []string{heap, columnar} {
+			inSchema(schema)
+			if _, err := conn.Exec(ctx, sql); err != nil {
+				t.Fatalf("unable to run %s in %s: %s", sql, schema, err)
+			}
+		}
+	}
+
+	for _, sql := range d.Queries {
+		inSchema(heap)
+		want := queryTextRows(t, ctx, conn.Conn(), sql)
+		inSchema(columnar)
+		got := queryTextRows(t, ctx, conn.Conn(), sql)
+
+		if diff := diffRows(want, got); diff != "" {
+			t.Errorf("columnar returned different rows than heap for %s: %s", strings.TrimSpace(sql), diff)
+		}
+	}
+}
+
+// queryTextRows returns the rows of sql in their text representation, with
+// the columns of a row separated by tabs and NULL as \N, sorted.
+func queryTextRows(t *testing.T, ctx context.Context, conn *pgx.Conn, sql string) []string {
+	t.Helper()
+
+	text, err := textRows(ctx, conn, sql)
+	if err != nil {
+		t.Fatalf("unable to query %s: %s", sql, err)
+	}
+
+	return text
+}
+
+// textRows returns the rows of sql as by queryTextRows, or the error of sql,
+// e.g. to check its SQLSTATE.
+func textRows(ctx context.Context, conn *pgx.Conn, sql string) ([]string, error) {
+	// the simple protocol returns every value as text
+	rows, err := conn.Query(ctx, sql, pgx.QueryExecModeSimpleProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var text []string
+	for rows.Next() {
+		values := rows.RawValues()
+		cols := make([]string, len(values))
+		for i, v := range values {
+			if v == nil {
+				cols[i] = `\N`
+				continue
+			}
+			cols[i] = string(v)
+		}
+		text = append(text, strings.Join(cols, "\t"))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(text)
+
+	return text, nil
+}
+
+// diffRows describes the first difference between the sorted rows want and
+// got, or returns an empty string if they are the same.
+func diffRows(want, got []string) string {
+	for i := 0; i < len(want) || i < len(got); i++ {
+		switch {
+		case i >= len(got):
+			return fmt.Sprintf("%d rows instead of %d, missing %q", len(got), len(want), want[i])
+		case i >= len(want):
+			return fmt.Sprintf("%d rows instead of %d, unexpected %q", len(got), len(want), got[i])
+		case want[i] != got[i]:
+			return fmt.Sprintf("row %q instead of %q", got[i], want[i])
+		}
+	}
+
+	return ""
+}
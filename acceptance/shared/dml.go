@@ -0,0 +1,264 @@
+package shared
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// UpdateDeleteCases describe single row and bulk UPDATE and DELETE on a
+// columnar table spanning many stripes, what they return with RETURNING and
+// what later queries see, as rows of columnar tables are not updated in place
+// but deleted and written anew.
+var UpdateDeleteCases = []Case{
+	{
+		Name: "create columnar table for update and delete",
+		Tags: []string{TagColumnar},
+		SQL: `
+CREATE TABLE dml_columnar (id INT8, grp INT, val TEXT) USING columnar;
+			`,
+		Settings: map[string]string{
+			"columnar.stripe_row_limit":      "1000",
+			"columnar.chunk_group_row_limit": "1000",
+		},
+	},
+	{
+		Name: "insert into columnar table for update and delete",
+		Tags: []string{TagColumnar},
+		SQL: `
+INSERT INTO dml_columnar SELECT i, i % 10, 'v' || i FROM generate_series(1, 10000) i;
+			`,
+	},
+	{
+		Name: "update single row of columnar table returning",
+		Tags: []string{TagColumnar},
+		SQL: `
+WITH updated AS (
+    UPDATE dml_columnar SET val = 'updated' WHERE id = 42 RETURNING id, val
+)
+SELECT count(*), string_agg(id || '=' || val, ',') FROM updated;
+			`,
+		Validate: func(t *testing.T, row pgx.Row) {
+			var count int
+			var rows string
+			if err := row.Scan(&count, &rows); err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := 1, count; want != got {
+				t.Errorf("updated row count should match: want=%d got=%d", want, got)
+			}
+			if want, got := "42=updated", rows; want != got {
+				t.Errorf("returned rows should match: want=%s got=%s", want, got)
+			}
+		},
+	},
+	{
+		Name: "updated single row of columnar table visible",
+		Tags: []string{TagColumnar},
+		SQL: `
+SELECT count(*), count(*) FILTER (WHERE val = 'updated') FROM dml_columnar WHERE id = 42;
+			`,
+		Validate: func(t *testing.T, row pgx.Row) {
+			var count, updated int
+			if err := row.Scan(&count, &updated); err != nil {
+				t.Fatal(err)
+			}
+
+			// the old version of the row must not be visible
+			if want, got := 1, count; want != got {
+				t.Errorf("row count should match: want=%d got=%d", want, got)
+			}
+			if want, got := 1, updated; want != got {
+				t.Errorf("updated row count should match: want=%d got=%d", want, got)
+			}
+		},
+	},
+	{
+		Name: "bulk update of columnar table returning",
+		Tags: []string{TagColumnar},
+		SQL: `
+WITH updated AS (
+    UPDATE dml_columnar SET grp = grp + 100 WHERE grp = 3 RETURNING grp
+)
+SELECT count(*), min(grp), max(grp) FROM updated;
+			`,
+		Validate: func(t *testing.T, row pgx.Row) {
+			var count, min, max int
+			if err := row.Scan(&count, &min, &max); err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := 1000, count; want != got {
+				t.Errorf("updated row count should match: want=%d got=%d", want, got)
+			}
+			if min != 103 || max != 103 {
+				t.Errorf("returned rows should have the new value: want=103 got=%d..%d", min, max)
+			}
+		},
+	},
+	{
+		Name: "bulk update of columnar table visible",
+		Tags: []string{TagColumnar},
+		SQL: `
+SELECT count(*), count(*) FILTER (WHERE grp = 3), count(*) FILTER (WHERE grp = 103) FROM dml_columnar;
+			`,
+		Validate: func(t *testing.T, row pgx.Row) {
+			var count, old, updated int
+			if err := row.Scan(&count, &old, &updated); err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := 10000, count; want != got {
+				t.Errorf("row count should match: want=%d got=%d", want, got)
+			}
+			if want, got := 0, old; want != got {
+				t.Errorf("old row count should match: want=%d got=%d", want, got)
+			}
+			if want, got := 1000, updated; want != got {
+				t.Errorf("updated row count should match: want=%d got=%d", want, got)
+			}
+		},
+	},
+	{
+		Name: "update every row of columnar table",
+		Tags: []string{TagColumnar},
+		SQL: `
+UPDATE dml_columnar SET val = val || '!';
+			`,
+	},
+	{
+		Name: "updated rows of columnar table visible once",
+		Tags: []string{TagColumnar},
+		SQL: `
+SELECT count(*), count(DISTINCT id), count(*) FILTER (WHERE val LIKE '%!'), max(val) FILTER (WHERE id = 42) FROM dml_columnar;
+			`,
+		Validate: func(t *testing.T, row pgx.Row) {
+			var count, distinct, updated int
+			var val string
+			if err := row.Scan(&count, &distinct, &updated, &val); err != nil {
+				t.Fatal(err)
+			}
+
+			if count != 10000 || distinct != 10000 || updated != 10000 {
+				t.Errorf("every row should be visible once and updated: want=10000 got=%d rows, %d distinct, %d updated", count, distinct, updated)
+			}
+			if want, got := "updated!", val; want != got {
+				t.Errorf("row updated twice should match: want=%s got=%s", want, got)
+			}
+		},
+	},
+	{
+		Name: "delete single row of columnar table returning",
+		Tags: []string{TagColumnar},
+		SQL: `
+WITH deleted AS (
+    DELETE FROM dml_columnar WHERE id = 7 RETURNING id, val
+)
+SELECT count(*), string_agg(id || '=' || val, ',') FROM deleted;
+			`,
+		Validate: func(t *testing.T, row pgx.Row) {
+			var count int
+			var rows string
+			if err := row.Scan(&count, &rows); err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := 1, count; want != got {
+				t.Errorf("deleted row count should match: want=%d got=%d", want, got)
+			}
+			if want, got := "7=v7!", rows; want != got {
+				t.Errorf("returned rows should match: want=%s got=%s", want, got)
+			}
+		},
+	},
+	{
+		Name: "bulk delete from columnar table returning",
+		Tags: []string{TagColumnar},
+		SQL: `
+WITH deleted AS (
+    DELETE FROM dml_columnar WHERE grp = 5 RETURNING id
+)
+SELECT count(*), sum(id) FROM deleted;
+			`,
+		Validate: func(t *testing.T, row pgx.Row) {
+			var count, sum int64
+			if err := row.Scan(&count, &sum); err != nil {
+				t.Fatal(err)
+			}
+
+			// 5, 15, ..., 9995
+			if count != 1000 || sum != 5000000 {
+				t.Errorf("deleted rows should match: want=1000 rows summing to 5000000 got=%d rows summing to %d", count, sum)
+			}
+		},
+	},
+	{
+		Name: "deleted rows of columnar table not visible",
+		Tags: []string{TagColumnar},
+		SQL: `
+SELECT count(*), count(*) FILTER (WHERE id = 7 OR grp = 5) FROM dml_columnar;
+			`,
+		Validate: func(t *testing.T, row pgx.Row) {
+			var count, deleted int
+			if err := row.Scan(&count, &deleted); err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := 8999, count; want != got {
+				t.Errorf("row count should match: want=%d got=%d", want, got)
+			}
+			if want, got := 0, deleted; want != got {
+				t.Errorf("deleted row count should match: want=%d got=%d", want, got)
+			}
+		},
+	},
+	{
+		Name: "update and delete of columnar table in transaction",
+		Tags: []string{TagColumnar},
+		Run: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+			WithTx(t, ctx, pool, func(t *testing.T, tx pgx.Tx) {
+				var val string
+				if err := tx.QueryRow(ctx, "UPDATE dml_columnar SET val = 'in tx' WHERE id = 1 RETURNING val").Scan(&val); err != nil {
+					t.Fatalf("unable to update: %s", err)
+				}
+				if err := tx.QueryRow(ctx, "SELECT val FROM dml_columnar WHERE id = 1").Scan(&val); err != nil {
+					t.Fatalf("unable to query: %s", err)
+				}
+				if want, got := "in tx", val; want != got {
+					t.Errorf("update should be visible in its transaction: want=%s got=%s", want, got)
+				}
+
+				if _, err := tx.Exec(ctx, "DELETE FROM dml_columnar WHERE grp < 5"); err != nil {
+					t.Fatalf("unable to delete: %s", err)
+				}
+				var count int
+				if err := tx.QueryRow(ctx, "SELECT count(*) FROM dml_columnar").Scan(&count); err != nil {
+					t.Fatalf("unable to query: %s", err)
+				}
+				// grp 6 to 9 but the row 7 deleted before, and grp 103
+				if want, got := 4999, count; want != got {
+					t.Errorf("rows left in the transaction should match: want=%d got=%d", want, got)
+				}
+			})
+
+			var count int
+			var val string
+			if err := pool.QueryRow(ctx, "SELECT count(*), max(val) FILTER (WHERE id = 1) FROM dml_columnar").Scan(&count, &val); err != nil {
+				t.Fatalf("unable to query: %s", err)
+			}
+			if count != 8999 || val != "v1!" {
+				t.Errorf("rolled back changes should not be visible: want=8999 rows, v1! got=%d rows, %s", count, val)
+			}
+		},
+	},
+	{
+		Name: "drop columnar table for update and delete",
+		Tags: []string{TagColumnar},
+		SQL: `
+DROP TABLE dml_columnar;
+			`,
+	},
+}
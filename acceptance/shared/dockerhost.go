@@ -0,0 +1,88 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const localHost = "127.0.0.1"
+
+var (
+	dockerHostOnce sync.Once
+	dockerHost     string
+	dockerHostErr  error
+)
+
+// DockerHostAddress returns the host that ports published by the Docker daemon
+// are reachable on. It is derived from DOCKER_HOST or, when that is unset, from
+// the endpoint of the active docker context, so that tests can run against a
+// remote daemon over tcp:// or ssh://. Local daemons resolve to 127.0.0.1. The
+// result is computed once per test binary.
+func DockerHostAddress() (string, error) {
+	dockerHostOnce.Do(func() {
+		endpoint := os.Getenv("DOCKER_HOST")
+		if endpoint == "" {
+			endpoint, dockerHostErr = dockerContextEndpoint()
+			if dockerHostErr != nil {
+				return
+			}
+		}
+
+		dockerHost, dockerHostErr = hostFromDockerEndpoint(endpoint)
+	})
+
+	return dockerHost, dockerHostErr
+}
+
+// dockerContextEndpoint returns the docker endpoint of the active docker
+// context. If the docker CLI is not installed an empty endpoint is returned.
+func dockerContextEndpoint() (string, error) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	args := []string{"context", "inspect", "--format", "{{.Endpoints.docker.Host}}"}
+	logCommand("docker", args)
+	output, err := exec.CommandContext(ctx, "docker", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("unable to inspect docker context: %w: %s", err, output)
+	}
+
+	return string(bytes.TrimSpace(output)), nil
+}
+
+// hostFromDockerEndpoint extracts the host from a docker endpoint such as
+// tcp://10.0.0.5:2376 or ssh://user@builder. Socket and pipe endpoints are
+// local.
+func hostFromDockerEndpoint(endpoint string) (string, error) {
+	if endpoint == "" {
+		return localHost, nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse docker endpoint %s: %w", endpoint, err)
+	}
+
+	switch u.Scheme {
+	case "unix", "npipe", "fd":
+		return localHost, nil
+	case "tcp", "http", "https", "ssh":
+		if u.Hostname() == "" {
+			return "", fmt.Errorf("docker endpoint %s has no host", endpoint)
+		}
+
+		return u.Hostname(), nil
+	default:
+		return "", fmt.Errorf("unsupported docker endpoint scheme %s", u.Scheme)
+	}
+}
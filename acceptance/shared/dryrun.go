@@ -0,0 +1,128 @@
+package shared
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// DryRun makes the suites log the commands, compose files and SQL of the cases
+// that they would run instead of running them, e.g. to review what a suite
+// will do with a configuration. The containers are started with a runtime
+// from [NewDryRunRuntime], and tests are skipped once they would start one.
+var DryRun bool
+
+// NewDryRunRuntime returns a [ContainerRuntime] that logs the commands of rt
+// instead of running them. Commands succeed without output, and containers
+// are reported as running and healthy.
+func NewDryRunRuntime(rt ContainerRuntime) ContainerRuntime {
+	return dryRunRuntime{name: rt.Name()}
+}
+
+type dryRunRuntime struct {
+	name string
+}
+
+func (r dryRunRuntime) log(args ...string) {
+	Logger.Info("dry run", "cmd", r.name+" "+strings.Join(args, " "))
+}
+
+func (r dryRunRuntime) Name() string {
+	return r.name
+}
+
+func (r dryRunRuntime) Start(ctx context.Context, project, composeFile string) error {
+	r.log("compose", "--project-name", project, "--file", composeFile, "up", "--detach")
+	return nil
+}
+
+func (r dryRunRuntime) Stop(ctx context.Context, project string, timeout time.Duration) error {
+	r.log("compose", "--project-name", project, "stop", "--timeout", strconv.Itoa(int(timeout.Seconds())))
+	return nil
+}
+
+func (r dryRunRuntime) Kill(ctx context.Context, project string) error {
+	r.log("compose", "--project-name", project, "kill")
+	return nil
+}
+
+func (r dryRunRuntime) Remove(ctx context.Context, project string, removeVolumes bool) error {
+	args := []string{"compose", "--project-name", project, "down"}
+	if removeVolumes {
+		args = append(args, "--volumes")
+	}
+	r.log(args...)
+
+	return nil
+}
+
+func (r dryRunRuntime) Logs(ctx context.Context, project, service string) ([]byte, error) {
+	r.log("compose", "--project-name", project, "logs", "--no-color", service)
+	return nil, nil
+}
+
+func (r dryRunRuntime) Exec(ctx context.Context, project, service string, cmd ...string) ([]byte, error) {
+	r.log(append([]string{"compose", "--project-name", project, "exec", "-T", service}, cmd...)...)
+	return nil, nil
+}
+
+func (r dryRunRuntime) State(ctx context.Context, project, service string) (ContainerState, error) {
+	return ContainerState{Status: "running", Health: "healthy"}, nil
+}
+
+func (r dryRunRuntime) ContainerID(ctx context.Context, project, service string) (string, error) {
+	return project + "-" + service, nil
+}
+
+func (r dryRunRuntime) Command(ctx context.Context, args ...string) *exec.Cmd {
+	r.log(args...)
+	return exec.CommandContext(ctx, "true")
+}
+
+func (r dryRunRuntime) FindProject(ctx context.Context, labels map[string]string) (string, error) {
+	return "", nil
+}
+
+// SkipDryRun logs commands and the content of file, e.g. the compose file or
+// manifest a test would start, and skips the test as nothing it runs after
+// starting the containers can be planned.
+func SkipDryRun(t *testing.T, file string, commands ...string) {
+	t.Helper()
+
+	for _, cmd := range commands {
+		t.Logf("dry run: %s", cmd)
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("unable to read %s: %s", file, err)
+	}
+	t.Logf("dry run: %s:\n%s", file, b)
+
+	t.Skip("Skipping the rest of the test in a dry run")
+}
+
+// planCases logs the SQL of the cases selected by [CaseTagFilter] in a dry
+// run.
+func planCases(t *testing.T, cases []Case) {
+	t.Helper()
+
+	if !DryRun {
+		return
+	}
+
+	for _, c := range cases {
+		if c.Skip || !CaseTagFilter.Match(c.Tags) {
+			continue
+		}
+		if c.Run != nil {
+			t.Logf("dry run: case %s runs a function", c.Name)
+			continue
+		}
+		t.Logf("dry run: case %s:\n%s", c.Name, strings.TrimSpace(c.SQL))
+	}
+}
@@ -0,0 +1,185 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A Server is a running Hydra container, e.g. the source or target of a dump.
+type Server struct {
+	Container string        // name or ID of the container, e.g. from [ContainerRuntime.ContainerID]
+	Pool      *pgxpool.Pool // pool of the superuser
+	ConnSpec  ConnSpec      // how to connect to the container from the tests
+}
+
+// DumpFormats are the formats of pg_dump covered by [RunDumpRestore], plain
+// SQL restored with psql and the custom archive restored with pg_restore.
+var DumpFormats = []string{"plain", "custom"}
+
+// dumpSchema creates the objects of the database dumped by [RunDumpRestore]:
+// columnar tables with indexes, constraints, deleted and updated rows and
+// non-default options, a heap table with constraints, a partitioned table
+// with columnar and heap partitions and a view.
+const dumpSchema = `
+CREATE EXTENSION IF NOT EXISTS columnar;
+
+CREATE TABLE dump_columnar (id INT8 PRIMARY KEY, grp INT4 NOT NULL, val TEXT, ts TIMESTAMPTZ DEFAULT now()) USING columnar;
+INSERT INTO dump_columnar SELECT i, i % 100, md5(i::text), TIMESTAMPTZ '2020-01-01 00:00+00' + i * INTERVAL '1 minute' FROM generate_series(1, 50000) i;
+CREATE INDEX dump_columnar_grp ON dump_columnar (grp);
+DELETE FROM dump_columnar WHERE id % 10 = 0;
+UPDATE dump_columnar SET val = upper(val) WHERE id % 7 = 0;
+
+CREATE TABLE dump_columnar_options (id INT4, doc JSONB, tags TEXT[]) USING columnar;
+SELECT columnar.alter_columnar_table_set('dump_columnar_options', compression => 'pglz', stripe_row_limit => 5000, chunk_group_row_limit => 1000);
+INSERT INTO dump_columnar_options SELECT i, jsonb_build_object('i', i), ARRAY['t' || i % 3] FROM generate_series(1, 20000) i;
+
+CREATE TABLE dump_heap (id INT4 PRIMARY KEY, columnar_id INT8 CHECK (columnar_id > 0)) USING heap;
+INSERT INTO dump_heap SELECT i, i FROM generate_series(1, 1000) i WHERE i % 10 <> 0;
+
+CREATE TABLE dump_part (ts DATE, id INT8) PARTITION BY RANGE (ts);
+CREATE TABLE dump_part_2020 PARTITION OF dump_part FOR VALUES FROM ('2020-01-01') TO ('2021-01-01') USING columnar;
+CREATE TABLE dump_part_2021 PARTITION OF dump_part FOR VALUES FROM ('2021-01-01') TO ('2022-01-01') USING heap;
+INSERT INTO dump_part SELECT d, row_number() OVER (ORDER BY d) FROM generate_series(DATE '2020-01-01', DATE '2021-12-31', INTERVAL '1 day') d;
+
+CREATE VIEW dump_view AS SELECT grp, count(*) AS n FROM dump_columnar GROUP BY grp;
+`
+
+// dumpTables are the tables of the dumpSchema whose rows must be restored.
+var dumpTables = []string{"dump_columnar", "dump_columnar_options", "dump_heap", "dump_part", "dump_view"}
+
+// dumpChecks describe the schema of the restored database that must match
+// that of the dumped database, as text rows.
+var dumpChecks = map[string]string{
+	"relations and access methods": `
+SELECT c.relname, c.relkind, coalesce(am.amname, '')
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace LEFT JOIN pg_am am ON am.oid = c.relam
+WHERE n.nspname = 'public'`,
+	"columns": `
+SELECT table_name, column_name, data_type, is_nullable, coalesce(column_default, '')
+FROM information_schema.columns
+WHERE table_schema = 'public'`,
+	"indexes": `
+SELECT indexname, indexdef FROM pg_indexes WHERE schemaname = 'public'`,
+	"constraints": `
+SELECT conrelid::regclass::text, conname, pg_get_constraintdef(oid) FROM pg_constraint WHERE connamespace = 'public'::regnamespace`,
+	"views": `
+SELECT viewname, definition FROM pg_views WHERE schemaname = 'public'`,
+	"partitions": `
+SELECT inhrelid::regclass::text, inhparent::regclass::text, pg_get_expr(c.relpartbound, c.oid)
+FROM pg_inherits JOIN pg_class c ON c.oid = inhrelid`,
+	"extensions": `
+SELECT extname FROM pg_extension`,
+}
+
+// RunDumpRestore creates a database with columnar tables on the source and
+// dumps it with pg_dump in every one of formats, from the target over a
+// network of their own, into a new database of the target, which must then
+// have the same schema, access methods and rows as the source.
+func RunDumpRestore(t *testing.T, ctx context.Context, rt ContainerRuntime, source, target Server, formats ...string) {
+	t.Helper()
+
+	// the target reaches the source by its alias on the network
+	const sourceHost = "dump-source"
+	connectServers(t, ctx, rt, source, target, sourceHost)
+
+	const database = "dump_source"
+	CreateDatabase(t, ctx, source.Pool, database)
+	dumped := PoolForDatabase(t, ctx, source.ConnSpec, database)
+	if _, err := dumped.Exec(ctx, dumpSchema, pgx.QueryExecModeSimpleProtocol); err != nil {
+		t.Fatalf("unable to create the dumped database: %s", err)
+	}
+
+	for _, format := range formats {
+		format := format
+		t.Run(format, func(t *testing.T) {
+			restoredDatabase := "dump_restored_" + format
+			CreateDatabase(t, ctx, target.Pool, restoredDatabase)
+
+			file := "/tmp/" + restoredDatabase + ".dump"
+			password := "PGPASSWORD=" + source.ConnSpec.Password
+			dump := []string{"env", password, "pg_dump", "-h", sourceHost, "-U", source.ConnSpec.Username, "-d", database, "-f", file}
+			var restore []string
+			switch format {
+			case "plain":
+				dump = append(dump, "-Fp")
+				restore = []string{"env", password, "psql", "-v", "ON_ERROR_STOP=1", "-q", "-U", target.ConnSpec.Username, "-d", restoredDatabase, "-f", file}
+			case "custom":
+				dump = append(dump, "-Fc")
+				restore = []string{"env", password, "pg_restore", "--exit-on-error", "-U", target.ConnSpec.Username, "-d", restoredDatabase, file}
+			default:
+				t.Fatalf("unknown dump format %s", format)
+			}
+
+			for _, cmd := range [][]string{dump, restore} {
+				if result := ExecInContainer(t, ctx, rt, target.Container, cmd...); result.ExitCode != 0 {
+					t.Fatalf("%s should succeed, exited with %d: %s", cmd[2], result.ExitCode, result.Stderr)
+				}
+			}
+
+			restored := PoolForDatabase(t, ctx, target.ConnSpec, restoredDatabase)
+			compareDatabases(t, ctx, dumped, restored)
+		})
+	}
+}
+
+// compareDatabases fails the test if the schema checked by dumpChecks or the
+// rows of the dumpTables of restored differ from those of dumped.
+func compareDatabases(t *testing.T, ctx context.Context, dumped, restored *pgxpool.Pool) {
+	t.Helper()
+
+	checks := map[string]string{}
+	for name, sql := range dumpChecks {
+		checks[name] = sql
+	}
+	for _, table := range dumpTables {
+		checks["rows of "+table] = tableChecksum(table)
+	}
+
+	for name, sql := range checks {
+		var want, got []string
+		withConn(t, ctx, dumped, func(conn *pgx.Conn) {
+			want = queryTextRows(t, ctx, conn, sql)
+		})
+		withConn(t, ctx, restored, func(conn *pgx.Conn) {
+			got = queryTextRows(t, ctx, conn, sql)
+		})
+
+		if len(want) == 0 {
+			t.Errorf("%s of the dumped database should not be empty", name)
+		}
+		if diff := diffRows(want, got); diff != "" {
+			t.Errorf("%s of the restored database should match the dumped database: %s", name, diff)
+		}
+	}
+}
+
+// connectServers connects source and target to a network of their own, on
+// which the target reaches the source by sourceHost.
+func connectServers(t *testing.T, ctx context.Context, rt ContainerRuntime, source, target Server, sourceHost string) {
+	t.Helper()
+
+	network := UniqueName(t, sourceHost)
+	CreateNetwork(t, ctx, rt, network)
+	for _, s := range []struct {
+		container string
+		aliases   []string
+	}{{source.Container, []string{sourceHost}}, {target.Container, nil}} {
+		s := s
+		ConnectNetwork(t, ctx, rt, network, s.container, s.aliases...)
+		t.Cleanup(func() {
+			// the test context may already be done during cleanup, and the
+			// network can only be removed once its containers are detached
+			DisconnectNetwork(t, context.Background(), rt, network, s.container)
+		})
+	}
+}
+
+// tableChecksum returns a query of the count and checksum of the rows of
+// table, independent of their order.
+func tableChecksum(table string) string {
+	return fmt.Sprintf("SELECT count(*), md5(coalesce(string_agg(r::text, ';' ORDER BY r::text), '')) FROM %s r", pgx.Identifier{table}.Sanitize())
+}
@@ -0,0 +1,146 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/joeshaw/envdecode"
+)
+
+// ErrInvalidConfig is returned by [ConfigFromEnv] when the environment does
+// not describe a valid configuration.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// A Validator reports whether a configuration is valid, joining an error for
+// every invalid setting.
+type Validator interface {
+	Validate() error
+}
+
+// EnvConfig is the configuration from the environment that is common to the
+// suites. It is embedded in the Config of each suite, which adds the settings
+// that are specific to the suite or differ in their defaults.
+type EnvConfig struct {
+	ArtifactDir    LogDir   `env:"ARTIFACT_DIR,default="`
+	PostgresPort   int      `env:"POSTGRES_PORT,default=0"`
+	QueryExecModes []string `env:"QUERY_EXEC_MODES,default="`
+	SuiteFile      string   `env:"SUITE_FILE,default="`    // path of a hydra-acceptance.yaml, see [SuiteFile]
+	CaseTags       []string `env:"CASE_TAGS,default="`     // expressions of a tag filter, e.g. smoke or !destructive, see [ParseTagFilter]
+	Seed           int64    `env:"SEED,default=0"`         // seed of the random data, taken from the clock if 0, see [InitSeed]
+	DryRun         bool     `env:"DRY_RUN,default=false"`  // log what the suites would run instead of running it, see [DryRun]
+	LogLevel       string   `env:"LOG_LEVEL,default=info"` // level of the [Logger], debug also logs every command and SQL statement
+	OnlyCases      []string `env:"ONLY_CASES,default="`    // names of the cases to run, see [CaseSelection]
+	SkipCases      []string `env:"SKIP_CASES,default="`    // names of the cases to skip, e.g. partition@16, see [CaseSelection]
+	RunID          string   `env:"RUN_ID,default="`        // ID labelling the created resources, generated if empty, see [InitRunID]
+
+	RunTimeout      time.Duration `env:"RUN_TIMEOUT,default=0"`         // deadline of the whole run, only bounded by go test -timeout if 0, see [InitRunContext]
+	ConnectTimeout  time.Duration `env:"CONNECT_TIMEOUT,default=1s"`    // see [Timeouts]
+	QueryTimeout    time.Duration `env:"QUERY_TIMEOUT,default=5s"`      // see [Timeouts]
+	LogFetchTimeout time.Duration `env:"LOG_FETCH_TIMEOUT,default=30s"` // see [Timeouts]
+}
+
+// Validate reports whether the ArtifactDir is valid, see [LogDir], the
+// SuiteFile is absolute, as go tests cannot determine the directory that they
+// are running from, the PostgresPort is a valid port or 0 to allocate a free
+// one, the QueryExecModes are known, the LogLevel, CaseTags, OnlyCases,
+// SkipCases and RunID are valid and the timeouts are positive, or not negative
+// for the RunTimeout.
+func (c EnvConfig) Validate() error {
+	var errs []error
+	if err := ValidateContainerLogDir(string(c.ArtifactDir)); err != nil {
+		errs = append(errs, fmt.Errorf("ARTIFACT_DIR: %w", err))
+	}
+	if c.SuiteFile != "" && !filepath.IsAbs(c.SuiteFile) {
+		errs = append(errs, fmt.Errorf("SUITE_FILE must be an absolute path, got %s", c.SuiteFile))
+	}
+	if err := ValidatePort("POSTGRES_PORT", c.PostgresPort); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ParseQueryExecModes(c.QueryExecModes); err != nil {
+		errs = append(errs, fmt.Errorf("QUERY_EXEC_MODES: %w", err))
+	}
+	if _, err := ParseLogLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: %w", err))
+	}
+	if _, err := ParseTagFilter(c.CaseTags); err != nil {
+		errs = append(errs, fmt.Errorf("CASE_TAGS: %w", err))
+	}
+	if _, err := ParseCaseSelection(c.OnlyCases, c.SkipCases); err != nil {
+		errs = append(errs, fmt.Errorf("ONLY_CASES or SKIP_CASES: %w", err))
+	}
+	if err := ValidateRunID("RUN_ID", c.RunID); err != nil {
+		errs = append(errs, err)
+	}
+	if c.RunTimeout < 0 {
+		errs = append(errs, fmt.Errorf("RUN_TIMEOUT must not be negative, got %s", c.RunTimeout))
+	}
+	if err := ValidateTimeout("CONNECT_TIMEOUT", c.ConnectTimeout); err != nil {
+		errs = append(errs, err)
+	}
+	if err := ValidateTimeout("QUERY_TIMEOUT", c.QueryTimeout); err != nil {
+		errs = append(errs, err)
+	}
+	if err := ValidateTimeout("LOG_FETCH_TIMEOUT", c.LogFetchTimeout); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// ConfigFromEnv loads config, a pointer to a struct tagged for envdecode, from
+// the environment and validates it. The error lists every invalid setting by
+// the name of its environment variable.
+func ConfigFromEnv(config Validator) error {
+	if err := envdecode.StrictDecode(config); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("%w:\n%w", ErrInvalidConfig, err)
+	}
+
+	return nil
+}
+
+// ValidatePort reports whether port, set by the environment variable name, is
+// a valid port or 0.
+func ValidatePort(name string, port int) error {
+	if port < 0 || port > 65535 {
+		return fmt.Errorf("%s must be between 0 and 65535, got %d", name, port)
+	}
+
+	return nil
+}
+
+// ValidateTimeout reports whether d, set by the environment variable name, is
+// positive.
+func ValidateTimeout(name string, d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("%s must be positive, got %s", name, d)
+	}
+
+	return nil
+}
+
+// ValidateImage reports whether image, set by the environment variable name,
+// is an image reference, e.g. ghcr.io/hydradatabase/hydra:latest.
+func ValidateImage(name, image string) error {
+	if image == "" || strings.ContainsAny(image, " \t\n") {
+		return fmt.Errorf("%s must be an image reference, got %q", name, image)
+	}
+
+	return nil
+}
+
+// ValidateContainerRuntime reports whether runtime, set by the environment
+// variable name, is supported by [NewContainerRuntime].
+func ValidateContainerRuntime(name, runtime string) error {
+	if _, err := NewContainerRuntime(runtime); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	return nil
+}
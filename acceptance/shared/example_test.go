@@ -0,0 +1,25 @@
+package shared_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hydradatabase/hydra/acceptance/shared"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// An image derived from Hydra registers its own acceptance criteria from an
+// init function of its suite, and they run with the shared cases of
+// [shared.RunAcceptanceTests].
+func ExampleRegisterCase() {
+	shared.RegisterCase("columnar access method", []string{shared.TagSmoke, shared.TagColumnar}, func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+		var amtype string
+		if err := pool.QueryRow(ctx, "SELECT amtype FROM pg_am WHERE amname = 'columnar'").Scan(&amtype); err != nil {
+			t.Fatal(err)
+		}
+
+		if amtype != "t" {
+			t.Errorf("columnar should be a table access method, got amtype %s", amtype)
+		}
+	})
+}
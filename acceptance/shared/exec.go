@@ -0,0 +1,44 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+// An ExecResult is the outcome of a command run inside a container.
+type ExecResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// ExecInContainer runs cmd inside the running container name, which may be a
+// container name or ID, e.g. from [ContainerRuntime.ContainerID]. A non-zero
+// exit code is reported in the result rather than failing the test, so callers
+// can assert on it; the test only fails if the command could not be run.
+func ExecInContainer(t *testing.T, ctx context.Context, rt ContainerRuntime, name string, cmd ...string) ExecResult {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	execCmd := rt.Command(ctx, append([]string{"exec", name}, cmd...)...)
+	execCmd.Stdout = &stdout
+	execCmd.Stderr = &stderr
+
+	result := ExecResult{}
+	if err := execCmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			t.Fatalf("unable to exec %v in %s: %s", cmd, name, err)
+		}
+
+		result.ExitCode = exitErr.ExitCode()
+	}
+
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+
+	return result
+}
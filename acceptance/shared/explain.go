@@ -0,0 +1,301 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// A Plan is a node of a plan of EXPLAIN (FORMAT JSON), with its properties
+// named as by EXPLAIN, e.g. "Node Type" or "Columnar Chunk Groups Removed by
+// Filter", and its child nodes.
+type Plan struct {
+	Properties map[string]any
+	Plans      []*Plan
+
+	text string // the whole plan as returned by EXPLAIN, set on the root node only
+}
+
+// Explain returns the plan of sql by EXPLAIN with the options, e.g. "ANALYZE,
+// COSTS OFF", and the JSON format.
+func Explain(t *testing.T, ctx context.Context, q querier, options, sql string, args ...any) *Plan {
+	t.Helper()
+
+	if options != "" {
+		options += ", "
+	}
+
+	var text string
+	if err := q.QueryRow(ctx, fmt.Sprintf("EXPLAIN (%sFORMAT JSON) %s", options, sql), args...).Scan(&text); err != nil {
+		t.Fatalf("unable to explain %s: %s", sql, err)
+	}
+
+	plan, err := ParsePlan(text)
+	if err != nil {
+		t.Fatalf("unable to parse the plan of %s: %s", sql, err)
+	}
+
+	return plan
+}
+
+// ParsePlan parses a plan in the JSON format of EXPLAIN and returns its root
+// node.
+func ParsePlan(text string) (*Plan, error) {
+	var explained []struct {
+		Plan map[string]any `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(text), &explained); err != nil {
+		return nil, err
+	}
+	if len(explained) != 1 || explained[0].Plan == nil {
+		return nil, fmt.Errorf("expected a single plan, got %s", text)
+	}
+
+	plan := newPlan(explained[0].Plan)
+	plan.text = text
+
+	return plan, nil
+}
+
+// newPlan returns the node of properties and of its child nodes.
+func newPlan(properties map[string]any) *Plan {
+	p := &Plan{Properties: properties}
+	if children, ok := properties["Plans"].([]any); ok {
+		for _, child := range children {
+			if child, ok := child.(map[string]any); ok {
+				p.Plans = append(p.Plans, newPlan(child))
+			}
+		}
+	}
+	delete(p.Properties, "Plans")
+
+	return p
+}
+
+// String returns the text property of p named key, or "" if it has none.
+func (p *Plan) String(key string) string {
+	s, _ := p.Properties[key].(string)
+	return s
+}
+
+// Int returns the numeric property of p named key, or 0 if it has none.
+func (p *Plan) Int(key string) int64 {
+	n, _ := p.Properties[key].(float64)
+	return int64(n)
+}
+
+// NodeType returns the node type of p, or the provider of custom scans, e.g.
+// ColumnarScan.
+func (p *Plan) NodeType() string {
+	if provider := p.String("Custom Plan Provider"); provider != "" {
+		return provider
+	}
+
+	return p.String("Node Type")
+}
+
+// Nodes returns p and its descendants that match, depth first.
+func (p *Plan) Nodes(match func(n *Plan) bool) []*Plan {
+	var nodes []*Plan
+	if match(p) {
+		nodes = append(nodes, p)
+	}
+	for _, child := range p.Plans {
+		nodes = append(nodes, child.Nodes(match)...)
+	}
+
+	return nodes
+}
+
+// Sum returns the sum of the numeric property named key of p and its
+// descendants.
+func (p *Plan) Sum(key string) int64 {
+	var sum int64
+	for _, n := range p.Nodes(func(*Plan) bool { return true }) {
+		sum += n.Int(key)
+	}
+
+	return sum
+}
+
+// ScannedRelations returns the names of the relations scanned by the plan,
+// sorted and without duplicates.
+func (p *Plan) ScannedRelations() []string {
+	seen := map[string]bool{}
+	for _, n := range p.Nodes(func(n *Plan) bool { return n.String("Relation Name") != "" }) {
+		seen[n.String("Relation Name")] = true
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// A PlanCheck returns an error describing how a plan does not have a
+// property, e.g. a scan of a columnar table, see [CheckPlan].
+type PlanCheck func(p *Plan) error
+
+// CheckPlan fails the test for every check that plan does not pass, with the
+// plan in the errors.
+func CheckPlan(t *testing.T, plan *Plan, checks ...PlanCheck) {
+	t.Helper()
+
+	for _, check := range checks {
+		if err := check(plan); err != nil {
+			t.Errorf("plan should pass the check: %s: %s", err, plan.text)
+		}
+	}
+}
+
+// scans returns a match of the scans of the relations of nodeType, e.g. Seq
+// Scan, of any relation if there are none.
+func scans(nodeType string, relations ...string) func(n *Plan) bool {
+	return func(n *Plan) bool {
+		if n.NodeType() != nodeType {
+			return false
+		}
+		if len(relations) == 0 {
+			return true
+		}
+
+		for _, r := range relations {
+			if n.String("Relation Name") == r {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// UsesNode checks that the plan has a node of nodeType, e.g. Gather.
+func UsesNode(nodeType string) PlanCheck {
+	return func(p *Plan) error {
+		if len(p.Nodes(scans(nodeType))) == 0 {
+			return fmt.Errorf("no %s", nodeType)
+		}
+
+		return nil
+	}
+}
+
+// NoNode checks that the plan has no node of nodeType, e.g. ColumnarScan.
+func NoNode(nodeType string) PlanCheck {
+	return func(p *Plan) error {
+		if len(p.Nodes(scans(nodeType))) > 0 {
+			return fmt.Errorf("%s in the plan", nodeType)
+		}
+
+		return nil
+	}
+}
+
+// HasProperty checks that a node of the plan has the property named key, e.g.
+// "Columnar Chunk Group Filters".
+func HasProperty(key string) PlanCheck {
+	return func(p *Plan) error {
+		if len(p.Nodes(func(n *Plan) bool { _, ok := n.Properties[key]; return ok })) == 0 {
+			return fmt.Errorf("no node with %s", key)
+		}
+
+		return nil
+	}
+}
+
+// NoProperty checks that no node of the plan has the property named key.
+func NoProperty(key string) PlanCheck {
+	return func(p *Plan) error {
+		if err := HasProperty(key)(p); err == nil {
+			return fmt.Errorf("a node with %s", key)
+		}
+
+		return nil
+	}
+}
+
+// UsesColumnarScan checks that the plan scans every one of the relations with
+// the custom scan of columnar tables, or any relation if there are none.
+func UsesColumnarScan(relations ...string) PlanCheck {
+	return func(p *Plan) error {
+		if len(relations) == 0 {
+			return UsesNode("ColumnarScan")(p)
+		}
+
+		for _, r := range relations {
+			if len(p.Nodes(scans("ColumnarScan", r))) == 0 {
+				return fmt.Errorf("no ColumnarScan on %s", r)
+			}
+		}
+
+		return nil
+	}
+}
+
+// NoSeqScan checks that the plan has no sequential scan of the relations, or
+// of any relation if there are none, e.g. of a heap table that should be
+// scanned with an index.
+func NoSeqScan(relations ...string) PlanCheck {
+	return func(p *Plan) error {
+		if nodes := p.Nodes(scans("Seq Scan", relations...)); len(nodes) > 0 {
+			return fmt.Errorf("Seq Scan on %s", nodes[0].String("Relation Name"))
+		}
+
+		return nil
+	}
+}
+
+// UsesIndex checks that the plan scans the index, with an index scan or an
+// index only scan.
+func UsesIndex(index string) PlanCheck {
+	return func(p *Plan) error {
+		if len(p.Nodes(func(n *Plan) bool { return n.String("Index Name") == index })) == 0 {
+			return fmt.Errorf("no scan of index %s", index)
+		}
+
+		return nil
+	}
+}
+
+// ChunkGroupsRemovedAtLeast checks that the columnar scans of the plan of
+// EXPLAIN ANALYZE removed at least n chunk groups with their chunk group
+// filters in total.
+func ChunkGroupsRemovedAtLeast(n int64) PlanCheck {
+	return func(p *Plan) error {
+		if removed := p.Sum("Columnar Chunk Groups Removed by Filter"); removed < n {
+			return fmt.Errorf("%d chunk groups removed by filter, fewer than %d", removed, n)
+		}
+
+		return nil
+	}
+}
+
+// ChunkGroupsRemovedAtMost checks that the columnar scans of the plan of
+// EXPLAIN ANALYZE removed at most n chunk groups with their chunk group filters
+// in total, e.g. none with uncorrelated values.
+func ChunkGroupsRemovedAtMost(n int64) PlanCheck {
+	return func(p *Plan) error {
+		if removed := p.Sum("Columnar Chunk Groups Removed by Filter"); removed > n {
+			return fmt.Errorf("%d chunk groups removed by filter, more than %d", removed, n)
+		}
+
+		return nil
+	}
+}
+
+// WorkersLaunchedAtLeast checks that the plan of EXPLAIN ANALYZE launched at
+// least n parallel workers in total.
+func WorkersLaunchedAtLeast(n int64) PlanCheck {
+	return func(p *Plan) error {
+		if launched := p.Sum("Workers Launched"); launched < n {
+			return fmt.Errorf("%d workers launched of %d planned, fewer than %d", launched, p.Sum("Workers Planned"), n)
+		}
+
+		return nil
+	}
+}
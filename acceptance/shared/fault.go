@@ -0,0 +1,34 @@
+package shared
+
+import (
+	"context"
+	"testing"
+)
+
+// PauseContainer freezes every process in the running container name, which
+// may be a container name or ID, e.g. to stall Hydra mid-query or
+// mid-checkpoint. Clients keep their connections but receive no responses
+// until [UnpauseContainer] is called. The container is unpaused when the test
+// completes if it is still paused.
+func PauseContainer(t *testing.T, ctx context.Context, rt ContainerRuntime, name string) {
+	t.Helper()
+
+	if output, err := rt.Command(ctx, "pause", name).CombinedOutput(); err != nil {
+		t.Fatalf("unable to pause %s: %s: %s", name, err, output)
+	}
+
+	t.Cleanup(func() {
+		// the test context may already be done during cleanup, and the
+		// container may have been unpaused or removed already
+		_ = rt.Command(context.Background(), "unpause", name).Run()
+	})
+}
+
+// UnpauseContainer resumes the container name paused by [PauseContainer].
+func UnpauseContainer(t *testing.T, ctx context.Context, rt ContainerRuntime, name string) {
+	t.Helper()
+
+	if output, err := rt.Command(ctx, "unpause", name).CombinedOutput(); err != nil {
+		t.Fatalf("unable to unpause %s: %s: %s", name, err, output)
+	}
+}
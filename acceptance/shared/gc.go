@@ -0,0 +1,253 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// Labels applied to every container, volume and network created by the
+// harness so that resources left behind by crashed or interrupted runs can be
+// found and removed.
+const (
+	LabelManaged   = "io.hydra.acceptance.managed"
+	LabelOwnerHost = "io.hydra.acceptance.owner-host"
+	LabelOwnerPID  = "io.hydra.acceptance.owner-pid"
+)
+
+// ResourceLabels returns the labels to apply to every resource created by the
+// harness during this run.
+func ResourceLabels() map[string]string {
+	hostname, _ := os.Hostname()
+
+	return map[string]string{
+		LabelManaged:   "true",
+		LabelRunID:     RunID,
+		LabelOwnerHost: hostname,
+		LabelOwnerPID:  strconv.Itoa(os.Getpid()),
+	}
+}
+
+// labelArgs returns [ResourceLabels] as --label flags of a create command.
+func labelArgs() []string {
+	var args []string
+	for k, v := range ResourceLabels() {
+		args = append(args, "--label", k+"="+v)
+	}
+
+	return args
+}
+
+// A Resource is a container, volume or network created by the harness.
+type Resource struct {
+	Kind   string // container, volume or network
+	ID     string
+	Labels map[string]string
+}
+
+func (r Resource) String() string {
+	return fmt.Sprintf("%s %s (run %s)", r.Kind, r.ID, r.Labels[LabelRunID])
+}
+
+// FindOrphans returns the resources created by harness processes on this host
+// that are no longer running, including those of an earlier attempt of the
+// same run. Resources of other hosts sharing the daemon and of concurrently
+// running test binaries are left alone.
+func FindOrphans(ctx context.Context, rt ContainerRuntime) ([]Resource, error) {
+	resources, err := findManagedResources(ctx, rt)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+
+	var orphans []Resource
+	for _, r := range resources {
+		if r.Labels[LabelOwnerHost] != hostname || r.ownedByThisProcess() {
+			continue
+		}
+
+		pid, err := strconv.Atoi(r.Labels[LabelOwnerPID])
+		if err == nil && processAlive(pid) {
+			continue
+		}
+
+		orphans = append(orphans, r)
+	}
+
+	return orphans, nil
+}
+
+// FindRunResources returns the resources created by this test binary that
+// still exist. Once all tests completed there should be none. The resources of
+// other test binaries of the same run are left alone.
+func FindRunResources(ctx context.Context, rt ContainerRuntime) ([]Resource, error) {
+	resources, err := findManagedResources(ctx, rt)
+	if err != nil {
+		return nil, err
+	}
+
+	var leaked []Resource
+	for _, r := range resources {
+		if r.ownedByThisProcess() {
+			leaked = append(leaked, r)
+		}
+	}
+
+	return leaked, nil
+}
+
+// FindResourcesOfRun returns the resources created by any test binary of the
+// run runID, e.g. to remove them once a CI run was cancelled.
+func FindResourcesOfRun(ctx context.Context, rt ContainerRuntime, runID string) ([]Resource, error) {
+	resources, err := findManagedResources(ctx, rt)
+	if err != nil {
+		return nil, err
+	}
+
+	var run []Resource
+	for _, r := range resources {
+		if r.Labels[LabelRunID] == runID {
+			run = append(run, r)
+		}
+	}
+
+	return run, nil
+}
+
+// ownedByThisProcess reports whether r was created by this test binary.
+func (r Resource) ownedByThisProcess() bool {
+	hostname, _ := os.Hostname()
+
+	return r.Labels[LabelRunID] == RunID &&
+		r.Labels[LabelOwnerHost] == hostname &&
+		r.Labels[LabelOwnerPID] == strconv.Itoa(os.Getpid())
+}
+
+// RemoveResources force removes resources. Containers are removed first so that
+// the volumes and networks they use can be removed afterwards.
+func RemoveResources(ctx context.Context, rt ContainerRuntime, resources []Resource) error {
+	for _, kind := range []string{"container", "volume", "network"} {
+		for _, r := range resources {
+			if r.Kind != kind {
+				continue
+			}
+
+			args := []string{kind, "rm", r.ID}
+			if kind != "network" {
+				args = []string{kind, "rm", "--force", r.ID}
+			}
+
+			if output, err := rt.Command(ctx, args...).CombinedOutput(); err != nil {
+				return fmt.Errorf("unable to remove %s: %w: %s", r, err, output)
+			}
+		}
+	}
+
+	return nil
+}
+
+// CollectOrphans finds the orphaned resources with [FindOrphans] and removes
+// them, returning what was removed.
+func CollectOrphans(ctx context.Context, rt ContainerRuntime) ([]Resource, error) {
+	orphans, err := FindOrphans(ctx, rt)
+	if err != nil {
+		return nil, err
+	}
+
+	return orphans, RemoveResources(ctx, rt, orphans)
+}
+
+func findManagedResources(ctx context.Context, rt ContainerRuntime) ([]Resource, error) {
+	filter := fmt.Sprintf("label=%s=true", LabelManaged)
+
+	listArgs := map[string][]string{
+		"container": {"ps", "--all", "--quiet", "--filter", filter},
+		"volume":    {"volume", "ls", "--quiet", "--filter", filter},
+		"network":   {"network", "ls", "--quiet", "--filter", filter},
+	}
+	// containers keep their labels in the config, volumes and networks at the
+	// top level
+	labelsFormat := map[string]string{
+		"container": "{{json .Config.Labels}}",
+		"volume":    "{{json .Labels}}",
+		"network":   "{{json .Labels}}",
+	}
+
+	var resources []Resource
+	for _, kind := range []string{"container", "volume", "network"} {
+		output, err := rt.Command(ctx, listArgs[kind]...).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("unable to list %ss: %w: %s", kind, err, output)
+		}
+
+		for _, id := range strings.Fields(string(output)) {
+			output, err := rt.Command(ctx, kind, "inspect", "--format", labelsFormat[kind], id).CombinedOutput()
+			if err != nil {
+				return nil, fmt.Errorf("unable to inspect %s %s: %w: %s", kind, id, err, output)
+			}
+
+			r := Resource{Kind: kind, ID: id}
+			if err := json.Unmarshal(bytes.TrimSpace(output), &r.Labels); err != nil {
+				return nil, fmt.Errorf("unable to parse labels of %s %s: %w", kind, id, err)
+			}
+
+			resources = append(resources, r)
+		}
+	}
+
+	return resources, nil
+}
+
+// processAlive reports whether a process with pid is running on this host.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// RunWithGC removes the resources orphaned by earlier runs, see [FindOrphans],
+// and then runs the tests. The run fails if the tests leaked any resources of
+// their own, which are removed as well. It returns the exit code for os.Exit.
+func RunWithGC(m *testing.M, rt ContainerRuntime) int {
+	ctx := context.Background()
+
+	orphans, err := CollectOrphans(ctx, rt)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, r := range orphans {
+		Logger.Info("removed orphaned resource", "resource", r)
+	}
+
+	code := m.Run()
+
+	leaked, err := FindRunResources(ctx, rt)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, r := range leaked {
+		Logger.Warn("leaked resource", "resource", r)
+	}
+
+	if len(leaked) > 0 {
+		if err := RemoveResources(ctx, rt, leaked); err != nil {
+			Logger.Error("unable to remove leaked resources", "err", err)
+		}
+		if code == 0 {
+			code = 1
+		}
+	}
+
+	return code
+}
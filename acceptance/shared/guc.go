@@ -0,0 +1,183 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SetGUC sets the setting name, e.g. columnar.compression or enable_seqscan, to
+// value for every session of pool. It is set for the user and database of the
+// pool with ALTER ROLE ... IN DATABASE, and the pool is reset so that its
+// connections pick it up. The previous value is restored when the test
+// completes, or earlier by calling restore, e.g. before the container of pool
+// is stopped. As the setting applies to every pool of the user and database,
+// it must not be used by the cases of groups running in parallel, see
+// [CaseGroup]. Use the Settings of a [Case] to change a setting for a single
+// case, or [PoolWithGUCs] for the sessions of a single pool instead.
+func SetGUC(t *testing.T, ctx context.Context, pool *pgxpool.Pool, name, value string) (restore func()) {
+	t.Helper()
+
+	var database string
+	if err := pool.QueryRow(ctx, "SELECT current_database()").Scan(&database); err != nil {
+		t.Fatalf("unable to query the current database: %s", err)
+	}
+	alter := "ALTER ROLE CURRENT_USER IN DATABASE " + pgx.Identifier{database}.Sanitize()
+
+	var previous *string
+	err := pool.QueryRow(ctx, `
+SELECT substr(s, length($1) + 2)
+FROM pg_db_role_setting d, unnest(d.setconfig) s
+WHERE d.setdatabase = (SELECT oid FROM pg_database WHERE datname = current_database())
+	AND d.setrole = (SELECT oid FROM pg_roles WHERE rolname = current_user)
+	AND split_part(s, '=', 1) = $1
+`, name).Scan(&previous)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("unable to query the setting %s: %s", name, err)
+	}
+
+	// ALTER ROLE does not accept parameters, the value is quoted instead
+	if _, err := pool.Exec(ctx, alter+" SET "+qualifiedIdent(name)+" = "+quoteLiteral(value)); err != nil {
+		t.Fatalf("unable to set %s: %s", name, err)
+	}
+	pool.Reset()
+
+	restored := false
+	restore = func() {
+		if restored {
+			return
+		}
+		restored = true
+
+		// the test context may already be done during cleanup
+		sql := alter + " RESET " + qualifiedIdent(name)
+		if previous != nil {
+			sql = alter + " SET " + qualifiedIdent(name) + " = " + quoteLiteral(*previous)
+		}
+
+		if _, err := pool.Exec(context.Background(), sql); err != nil {
+			t.Errorf("unable to restore %s: %s", name, err)
+		}
+		pool.Reset()
+	}
+	t.Cleanup(restore)
+
+	return restore
+}
+
+// PoolWithGUCs returns a pool connected like pool whose sessions start with
+// every setting of settings, e.g. the settings of a [SuiteConfig], set once
+// connected. Unlike with [SetGUC], the settings do not affect other pools,
+// e.g. of other tests, and do not outlive the pool, which is closed when the
+// test completes. Pools derived from it, e.g. by [PoolForSchema], keep the
+// settings. It returns pool itself if settings is empty. Settings that cannot
+// be changed by a session, e.g. shared_buffers of the postmaster context or
+// those of sighup, fail the test before connecting, as they must be set when
+// the server starts.
+func PoolWithGUCs(t *testing.T, ctx context.Context, pool *pgxpool.Pool, settings map[string]string) *pgxpool.Pool {
+	t.Helper()
+
+	if len(settings) == 0 {
+		return pool
+	}
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	rows, err := pool.Query(ctx, `
+SELECT format('%s of the %s context', name, context) FROM pg_settings
+WHERE name = ANY($1) AND context NOT IN ('user', 'superuser')
+ORDER BY name`, names)
+	if err != nil {
+		t.Fatalf("unable to query the contexts of the settings: %s", err)
+	}
+	unsettable, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		t.Fatalf("unable to query the contexts of the settings: %s", err)
+	}
+	if len(unsettable) > 0 {
+		t.Fatalf("settings cannot be changed by a session and must be set when the server starts: %s", strings.Join(unsettable, ", "))
+	}
+
+	config := pool.Config()
+	afterConnect := config.AfterConnect
+	config.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		if afterConnect != nil {
+			if err := afterConnect(ctx, conn); err != nil {
+				return err
+			}
+		}
+
+		for name, value := range settings {
+			if _, err := conn.Exec(ctx, "SELECT set_config($1, $2, false)", name, value); err != nil {
+				return fmt.Errorf("unable to set %s: %w", name, err)
+			}
+		}
+
+		return nil
+	}
+
+	settingsPool, err := createPGPool(t, ctx, config, RetryPolicy{Attempts: 1})
+	if err != nil {
+		t.Fatalf("unable to connect with the settings: %s", err)
+	}
+
+	return settingsPool
+}
+
+// AlterSystemSet sets the setting name to value for the whole server with
+// ALTER SYSTEM and reloads the configuration using pool, which must be
+// connected as a superuser. The previous value is restored and the
+// configuration reloaded when the test completes. Settings that can only be
+// changed at server start take effect once the container restarts, see
+// [RestartContainer].
+func AlterSystemSet(t *testing.T, ctx context.Context, pool *pgxpool.Pool, name, value string) {
+	t.Helper()
+
+	var previous *string
+	err := pool.QueryRow(ctx, `
+SELECT setting FROM pg_file_settings
+WHERE name = $1 AND sourcefile LIKE '%/postgresql.auto.conf'
+ORDER BY seqno DESC LIMIT 1
+`, name).Scan(&previous)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("unable to query the setting %s: %s", name, err)
+	}
+
+	// ALTER SYSTEM does not accept parameters, the value is quoted instead
+	if err := alterSystem(ctx, pool, "ALTER SYSTEM SET "+qualifiedIdent(name)+" = "+quoteLiteral(value)); err != nil {
+		t.Fatalf("unable to set %s: %s", name, err)
+	}
+
+	t.Cleanup(func() {
+		sql := "ALTER SYSTEM RESET " + qualifiedIdent(name)
+		if previous != nil {
+			sql = "ALTER SYSTEM SET " + qualifiedIdent(name) + " = " + quoteLiteral(*previous)
+		}
+
+		// the test context may already be done during cleanup
+		if err := alterSystem(context.Background(), pool, sql); err != nil {
+			t.Errorf("unable to restore %s: %s", name, err)
+		}
+	})
+}
+
+// alterSystem runs the ALTER SYSTEM statement sql and reloads the
+// configuration.
+func alterSystem(ctx context.Context, pool *pgxpool.Pool, sql string) error {
+	if _, err := pool.Exec(ctx, sql); err != nil {
+		return err
+	}
+
+	if _, err := pool.Exec(ctx, "SELECT pg_reload_conf()"); err != nil {
+		return fmt.Errorf("unable to reload the configuration: %w", err)
+	}
+
+	return nil
+}
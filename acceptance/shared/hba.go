@@ -0,0 +1,114 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// An HBAEntry is a record of pg_hba.conf, see the Postgres documentation of
+// client authentication.
+type HBAEntry struct {
+	Type     string // local, host, hostssl or hostnossl
+	Database string // e.g. all, replication or a database name
+	User     string // e.g. all or a role name
+	Address  string // e.g. all or 172.16.0.0/12, omitted for local entries
+	Method   string // e.g. trust, reject, scram-sha-256 or cert
+	Options  string // options of the method, e.g. clientcert=verify-full
+}
+
+// String returns the entry as a line of pg_hba.conf.
+func (e HBAEntry) String() string {
+	fields := []string{e.Type, e.Database, e.User}
+	if e.Type != "local" {
+		fields = append(fields, e.Address)
+	}
+	fields = append(fields, e.Method)
+	if e.Options != "" {
+		fields = append(fields, e.Options)
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// SetHBA adds entries in front of the pg_hba.conf of Postgres in the running
+// container name, so that they take precedence over the existing rules, and
+// reloads the configuration using pool, which must be connected as a
+// superuser. The test fails if Postgres rejects any of the entries. The
+// original file is restored and the configuration reloaded when the test
+// completes. Connections allowed by the existing rules are not affected.
+func SetHBA(t *testing.T, ctx context.Context, rt ContainerRuntime, pool *pgxpool.Pool, name string, entries ...HBAEntry) {
+	t.Helper()
+
+	var hbaFile string
+	if err := pool.QueryRow(ctx, "SHOW hba_file").Scan(&hbaFile); err != nil {
+		t.Fatalf("unable to query hba_file: %s", err)
+	}
+
+	original, err := rt.Command(ctx, "exec", name, "cat", hbaFile).Output()
+	if err != nil {
+		t.Fatalf("unable to read %s in %s: %s", hbaFile, name, err)
+	}
+
+	var hba bytes.Buffer
+	hba.WriteString("# added by the acceptance tests\n")
+	for _, e := range entries {
+		hba.WriteString(e.String() + "\n")
+	}
+	hba.Write(original)
+
+	if err := writeHBA(ctx, rt, pool, name, hbaFile, hba.Bytes()); err != nil {
+		t.Fatalf("unable to set %s in %s: %s", hbaFile, name, err)
+	}
+
+	t.Cleanup(func() {
+		// the test context may already be done during cleanup
+		if err := writeHBA(context.Background(), rt, pool, name, hbaFile, original); err != nil {
+			t.Errorf("unable to restore %s in %s: %s", hbaFile, name, err)
+		}
+	})
+
+	rows, err := pool.Query(ctx, "SELECT line_number, error FROM pg_hba_file_rules WHERE error IS NOT NULL")
+	if err != nil {
+		t.Fatalf("unable to query pg_hba_file_rules: %s", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			line   int
+			reason string
+		)
+		if err := rows.Scan(&line, &reason); err != nil {
+			t.Fatalf("unable to scan pg_hba_file_rules: %s", err)
+		}
+
+		t.Errorf("line %d of %s is invalid: %s", line, hbaFile, reason)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("unable to query pg_hba_file_rules: %s", err)
+	}
+	if t.Failed() {
+		t.FailNow()
+	}
+}
+
+// writeHBA replaces the contents of hbaFile in the container name, keeping its
+// owner and mode, and reloads the configuration.
+func writeHBA(ctx context.Context, rt ContainerRuntime, pool *pgxpool.Pool, name, hbaFile string, contents []byte) error {
+	cmd := rt.Command(ctx, "exec", "--interactive", name, "sh", "-c", `cat > "$1"`, "sh", hbaFile)
+	cmd.Stdin = bytes.NewReader(contents)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, output)
+	}
+
+	if _, err := pool.Exec(ctx, "SELECT pg_reload_conf()"); err != nil {
+		return fmt.Errorf("unable to reload the configuration: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package shared
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Hooks set up and tear down the fixtures of a run of cases, e.g. loading a
+// dataset once and resetting it between cases. Every hook is optional. The
+// suite hooks run against the pool of the run, the case hooks against the
+// pool of the case, which differs for the groups of [RunCaseGroups].
+type Hooks struct {
+	BeforeSuite func(t *testing.T, ctx context.Context, pool *pgxpool.Pool)         // before the first case
+	AfterSuite  func(t *testing.T, ctx context.Context, pool *pgxpool.Pool)         // after the last case, even if a case failed
+	BeforeCase  func(t *testing.T, ctx context.Context, pool *pgxpool.Pool, c Case) // before each case that is not skipped
+	AfterCase   func(t *testing.T, ctx context.Context, pool *pgxpool.Pool, c Case) // after each case that is not skipped, even if it failed
+}
+
+// A HooksProvider is a [DockerComposeManager] with fixtures of its own, which
+// [RunAcceptanceTests] runs its cases with.
+type HooksProvider interface {
+	Hooks() Hooks
+}
+
+type hooksKey struct{}
+
+// WithHooks returns ctx to run cases with hooks, e.g. with [RunCases].
+func WithHooks(ctx context.Context, hooks Hooks) context.Context {
+	return context.WithValue(ctx, hooksKey{}, hooks)
+}
+
+// hooksFrom returns the hooks of ctx, see WithHooks.
+func hooksFrom(ctx context.Context) Hooks {
+	hooks, _ := ctx.Value(hooksKey{}).(Hooks)
+	return hooks
+}
+
+// withManagerHooks returns ctx with the hooks of cm if it is a HooksProvider.
+func withManagerHooks(ctx context.Context, cm DockerComposeManager) context.Context {
+	if p, ok := cm.(HooksProvider); ok {
+		return WithHooks(ctx, p.Hooks())
+	}
+
+	return ctx
+}
+
+// runSuiteHooks runs the BeforeSuite hook of ctx and registers the AfterSuite
+// hook to run when t completes, before the containers are terminated.
+func runSuiteHooks(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+	t.Helper()
+
+	hooks := hooksFrom(ctx)
+	if hooks.AfterSuite != nil {
+		t.Cleanup(func() {
+			// the test context may already be done during cleanup
+			hooks.AfterSuite(t, context.Background(), pool)
+		})
+	}
+	if hooks.BeforeSuite != nil {
+		hooks.BeforeSuite(t, ctx, pool)
+	}
+}
+
+// runCaseHooks runs the BeforeCase hook of ctx for c and registers the
+// AfterCase hook to run when t completes.
+func runCaseHooks(t *testing.T, ctx context.Context, pool *pgxpool.Pool, c Case) {
+	t.Helper()
+
+	hooks := hooksFrom(ctx)
+	if hooks.AfterCase != nil {
+		t.Cleanup(func() {
+			// the case context is done once the case returned
+			hooks.AfterCase(t, context.Background(), pool, c)
+		})
+	}
+	if hooks.BeforeCase != nil {
+		hooks.BeforeCase(t, ctx, pool, c)
+	}
+}
@@ -0,0 +1,112 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// BuildOptions configure how [BuildImage] builds an image.
+type BuildOptions struct {
+	Dockerfile string   // path of the Dockerfile, Dockerfile in the build directory if empty
+	Target     string   // build stage to build, the last stage if empty
+	Platform   string   // platform to build for, e.g. linux/amd64, the native platform if empty
+	BuildArgs  []string // build arguments as KEY=VALUE
+	Contexts   []string // additional named build contexts as NAME=VALUE, requires buildx
+	LogDir     string   // root directory to save the build log to, if included, see [TestArtifactDir]
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// BuildImage builds the image in dockerfileDir and tags it as tag, so that the
+// suite can run against an image built from source instead of a pre-built tag.
+// The build log is saved to opts.LogDir and included in the failure message if
+// the build fails.
+func BuildImage(t *testing.T, ctx context.Context, rt ContainerRuntime, dockerfileDir, tag string, opts BuildOptions) {
+	t.Helper()
+
+	args := []string{"build", "--tag", tag}
+	if opts.Dockerfile != "" {
+		args = append(args, "--file", opts.Dockerfile)
+	}
+	if opts.Target != "" {
+		args = append(args, "--target", opts.Target)
+	}
+	if opts.Platform != "" {
+		args = append(args, "--platform", opts.Platform)
+	}
+	for _, arg := range opts.BuildArgs {
+		args = append(args, "--build-arg", arg)
+	}
+	for _, c := range opts.Contexts {
+		args = append(args, "--build-context", c)
+	}
+	args = append(args, dockerfileDir)
+
+	t.Logf("Building image %s from %s", tag, dockerfileDir)
+	output, err := rt.Command(ctx, args...).CombinedOutput()
+
+	if dir := TestArtifactDir(t, opts.LogDir); dir != "" {
+		name := fmt.Sprintf("build-%s-%s.log", unsafeFileChars.ReplaceAllString(tag, "_"), time.Now().Format(time.RFC3339))
+		if err := os.WriteFile(filepath.Join(dir, name), output, 0644); err != nil {
+			t.Errorf("unable to write build log for %s: %s", tag, err)
+		}
+	}
+
+	if err != nil {
+		t.Fatalf("unable to build %s: %s: %s", tag, err, output)
+	}
+}
+
+// PullImage pulls ref for platform, or the native platform if empty, and
+// returns its digest, e.g. sha256:1234..., which is logged so that the results
+// of the test are traceable to an exact image build. If expectedDigest is set
+// the test fails unless the pulled image has that digest.
+func PullImage(t *testing.T, ctx context.Context, rt ContainerRuntime, ref, platform, expectedDigest string) string {
+	t.Helper()
+
+	args := []string{"pull"}
+	if platform != "" {
+		args = append(args, "--platform", platform)
+	}
+
+	if output, err := rt.Command(ctx, append(args, ref)...).CombinedOutput(); err != nil {
+		t.Fatalf("unable to pull %s: %s: %s", ref, err, output)
+	}
+
+	digest := ImageDigest(t, ctx, rt, ref)
+	if expectedDigest != "" && digest != expectedDigest {
+		t.Fatalf("image %s has digest %s, expected %s", ref, digest, expectedDigest)
+	}
+
+	t.Logf("Using image %s@%s", ref, digest)
+
+	return digest
+}
+
+// ImageDigest returns the registry digest of the local image ref. The test
+// fails if the image is not present or was never pushed to or pulled from a
+// registry, in which case it has no digest.
+func ImageDigest(t *testing.T, ctx context.Context, rt ContainerRuntime, ref string) string {
+	t.Helper()
+
+	output, err := rt.Command(ctx, "image", "inspect", "--format", "{{range .RepoDigests}}{{println .}}{{end}}", ref).CombinedOutput()
+	if err != nil {
+		t.Fatalf("unable to inspect image %s: %s: %s", ref, err, output)
+	}
+
+	// repo digests have the form repository@digest
+	repoDigests := strings.Fields(string(output))
+	if len(repoDigests) == 0 {
+		t.Fatalf("image %s has no digest", ref)
+	}
+
+	_, digest, _ := strings.Cut(repoDigests[0], "@")
+
+	return digest
+}
@@ -0,0 +1,215 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// An Index checks an index on a columnar table. The index is created on a
+// table of its own loaded with the rows of indexedRows, with Create run with
+// %[1]s the table and %[2]s the index. If Err is set, the creation must fail
+// with an error containing it. Otherwise every query must return the same
+// rows when planned with the index as without indexes, and must be planned
+// with a scan of the index if Scan is set.
+type Index struct {
+	Name    string
+	Create  string
+	Err     string   // documented error of the creation, e.g. for an unsupported access method
+	Queries []string // queries of the table, with %[1]s the table
+	Scan    bool     // whether the queries are planned with a scan of the index
+	Fails   []string // statements that must fail once the index exists, e.g. violating a unique index, with %[1]s the table
+	Tags    []string // tags of the case in addition to TagColumnar, see Case
+}
+
+// Case returns i as a [Case] that runs it with [RunIndex].
+func (i Index) Case() Case {
+	return Case{
+		Name: i.Name,
+		Tags: append([]string{TagColumnar}, i.Tags...),
+		Run: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+			RunIndex(t, ctx, pool, i)
+		},
+	}
+}
+
+// IndexCases returns the [Indexes] as cases.
+func IndexCases() []Case {
+	return casesOf(Indexes...)
+}
+
+// indexedRows creates and loads the table of an [Index], with %[1]s the table.
+const indexedRows = `
+CREATE TABLE %[1]s (id INT8, grp INT4, val TEXT, tags TEXT[], span INT4RANGE, pt POINT) USING columnar;
+INSERT INTO %[1]s
+SELECT i,
+       i %% 1000,
+       md5(i::text),
+       ARRAY['t' || i %% 7, 't' || i %% 11],
+       int4range(i, i + 10),
+       point(i %% 100, i / 100)
+FROM generate_series(1, 20000) i;
+`
+
+// Indexes are the indexes covered by the [IndexCases]. Columnar tables only
+// support btree, hash, gin, gist, spgist and rum indexes, and are never
+// scanned with bitmap scans, so that gin indexes are not used by queries.
+var Indexes = []Index{
+	{
+		Name:   "btree index on columnar table",
+		Create: "CREATE INDEX %[2]s ON %[1]s (id)",
+		Queries: []string{
+			"SELECT * FROM %[1]s WHERE id = 4242",
+			"SELECT id, val FROM %[1]s WHERE id BETWEEN 100 AND 200",
+		},
+		Scan: true,
+		Tags: []string{TagSmoke},
+	},
+	{
+		Name:    "unique btree index on columnar table",
+		Create:  "CREATE UNIQUE INDEX %[2]s ON %[1]s (id)",
+		Queries: []string{"SELECT * FROM %[1]s WHERE id = 17"},
+		Scan:    true,
+		Fails:   []string{"INSERT INTO %[1]s (id) VALUES (17)"},
+	},
+	{
+		Name:    "hash index on columnar table",
+		Create:  "CREATE INDEX %[2]s ON %[1]s USING hash (val)",
+		Queries: []string{"SELECT * FROM %[1]s WHERE val = md5('42')"},
+		Scan:    true,
+	},
+	{
+		Name:    "partial index on columnar table",
+		Create:  "CREATE INDEX %[2]s ON %[1]s (grp) WHERE grp < 10",
+		Queries: []string{"SELECT id, grp FROM %[1]s WHERE grp = 5"},
+		Scan:    true,
+	},
+	{
+		Name:    "expression index on columnar table",
+		Create:  "CREATE INDEX %[2]s ON %[1]s (upper(val))",
+		Queries: []string{"SELECT id, val FROM %[1]s WHERE upper(val) = upper(md5('7'))"},
+		Scan:    true,
+	},
+	{
+		Name:    "multicolumn index on columnar table",
+		Create:  "CREATE INDEX %[2]s ON %[1]s (grp, id)",
+		Queries: []string{"SELECT id FROM %[1]s WHERE grp = 3 AND id > 10000"},
+		Scan:    true,
+	},
+	{
+		Name:    "gist index on columnar table",
+		Create:  "CREATE INDEX %[2]s ON %[1]s USING gist (span)",
+		Queries: []string{"SELECT id, span FROM %[1]s WHERE span @> 5000"},
+		Scan:    true,
+	},
+	{
+		Name:    "spgist index on columnar table",
+		Create:  "CREATE INDEX %[2]s ON %[1]s USING spgist (pt)",
+		Queries: []string{"SELECT id, pt FROM %[1]s WHERE pt <@ box '((0,0),(10,10))'"},
+		Scan:    true,
+	},
+	{
+		Name:    "gin index on columnar table",
+		Create:  "CREATE INDEX %[2]s ON %[1]s USING gin (tags)",
+		Queries: []string{"SELECT id, tags FROM %[1]s WHERE tags @> ARRAY['t3', 't5']"},
+	},
+	{
+		Name:   "brin index on columnar table",
+		Create: "CREATE INDEX %[2]s ON %[1]s USING brin (id)",
+		Err:    "unsupported access method for the index on columnar table",
+	},
+}
+
+// Settings of the queries of an [Index] planned with and without indexes. The
+// custom scan of columnar tables is not disabled by enable_seqscan.
+var (
+	withIndexes = map[string]string{
+		"enable_seqscan":              "off",
+		"columnar.enable_custom_scan": "off",
+	}
+	withoutIndexes = map[string]string{
+		"enable_indexscan":     "off",
+		"enable_indexonlyscan": "off",
+		"enable_bitmapscan":    "off",
+	}
+)
+
+// RunIndex runs i against the database of pool, with a table of its own that
+// is dropped when the test completes.
+func RunIndex(t *testing.T, ctx context.Context, pool *pgxpool.Pool, i Index) {
+	t.Helper()
+
+	// the names are lower case, so that EXPLAIN does not quote them
+	table := uniqueTable(t, ctx, pool, "i", indexedRows, nil)
+	index := uniqueIdent(t, "x")
+
+	_, err := pool.Exec(ctx, fmt.Sprintf(i.Create, table, pgx.Identifier{index}.Sanitize()))
+	switch {
+	case i.Err != "" && err == nil:
+		t.Fatalf("%s should fail with %q", i.Name, i.Err)
+	case i.Err != "" && !strings.Contains(err.Error(), i.Err):
+		t.Fatalf("%s should fail with %q, got %s", i.Name, i.Err, err)
+	case i.Err != "":
+		return
+	case err != nil:
+		t.Fatalf("unable to create the index: %s", err)
+	}
+
+	for _, query := range i.Queries {
+		sql := fmt.Sprintf(query, table)
+
+		var plan, want, got []string
+		queryWithSettings(t, ctx, pool, withIndexes, func(conn *pgx.Conn) {
+			plan = queryTextRows(t, ctx, conn, "EXPLAIN (COSTS OFF) "+sql)
+			got = queryTextRows(t, ctx, conn, sql)
+		})
+		queryWithSettings(t, ctx, pool, withoutIndexes, func(conn *pgx.Conn) {
+			want = queryTextRows(t, ctx, conn, sql)
+		})
+
+		if len(want) == 0 {
+			t.Errorf("%s should return rows", sql)
+		}
+		if diff := diffRows(want, got); diff != "" {
+			t.Errorf("%s should return the same rows with the index: %s", sql, diff)
+		}
+
+		usesIndex := strings.Contains(strings.Join(plan, "\n"), "using "+index)
+		if i.Scan && !usesIndex {
+			t.Errorf("%s should be planned with a scan of the index: %s", sql, strings.Join(plan, "\n"))
+		}
+		if !i.Scan && usesIndex {
+			t.Errorf("%s should not be planned with a scan of the index: %s", sql, strings.Join(plan, "\n"))
+		}
+	}
+
+	for _, stmt := range i.Fails {
+		sql := fmt.Sprintf(stmt, table)
+		if _, err := pool.Exec(ctx, sql); err == nil {
+			t.Errorf("%s should fail once the index exists", sql)
+		}
+	}
+}
+
+// errRollback rolls back the transaction of inTxWithSettings without failing.
+var errRollback = errors.New("rollback")
+
+// queryWithSettings runs fn with a connection of pool in a transaction with
+// the settings applied, see inTxWithSettings, which is rolled back. Use
+// withConn if there are no settings.
+func queryWithSettings(t *testing.T, ctx context.Context, pool *pgxpool.Pool, settings map[string]string, fn func(conn *pgx.Conn)) {
+	t.Helper()
+
+	err := inTxWithSettings(ctx, pool, settings, func(tx pgx.Tx) error {
+		fn(tx.Conn())
+		return errRollback
+	})
+	if err != nil && !errors.Is(err, errRollback) {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,254 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// An Ingestion streams Rows rows of the ingestColumns, generated as they are
+// copied, into a columnar table created with Settings, e.g.
+// columnar.compression, with COPY FROM STDIN. The throughput of the copy is
+// logged, and the checksum of every column, computed while the rows are
+// generated, must match the checksum of the column read back.
+type Ingestion struct {
+	Name     string
+	Rows     int64
+	Settings map[string]string // settings of the transaction creating the table, e.g. columnar.stripe_row_limit
+}
+
+// Case returns in as a [Case] that runs it with [RunIngestion].
+func (in Ingestion) Case() Case {
+	return Case{
+		Name: "columnar ingestion " + in.Name,
+		Tags: []string{TagColumnar, TagSlow},
+		Run: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+			RunIngestion(t, ctx, pool, in)
+		},
+	}
+}
+
+// IngestionCases returns a case for each of ingestions.
+func IngestionCases(ingestions ...Ingestion) []Case {
+	return casesOf(ingestions...)
+}
+
+// Ingestions are the bulk loads covered by the [IngestionCases].
+var Ingestions = []Ingestion{
+	{
+		Name: "of 20 million rows",
+		Rows: 20000000,
+	},
+	{
+		Name:     "of 10 million rows with lz4",
+		Rows:     10000000,
+		Settings: map[string]string{"columnar.compression": "lz4"},
+	},
+	{
+		// a thousand stripes, each with its own metadata
+		Name:     "of 10 million rows into small stripes",
+		Rows:     10000000,
+		Settings: map[string]string{"columnar.stripe_row_limit": "10000", "columnar.chunk_group_row_limit": "1000"},
+	},
+}
+
+// An ingestColumn is a column of the rows of an [Ingestion], with the text
+// representation of its value in row i as output by Postgres, or false if it
+// is NULL.
+type ingestColumn struct {
+	Name  string
+	Type  string
+	Value func(i int64) (string, bool)
+}
+
+// ingestEpoch is the timestamp of the first row of an [Ingestion], which are a
+// second apart.
+var ingestEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ingestColumns are the columns of the rows of an [Ingestion], a mix of
+// sequential, repetitive, random looking and sparse values.
+var ingestColumns = []ingestColumn{
+	{Name: "id", Type: "INT8", Value: func(i int64) (string, bool) {
+		return strconv.FormatInt(i, 10), true
+	}},
+	{Name: "grp", Type: "INT4", Value: func(i int64) (string, bool) {
+		return strconv.FormatInt(i%1000, 10), true
+	}},
+	{Name: "hash", Type: "TEXT", Value: func(i int64) (string, bool) {
+		return fmt.Sprintf("%016x", splitmix64(uint64(i))), true
+	}},
+	{Name: "amount", Type: "NUMERIC(12, 2)", Value: func(i int64) (string, bool) {
+		cents := i * 7919 % 10000000
+		return fmt.Sprintf("%d.%02d", cents/100, cents%100), true
+	}},
+	{Name: "ts", Type: "TIMESTAMP", Value: func(i int64) (string, bool) {
+		return ingestEpoch.Add(time.Duration(i) * time.Second).Format("2006-01-02 15:04:05"), true
+	}},
+	{Name: "flag", Type: "BOOLEAN", Value: func(i int64) (string, bool) {
+		if i%3 == 0 {
+			return "t", true
+		}
+		return "f", true
+	}},
+	{Name: "note", Type: "TEXT", Value: func(i int64) (string, bool) {
+		if i%10 == 0 {
+			return "", false
+		}
+		return "note " + strconv.FormatInt(i%100, 10), true
+	}},
+}
+
+// splitmix64 returns the SplitMix64 hash of x, random looking and cheap to
+// compute for every row.
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+
+	return x ^ (x >> 31)
+}
+
+// A columnChecksum is the number of values of a column that are not NULL and
+// the sum of the first 32 bits of their MD5 hashes, which does not depend on
+// the order of the rows, see ingestChecksumSQL.
+type columnChecksum struct {
+	Count uint64
+	Sum   uint64
+}
+
+// add adds value to the checksum.
+func (c *columnChecksum) add(value string) {
+	h := md5.Sum([]byte(value))
+	c.Count++
+	c.Sum += uint64(binary.BigEndian.Uint32(h[:4]))
+}
+
+// String returns the checksum in the text format of queryTextRows.
+func (c columnChecksum) String() string {
+	return strconv.FormatUint(c.Count, 10) + "\t" + strconv.FormatUint(c.Sum, 10)
+}
+
+// ingestChecksumSQL returns the query of the checksums of every column of
+// table, as computed by columnChecksum.
+func ingestChecksumSQL(table string) string {
+	exprs := make([]string, 0, len(ingestColumns))
+	for _, c := range ingestColumns {
+		col := pgx.Identifier{c.Name}.Sanitize()
+		exprs = append(exprs, fmt.Sprintf("count(%[1]s), coalesce(sum(('x' || left(md5(%[1]s::text), 8))::bit(32)::int8), 0)", col))
+	}
+
+	return fmt.Sprintf("SELECT %s FROM %s", strings.Join(exprs, ", "), table)
+}
+
+// ingestRows is a reader of n rows of the ingestColumns in the text format of
+// COPY, generated as they are read, and computes the checksum of every column
+// meanwhile.
+type ingestRows struct {
+	n, next   int64
+	buf       bytes.Buffer
+	checksums []columnChecksum
+	written   int64
+}
+
+// newIngestRows returns a reader of the rows 1 to n.
+func newIngestRows(n int64) *ingestRows {
+	return &ingestRows{n: n, next: 1, checksums: make([]columnChecksum, len(ingestColumns))}
+}
+
+// ingestBatchRows is the number of rows generated at once by ingestRows.
+const ingestBatchRows = 1000
+
+// Read implements io.Reader.
+func (r *ingestRows) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if r.next > r.n {
+			return 0, io.EOF
+		}
+
+		for end := min(r.next+ingestBatchRows, r.n+1); r.next < end; r.next++ {
+			for c, col := range ingestColumns {
+				if c > 0 {
+					r.buf.WriteByte('\t')
+				}
+
+				value, ok := col.Value(r.next)
+				if !ok {
+					r.buf.WriteString(`\N`)
+					continue
+				}
+				r.buf.WriteString(value)
+				r.checksums[c].add(value)
+			}
+			r.buf.WriteByte('\n')
+		}
+	}
+
+	n, err := r.buf.Read(p)
+	r.written += int64(n)
+
+	return n, err
+}
+
+// RunIngestion creates a columnar table of its own for in, which is dropped
+// when the test completes, and copies the rows of in into it.
+func RunIngestion(t *testing.T, ctx context.Context, pool *pgxpool.Pool, in Ingestion) {
+	t.Helper()
+
+	defs := make([]string, 0, len(ingestColumns))
+	for _, c := range ingestColumns {
+		defs = append(defs, pgx.Identifier{c.Name}.Sanitize()+" "+c.Type)
+	}
+	table := uniqueTable(t, ctx, pool, "n", fmt.Sprintf("CREATE TABLE %%s (%s) USING columnar", strings.Join(defs, ", ")), in.Settings)
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("unable to acquire connection: %s", err)
+	}
+	defer conn.Release()
+
+	// table needs no quoting, unlike the tables of CopyFromText, which
+	// would also checksum every byte copied
+	rows := newIngestRows(in.Rows)
+	start := time.Now()
+	tag, err := conn.Conn().PgConn().CopyFrom(ctx, rows, "COPY "+table+" FROM STDIN")
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("unable to copy into %s: %s", table, err)
+	}
+	copied := tag.RowsAffected()
+	if copied != in.Rows {
+		t.Fatalf("%d rows should be copied into %s, got %d", in.Rows, table, copied)
+	}
+	t.Logf("ingestion %s: %d rows, %d bytes in %s, %.0f rows/s, %.1f MiB/s", in.Name, copied, rows.written, elapsed.Round(time.Millisecond),
+		float64(copied)/elapsed.Seconds(), float64(rows.written)/(1<<20)/elapsed.Seconds())
+
+	want := make([]string, 0, len(rows.checksums))
+	for _, c := range rows.checksums {
+		want = append(want, c.String())
+	}
+	withConn(t, ctx, pool, func(conn *pgx.Conn) {
+		got := queryTextRows(t, ctx, conn, ingestChecksumSQL(table))
+		if len(got) != 1 {
+			t.Fatalf("checksums of %s should be a single row, got %d", table, len(got))
+		}
+
+		cols := strings.Split(got[0], "\t")
+		for i, c := range ingestColumns {
+			if want, got := want[i], strings.Join(cols[2*i:2*i+2], "\t"); want != got {
+				t.Errorf("checksum of column %s of %s should match the copied rows: want=%s got=%s", c.Name, table, want, got)
+			}
+		}
+	})
+
+	CheckColumnarMetadata(t, ctx, pool, table)
+}
@@ -0,0 +1,192 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SQLStateSerializationFailure is the SQLSTATE serialization_failure of
+// transactions that must be retried, e.g. after a concurrent delete of the
+// rows they delete at REPEATABLE READ.
+const SQLStateSerializationFailure = "40001"
+
+// An IsolationStep is a statement run by one of the two sessions of an
+// [Isolation], with %[1]s the table.
+type IsolationStep struct {
+	Session  int      // session running the statement, 0 or 1
+	SQL      string   // e.g. BEGIN ISOLATION LEVEL REPEATABLE READ, or a query
+	Rows     []string // rows of the statement in the text format of queryTextRows, unchecked if nil
+	SQLState string   // SQLSTATE the statement must fail with, e.g. SQLStateSerializationFailure
+}
+
+// An Isolation interleaves the statements of two concurrent sessions on a
+// columnar table of ids 1 to 10, with val v1 to v10, and checks what each
+// statement returns. The steps run in order, so that none may wait for a lock
+// of the other session.
+type Isolation struct {
+	Name  string
+	Steps []IsolationStep
+}
+
+// Case returns i as a [Case] that runs it with [RunIsolation].
+func (i Isolation) Case() Case {
+	return Case{
+		Name: "columnar isolation " + i.Name,
+		Tags: []string{TagColumnar},
+		Run: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+			RunIsolation(t, ctx, pool, i)
+		},
+	}
+}
+
+// IsolationCases returns a case for each of isolations.
+func IsolationCases(isolations ...Isolation) []Case {
+	return casesOf(isolations...)
+}
+
+// Isolations are the interleavings covered by the [IsolationCases].
+var Isolations = []Isolation{
+	{
+		Name: "read committed sees committed inserts only",
+		Steps: []IsolationStep{
+			{Session: 0, SQL: "BEGIN ISOLATION LEVEL READ COMMITTED"},
+			{Session: 0, SQL: "SELECT count(*) FROM %[1]s", Rows: []string{"10"}},
+			{Session: 1, SQL: "INSERT INTO %[1]s VALUES (11, 'v11')"},
+			{Session: 0, SQL: "SELECT count(*) FROM %[1]s", Rows: []string{"11"}},
+			{Session: 1, SQL: "BEGIN"},
+			{Session: 1, SQL: "INSERT INTO %[1]s VALUES (12, 'v12')"},
+			{Session: 0, SQL: "SELECT count(*) FROM %[1]s", Rows: []string{"11"}},
+			{Session: 1, SQL: "COMMIT"},
+			{Session: 0, SQL: "SELECT count(*) FROM %[1]s", Rows: []string{"12"}},
+			{Session: 0, SQL: "COMMIT"},
+		},
+	},
+	{
+		Name: "read committed sees committed updates and deletes",
+		Steps: []IsolationStep{
+			{Session: 0, SQL: "BEGIN ISOLATION LEVEL READ COMMITTED"},
+			{Session: 0, SQL: "SELECT val FROM %[1]s WHERE id = 1", Rows: []string{"v1"}},
+			{Session: 1, SQL: "UPDATE %[1]s SET val = 'updated' WHERE id = 1"},
+			{Session: 1, SQL: "DELETE FROM %[1]s WHERE id = 2"},
+			{Session: 0, SQL: "SELECT val FROM %[1]s WHERE id <= 2", Rows: []string{"updated"}},
+			{Session: 0, SQL: "DELETE FROM %[1]s WHERE id = 2 RETURNING id", Rows: []string{}},
+			{Session: 0, SQL: "COMMIT"},
+		},
+	},
+	{
+		Name: "repeatable read keeps the snapshot of its first query",
+		Steps: []IsolationStep{
+			{Session: 0, SQL: "BEGIN ISOLATION LEVEL REPEATABLE READ"},
+			{Session: 0, SQL: "SELECT count(*), max(id) FROM %[1]s", Rows: []string{"10\t10"}},
+			{Session: 1, SQL: "INSERT INTO %[1]s VALUES (11, 'v11')"},
+			{Session: 1, SQL: "UPDATE %[1]s SET val = 'updated' WHERE id = 1"},
+			{Session: 1, SQL: "DELETE FROM %[1]s WHERE id = 2"},
+			{Session: 0, SQL: "SELECT count(*), max(id) FROM %[1]s", Rows: []string{"10\t10"}},
+			{Session: 0, SQL: "SELECT id, val FROM %[1]s WHERE id <= 2", Rows: []string{"1\tv1", "2\tv2"}},
+			{Session: 0, SQL: "COMMIT"},
+			{Session: 0, SQL: "SELECT count(*), max(id) FROM %[1]s", Rows: []string{"10\t11"}},
+		},
+	},
+	{
+		Name: "repeatable read fails to delete concurrently deleted rows",
+		Steps: []IsolationStep{
+			{Session: 0, SQL: "BEGIN ISOLATION LEVEL REPEATABLE READ"},
+			{Session: 0, SQL: "SELECT count(*) FROM %[1]s", Rows: []string{"10"}},
+			{Session: 1, SQL: "DELETE FROM %[1]s WHERE id = 1"},
+			{Session: 0, SQL: "DELETE FROM %[1]s WHERE id = 1", SQLState: SQLStateSerializationFailure},
+			{Session: 0, SQL: "ROLLBACK"},
+			{Session: 0, SQL: "SELECT count(*) FROM %[1]s", Rows: []string{"9"}},
+		},
+	},
+	{
+		Name: "repeatable read fails to update concurrently updated rows",
+		Steps: []IsolationStep{
+			{Session: 0, SQL: "BEGIN ISOLATION LEVEL REPEATABLE READ"},
+			{Session: 0, SQL: "SELECT count(*) FROM %[1]s", Rows: []string{"10"}},
+			{Session: 1, SQL: "UPDATE %[1]s SET val = 'session 1' WHERE id = 1"},
+			{Session: 0, SQL: "UPDATE %[1]s SET val = 'session 0' WHERE id = 1", SQLState: SQLStateSerializationFailure},
+			{Session: 0, SQL: "ROLLBACK"},
+			{Session: 0, SQL: "SELECT val FROM %[1]s WHERE id = 1", Rows: []string{"session 1"}},
+		},
+	},
+	{
+		Name: "serializable fails to delete concurrently deleted rows",
+		Steps: []IsolationStep{
+			{Session: 0, SQL: "BEGIN ISOLATION LEVEL SERIALIZABLE"},
+			{Session: 0, SQL: "SELECT count(*) FROM %[1]s", Rows: []string{"10"}},
+			{Session: 1, SQL: "DELETE FROM %[1]s WHERE id = 1"},
+			{Session: 0, SQL: "DELETE FROM %[1]s WHERE id = 1", SQLState: SQLStateSerializationFailure},
+			{Session: 0, SQL: "ROLLBACK"},
+		},
+	},
+	{
+		// serializable isolation is not supported by columnar tables, whose
+		// scans take no predicate locks, so that the write skew of two
+		// sessions inserting the sum they read is not detected, unlike with
+		// heap tables; the case fails once it is, and must then expect the
+		// second commit to fail with SQLStateSerializationFailure
+		Name: "serializable does not detect write skew",
+		Steps: []IsolationStep{
+			{Session: 0, SQL: "BEGIN ISOLATION LEVEL SERIALIZABLE"},
+			{Session: 1, SQL: "BEGIN ISOLATION LEVEL SERIALIZABLE"},
+			{Session: 0, SQL: "SELECT sum(id) FROM %[1]s", Rows: []string{"55"}},
+			{Session: 1, SQL: "SELECT sum(id) FROM %[1]s", Rows: []string{"55"}},
+			{Session: 0, SQL: "INSERT INTO %[1]s VALUES (55, 'session 0')"},
+			{Session: 1, SQL: "INSERT INTO %[1]s VALUES (55, 'session 1')"},
+			{Session: 0, SQL: "COMMIT"},
+			{Session: 1, SQL: "COMMIT"},
+			{Session: 0, SQL: "SELECT val FROM %[1]s WHERE id = 55 ORDER BY val", Rows: []string{"session 0", "session 1"}},
+		},
+	},
+}
+
+// RunIsolation runs the steps of i with two sessions of pool, on a table of
+// its own that is dropped when the test completes.
+func RunIsolation(t *testing.T, ctx context.Context, pool *pgxpool.Pool, i Isolation) {
+	t.Helper()
+
+	table := uniqueTable(t, ctx, pool, "i", `
+CREATE TABLE %[1]s (id INT8, val TEXT) USING columnar;
+INSERT INTO %[1]s SELECT i, 'v' || i FROM generate_series(1, 10) i;
+		`, nil)
+
+	var sessions [2]*pgxpool.Conn
+	for s := range sessions {
+		s := s
+		conn, err := pool.Acquire(ctx)
+		if err != nil {
+			t.Fatalf("unable to acquire a connection for session %d: %s", s, err)
+		}
+		sessions[s] = conn
+		t.Cleanup(func() {
+			// the transaction of the session is left open if a step failed,
+			// and is rolled back before the table can be dropped
+			if _, err := conn.Exec(context.Background(), "ROLLBACK"); err != nil {
+				t.Errorf("unable to roll back session %d: %s", s, err)
+			}
+			conn.Release()
+		})
+	}
+
+	for n, step := range i.Steps {
+		sql := fmt.Sprintf(step.SQL, table)
+		rows, err := textRows(ctx, sessions[step.Session].Conn(), sql)
+
+		switch {
+		case step.SQLState != "":
+			if err == nil {
+				t.Fatalf("step %d of session %d: %s should fail with SQLSTATE %s", n, step.Session, sql, step.SQLState)
+			}
+			AssertSQLState(t, err, step.SQLState)
+		case err != nil:
+			t.Fatalf("step %d of session %d: unable to run %s: %s", n, step.Session, sql, err)
+		case step.Rows != nil:
+			if diff := diffRows(step.Rows, rows); diff != "" {
+				t.Errorf("step %d of session %d: %s should return the rows: %s", n, step.Session, sql, diff)
+			}
+		}
+	}
+}
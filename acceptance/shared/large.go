@@ -0,0 +1,171 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A LargeValue is a type of column of a columnar table and the size of the
+// values stored in it, large enough to be TOASTed in heap tables and to span
+// many pages of a columnar chunk.
+type LargeValue struct {
+	Type string // TEXT, BYTEA or JSONB
+	Size int    // size of the values in bytes, about that of their text for JSONB
+}
+
+// Name returns the name of v, e.g. bytea_16mib.
+func (v LargeValue) Name() string {
+	return fmt.Sprintf("%s_%dmib", strings.ToLower(v.Type), v.Size>>20)
+}
+
+// LargeValues are the large values covered by the [LargeValueCases].
+var LargeValues = []LargeValue{
+	{Type: "TEXT", Size: 1 << 20},
+	{Type: "BYTEA", Size: 1 << 20},
+	{Type: "JSONB", Size: 1 << 20},
+	{Type: "TEXT", Size: 16 << 20},
+	{Type: "BYTEA", Size: 16 << 20},
+	{Type: "JSONB", Size: 16 << 20},
+}
+
+// LargeValueCases returns a case for each of values that stores large random
+// values of its type in a columnar table, between a small value and NULL, and
+// verifies that they read back byte for byte. The values of 16 MiB and more
+// are tagged slow.
+func LargeValueCases(values ...LargeValue) []Case {
+	var cases []Case
+	for _, v := range values {
+		v := v
+		tags := []string{TagColumnar}
+		if v.Size >= 16<<20 {
+			tags = append(tags, TagSlow)
+		}
+		cases = append(cases, Case{
+			Name: "columnar large value " + v.Name(),
+			Tags: tags,
+			Run: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+				RunLargeValue(t, ctx, pool, v)
+			},
+		})
+	}
+
+	return cases
+}
+
+// RunLargeValue stores values of v in a columnar table of its own, which is
+// dropped when the test completes, and reads them back. The values are
+// generated from [Rand], so that a failure can be reproduced with the same
+// SEED.
+func RunLargeValue(t *testing.T, ctx context.Context, pool *pgxpool.Pool, v LargeValue) {
+	t.Helper()
+
+	table := uniqueTable(t, ctx, pool, "l", fmt.Sprintf("CREATE TABLE %%s (id INT4, v %s) USING columnar", v.Type), nil)
+
+	r := Rand(t)
+	values := map[int32]any{
+		1: largeValue(r, v.Type, 100),
+		2: largeValue(r, v.Type, v.Size),
+		3: nil,
+		4: largeValue(r, v.Type, v.Size),
+	}
+	for id := int32(1); id <= int32(len(values)); id++ {
+		sql := fmt.Sprintf("INSERT INTO %s VALUES ($1, $2::%s)", table, v.Type)
+		if _, err := pool.Exec(ctx, sql, id, values[id]); err != nil {
+			t.Fatalf("unable to insert value %d: %s", id, err)
+		}
+	}
+
+	var count, notNull int
+	if err := pool.QueryRow(ctx, fmt.Sprintf("SELECT count(*), count(v) FROM %s", table)).Scan(&count, &notNull); err != nil {
+		t.Fatalf("unable to count the values: %s", err)
+	}
+	if count != 4 || notNull != 3 {
+		t.Errorf("values should match: want=4 rows, 3 not null got=%d rows, %d not null", count, notNull)
+	}
+
+	for id := int32(1); id <= int32(len(values)); id++ {
+		var got []byte
+		if err := pool.QueryRow(ctx, fmt.Sprintf("SELECT v FROM %s WHERE id = $1", table), id).Scan(&got); err != nil {
+			t.Fatalf("unable to read value %d: %s", id, err)
+		}
+
+		want := largeValueBytes(values[id])
+		if !equalLargeValue(v.Type, want, got) {
+			t.Errorf("value %d should read back as generated: want=%d bytes sha256 %x got=%d bytes sha256 %x", id, len(want), sha256.Sum256(want), len(got), sha256.Sum256(got))
+		}
+	}
+}
+
+// largeValueBytes returns the bytes of a value of largeValue, nil for NULL.
+func largeValueBytes(value any) []byte {
+	switch value := value.(type) {
+	case []byte:
+		return value
+	case string:
+		return []byte(value)
+	default:
+		return nil
+	}
+}
+
+// equalLargeValue reports whether got, a value of typ read back, equals want,
+// as generated. JSONB is normalized by Postgres, e.g. the order of the keys of
+// objects, so JSONB values are compared once decoded.
+func equalLargeValue(typ string, want, got []byte) bool {
+	if want == nil || got == nil || typ != "JSONB" {
+		return (want == nil) == (got == nil) && bytes.Equal(want, got)
+	}
+
+	var wantJSON, gotJSON any
+	if err := json.Unmarshal(want, &wantJSON); err != nil {
+		return false
+	}
+	if err := json.Unmarshal(got, &gotJSON); err != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(wantJSON, gotJSON)
+}
+
+// largeValue returns a random value of typ of about size bytes, as the
+// parameter of a query.
+func largeValue(r *rand.Rand, typ string, size int) any {
+	switch typ {
+	case "BYTEA":
+		b := make([]byte, size)
+		_, _ = r.Read(b)
+		return b
+	case "JSONB":
+		// an object with an array of random strings, whose text is about size
+		// bytes
+		items := make([]string, 0, size/70+1)
+		for n := 0; n < size; n += 68 {
+			items = append(items, randomText(r, 64))
+		}
+		b, _ := json.Marshal(map[string]any{"size": size, "items": items})
+		return string(b)
+	default:
+		return randomText(r, size)
+	}
+}
+
+// randomText returns size random letters and digits.
+func randomText(r *rand.Rand, size int) string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = alphabet[r.Intn(len(alphabet))]
+	}
+
+	return string(b)
+}
@@ -0,0 +1,127 @@
+package shared
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// leakTimeout is how long connections and goroutines are given to wind down
+// after a test before they are reported as leaked.
+const leakTimeout = 2 * time.Second
+
+// harnessPackage prefixes the functions of the harness and its suites in
+// goroutine stacks.
+const harnessPackage = "github.com/hydradatabase/hydra/acceptance/"
+
+// leakIgnored are goroutines of the harness that outlive tests by design.
+var leakIgnored = []string{
+	"shared.onAbort", // handles SIGINT and SIGTERM for the whole test binary
+}
+
+// CheckLeaks fails the test if, once it and its cleanups registered after
+// CheckLeaks completed, any of pools still has acquired connections or a
+// goroutine started by the harness during the test is still running. The
+// stacks of all goroutines are logged to find the leak.
+func CheckLeaks(t *testing.T, pools ...*pgxpool.Pool) {
+	t.Helper()
+
+	before := make(map[string]bool)
+	for _, g := range goroutines() {
+		before[goroutineID(g)] = true
+	}
+
+	t.Cleanup(func() {
+		var acquired []int32
+		var leaked [][]byte
+
+		deadline := time.Now().Add(leakTimeout)
+		for {
+			acquired = acquired[:0]
+			for _, pool := range pools {
+				acquired = append(acquired, pool.Stat().AcquiredConns())
+			}
+
+			leaked = leaked[:0]
+			for _, g := range goroutines() {
+				if !before[goroutineID(g)] && isHarnessGoroutine(g) {
+					leaked = append(leaked, g)
+				}
+			}
+
+			if len(leaked) == 0 && !hasAcquired(acquired) {
+				return
+			}
+			if time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		for i, n := range acquired {
+			if n > 0 {
+				t.Errorf("pool %d has %d acquired connections after the test", i, n)
+			}
+		}
+		for _, g := range leaked {
+			t.Errorf("goroutine leaked by the test:\n%s", g)
+		}
+
+		if hasAcquired(acquired) {
+			t.Logf("goroutines after the test:\n%s", bytes.Join(goroutines(), []byte("\n\n")))
+		}
+	})
+}
+
+func hasAcquired(acquired []int32) bool {
+	for _, n := range acquired {
+		if n > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// goroutines returns the stacks of all goroutines but the calling one.
+func goroutines() [][]byte {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	// the calling goroutine is always first
+	stacks := bytes.Split(buf, []byte("\n\n"))
+	return stacks[1:]
+}
+
+// goroutineID returns the ID of the goroutine from the first line of its
+// stack, e.g. goroutine 42 [running]:.
+func goroutineID(stack []byte) string {
+	id, _, _ := strings.Cut(strings.TrimPrefix(string(stack), "goroutine "), " ")
+	return id
+}
+
+func isHarnessGoroutine(stack []byte) bool {
+	_, createdBy, ok := bytes.Cut(stack, []byte("\ncreated by "))
+	if !ok || !bytes.HasPrefix(createdBy, []byte(harnessPackage)) {
+		return false
+	}
+
+	for _, ignored := range leakIgnored {
+		if bytes.Contains(createdBy, []byte(ignored)) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,86 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrUnknownLogLevel is used when the name of a log level is not one of debug,
+// info, warn or error.
+var ErrUnknownLogLevel = errors.New("unknown log level")
+
+var logLevel = new(slog.LevelVar)
+
+// Logger is the logger of the harness for what happens outside of a test, e.g.
+// collecting orphaned containers. Tests log with t.Log instead. At debug level
+// it also logs every command of the container runtime and every SQL statement
+// sent by the pools and connections of [CreatePGPool] and [CreatePGConn].
+var Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+
+// ParseLogLevel parses the name of a log level for [SetLogLevel], e.g. debug.
+func ParseLogLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("%w: %s", ErrUnknownLogLevel, name)
+	}
+}
+
+// SetLogLevel sets the minimum level of the [Logger].
+func SetLogLevel(level slog.Level) {
+	logLevel.Set(level)
+}
+
+// logCommand logs a command of bin, e.g. docker, at debug level before it is
+// run.
+func logCommand(bin string, args []string) {
+	Logger.Debug("exec", "cmd", bin+" "+strings.Join(args, " "))
+}
+
+// LogCommand logs a command of bin like the commands of the container
+// runtimes, for the suites running commands of their own, e.g. kubectl.
+func LogCommand(bin string, args ...string) {
+	logCommand(bin, args)
+}
+
+// sqlTracer logs every statement of a connection at debug level.
+type sqlTracer struct{}
+
+type sqlTraceKey struct{}
+
+func (sqlTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if !Logger.Enabled(ctx, slog.LevelDebug) {
+		return ctx
+	}
+
+	Logger.DebugContext(ctx, "sql", "pid", conn.PgConn().PID(), "sql", strings.TrimSpace(data.SQL), "args", len(data.Args))
+
+	return context.WithValue(ctx, sqlTraceKey{}, time.Now())
+}
+
+func (sqlTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(sqlTraceKey{}).(time.Time)
+	if !ok {
+		return
+	}
+
+	if data.Err != nil {
+		Logger.DebugContext(ctx, "sql failed", "pid", conn.PgConn().PID(), "duration", time.Since(start), "err", data.Err)
+		return
+	}
+	Logger.DebugContext(ctx, "sql done", "pid", conn.PgConn().PID(), "duration", time.Since(start), "tag", data.CommandTag.String())
+}
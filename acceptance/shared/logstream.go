@@ -0,0 +1,56 @@
+package shared
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"testing"
+)
+
+// StreamLogs follows the logs of the container name, which may be a container
+// name or ID, and writes them line by line to t.Log and, if w is not nil, to
+// w while the test runs, so that a hung test still shows what the container
+// is doing. Streaming stops when the container exits or the test completes.
+func StreamLogs(t *testing.T, ctx context.Context, rt ContainerRuntime, name string, w io.Writer) {
+	t.Helper()
+
+	r, pw := io.Pipe()
+
+	// the logs command is stopped during cleanup rather than with ctx, as ctx
+	// may outlive the test
+	cmd := rt.Command(context.WithoutCancel(ctx), "logs", "--follow", name)
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("unable to stream logs of %s: %s", name, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			t.Logf("%s: %s", name, scanner.Text())
+			if w != nil {
+				_, _ = w.Write(append(scanner.Bytes(), '\n'))
+			}
+		}
+
+		// keep draining if a line was too long so that the logs command does
+		// not block
+		_, _ = io.Copy(io.Discard, r)
+	}()
+
+	go func() {
+		_ = cmd.Wait()
+		_ = pw.Close()
+	}()
+
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		<-done
+	})
+}
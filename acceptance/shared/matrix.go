@@ -0,0 +1,108 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A GUCSet is a named set of settings, e.g. parallel execution turned off.
+type GUCSet struct {
+	Name     string
+	Settings map[string]string
+}
+
+// A GUCDimension lists alternative [GUCSet]s of which a combination in
+// [RunGUCMatrix] includes exactly one.
+type GUCDimension []GUCSet
+
+// DefaultGUCMatrix covers the settings that change how the columnar executor
+// runs a query: parallel workers, JIT and compression.
+var DefaultGUCMatrix = []GUCDimension{
+	{
+		{Name: "parallel_off", Settings: map[string]string{"max_parallel_workers_per_gather": "0", "columnar.enable_parallel_execution": "off"}},
+		{Name: "parallel_on", Settings: map[string]string{"max_parallel_workers_per_gather": "4", "columnar.enable_parallel_execution": "on", "parallel_setup_cost": "0", "parallel_tuple_cost": "0"}},
+	},
+	{
+		{Name: "jit_off", Settings: map[string]string{"jit": "off"}},
+		{Name: "jit_on", Settings: map[string]string{"jit": "on", "jit_above_cost": "0"}},
+	},
+	{
+		{Name: "compression_none", Settings: map[string]string{"columnar.compression": "none"}},
+		{Name: "compression_pglz", Settings: map[string]string{"columnar.compression": "pglz"}},
+	},
+}
+
+// GUCCombinations returns the cross product of dims, one [GUCSet] per
+// combination named after the sets it combines. Later dimensions override the
+// settings of earlier ones.
+func GUCCombinations(dims ...GUCDimension) []GUCSet {
+	combinations := []GUCSet{{Settings: map[string]string{}}}
+	for _, dim := range dims {
+		next := make([]GUCSet, 0, len(combinations)*len(dim))
+		for _, c := range combinations {
+			for _, set := range dim {
+				settings := maps.Clone(c.Settings)
+				maps.Copy(settings, set.Settings)
+
+				name := set.Name
+				if c.Name != "" {
+					name = c.Name + "," + set.Name
+				}
+
+				next = append(next, GUCSet{Name: name, Settings: settings})
+			}
+		}
+		combinations = next
+	}
+
+	return combinations
+}
+
+// RunGUCMatrix runs cases in a sub-test per combination of dims, e.g.
+// [DefaultGUCMatrix], with the settings of the combination applied to every
+// case. Settings of a case take precedence. Each combination runs in its own
+// schema, see [PoolForSchema], created using pool, so that cases creating
+// tables can run in every combination. The result of every combination is logged once all ran.
+func RunGUCMatrix(t *testing.T, ctx context.Context, pool *pgxpool.Pool, dims []GUCDimension, cases ...Case) {
+	t.Helper()
+
+	combinations := GUCCombinations(dims...)
+	results := make([]string, 0, len(combinations))
+	for _, combination := range combinations {
+		combination := combination
+
+		passed := t.Run(combination.Name, func(t *testing.T) {
+			schemaPool, _ := PoolForSchema(t, ctx, pool)
+			runCases(t, ctx, schemaPool, withSettings(cases, combination.Settings))
+		})
+
+		result := "PASS"
+		if !passed {
+			result = "FAIL"
+		}
+		results = append(results, fmt.Sprintf("%s: %s", result, combination.Name))
+	}
+
+	t.Logf("GUC matrix results:\n%s", strings.Join(results, "\n"))
+}
+
+// withSettings returns a copy of cases with settings added to the Settings of
+// every case, keeping the settings of a case.
+func withSettings(cases []Case, settings map[string]string) []Case {
+	out := make([]Case, len(cases))
+	for i, c := range cases {
+		merged := make(map[string]string, len(settings)+len(c.Settings))
+		maps.Copy(merged, settings)
+		maps.Copy(merged, c.Settings)
+		c.Settings = merged
+
+		out[i] = c
+	}
+
+	return out
+}
@@ -0,0 +1,189 @@
+package shared
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// storageIDFunctionSQL declares columnar_relation_storageid of the columnar
+// library, as the regression tests of columnar do, which returns the storage id
+// of a columnar table. The columnar catalog tables identify the rows of a table
+// by its storage id only.
+const storageIDFunctionSQL = `
+CREATE FUNCTION pg_temp.columnar_relation_storageid(relid oid) RETURNS bigint
+  LANGUAGE C STABLE STRICT AS 'columnar', $$columnar_relation_storageid$$`
+
+// storageIDSQL returns the storage id of the columnar table $1.
+const storageIDSQL = "SELECT pg_temp.columnar_relation_storageid($1::text::regclass)"
+
+// A metadataCheck is a query of the columnar catalog tables returning a row
+// describing each inconsistency of the metadata of a table, with $1 its
+// storage id.
+type metadataCheck struct {
+	Name string
+	SQL  string
+}
+
+// metadataChecks are the checks of [CheckColumnarMetadata].
+var metadataChecks = []metadataCheck{
+	{
+		Name: "stripes and their chunk groups",
+		SQL: `
+SELECT format('stripe %s has %s chunk groups of %s rows, want %s of %s rows',
+              s.stripe_num, count(g.chunk_group_num), coalesce(sum(g.row_count), 0), s.chunk_group_count, s.row_count)
+FROM columnar.stripe s
+LEFT JOIN columnar.chunk_group g USING (storage_id, stripe_num)
+WHERE s.storage_id = $1
+GROUP BY s.stripe_num, s.chunk_group_count, s.row_count
+HAVING count(g.chunk_group_num) <> s.chunk_group_count OR coalesce(sum(g.row_count), 0) <> s.row_count`,
+	},
+	{
+		Name: "chunk groups and their chunks",
+		SQL: `
+SELECT format('chunk group %s of stripe %s has %s chunks, want one for each of %s columns',
+              g.chunk_group_num, g.stripe_num, count(c.attr_num), s.column_count)
+FROM columnar.stripe s
+JOIN columnar.chunk_group g USING (storage_id, stripe_num)
+LEFT JOIN columnar.chunk c USING (storage_id, stripe_num, chunk_group_num)
+WHERE s.storage_id = $1
+GROUP BY g.stripe_num, g.chunk_group_num, s.column_count
+HAVING count(DISTINCT c.attr_num) <> s.column_count OR count(c.attr_num) <> s.column_count`,
+	},
+	{
+		Name: "chunks within their stripes",
+		SQL: `
+SELECT format('chunk of column %s of chunk group %s of stripe %s has %s values of %s rows, in streams ending at %s and %s of %s bytes',
+              c.attr_num, c.chunk_group_num, c.stripe_num, c.value_count, g.row_count,
+              c.value_stream_offset + c.value_stream_length, c.exists_stream_offset + c.exists_stream_length, s.data_length)
+FROM columnar.chunk c
+JOIN columnar.chunk_group g USING (storage_id, stripe_num, chunk_group_num)
+JOIN columnar.stripe s USING (storage_id, stripe_num)
+WHERE c.storage_id = $1
+  AND (c.value_count > g.row_count
+       OR c.value_stream_offset + c.value_stream_length > s.data_length
+       OR c.exists_stream_offset + c.exists_stream_length > s.data_length)`,
+	},
+	{
+		Name: "orphaned chunk groups and chunks",
+		SQL: `
+SELECT format('chunk group %s of stripe %s has no stripe', g.chunk_group_num, g.stripe_num)
+FROM columnar.chunk_group g
+WHERE g.storage_id = $1
+  AND NOT EXISTS (SELECT FROM columnar.stripe s WHERE s.storage_id = g.storage_id AND s.stripe_num = g.stripe_num)
+UNION ALL
+SELECT format('chunk of column %s of chunk group %s of stripe %s has no chunk group', c.attr_num, c.chunk_group_num, c.stripe_num)
+FROM columnar.chunk c
+WHERE c.storage_id = $1
+  AND NOT EXISTS (
+    SELECT FROM columnar.chunk_group g
+    WHERE g.storage_id = c.storage_id AND g.stripe_num = c.stripe_num AND g.chunk_group_num = c.chunk_group_num
+  )`,
+	},
+	{
+		Name: "stripes overlapping in the table",
+		SQL: `
+SELECT format('stripe %s of bytes %s to %s overlaps stripe %s ending at %s', stripe_num, file_offset, file_offset + data_length, previous, previous_end)
+FROM (
+  SELECT stripe_num, file_offset, data_length,
+         lag(stripe_num) OVER (ORDER BY file_offset) AS previous,
+         lag(file_offset + data_length) OVER (ORDER BY file_offset) AS previous_end
+  FROM columnar.stripe
+  WHERE storage_id = $1
+) s
+WHERE file_offset < previous_end`,
+	},
+	{
+		Name: "stripes overlapping in row numbers",
+		SQL: `
+SELECT format('stripe %s of rows %s to %s overlaps stripe %s ending at %s', stripe_num, first_row_number, first_row_number + row_count, previous, previous_end)
+FROM (
+  SELECT stripe_num, first_row_number, row_count,
+         lag(stripe_num) OVER (ORDER BY first_row_number) AS previous,
+         lag(first_row_number + row_count) OVER (ORDER BY first_row_number) AS previous_end
+  FROM columnar.stripe
+  WHERE storage_id = $1
+) s
+WHERE first_row_number < previous_end`,
+	},
+	{
+		Name: "deleted rows of chunk groups and their row masks",
+		SQL: `
+SELECT format('chunk group %s of stripe %s has %s deleted rows of %s, but row masks of %s deleted rows of %s',
+              g.chunk_group_num, g.stripe_num, g.deleted_rows, g.row_count,
+              coalesce(sum(m.deleted_rows), 0), coalesce(sum(m.end_row_number - m.start_row_number + 1), 0))
+FROM columnar.chunk_group g
+LEFT JOIN columnar.row_mask m ON m.storage_id = g.storage_id AND m.stripe_id = g.stripe_num AND m.chunk_id = g.chunk_group_num
+WHERE g.storage_id = $1
+GROUP BY g.stripe_num, g.chunk_group_num, g.deleted_rows, g.row_count
+HAVING g.deleted_rows > g.row_count
+    OR g.deleted_rows <> coalesce(sum(m.deleted_rows), 0)
+    OR g.row_count <> coalesce(sum(m.end_row_number - m.start_row_number + 1), 0)`,
+	},
+}
+
+// CheckColumnarMetadata cross-validates the columnar catalog tables of each of
+// the columnar tables against each other and against the table, failing the
+// test for each inconsistency: the chunk groups of every stripe must add up to
+// its rows and have a chunk for each column within the stripe, stripes must
+// neither overlap nor extend beyond the table, and the row masks must match
+// the deleted rows of their chunk groups, which together with those of the
+// stripes must add up to the rows of the table. It is meant to run once the
+// tables are no longer written, e.g. at the end of destructive cases, to catch
+// metadata silently corrupted by a crash or restart.
+//
+// The storage ids of the tables are read with a C function of the columnar
+// library, which is declared in a transaction that is rolled back, so that
+// pool must connect as a superuser.
+func CheckColumnarMetadata(t *testing.T, ctx context.Context, pool *pgxpool.Pool, tables ...string) {
+	t.Helper()
+
+	WithTx(t, ctx, pool, func(t *testing.T, tx pgx.Tx) {
+		conn := tx.Conn()
+		if _, err := conn.Exec(ctx, storageIDFunctionSQL); err != nil {
+			t.Fatalf("unable to declare columnar_relation_storageid: %s", err)
+		}
+
+		for _, table := range tables {
+			var storageID int64
+			if err := conn.QueryRow(ctx, storageIDSQL, table).Scan(&storageID); err != nil {
+				t.Fatalf("unable to read the storage id of %s: %s", table, err)
+			}
+
+			for _, check := range metadataChecks {
+				rows, err := conn.Query(ctx, check.SQL, storageID)
+				if err != nil {
+					t.Fatalf("unable to check the %s of %s: %s", check.Name, table, err)
+				}
+				inconsistencies, err := pgx.CollectRows(rows, pgx.RowTo[string])
+				if err != nil {
+					t.Fatalf("unable to check the %s of %s: %s", check.Name, table, err)
+				}
+				for _, inconsistency := range inconsistencies {
+					t.Errorf("metadata of %s should be consistent: %s", table, inconsistency)
+				}
+			}
+
+			var count, live, end, size int64
+			if err := conn.QueryRow(ctx, "SELECT count(*), pg_relation_size($1::text::regclass) FROM "+table, table).Scan(&count, &size); err != nil {
+				t.Fatalf("unable to count the rows of %s: %s", table, err)
+			}
+			if err := conn.QueryRow(ctx, `
+SELECT ((SELECT coalesce(sum(row_count), 0) FROM columnar.stripe WHERE storage_id = $1)
+      - (SELECT coalesce(sum(deleted_rows), 0) FROM columnar.chunk_group WHERE storage_id = $1))::int8,
+       (SELECT coalesce(max(file_offset + data_length), 0) FROM columnar.stripe WHERE storage_id = $1)`,
+				storageID,
+			).Scan(&live, &end); err != nil {
+				t.Fatalf("unable to count the live rows of %s by its metadata: %s", table, err)
+			}
+			if count != live {
+				t.Errorf("metadata of %s should be consistent: %d rows are live by the metadata, but the table has %d", table, live, count)
+			}
+			if end > size {
+				t.Errorf("metadata of %s should be consistent: stripes end at byte %d of a table of %d bytes", table, end, size)
+			}
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rs/xid"
+)
+
+// LabelRunID is applied to the resources created during a test run, with
+// [RunID] as its value.
+const LabelRunID = "io.hydra.acceptance.run-id"
+
+// RunID identifies the current run of the tests. It is unique to the test
+// binary unless set with [InitRunID], e.g. to the same ID for every test
+// binary and retry of a CI run.
+var RunID = xid.New().String()
+
+// ErrInvalidRunID is used when a run ID is not a valid label value.
+var ErrInvalidRunID = errors.New("invalid run ID")
+
+// regexpRunID matches the label values of Kubernetes, which are stricter than
+// those of docker: at most 63 characters, beginning and ending with an
+// alphanumeric character.
+var regexpRunID = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9_.-]{0,61}[A-Za-z0-9])?)?$`)
+
+// ValidateRunID reports whether id, set by the environment variable name, can
+// be used as the [RunID], or is empty to generate one.
+func ValidateRunID(name, id string) error {
+	if id != "" && !regexpRunID.MatchString(id) {
+		return fmt.Errorf("%s: %w: %q", name, ErrInvalidRunID, id)
+	}
+
+	return nil
+}
+
+// InitRunID sets the [RunID] to id, keeping the generated one if id is empty,
+// and logs it. It is called from TestMain of each suite.
+func InitRunID(id string) string {
+	if id != "" {
+		RunID = id
+	}
+
+	Logger.Info("run ID, the value of the label "+LabelRunID, "id", RunID)
+
+	return RunID
+}
+
+var regexpInvalidNameChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// maxNameTestLength limits how much of the test name ends up in a generated
+// name so that container names stay readable.
+const maxNameTestLength = 40
+
+// UniqueName returns a name for a container, compose project, network or volume
+// that is unique across parallel tests and test runs. It is made of prefix, the
+// name of the test and a random suffix, and only contains characters valid in a
+// compose project name.
+func UniqueName(t *testing.T, prefix string) string {
+	t.Helper()
+
+	testName := regexpInvalidNameChars.ReplaceAllString(strings.ToLower(t.Name()), "-")
+	testName = strings.Trim(testName, "-")
+	if len(testName) > maxNameTestLength {
+		testName = strings.TrimRight(testName[:maxNameTestLength], "-")
+	}
+
+	return strings.Join([]string{prefix, testName, xid.New().String()}, "-")
+}
+
+// FreePort returns a TCP port on the host that is currently free to publish a
+// container port on. The port is not reserved, so it should be used right
+// away.
+func FreePort(t *testing.T) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("unable to allocate a free port: %s", err)
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// PublishedPort returns the host port that containerPort of the container is
+// published on.
+func PublishedPort(t *testing.T, ctx context.Context, rt ContainerRuntime, container string, containerPort int) int {
+	t.Helper()
+
+	_, port := PublishedAddress(t, ctx, rt, container, containerPort)
+	return port
+}
+
+// PublishedAddress returns the host and port that containerPort of the
+// container is published on. Ports published on all interfaces are reachable
+// on the address of [DockerHostAddress], ports published on a single address
+// on that address.
+func PublishedAddress(t *testing.T, ctx context.Context, rt ContainerRuntime, container string, containerPort int) (string, int) {
+	t.Helper()
+
+	output, err := rt.Command(ctx, "port", container, strconv.Itoa(containerPort)+"/tcp").CombinedOutput()
+	if err != nil {
+		t.Fatalf("unable to find the published port of %s: %s: %s", container, err, output)
+	}
+
+	// the output has one host:port line per address family
+	lines := strings.Fields(string(output))
+	if len(lines) == 0 {
+		t.Fatalf("port %d of %s is not published", containerPort, container)
+	}
+
+	host, port, err := net.SplitHostPort(lines[0])
+	if err != nil {
+		t.Fatalf("unable to parse published port %s: %s", lines[0], err)
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatalf("unable to parse published port %s: %s", lines[0], err)
+	}
+
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		if host, err = DockerHostAddress(); err != nil {
+			t.Fatalf("unable to resolve docker host: %s", err)
+		}
+	}
+
+	return host, p
+}
@@ -0,0 +1,102 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// CreateNetwork creates an isolated bridge network called name and removes it
+// when the test completes. Containers are attached with [ConnectNetwork] and
+// can reach each other by container name or alias on the network.
+func CreateNetwork(t *testing.T, ctx context.Context, rt ContainerRuntime, name string) {
+	t.Helper()
+
+	args := append([]string{"network", "create", "--driver", "bridge"}, labelArgs()...)
+	if output, err := rt.Command(ctx, append(args, name)...).CombinedOutput(); err != nil {
+		t.Fatalf("unable to create network %s: %s: %s", name, err, output)
+	}
+
+	t.Cleanup(func() {
+		// the test context may already be done during cleanup
+		if output, err := rt.Command(context.Background(), "network", "rm", name).CombinedOutput(); err != nil {
+			t.Errorf("unable to remove network %s: %s: %s", name, err, output)
+		}
+	})
+}
+
+// ConnectNetwork attaches the container to network. The container is reachable
+// on the network by its name and by any of the given aliases.
+func ConnectNetwork(t *testing.T, ctx context.Context, rt ContainerRuntime, network, container string, aliases ...string) {
+	t.Helper()
+
+	args := []string{"network", "connect"}
+	for _, alias := range aliases {
+		args = append(args, "--alias", alias)
+	}
+	args = append(args, network, container)
+
+	if output, err := rt.Command(ctx, args...).CombinedOutput(); err != nil {
+		t.Fatalf("unable to connect %s to network %s: %s: %s", container, network, err, output)
+	}
+}
+
+// DisconnectNetwork detaches the container from network, e.g. to simulate a
+// network partition.
+func DisconnectNetwork(t *testing.T, ctx context.Context, rt ContainerRuntime, network, container string) {
+	t.Helper()
+
+	if output, err := rt.Command(ctx, "network", "disconnect", network, container).CombinedOutput(); err != nil {
+		t.Fatalf("unable to disconnect %s from network %s: %s: %s", container, network, err, output)
+	}
+}
+
+// ContainerIP returns the IP address of the container on network.
+func ContainerIP(t *testing.T, ctx context.Context, rt ContainerRuntime, network, container string) string {
+	t.Helper()
+
+	format := fmt.Sprintf("{{with index .NetworkSettings.Networks %q}}{{.IPAddress}}{{end}}", network)
+	output, err := rt.Command(ctx, "inspect", "--format", format, container).CombinedOutput()
+	if err != nil {
+		t.Fatalf("unable to inspect %s: %s: %s", container, err, output)
+	}
+
+	ip := strings.TrimSpace(string(output))
+	if ip == "" {
+		t.Fatalf("%s is not connected to network %s", container, network)
+	}
+
+	return ip
+}
+
+// ResolveHostname resolves hostname, e.g. a container name or network alias,
+// from inside the container from and returns its first address.
+func ResolveHostname(t *testing.T, ctx context.Context, rt ContainerRuntime, from, hostname string) string {
+	t.Helper()
+
+	result := ExecInContainer(t, ctx, rt, from, "getent", "hosts", hostname)
+	if result.ExitCode != 0 {
+		t.Fatalf("unable to resolve %s from %s: %s", hostname, from, result.Stderr)
+	}
+
+	fields := strings.Fields(result.Stdout)
+	if len(fields) == 0 {
+		t.Fatalf("unable to resolve %s from %s: no address", hostname, from)
+	}
+
+	return fields[0]
+}
+
+// RequireIPv6 skips the test unless the host is able to use IPv6, which it
+// detects by listening on the IPv6 loopback address ::1.
+func RequireIPv6(t *testing.T) {
+	t.Helper()
+
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is not available: %s", err)
+	}
+	_ = l.Close()
+}
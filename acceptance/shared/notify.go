@@ -0,0 +1,61 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Listen subscribes conn, e.g. a connection from [CreatePGConn], to the
+// notifications on channel. Notifications are delivered to the session, so
+// they cannot be received through a pool.
+func Listen(t *testing.T, ctx context.Context, conn *pgx.Conn, channel string) {
+	t.Helper()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		t.Fatalf("unable to listen on %s: %s", channel, err)
+	}
+}
+
+// WaitForNotification waits up to timeout for the next notification on any of
+// the channels conn listens on, see [Listen]. It returns nil if none arrives
+// in time.
+func WaitForNotification(t *testing.T, ctx context.Context, conn *pgx.Conn, timeout time.Duration) *pgconn.Notification {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	n, err := conn.WaitForNotification(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("unable to wait for notification: %s", err)
+	}
+
+	return n
+}
+
+// AssertNotification fails the test unless a notification on channel with
+// payload arrives on conn within timeout. Notifications on other channels or
+// with other payloads are skipped.
+func AssertNotification(t *testing.T, ctx context.Context, conn *pgx.Conn, channel, payload string, timeout time.Duration) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		n := WaitForNotification(t, ctx, conn, time.Until(deadline))
+		if n == nil {
+			t.Fatalf("no notification on %s with payload %q within %s", channel, payload, timeout)
+		}
+
+		if n.Channel == channel && n.Payload == payload {
+			return
+		}
+	}
+}
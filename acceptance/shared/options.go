@@ -0,0 +1,162 @@
+package shared
+
+import (
+	"encoding/json"
+	"strings"
+	"text/template"
+)
+
+// ContainerOptions configure how the hydra container is run. The zero value
+// runs the container without any limits.
+type ContainerOptions struct {
+	Platform       string            // platform of the image to run, e.g. linux/amd64, the native platform if empty
+	Memory         string            // memory limit, e.g. 512m
+	CPUs           string            // number of CPUs, e.g. 1.5
+	ShmSize        string            // size of /dev/shm, e.g. 1g, the runtime default of 64m if empty
+	Ulimits        []Ulimit          // resource limits of the processes in the container
+	BlkioWeight    int               // relative block IO weight between 10 and 1000
+	DeviceReadBps  []ThrottleDevice  // read rate limits in bytes per second, e.g. 10mb
+	DeviceWriteBps []ThrottleDevice  // write rate limits in bytes per second, e.g. 10mb
+	DataVolume     string            // existing volume to keep the data directory in, see [CreateVolume]
+	DataTmpfs      string            // size of a tmpfs to keep the data directory in instead of a volume, e.g. 1g; the data is lost when the container stops
+	SocketDir      string            // host directory to bind mount as the socket directory of Postgres instead of publishing its port, see [CreateSocketDir]
+	Entrypoint     []string          // overrides the entrypoint of the image
+	Command        []string          // overrides the command of the image, e.g. postgres -c shared_preload_libraries=columnar
+	Env            map[string]string // environment variables added to or overriding those of the suite, e.g. POSTGRES_INITDB_ARGS
+	AuthMethod     string            // authentication method of host connections to the postgres image, e.g. one of the AuthMethod constants
+	Settings       map[string]string // postgresql.conf settings of the postgres image applied from the start of Postgres, see [WriteConfFragment]
+}
+
+// Environment returns the environment of the container: defaults, the
+// environment set by the suite, overridden by Env. AuthMethod is set with
+// POSTGRES_HOST_AUTH_METHOD and added to POSTGRES_INITDB_ARGS as the method of
+// the host entries created by initdb.
+func (o ContainerOptions) Environment(defaults map[string]string) map[string]string {
+	env := make(map[string]string, len(defaults)+len(o.Env))
+	for k, v := range defaults {
+		env[k] = v
+	}
+	for k, v := range o.Env {
+		env[k] = v
+	}
+
+	if o.AuthMethod != "" {
+		env["POSTGRES_HOST_AUTH_METHOD"] = o.AuthMethod
+		env["POSTGRES_INITDB_ARGS"] = strings.TrimSpace(env["POSTGRES_INITDB_ARGS"] + " --auth-host=" + o.AuthMethod)
+	}
+
+	return env
+}
+
+// A Ulimit limits a resource of the processes in a container.
+type Ulimit struct {
+	Name string // name of the limit, e.g. nofile
+	Soft int
+	Hard int
+}
+
+// A ThrottleDevice limits the IO rate of a block device.
+type ThrottleDevice struct {
+	Path string // path of the device, e.g. /dev/sda
+	Rate string // rate limit, e.g. 10mb
+}
+
+// composeDefinitions render ContainerOptions and labels as the keys of a
+// service and of the data volume in a compose file.
+const composeDefinitions = `
+{{- define "containerOptions" }}
+{{- if .Platform }}
+    platform: {{ .Platform }}
+{{- end }}
+{{- if .Memory }}
+    mem_limit: {{ .Memory }}
+{{- end }}
+{{- if .CPUs }}
+    cpus: {{ .CPUs }}
+{{- end }}
+{{- if .ShmSize }}
+    shm_size: {{ .ShmSize }}
+{{- end }}
+{{- with .Ulimits }}
+    ulimits:
+      {{- range . }}
+      {{ .Name }}:
+        soft: {{ .Soft }}
+        hard: {{ .Hard }}
+      {{- end }}
+{{- end }}
+{{- with .Entrypoint }}
+    entrypoint: {{ json . }}
+{{- end }}
+{{- with .Command }}
+    command: {{ json . }}
+{{- end }}
+{{- if or .BlkioWeight .DeviceReadBps .DeviceWriteBps }}
+    blkio_config:
+      {{- if .BlkioWeight }}
+      weight: {{ .BlkioWeight }}
+      {{- end }}
+      {{- with .DeviceReadBps }}
+      device_read_bps:
+        {{- range . }}
+        - path: {{ .Path }}
+          rate: "{{ .Rate }}"
+        {{- end }}
+      {{- end }}
+      {{- with .DeviceWriteBps }}
+      device_write_bps:
+        {{- range . }}
+        - path: {{ .Path }}
+          rate: "{{ .Rate }}"
+        {{- end }}
+      {{- end }}
+{{- end }}
+{{- end }}
+
+{{- define "environment" }}
+    environment:
+    {{- range $k, $v := . }}
+      {{ $k }}: {{ json $v }}
+    {{- end }}
+{{- end }}
+
+{{- define "labels" }}
+    labels:
+    {{- range $k, $v := . }}
+      {{ $k }}: "{{ $v }}"
+    {{- end }}
+{{- end }}
+
+{{- define "dataVolume" }}
+{{- with .DataVolume }}
+    external: true
+    name: {{ . }}
+{{- end }}
+{{- end }}`
+
+// NewComposeTemplate parses text as a compose file template. The template may
+// render [ContainerOptions] into a service definition with
+// {{ template "containerOptions" .Options }} and into the definition of the
+// data volume with {{ template "dataVolume" .Options }}, where .Options is a
+// ContainerOptions. {{ template "labels" .Labels }} renders a map of labels
+// into a service, volume or network and {{ template "environment" .Env }} a
+// map of environment variables into a service, see
+// [ContainerOptions.Environment]. It panics if text cannot be parsed.
+func NewComposeTemplate(name, text string) *template.Template {
+	return template.Must(template.Must(template.New(name).Funcs(composeFuncs).Parse(composeDefinitions)).Parse(text))
+}
+
+// composeFuncs are available to compose templates. json renders a value as
+// JSON, which is also valid YAML, to quote strings and lists.
+// confFragmentDir and confIncludeScript render where to mount a directory
+// from [WriteConfFragment].
+var composeFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"confFragmentDir": func() string {
+		return ConfFragmentDir
+	},
+	"confIncludeScript": ConfIncludeScript,
+}
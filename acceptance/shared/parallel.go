@@ -0,0 +1,145 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A ParallelScan is a query of the columnar table of the [ParallelScanCases],
+// with %[1]s the table, that must be planned with parallel workers and return
+// the same rows as when planned serially. Its results must not depend on the
+// order in which workers return rows, e.g. no sum of floats.
+type ParallelScan struct {
+	Name  string
+	Query string
+}
+
+// parallelScanTable is the columnar table of the [ParallelScanCases], made of
+// enough stripes for several workers to scan it, as columnar tables plan at
+// most as many workers as they have stripes.
+const parallelScanTable = "parallel_scan_columnar"
+
+// ParallelScans are the queries covered by the [ParallelScanCases].
+var ParallelScans = []ParallelScan{
+	{
+		Name:  "aggregates",
+		Query: "SELECT count(*), sum(id), sum(grp), min(val), max(val) FROM %[1]s",
+	},
+	{
+		Name:  "group by",
+		Query: "SELECT grp, count(*), sum(id), min(id), max(val) FROM %[1]s GROUP BY grp",
+	},
+	{
+		Name:  "filter",
+		Query: "SELECT count(*), sum(id) FROM %[1]s WHERE grp BETWEEN 10 AND 19 AND val LIKE 'a%%'",
+	},
+	{
+		Name:  "rows",
+		Query: "SELECT id, grp, val FROM %[1]s WHERE id %% 9973 = 0",
+	},
+	{
+		Name:  "distinct",
+		Query: "SELECT count(DISTINCT grp), count(DISTINCT left(val, 2)) FROM %[1]s",
+	},
+}
+
+// Settings of the queries of a [ParallelScan] planned with and without
+// parallel workers. Parallel plans are made as cheap as serial ones, so that
+// the planner picks them for any table.
+var (
+	withParallelWorkers = map[string]string{
+		"columnar.enable_parallel_execution": "on",
+		"max_parallel_workers_per_gather":    "4",
+		"parallel_setup_cost":                "0",
+		"parallel_tuple_cost":                "0",
+		"min_parallel_table_scan_size":       "0",
+	}
+	withoutParallelWorkers = map[string]string{
+		"max_parallel_workers_per_gather": "0",
+	}
+)
+
+// ParallelScanCases returns a case that creates the table of the
+// [ParallelScan]s, followed by a case for each of scans, see
+// [RunParallelScan], and a case that drops the table, so that a reused
+// container does not keep it.
+func ParallelScanCases(scans ...ParallelScan) []Case {
+	cases := []Case{
+		{
+			Name: "create columnar table for parallel scans",
+			Tags: []string{TagColumnar, TagSlow},
+			SQL: fmt.Sprintf(`
+CREATE TABLE %[1]s (id INT8, grp INT4, val TEXT) USING columnar;
+INSERT INTO %[1]s SELECT i, i %% 100, md5(i::text) FROM generate_series(1, 1000000) i;
+ANALYZE %[1]s;
+				`, parallelScanTable),
+			Settings: map[string]string{"columnar.stripe_row_limit": "100000"},
+		},
+	}
+
+	for _, scan := range scans {
+		scan := scan
+		cases = append(cases, Case{
+			Name: "columnar parallel scan " + scan.Name,
+			Tags: []string{TagColumnar, TagSlow},
+			Run: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+				RunParallelScan(t, ctx, pool, scan)
+			},
+		})
+	}
+
+	return append(cases, Case{
+		Name: "drop columnar table for parallel scans",
+		Tags: []string{TagColumnar, TagSlow},
+		SQL:  "DROP TABLE IF EXISTS " + parallelScanTable,
+	})
+}
+
+// workers matches the planned and launched workers of the Gather nodes of a
+// plan of EXPLAIN ANALYZE.
+var workers = regexp.MustCompile(`Workers (Planned|Launched): (\d+)`)
+
+// RunParallelScan runs the query of scan planned with parallel workers, which
+// must be both planned and launched, and planned serially, and compares their
+// rows.
+func RunParallelScan(t *testing.T, ctx context.Context, pool *pgxpool.Pool, scan ParallelScan) {
+	t.Helper()
+
+	sql := fmt.Sprintf(scan.Query, pgx.Identifier{parallelScanTable}.Sanitize())
+
+	var plan, want, got []string
+	queryWithSettings(t, ctx, pool, withParallelWorkers, func(conn *pgx.Conn) {
+		plan = queryTextRows(t, ctx, conn, "EXPLAIN (ANALYZE, COSTS OFF, TIMING OFF, SUMMARY OFF) "+sql)
+		got = queryTextRows(t, ctx, conn, sql)
+	})
+	queryWithSettings(t, ctx, pool, withoutParallelWorkers, func(conn *pgx.Conn) {
+		want = queryTextRows(t, ctx, conn, sql)
+	})
+
+	planned, launched := 0, 0
+	for _, m := range workers.FindAllStringSubmatch(strings.Join(plan, "\n"), -1) {
+		n, _ := strconv.Atoi(m[2])
+		if m[1] == "Planned" {
+			planned += n
+		} else {
+			launched += n
+		}
+	}
+	if planned == 0 || launched == 0 {
+		t.Errorf("%s should be planned and run with parallel workers, got %d planned and %d launched: %s", sql, planned, launched, strings.Join(plan, "\n"))
+	}
+
+	if len(want) == 0 {
+		t.Errorf("%s should return rows", sql)
+	}
+	if diff := diffRows(want, got); diff != "" {
+		t.Errorf("%s should return the same rows with parallel workers: %s", sql, diff)
+	}
+}
@@ -0,0 +1,283 @@
+package shared
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PartitionCases describe range, list and hash partitioned tables whose
+// partitions use the columnar access method: routing rows to partitions,
+// partition pruning, queries across partitions and ATTACH and DETACH of
+// columnar partitions. Partitioned tables themselves have no access method.
+var PartitionCases = []Case{
+	{
+		Name: "create range partitioned columnar table",
+		Tags: []string{TagColumnar},
+		SQL: `
+CREATE TABLE part_range (ts DATE, id INT8, val TEXT) PARTITION BY RANGE (ts);
+CREATE TABLE part_range_2020 PARTITION OF part_range FOR VALUES FROM ('2020-01-01') TO ('2021-01-01') USING columnar;
+CREATE TABLE part_range_2021 PARTITION OF part_range FOR VALUES FROM ('2021-01-01') TO ('2022-01-01') USING columnar;
+CREATE TABLE part_range_2022 PARTITION OF part_range FOR VALUES FROM ('2022-01-01') TO ('2023-01-01') USING columnar;
+			`,
+	},
+	{
+		Name: "insert into range partitioned columnar table",
+		Tags: []string{TagColumnar},
+		SQL: `
+INSERT INTO part_range
+SELECT d, row_number() OVER (ORDER BY d), md5(d::text)
+FROM generate_series(DATE '2020-01-01', DATE '2022-12-31', INTERVAL '1 day') d;
+			`,
+	},
+	{
+		Name: "rows routed to range partitions",
+		Tags: []string{TagColumnar},
+		SQL: `
+SELECT (SELECT count(*) FROM part_range_2020),
+       (SELECT count(*) FROM part_range_2021),
+       (SELECT count(*) FROM part_range_2022);
+			`,
+		Validate: validatePartitionCounts(366, 365, 365),
+	},
+	{
+		Name: "range partitions pruned",
+		Tags: []string{TagColumnar},
+		SQL: `
+EXPLAIN (COSTS OFF, FORMAT JSON)
+SELECT count(*) FROM part_range WHERE ts >= DATE '2021-03-01' AND ts < DATE '2021-04-01';
+			`,
+		Validate: validatePartitionsScanned("part_range_2021"),
+	},
+	{
+		Name: "query across range partitions",
+		Tags: []string{TagColumnar},
+		SQL: `
+SELECT count(*), min(ts)::text, max(ts)::text, sum(id)
+FROM part_range
+WHERE ts BETWEEN DATE '2020-12-01' AND DATE '2021-01-31';
+			`,
+		Validate: func(t *testing.T, row pgx.Row) {
+			var count, sum int64
+			var min, max string
+			if err := row.Scan(&count, &min, &max, &sum); err != nil {
+				t.Fatal(err)
+			}
+
+			// the ids of 2020-12-01 and 2021-01-31 are 336 and 397
+			if count != 62 || min != "2020-12-01" || max != "2021-01-31" || sum != (336+397)*62/2 {
+				t.Errorf("rows across partitions should match: want=62 rows from 2020-12-01 to 2021-01-31 summing to %d got=%d rows from %s to %s summing to %d", (336+397)*62/2, count, min, max, sum)
+			}
+		},
+	},
+	{
+		Name: "update moving rows across range partitions",
+		Tags: []string{TagColumnar},
+		SQL: `
+UPDATE part_range SET ts = ts + 365 WHERE ts >= DATE '2021-12-25' AND ts < DATE '2022-01-01';
+			`,
+	},
+	{
+		Name: "moved rows in their new range partition",
+		Tags: []string{TagColumnar},
+		SQL: `
+SELECT (SELECT count(*) FROM part_range_2020),
+       (SELECT count(*) FROM part_range_2021),
+       (SELECT count(*) FROM part_range_2022);
+			`,
+		Validate: validatePartitionCounts(366, 358, 372),
+	},
+	{
+		Name: "detach columnar partition",
+		Tags: []string{TagColumnar},
+		SQL: `
+ALTER TABLE part_range DETACH PARTITION part_range_2020;
+			`,
+	},
+	{
+		Name: "detached columnar partition keeps its rows",
+		Tags: []string{TagColumnar},
+		SQL: `
+SELECT (SELECT count(*) FROM part_range),
+       (SELECT count(*) FROM part_range_2020),
+       (SELECT count(*) FROM pg_class c JOIN pg_am am ON am.oid = c.relam WHERE c.oid = 'part_range_2020'::regclass AND am.amname = 'columnar');
+			`,
+		Validate: validatePartitionCounts(730, 366, 1),
+	},
+	{
+		Name: "attach columnar partitions",
+		Tags: []string{TagColumnar},
+		SQL: `
+CREATE TABLE part_range_2023 (LIKE part_range) USING columnar;
+INSERT INTO part_range_2023
+SELECT d, 1096 + row_number() OVER (ORDER BY d), md5(d::text)
+FROM generate_series(DATE '2023-01-01', DATE '2023-12-31', INTERVAL '1 day') d;
+ALTER TABLE part_range ATTACH PARTITION part_range_2023 FOR VALUES FROM ('2023-01-01') TO ('2024-01-01');
+ALTER TABLE part_range ATTACH PARTITION part_range_2020 FOR VALUES FROM ('2020-01-01') TO ('2021-01-01');
+			`,
+	},
+	{
+		Name: "attached columnar partitions queried",
+		Tags: []string{TagColumnar},
+		SQL: `
+SELECT count(*), count(DISTINCT id), count(*) FILTER (WHERE ts >= DATE '2023-01-01') FROM part_range;
+			`,
+		Validate: validatePartitionCounts(1461, 1461, 365),
+	},
+	{
+		Name: "attached range partition pruned",
+		Tags: []string{TagColumnar},
+		SQL: `
+EXPLAIN (COSTS OFF, FORMAT JSON)
+SELECT * FROM part_range WHERE ts = DATE '2023-06-01';
+			`,
+		Validate: validatePartitionsScanned("part_range_2023"),
+	},
+	{
+		Name: "create list partitioned columnar table",
+		Tags: []string{TagColumnar},
+		SQL: `
+CREATE TABLE part_list (region TEXT, id INT8) PARTITION BY LIST (region);
+CREATE TABLE part_list_americas PARTITION OF part_list FOR VALUES IN ('us', 'ca', 'br') USING columnar;
+CREATE TABLE part_list_europe PARTITION OF part_list FOR VALUES IN ('de', 'fr') USING columnar;
+CREATE TABLE part_list_other PARTITION OF part_list DEFAULT USING heap;
+INSERT INTO part_list
+SELECT (ARRAY['us', 'ca', 'br', 'de', 'fr', 'jp', 'au'])[i % 7 + 1], i FROM generate_series(1, 7000) i;
+			`,
+	},
+	{
+		Name: "rows routed to list partitions",
+		Tags: []string{TagColumnar},
+		SQL: `
+SELECT (SELECT count(*) FROM part_list_americas),
+       (SELECT count(*) FROM part_list_europe),
+       (SELECT count(*) FROM part_list_other);
+			`,
+		Validate: validatePartitionCounts(3000, 2000, 2000),
+	},
+	{
+		Name: "list partitions pruned",
+		Tags: []string{TagColumnar},
+		SQL: `
+EXPLAIN (COSTS OFF, FORMAT JSON)
+SELECT count(*) FROM part_list WHERE region IN ('de', 'fr');
+			`,
+		Validate: validatePartitionsScanned("part_list_europe"),
+	},
+	{
+		Name: "query across columnar and heap list partitions",
+		Tags: []string{TagColumnar},
+		SQL: `
+SELECT count(*), count(DISTINCT region), sum(id) FROM part_list WHERE region IN ('us', 'jp');
+			`,
+		// 7, 14, ..., 7000 of us and 5, 12, ..., 6998 of jp
+		Validate: validatePartitionCounts(2000, 2, 3503500+3501500),
+	},
+	{
+		Name: "create hash partitioned columnar table",
+		Tags: []string{TagColumnar},
+		SQL: `
+CREATE TABLE part_hash (id INT8, val TEXT) PARTITION BY HASH (id);
+CREATE TABLE part_hash_0 PARTITION OF part_hash FOR VALUES WITH (MODULUS 4, REMAINDER 0) USING columnar;
+CREATE TABLE part_hash_1 PARTITION OF part_hash FOR VALUES WITH (MODULUS 4, REMAINDER 1) USING columnar;
+CREATE TABLE part_hash_2 PARTITION OF part_hash FOR VALUES WITH (MODULUS 4, REMAINDER 2) USING columnar;
+CREATE TABLE part_hash_3 PARTITION OF part_hash FOR VALUES WITH (MODULUS 4, REMAINDER 3) USING columnar;
+INSERT INTO part_hash SELECT i, md5(i::text) FROM generate_series(1, 10000) i;
+			`,
+	},
+	{
+		Name: "rows spread across hash partitions",
+		Tags: []string{TagColumnar},
+		SQL: `
+SELECT count(*), count(DISTINCT tableoid), count(*) FILTER (WHERE val = md5(id::text)) FROM part_hash;
+			`,
+		Validate: validatePartitionCounts(10000, 4, 10000),
+	},
+	{
+		Name: "hash partitions pruned",
+		Tags: []string{TagColumnar},
+		SQL: `
+EXPLAIN (COSTS OFF, FORMAT JSON)
+SELECT * FROM part_hash WHERE id = 42;
+			`,
+		Validate: func(t *testing.T, row pgx.Row) {
+			var plan string
+			if err := row.Scan(&plan); err != nil {
+				t.Fatal(err)
+			}
+
+			if scanned := partitionsScanned(plan); len(scanned) != 1 {
+				t.Errorf("a single hash partition should be scanned, got %v", scanned)
+			}
+		},
+	},
+	{
+		Name: "drop partitioned columnar tables",
+		Tags: []string{TagColumnar},
+		SQL: `
+DROP TABLE part_range, part_list, part_hash;
+			`,
+	},
+}
+
+// validatePartitionCounts returns a validation of a row of counts, e.g. of
+// the rows of partitions.
+func validatePartitionCounts(want ...int64) func(t *testing.T, row pgx.Row) {
+	return func(t *testing.T, row pgx.Row) {
+		got := make([]int64, len(want))
+		dest := make([]any, len(want))
+		for i := range got {
+			dest[i] = &got[i]
+		}
+		if err := row.Scan(dest...); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := range want {
+			if want[i] != got[i] {
+				t.Errorf("counts should match: want=%v got=%v", want, got)
+				return
+			}
+		}
+	}
+}
+
+// validatePartitionsScanned returns a validation of a plan in the JSON format
+// of EXPLAIN that scans exactly the partitions.
+func validatePartitionsScanned(partitions ...string) func(t *testing.T, row pgx.Row) {
+	return func(t *testing.T, row pgx.Row) {
+		var plan string
+		if err := row.Scan(&plan); err != nil {
+			t.Fatal(err)
+		}
+
+		want := append([]string(nil), partitions...)
+		sort.Strings(want)
+		if got := partitionsScanned(plan); strings.Join(want, ",") != strings.Join(got, ",") {
+			t.Errorf("partitions scanned should match: want=%v got=%v", want, got)
+		}
+	}
+}
+
+// relationName matches the relations scanned in a plan in the JSON format of
+// EXPLAIN.
+var relationName = regexp.MustCompile(`"Relation Name": "([^"]+)"`)
+
+// partitionsScanned returns the names of the relations scanned by plan, sorted
+// and without duplicates.
+func partitionsScanned(plan string) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, m := range relationName.FindAllStringSubmatch(plan, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
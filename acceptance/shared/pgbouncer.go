@@ -0,0 +1,133 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DefaultPgBouncerImage is the PgBouncer image run by [StartPgBouncer] unless
+// configured otherwise.
+const DefaultPgBouncerImage = "edoburu/pgbouncer:v1.23.1-p2"
+
+// PgBouncerOptions configure the PgBouncer started by [StartPgBouncer].
+type PgBouncerOptions struct {
+	Image           string        // image to run, DefaultPgBouncerImage if empty
+	PoolMode        string        // session, transaction or statement, transaction if empty
+	DefaultPoolSize int           // server connections per user and database, the PgBouncer default of 20 if zero
+	StartTimeout    time.Duration // time to wait for PgBouncer to accept connections, the Startup timeout if zero, see [TimeoutsFor]
+	LogDir          string        // directory to save the PgBouncer logs to if the test fails
+}
+
+// StartPgBouncer runs PgBouncer in a container attached to network, on which
+// the Postgres to pool is reachable as upstream, e.g. the name of the hydra
+// service. It authenticates and connects to the database as described by
+// spec, e.g. [DockerComposeManager.ConnSpec], and returns a pool that connects
+// through it. The container is removed when the test completes.
+//
+// Transaction and statement pooling do not keep the prepared statements of a
+// session, so the pool does not prepare statements in those modes.
+func StartPgBouncer(t *testing.T, ctx context.Context, rt ContainerRuntime, network, upstream string, spec ConnSpec, opts PgBouncerOptions) *pgxpool.Pool {
+	t.Helper()
+
+	if opts.Image == "" {
+		opts.Image = DefaultPgBouncerImage
+	}
+	if opts.PoolMode == "" {
+		opts.PoolMode = "transaction"
+	}
+	if opts.StartTimeout == 0 {
+		opts.StartTimeout = TimeoutsFor(t).Startup
+	}
+
+	database := spec.Database
+	if database == "" {
+		database = spec.Username
+	}
+
+	env := map[string]string{
+		"DB_HOST":     upstream,
+		"DB_PORT":     "5432",
+		"DB_USER":     spec.Username,
+		"DB_PASSWORD": spec.Password,
+		"DB_NAME":     database,
+		"POOL_MODE":   opts.PoolMode,
+		"LISTEN_PORT": "5432",
+		// the password is kept in plain text to authenticate to Postgres with
+		// SCRAM
+		"AUTH_TYPE": "scram-sha-256",
+	}
+	if opts.DefaultPoolSize > 0 {
+		env["DEFAULT_POOL_SIZE"] = strconv.Itoa(opts.DefaultPoolSize)
+	}
+
+	name := UniqueName(t, "pgbouncer")
+	args := append([]string{"run", "--detach", "--name", name, "--network", network, "--publish", "5432"}, labelArgs()...)
+	for k, v := range env {
+		args = append(args, "--env", k+"="+v)
+	}
+
+	if output, err := rt.Command(ctx, append(args, opts.Image)...).CombinedOutput(); err != nil {
+		t.Fatalf("unable to start pgbouncer: %s: %s", err, output)
+	}
+
+	t.Cleanup(func() {
+		// the test context may already be done during cleanup
+		ctx := context.Background()
+		if t.Failed() {
+			if err := savePgBouncerLogs(t, ctx, rt, name, opts.LogDir); err != nil {
+				t.Error(err)
+			}
+		}
+		if output, err := rt.Command(ctx, "rm", "--force", name).CombinedOutput(); err != nil {
+			t.Errorf("unable to remove pgbouncer: %s: %s", err, output)
+		}
+	})
+
+	bouncer := spec.With(
+		WithHost(""),
+		WithDatabase(database),
+		WithRetry(RetryPolicy{Deadline: opts.StartTimeout}),
+	)
+	bouncer.Port = PublishedPort(t, ctx, rt, name, 5432)
+	if opts.PoolMode != "session" {
+		bouncer = bouncer.With(WithParam("default_query_exec_mode", "exec"))
+	}
+
+	pool, err := CreatePGPool(t, ctx, bouncer)
+	if err != nil {
+		t.Fatalf("unable to connect through pgbouncer: %s", err)
+	}
+
+	return pool
+}
+
+// savePgBouncerLogs saves the logs of the pgbouncer container name for t,
+// returning a wrapped ErrSaveLogs if they cannot be saved.
+func savePgBouncerLogs(t *testing.T, ctx context.Context, rt ContainerRuntime, name, logDir string) error {
+	dir := ContainerArtifactDir(t, logDir, "pgbouncer")
+	if dir == "" {
+		return nil
+	}
+
+	ctx, cancel := WithOperation(ctx, "fetch logs of "+name, TimeoutsFor(t).LogFetch)
+	defer cancel()
+
+	output, err := rt.Command(ctx, "logs", name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: unable to fetch pgbouncer logs: %w: %s", ErrSaveLogs, ContextError(ctx, err), output)
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("logs-%s.log", time.Now().Format(time.RFC3339)))
+	if err := os.WriteFile(path, output, 0644); err != nil {
+		return fmt.Errorf("%w: unable to write pgbouncer logs: %w", ErrSaveLogs, err)
+	}
+
+	return nil
+}
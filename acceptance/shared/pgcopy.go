@@ -0,0 +1,75 @@
+package shared
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// CopyFrom bulk loads rows into columns of table, which may be qualified with a
+// schema, with the binary COPY protocol and returns the number of rows copied,
+// e.g. from pgx.CopyFromRows or pgx.CopyFromSlice.
+func CopyFrom(t *testing.T, ctx context.Context, pool *pgxpool.Pool, table string, columns []string, rows pgx.CopyFromSource) int64 {
+	t.Helper()
+
+	n, err := pool.CopyFrom(ctx, pgx.Identifier(strings.Split(table, ".")), columns, rows)
+	if err != nil {
+		t.Fatalf("unable to copy into %s: %s", table, err)
+	}
+
+	return n
+}
+
+// CopyFromText bulk loads r, rows in the text format of COPY, into table with
+// COPY FROM STDIN and returns the number of rows copied and the SHA-256
+// checksum of r. Exporting the same rows with [CopyTo] results in the same
+// checksum.
+func CopyFromText(t *testing.T, ctx context.Context, pool *pgxpool.Pool, table string, r io.Reader) (int64, string) {
+	t.Helper()
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("unable to acquire connection: %s", err)
+	}
+	defer conn.Release()
+
+	h := sha256.New()
+	tag, err := conn.Conn().PgConn().CopyFrom(ctx, io.TeeReader(r, h), "COPY "+qualifiedIdent(table)+" FROM STDIN")
+	if err != nil {
+		t.Fatalf("unable to copy into %s: %s", table, err)
+	}
+
+	return tag.RowsAffected(), hex.EncodeToString(h.Sum(nil))
+}
+
+// CopyTo exports the result of query with COPY TO STDOUT in the text format to
+// w, which may be nil to only compute the checksum, and returns the number of
+// rows copied and the SHA-256 checksum of the output. query must be ordered
+// for the checksum to be stable.
+func CopyTo(t *testing.T, ctx context.Context, pool *pgxpool.Pool, query string, w io.Writer) (int64, string) {
+	t.Helper()
+
+	if w == nil {
+		w = io.Discard
+	}
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("unable to acquire connection: %s", err)
+	}
+	defer conn.Release()
+
+	h := sha256.New()
+	tag, err := conn.Conn().PgConn().CopyTo(ctx, io.MultiWriter(w, h), "COPY ("+query+") TO STDOUT")
+	if err != nil {
+		t.Fatalf("unable to copy %s: %s", query, err)
+	}
+
+	return tag.RowsAffected(), hex.EncodeToString(h.Sum(nil))
+}
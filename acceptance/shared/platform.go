@@ -0,0 +1,43 @@
+package shared
+
+import (
+	"context"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// DaemonPlatform returns the platform of the host the container runtime runs
+// containers on, e.g. linux/arm64, which may differ from the platform of the
+// test binary when the daemon is remote or runs in a VM. If the runtime does
+// not report its platform the platform of the test binary is assumed.
+func DaemonPlatform(t *testing.T, ctx context.Context, rt ContainerRuntime) string {
+	t.Helper()
+
+	output, err := rt.Command(ctx, "version", "--format", "{{.Server.Os}}/{{.Server.Arch}}").Output()
+	if platform := strings.TrimSpace(string(output)); err == nil && platform != "/" {
+		return platform
+	}
+
+	return "linux/" + runtime.GOARCH
+}
+
+// IsEmulated reports whether running images for platform requires emulation on
+// the host of the container runtime. An empty platform is the native one.
+func IsEmulated(t *testing.T, ctx context.Context, rt ContainerRuntime, platform string) bool {
+	t.Helper()
+
+	return platform != "" && platform != DaemonPlatform(t, ctx, rt)
+}
+
+// RequirePlatform skips the test unless the host of the container runtime runs
+// one of platforms natively, for tests that are too slow or unreliable under
+// emulation.
+func RequirePlatform(t *testing.T, ctx context.Context, rt ContainerRuntime, platforms ...string) {
+	t.Helper()
+
+	if platform := DaemonPlatform(t, ctx, rt); !slices.Contains(platforms, platform) {
+		t.Skipf("Skipping test on %s, requires one of %s", platform, strings.Join(platforms, ", "))
+	}
+}
@@ -0,0 +1,60 @@
+package shared
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Seed is the seed of the random data of a test run. Every test derives its
+// own random source from it with [Rand], so that a failure can be reproduced
+// by running again with the same SEED, regardless of which other tests run.
+var Seed int64
+
+// InitSeed sets the [Seed] of the test run to seed, or to a seed taken from the
+// clock if 0, and logs it. It is called from TestMain of each suite.
+func InitSeed(seed int64) int64 {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	Seed = seed
+
+	Logger.Info("random seed, set SEED to reproduce", "seed", seed)
+
+	return seed
+}
+
+// Rand returns a random source for the data generators of t. It is seeded
+// from the [Seed] and the name of the test, and logs the seed if the test
+// fails.
+func Rand(t *testing.T) *rand.Rand {
+	t.Helper()
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(t.Name()))
+
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("random data generated with SEED=%d", Seed)
+		}
+	})
+
+	return rand.New(rand.NewSource(Seed ^ int64(h.Sum64())))
+}
+
+// SeedSession seeds random() of the session of conn from r with setseed, so
+// that randomized SQL, e.g. INSERT ... SELECT random(), is reproducible. The
+// seed only applies to the session, use a dedicated connection rather than a
+// pool.
+func SeedSession(t *testing.T, ctx context.Context, conn *pgx.Conn, r *rand.Rand) {
+	t.Helper()
+
+	// setseed accepts a seed between -1 and 1
+	if _, err := conn.Exec(ctx, "SELECT setseed($1)", r.Float64()*2-1); err != nil {
+		t.Fatalf("unable to seed the session: %s", err)
+	}
+}
@@ -0,0 +1,174 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrNotReady is returned by a [ReadinessProbe] when the service is not ready
+// yet but may become ready later.
+var ErrNotReady = errors.New("not ready")
+
+// A ReadinessProbe checks whether a service is ready to accept connections. It
+// returns nil when ready, a wrapped ErrNotReady while the service is still
+// starting, and any other error if the service will never become ready.
+type ReadinessProbe func(ctx context.Context) error
+
+// HealthCheckProbe returns a [ReadinessProbe] that reports the HEALTHCHECK
+// status of a service in a compose project using rt. Services without a
+// healthcheck are ready once they are running.
+func HealthCheckProbe(rt ContainerRuntime, project, service string) ReadinessProbe {
+	return func(ctx context.Context) error {
+		state, err := rt.State(ctx, project, service)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrNotReady, err)
+		}
+
+		status := state.Health
+		if status == "" {
+			status = state.Status
+		}
+
+		switch status {
+		case "healthy", "running":
+			return nil
+		case "unhealthy", "exited", "dead":
+			return fmt.Errorf("service %s is %s", service, status)
+		default:
+			return fmt.Errorf("%w: service %s is %s", ErrNotReady, service, status)
+		}
+	}
+}
+
+// HTTPProbe returns a [ReadinessProbe] that is ready once a GET request to url
+// responds with 200 OK.
+func HTTPProbe(url string) ReadinessProbe {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrNotReady, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return fmt.Errorf("%w: unable to read readiness body: %w", ErrNotReady, err)
+			}
+
+			return fmt.Errorf("%w: %d: %s", ErrNotReady, resp.StatusCode, body)
+		}
+
+		return nil
+	}
+}
+
+// WaitForReadiness polls probe every interval until it reports ready. The test
+// fails if the probe returns an error other than ErrNotReady or the service is
+// not ready within timeout.
+func WaitForReadiness(t *testing.T, ctx context.Context, probe ReadinessProbe, timeout, interval time.Duration) {
+	t.Helper()
+
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := probe(ctx)
+			if err == nil {
+				return
+			}
+			if !errors.Is(err, ErrNotReady) {
+				t.Fatalf("readiness probe failed: %s", err)
+			}
+
+			t.Logf("waiting for readiness: %s", err)
+		case <-deadline:
+			t.Fatalf("timed out waiting for readiness after %s", timeout)
+		case <-ctx.Done():
+			t.Fatalf("stopped waiting for readiness: %s", ctx.Err())
+		}
+	}
+}
+
+// A ServerStatus is the state of a Postgres server as reported by pg_isready.
+type ServerStatus string
+
+const (
+	ServerNoResponse ServerStatus = "no response"           // the server could not be reached
+	ServerStarting   ServerStatus = "starting up"           // the server is starting or shutting down
+	ServerRejecting  ServerStatus = "rejecting connections" // the server is in recovery and not yet consistent
+	ServerAccepting  ServerStatus = "accepting connections" // the server accepts connections
+)
+
+// PingServer connects once to the server described by spec and reports its
+// status with the semantics of pg_isready: any response other than
+// cannot_connect_now, including a failed authentication, means that the server
+// accepts connections.
+func PingServer(ctx context.Context, spec ConnSpec) (ServerStatus, error) {
+	dsn, err := spec.DSN()
+	if err != nil {
+		return "", err
+	}
+
+	conn, err := pgconn.Connect(ctx, dsn)
+	if err == nil {
+		conn.Close(ctx)
+		return ServerAccepting, nil
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return ServerNoResponse, err
+	}
+
+	// 57P03: cannot_connect_now
+	if pgErr.Code != "57P03" {
+		return ServerAccepting, nil
+	}
+	if strings.Contains(pgErr.Message, "starting up") || strings.Contains(pgErr.Message, "shutting down") {
+		return ServerStarting, err
+	}
+
+	return ServerRejecting, err
+}
+
+// PostgresProbe returns a [ReadinessProbe] that is ready once the server
+// described by spec accepts connections, see [PingServer].
+func PostgresProbe(spec ConnSpec) ReadinessProbe {
+	return func(ctx context.Context) error {
+		status, err := PingServer(ctx, spec)
+		if status == ServerAccepting {
+			return nil
+		}
+		if status == "" {
+			return err
+		}
+
+		return fmt.Errorf("%w: %s: %w", ErrNotReady, status, err)
+	}
+}
+
+// WaitForReady waits up to deadline for the server described by spec to
+// accept connections, e.g. after a restart or a failover, logging whether it
+// is still starting up or rejecting connections during recovery.
+func WaitForReady(t *testing.T, ctx context.Context, spec ConnSpec, deadline time.Duration) {
+	t.Helper()
+
+	WaitForReadiness(t, ctx, PostgresProbe(spec), deadline, 250*time.Millisecond)
+}
@@ -0,0 +1,126 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// A CrashWrite is a write into a columnar table of [RunCrashRecovery], with
+// %[1]s the table, that is still running when the container is killed. None
+// of its rows, all with ids above 1000000, must be present after the restart.
+type CrashWrite struct {
+	Name string
+	SQL  string
+}
+
+// CrashWrites are the writes covered by [RunCrashRecovery]. The insert and
+// the copy write far more rows than they can before the kill, and the
+// transaction writes enough rows to flush stripes before it waits instead of
+// committing.
+var CrashWrites = []CrashWrite{
+	{
+		Name: "insert",
+		SQL:  "INSERT INTO %[1]s SELECT i, md5(i::text) FROM generate_series(1000001, 1000000000) i",
+	},
+	{
+		Name: "copy",
+		SQL:  "COPY %[1]s (id) FROM PROGRAM 'seq 1000001 1000000000'",
+	},
+	{
+		Name: "uncommitted transaction",
+		SQL:  "BEGIN; INSERT INTO %[1]s SELECT i, md5(i::text) FROM generate_series(1000001, 2000000) i; SELECT pg_sleep(3600); COMMIT",
+	},
+}
+
+// crashWriteDelay is how long the writes run before the container is killed,
+// so that they are killed while writing stripes rather than while starting.
+const crashWriteDelay = 3 * time.Second
+
+// RunCrashRecovery starts the container of cm and loads a columnar table for
+// each of writes, then runs the writes while more rows are committed and
+// kills the container. The container is killed and removed, so cm must keep
+// its data directory on an external volume, e.g. with
+// [ContainerOptions.DataVolume]. Once restarted on the same data directory,
+// every table must be readable, with the rows committed before the kill and
+// none of those of its write, and writable, with consistent metadata.
+func RunCrashRecovery(t *testing.T, ctx context.Context, cm DockerComposeManager, writes ...CrashWrite) {
+	t.Helper()
+
+	t.Cleanup(func() {
+		cm.TerminateCompose(t, ctx, true)
+	})
+	cm.StartCompose(t, ctx, cm.Image(), false)
+
+	tables := make([]string, len(writes))
+	var checks []DurabilityCheck
+	for i, w := range writes {
+		tables[i] = pgx.Identifier{"crash_" + strings.ReplaceAll(w.Name, " ", "_")}.Sanitize()
+		if _, err := cm.PGPool().Exec(ctx, fmt.Sprintf(`
+CREATE TABLE %[1]s (id INT8, val TEXT DEFAULT 'copied') USING columnar;
+INSERT INTO %[1]s SELECT i, md5(i::text) FROM generate_series(1, 100000) i;
+			`, tables[i]), pgx.QueryExecModeSimpleProtocol); err != nil {
+			t.Fatalf("unable to create %s: %s", tables[i], err)
+		}
+
+		checks = append(checks,
+			RowCountCheck(tables[i]),
+			ChecksumCheck(tables[i], "id"),
+			DurabilityCheck{
+				Name: "uncommitted rows of " + tables[i],
+				SQL:  fmt.Sprintf("SELECT count(*) FROM %s WHERE id > 1000000", tables[i]),
+			},
+		)
+	}
+
+	running := make([]*RunningQuery, len(writes))
+	for i, w := range writes {
+		running[i] = StartQuery(t, ctx, cm.PGPool(), fmt.Sprintf(w.SQL, tables[i]))
+	}
+
+	// committed while the writes are in flight
+	for _, table := range tables {
+		if _, err := cm.PGPool().Exec(ctx, fmt.Sprintf("INSERT INTO %s SELECT i, md5(i::text) FROM generate_series(100001, 110000) i", table)); err != nil {
+			t.Fatalf("unable to insert into %s: %s", table, err)
+		}
+	}
+
+	time.Sleep(crashWriteDelay)
+	before := runDurabilityChecks(t, ctx, cm.PGPool(), checks)
+
+	cm.TerminateCompose(t, ctx, true)
+	for i, q := range running {
+		if err := q.Wait(t, TimeoutsFor(t).Shutdown); err == nil {
+			t.Fatalf("%s should still be running when the container is killed", writes[i].Name)
+		}
+	}
+
+	cm.StartCompose(t, ctx, cm.Image(), false)
+
+	after := runDurabilityChecks(t, ctx, cm.PGPool(), checks)
+	for i, c := range checks {
+		if want, got := before[i], after[i]; want != got {
+			t.Errorf("%s should be unchanged after crash recovery: want=%s got=%s", c.Name, want, got)
+		}
+	}
+
+	for _, table := range tables {
+		var count int64
+		if err := cm.PGPool().QueryRow(ctx, fmt.Sprintf("SELECT count(*) FROM %s", table)).Scan(&count); err != nil {
+			t.Fatalf("unable to count the rows of %s: %s", table, err)
+		}
+		if count != 110000 {
+			t.Errorf("%s should have the 110000 committed rows after crash recovery, got %d", table, count)
+		}
+
+		if _, err := cm.PGPool().Exec(ctx, fmt.Sprintf("INSERT INTO %s SELECT i, md5(i::text) FROM generate_series(110001, 111000) i", table)); err != nil {
+			t.Errorf("%s should be writable after crash recovery: %s", table, err)
+		}
+	}
+
+	CheckColumnarMetadata(t, ctx, cm.PGPool(), tables...)
+}
@@ -0,0 +1,55 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A CaseFunc runs a case against pool, failing t if the case does not hold.
+type CaseFunc func(t *testing.T, ctx context.Context, pool *pgxpool.Pool)
+
+var (
+	registryMu      sync.Mutex
+	registeredCases []Case
+)
+
+// RegisterCase registers fn as a case named name with tags, e.g. [TagSmoke],
+// for images derived from Hydra to run their own acceptance criteria with
+// [RunAcceptanceTests], together with its filtering, reporting and artifacts.
+// It is meant to be called from an init function of the package of a suite.
+// The registered cases run in order of registration against a pool of their
+// own schema, see [RunCaseGroups]. RegisterCase panics if a case named name is
+// already registered.
+func RegisterCase(name string, tags []string, fn CaseFunc) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if fn == nil {
+		panic(fmt.Sprintf("shared: case %s registered without a function", name))
+	}
+	for _, c := range registeredCases {
+		if c.Name == name {
+			panic(fmt.Sprintf("shared: case %s registered twice", name))
+		}
+	}
+
+	registeredCases = append(registeredCases, Case{
+		Name: name,
+		Tags: slices.Clone(tags),
+		Run:  fn,
+	})
+}
+
+// RegisteredCases returns the cases registered with [RegisterCase] in order of
+// registration.
+func RegisteredCases() []Case {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	return slices.Clone(registeredCases)
+}
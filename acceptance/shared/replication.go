@@ -0,0 +1,157 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SQLStateFeatureNotSupported is the SQLSTATE feature_not_supported, e.g. of
+// writes to columnar tables that are part of a publication.
+const SQLStateFeatureNotSupported = "0A000"
+
+// replicationSchema creates the tables of [RunLogicalReplication], with %[1]s
+// the access method of the table of events: heap on the publisher, whose
+// changes are decoded, and columnar on the subscriber, which applies them.
+// The columnar table is columnar on both.
+const replicationSchema = `
+CREATE EXTENSION IF NOT EXISTS columnar;
+CREATE TABLE replication_events (id INT8 PRIMARY KEY, grp INT4 NOT NULL, val TEXT) USING %[1]s;
+CREATE TABLE replication_columnar (id INT8 PRIMARY KEY, grp INT4 NOT NULL, val TEXT) USING columnar;
+`
+
+// replicationPublication loads the rows of the initial sync of the
+// publisher. Columnar tables cannot be written to once they are part of a
+// publication, so they are loaded before it is created.
+const replicationPublication = `
+INSERT INTO replication_events SELECT i, i % 100, md5(i::text) FROM generate_series(1, 10000) i;
+INSERT INTO replication_columnar SELECT i, i % 100, md5(i::text) FROM generate_series(1, 10000) i;
+CREATE PUBLICATION replication_publication FOR TABLE replication_events, replication_columnar;
+`
+
+// replicationChanges are the changes of the publisher after the initial sync,
+// each in a transaction of its own.
+var replicationChanges = []string{
+	"INSERT INTO replication_events SELECT i, i % 100, md5(i::text) FROM generate_series(10001, 12000) i",
+	"UPDATE replication_events SET val = upper(val) WHERE id % 7 = 0",
+	"DELETE FROM replication_events WHERE id % 10 = 0",
+}
+
+// replicationTables are the tables whose rows must be replicated.
+var replicationTables = []string{"replication_events", "replication_columnar"}
+
+// RunLogicalReplication subscribes a database of the subscriber to a
+// publication of a database of the publisher, which must run with wal_level
+// logical. The subscriber must copy the rows of every table with the initial
+// sync, and then apply the changes of the heap table of the publisher to its
+// columnar table. Columnar tables do not support logical decoding, so writes
+// to the columnar table of the publication must fail instead.
+func RunLogicalReplication(t *testing.T, ctx context.Context, rt ContainerRuntime, publisher, subscriber Server) {
+	t.Helper()
+
+	var walLevel string
+	if err := publisher.Pool.QueryRow(ctx, "SHOW wal_level").Scan(&walLevel); err != nil {
+		t.Fatalf("unable to query wal_level: %s", err)
+	}
+	if walLevel != "logical" {
+		t.Fatalf("publisher should run with wal_level logical, got %s", walLevel)
+	}
+
+	// the subscriber reaches the publisher by its alias on the network
+	const publisherHost = "replication-publisher"
+	connectServers(t, ctx, rt, publisher, subscriber, publisherHost)
+
+	const database = "replication"
+	CreateDatabase(t, ctx, publisher.Pool, database)
+	published := PoolForDatabase(t, ctx, publisher.ConnSpec, database)
+	if _, err := published.Exec(ctx, fmt.Sprintf(replicationSchema, "heap")+replicationPublication, pgx.QueryExecModeSimpleProtocol); err != nil {
+		t.Fatalf("unable to create the published database: %s", err)
+	}
+
+	CreateDatabase(t, ctx, subscriber.Pool, database)
+	subscribed := PoolForDatabase(t, ctx, subscriber.ConnSpec, database)
+	if _, err := subscribed.Exec(ctx, fmt.Sprintf(replicationSchema, "columnar"), pgx.QueryExecModeSimpleProtocol); err != nil {
+		t.Fatalf("unable to create the subscribed database: %s", err)
+	}
+
+	connection := fmt.Sprintf("host=%s port=5432 user=%s password=%s dbname=%s", publisherHost, publisher.ConnSpec.Username, publisher.ConnSpec.Password, database)
+	if _, err := subscribed.Exec(ctx, fmt.Sprintf("CREATE SUBSCRIPTION replication_subscription CONNECTION '%s' PUBLICATION replication_publication", connection)); err != nil {
+		t.Fatalf("unable to subscribe to the publication: %s", err)
+	}
+	t.Cleanup(func() {
+		// the replication slot of the publisher is dropped with the
+		// subscription, before either database can be dropped
+		if _, err := subscribed.Exec(context.Background(), "DROP SUBSCRIPTION IF EXISTS replication_subscription"); err != nil {
+			t.Errorf("unable to drop the subscription: %s", err)
+		}
+	})
+
+	waitForReplication(t, ctx, published, subscribed, replicationTables...)
+
+	for _, sql := range replicationChanges {
+		if _, err := published.Exec(ctx, sql); err != nil {
+			t.Fatalf("unable to change the published rows with %s: %s", sql, err)
+		}
+	}
+
+	for _, write := range []struct {
+		name string
+		fn   func() error
+	}{
+		{"insert", func() error {
+			_, err := published.Exec(ctx, "INSERT INTO replication_columnar VALUES (10001, 1, 'published')")
+			return err
+		}},
+		{"copy", func() error {
+			_, err := published.CopyFrom(ctx, pgx.Identifier{"replication_columnar"}, []string{"id", "grp", "val"}, pgx.CopyFromRows([][]any{{int64(10002), int32(2), "published"}}))
+			return err
+		}},
+	} {
+		err := write.fn()
+		if err == nil {
+			t.Fatalf("%s into a columnar table of a publication should fail, as its changes cannot be decoded", write.name)
+		}
+		AssertSQLState(t, err, SQLStateFeatureNotSupported)
+	}
+
+	waitForReplication(t, ctx, published, subscribed, replicationTables...)
+
+	var accessMethod string
+	if err := subscribed.QueryRow(ctx, "SELECT amname FROM pg_class JOIN pg_am ON pg_am.oid = relam WHERE pg_class.oid = 'replication_events'::regclass").Scan(&accessMethod); err != nil {
+		t.Fatalf("unable to query the access method of the subscribed table: %s", err)
+	}
+	if accessMethod != "columnar" {
+		t.Errorf("subscribed table should be columnar, got %s", accessMethod)
+	}
+}
+
+// waitForReplication waits until the rows of every one of tables of the
+// subscribed database match those of the published database.
+func waitForReplication(t *testing.T, ctx context.Context, published, subscribed *pgxpool.Pool, tables ...string) {
+	t.Helper()
+
+	probe := func(ctx context.Context) error {
+		for _, table := range tables {
+			var wantRows, gotRows int64
+			var want, got string
+			if err := published.QueryRow(ctx, tableChecksum(table)).Scan(&wantRows, &want); err != nil {
+				return fmt.Errorf("unable to query the published rows of %s: %w", table, err)
+			}
+			if err := subscribed.QueryRow(ctx, tableChecksum(table)).Scan(&gotRows, &got); err != nil {
+				return fmt.Errorf("unable to query the subscribed rows of %s: %w", table, err)
+			}
+
+			if want != got {
+				return fmt.Errorf("%w: %s has %d of %d published rows, or different rows", ErrNotReady, table, gotRows, wantRows)
+			}
+		}
+
+		return nil
+	}
+
+	WaitForReadiness(t, ctx, probe, TimeoutsFor(t).Startup, 500*time.Millisecond)
+}
@@ -0,0 +1,82 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A DurabilityCheck is a query whose result must survive a restart, e.g. the
+// row count or checksum of a table. The query must return a single row with a
+// single column.
+type DurabilityCheck struct {
+	Name string
+	SQL  string
+}
+
+// RowCountCheck returns a [DurabilityCheck] of the number of rows in table.
+func RowCountCheck(table string) DurabilityCheck {
+	return DurabilityCheck{
+		Name: "row count of " + table,
+		SQL:  fmt.Sprintf("SELECT count(*) FROM %s", table),
+	}
+}
+
+// ChecksumCheck returns a [DurabilityCheck] of the md5 checksum of all rows of
+// table, ordered by orderBy so that the checksum is stable across scans.
+func ChecksumCheck(table, orderBy string) DurabilityCheck {
+	return DurabilityCheck{
+		Name: "checksum of " + table,
+		SQL:  fmt.Sprintf("SELECT md5(coalesce(string_agg(t::text, ',' ORDER BY %s), '')) FROM %s t", orderBy, table),
+	}
+}
+
+// RestartContainer stops the container name, waiting up to the Shutdown timeout
+// of [TimeoutsFor] for Postgres to shut down, and starts it again with the same data directory.
+// It blocks until pool is able to connect again, failing the test after
+// timeout, and then verifies that every check returns the same result as
+// before the restart.
+func RestartContainer(t *testing.T, ctx context.Context, rt ContainerRuntime, pool *pgxpool.Pool, name string, timeout time.Duration, checks ...DurabilityCheck) {
+	t.Helper()
+
+	before := runDurabilityChecks(t, ctx, pool, checks)
+
+	stopTimeout := strconv.Itoa(int(TimeoutsFor(t).Shutdown.Seconds()))
+	if output, err := rt.Command(ctx, "restart", "--time", stopTimeout, name).CombinedOutput(); err != nil {
+		t.Fatalf("unable to restart %s: %s: %s", name, err, output)
+	}
+
+	// connections of the pool were closed by the restart and are replaced as
+	// they fail
+	WaitForReadiness(t, ctx, func(ctx context.Context) error {
+		if err := pool.Ping(ctx); err != nil {
+			return fmt.Errorf("%w: %w", ErrNotReady, err)
+		}
+
+		return nil
+	}, timeout, time.Second)
+
+	after := runDurabilityChecks(t, ctx, pool, checks)
+	for i, c := range checks {
+		if want, got := before[i], after[i]; want != got {
+			t.Errorf("%s should be unchanged after restart: want=%s got=%s", c.Name, want, got)
+		}
+	}
+}
+
+func runDurabilityChecks(t *testing.T, ctx context.Context, pool *pgxpool.Pool, checks []DurabilityCheck) []string {
+	t.Helper()
+
+	results := make([]string, len(checks))
+	for i, c := range checks {
+		if err := pool.QueryRow(ctx, fmt.Sprintf("SELECT (%s)::text", c.SQL)).Scan(&results[i]); err != nil {
+			t.Fatalf("unable to run durability check %s: %s", c.Name, err)
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,43 @@
+package shared
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Labels applied to the hydra service so that a running project can be found
+// and reused by a later test run with the same configuration.
+const (
+	LabelImage      = "io.hydra.acceptance.image"
+	LabelConfigHash = "io.hydra.acceptance.config-hash"
+)
+
+// ReuseLabels returns the labels identifying a hydra container started from
+// img with the given compose template data. Any change to data results in
+// different labels, so a container is only reused for an identical
+// configuration.
+func ReuseLabels(img string, data any) map[string]string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%#v", data)))
+
+	return map[string]string{
+		LabelImage:      img,
+		LabelConfigHash: hex.EncodeToString(sum[:])[:16],
+	}
+}
+
+// FindReusableProject returns the project of a running hydra container with the
+// given labels, typically from [ReuseLabels], or an empty string if there is
+// none to reuse.
+//
+// Reused containers keep the data of previous runs, so reuse is meant for
+// iterating on new test cases locally rather than for CI.
+func FindReusableProject(ctx context.Context, rt ContainerRuntime, labels map[string]string) (string, error) {
+	project, err := rt.FindProject(ctx, labels)
+	if err != nil {
+		return "", fmt.Errorf("failed to find a reusable project: %w", err)
+	}
+
+	return project, nil
+}
@@ -0,0 +1,153 @@
+package shared
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A Role describes a role created with [CreateRole].
+type Role struct {
+	Name               string
+	Password           string   // allows logging in with the password if included
+	PasswordEncryption string   // how the password is stored, md5 or scram-sha-256, the password_encryption of the server if empty
+	Attributes         []string // role attributes, e.g. CREATEDB or NOINHERIT
+}
+
+// AppRole is the role of the pools returned by [CreateAppPool]. It may log in
+// and create tables in the public schema, but is neither a superuser nor
+// allowed to create databases or roles.
+var AppRole = Role{Name: "hydra_app", Password: "hydra_app"}
+
+// CreateRole creates role using pool, which must be connected as a user allowed
+// to create roles, and drops it when the test completes. Objects owned by the
+// role and its privileges are dropped with it. Connect as the role with
+// [PoolForRole].
+func CreateRole(t *testing.T, ctx context.Context, pool *pgxpool.Pool, role Role) {
+	t.Helper()
+
+	ident := pgx.Identifier{role.Name}.Sanitize()
+
+	sql := "CREATE ROLE " + ident
+	attributes := role.Attributes
+	if role.Password != "" {
+		// CREATE ROLE does not accept parameters, the password is quoted instead
+		attributes = append([]string{"LOGIN", "PASSWORD " + quoteLiteral(role.Password)}, attributes...)
+	}
+	if len(attributes) > 0 {
+		sql += " WITH " + strings.Join(attributes, " ")
+	}
+
+	err := pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		if role.PasswordEncryption != "" {
+			if _, err := tx.Exec(ctx, "SELECT set_config('password_encryption', $1, true)", role.PasswordEncryption); err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.Exec(ctx, sql)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unable to create role %s: %s", role.Name, err)
+	}
+
+	t.Cleanup(func() {
+		// the test context may already be done during cleanup
+		ctx := context.Background()
+		if _, err := pool.Exec(ctx, "SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE usename = $1", role.Name); err != nil {
+			t.Errorf("unable to disconnect role %s: %s", role.Name, err)
+		}
+		if _, err := pool.Exec(ctx, "DROP OWNED BY "+ident); err != nil {
+			t.Errorf("unable to drop objects owned by role %s: %s", role.Name, err)
+		}
+		if _, err := pool.Exec(ctx, "DROP ROLE IF EXISTS "+ident); err != nil {
+			t.Errorf("unable to drop role %s: %s", role.Name, err)
+		}
+	})
+}
+
+// Grant grants privileges, e.g. SELECT, INSERT or ALL, on table, which may be
+// qualified with a schema, to role using pool, which must be connected as a
+// user allowed to grant them.
+func Grant(t *testing.T, ctx context.Context, pool *pgxpool.Pool, table, role string, privileges ...string) {
+	t.Helper()
+
+	sql := "GRANT " + strings.Join(privileges, ", ") + " ON " + qualifiedIdent(table) + " TO " + pgx.Identifier{role}.Sanitize()
+	if _, err := pool.Exec(ctx, sql); err != nil {
+		t.Fatalf("unable to grant %s on %s to %s: %s", strings.Join(privileges, ", "), table, role, err)
+	}
+}
+
+// Revoke revokes privileges on table from role using pool, see [Grant].
+func Revoke(t *testing.T, ctx context.Context, pool *pgxpool.Pool, table, role string, privileges ...string) {
+	t.Helper()
+
+	sql := "REVOKE " + strings.Join(privileges, ", ") + " ON " + qualifiedIdent(table) + " FROM " + pgx.Identifier{role}.Sanitize()
+	if _, err := pool.Exec(ctx, sql); err != nil {
+		t.Fatalf("unable to revoke %s on %s from %s: %s", strings.Join(privileges, ", "), table, role, err)
+	}
+}
+
+// PoolForRole connects as role, which must have a password, as described by
+// spec, e.g. [DockerComposeManager.ConnSpec]. Unless spec names a database the
+// pool connects to the database named after the user of spec rather than the
+// role. The pool is closed when the test completes.
+func PoolForRole(t *testing.T, ctx context.Context, spec ConnSpec, role Role) *pgxpool.Pool {
+	t.Helper()
+
+	if spec.Database == "" {
+		spec.Database = spec.Username
+	}
+
+	pool, err := CreatePGPool(t, ctx, spec.With(WithUser(role.Name, role.Password)))
+	if err != nil {
+		t.Fatalf("unable to connect as role %s: %s", role.Name, err)
+	}
+
+	return pool
+}
+
+// qualifiedIdent quotes name, e.g. of a table qualified with a schema.
+func qualifiedIdent(name string) string {
+	return pgx.Identifier(strings.Split(name, ".")).Sanitize()
+}
+
+// quoteLiteral quotes s as an SQL string literal.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// CreateAppPool creates the [AppRole] using pool, which must be connected as a
+// superuser, unless it exists already, and returns a pool connected as it as
+// described by spec, e.g. [DockerComposeManager.ConnSpec]. Unlike roles of
+// [CreateRole], it is kept for the lifetime of the container so that it
+// survives restarts and upgrades of the data directory.
+func CreateAppPool(t *testing.T, ctx context.Context, pool *pgxpool.Pool, spec ConnSpec) *pgxpool.Pool {
+	t.Helper()
+
+	ident := pgx.Identifier{AppRole.Name}.Sanitize()
+
+	var exists bool
+	if err := pool.QueryRow(ctx, "SELECT EXISTS (SELECT FROM pg_roles WHERE rolname = $1)", AppRole.Name).Scan(&exists); err != nil {
+		t.Fatalf("unable to query role %s: %s", AppRole.Name, err)
+	}
+
+	if !exists {
+		// CREATE ROLE does not accept parameters, the password is quoted instead
+		sql := "CREATE ROLE " + ident + " WITH LOGIN NOSUPERUSER NOCREATEDB NOCREATEROLE PASSWORD " + quoteLiteral(AppRole.Password)
+		if _, err := pool.Exec(ctx, sql); err != nil {
+			t.Fatalf("unable to create role %s: %s", AppRole.Name, err)
+		}
+	}
+
+	// the public schema is not writable by other roles since Postgres 15
+	if _, err := pool.Exec(ctx, "GRANT USAGE, CREATE ON SCHEMA public TO "+ident); err != nil {
+		t.Fatalf("unable to grant role %s access to schema public: %s", AppRole.Name, err)
+	}
+
+	return PoolForRole(t, ctx, spec, AppRole)
+}
@@ -2,10 +2,14 @@ package shared
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"slices"
+	"strings"
 	"testing"
-	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -27,100 +31,470 @@ type DockerComposeManager interface {
 	// Returns the already established pool for the container manager, typically
 	// by calling [CreatePGPool]
 	PGPool() *pgxpool.Pool
+	// Returns the pool connected as the restricted [AppRole] next to PGPool,
+	// typically by calling [CreateAppPool], for cases that must not run as the
+	// superuser.
+	AppPool() *pgxpool.Pool
+	// Returns how to connect to the running Hydra container, for opening pools
+	// besides PGPool, e.g. as another user or to another database.
+	ConnSpec() ConnSpec
+}
+
+// ErrUnknownQueryExecMode is used when the name of a query exec mode does not
+// match any of the modes of pgx.
+var ErrUnknownQueryExecMode = errors.New("unknown query exec mode")
+
+// QueryExecModes are the protocols the shared cases are sent with. Every case
+// list runs once for each mode as a subtest named after it, e.g. to compare the
+// simple and the extended protocol. The cases run once with the defaults of
+// pgx if empty.
+var QueryExecModes []pgx.QueryExecMode
+
+// ParseQueryExecModes parses names of query exec modes as used in connection
+// strings, e.g. simple_protocol or exec, for [QueryExecModes].
+func ParseQueryExecModes(names []string) ([]pgx.QueryExecMode, error) {
+	modes := map[string]pgx.QueryExecMode{
+		"cache_statement": pgx.QueryExecModeCacheStatement,
+		"cache_describe":  pgx.QueryExecModeCacheDescribe,
+		"describe_exec":   pgx.QueryExecModeDescribeExec,
+		"exec":            pgx.QueryExecModeExec,
+		"simple_protocol": pgx.QueryExecModeSimpleProtocol,
+	}
+
+	var parsed []pgx.QueryExecMode
+	for _, name := range names {
+		mode, ok := modes[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownQueryExecMode, name)
+		}
+		parsed = append(parsed, mode)
+	}
+
+	return parsed, nil
 }
 
 // RunAcceptanceTests runs the shared acceptance tests for a given
-// [ContainerManager] as well as any additional cases provided.
+// [ContainerManager] as well as any additional cases provided and the cases of
+// [RegisterCase]. The [AcceptanceCaseGroups], the additional and the
+// registered cases run in parallel, see [RunCaseGroups], with the [Hooks] of
+// cm if it is a [HooksProvider].
 func RunAcceptanceTests(t *testing.T, ctx context.Context, cm DockerComposeManager, additionalCases ...Case) {
-	cm.StartCompose(t, ctx, cm.Image(), true)
+	// registered before starting so that the logs are saved and the containers
+	// removed even if starting fails
 	t.Cleanup(func() {
 		cm.TerminateCompose(t, ctx, true)
 	})
+	groups := AcceptanceCaseGroups()
+	if len(additionalCases) > 0 {
+		groups = append(groups, CaseGroup{Name: "additional", Cases: additionalCases})
+	}
+	if registered := RegisteredCases(); len(registered) > 0 {
+		groups = append(groups, CaseGroup{Name: "registered", Cases: registered})
+	}
+	for _, g := range groups {
+		planCases(t, g.Cases)
+	}
+	cm.StartCompose(t, ctx, cm.Image(), true)
 
-	pool := cm.PGPool()
-	ver := QueryPGVersion(t, ctx, pool)
+	ctx = withManagerHooks(ctx, cm)
+	RunCaseGroups(t, ctx, cm.PGPool(), groups...)
+}
 
-	cases := append(AcceptanceCases(), additionalCases...)
-	for _, c := range cases {
-		c := c
-		t.Run(c.Name, func(t *testing.T) {
-			checkSkipTest(t, c, ver)
+// A CaseGroup is a list of cases that depend on each other, e.g. enabling an
+// extension and then using it, so they run in order. Groups only isolate the
+// tables of their cases from each other, see [RunCaseGroups], so groups
+// changing anything else must be Serial.
+type CaseGroup struct {
+	Name   string
+	Cases  []Case
+	Serial bool // whether the group must run alone, e.g. as its cases restart the container or create extensions or foreign servers
+}
 
-			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-			defer cancel()
+// RunCaseGroups runs groups as subtests against the database of pool. The
+// groups that are not Serial run in parallel, each in its own schema from
+// [PoolForSchema], so that their tables do not collide. A schema does not
+// isolate database-wide objects, e.g. extensions, foreign servers or user
+// mappings, so the Serial groups run afterwards, one at a time, against pool
+// and with the first of the [QueryExecModes] only, as their cases cannot be
+// repeated. The suite [Hooks] of ctx run against pool once, before the first
+// group and after the last, and the case hooks around every case of every
+// group.
+func RunCaseGroups(t *testing.T, ctx context.Context, pool *pgxpool.Pool, groups ...CaseGroup) {
+	runSuiteHooks(t, ctx, pool)
 
-			if val := c.Validate; val == nil {
-				if _, err := pool.Exec(ctx, c.SQL); err != nil {
-					t.Errorf("unable to execute %s: %s", c.SQL, err)
-				}
-			} else {
-				val(t, pool.QueryRow(ctx, c.SQL))
+	scheduleGroups(t, groups, func(t *testing.T, g CaseGroup) {
+		if g.Serial {
+			runCasesOnce(t, ctx, pool, g.Cases)
+			return
+		}
+
+		schemaPool, _ := PoolForSchema(t, ctx, pool)
+		runCases(t, ctx, schemaPool, g.Cases)
+	})
+}
+
+// scheduleGroups runs run for each of groups as a subtest named after it: the
+// groups that are not Serial in parallel, and then the Serial ones one at a
+// time.
+func scheduleGroups(t *testing.T, groups []CaseGroup, run func(t *testing.T, g CaseGroup)) {
+	// returns once every parallel group completed
+	t.Run("parallel", func(t *testing.T) {
+		for _, g := range groups {
+			if g.Serial {
+				continue
 			}
+
+			g := g
+			t.Run(g.Name, func(t *testing.T) {
+				t.Parallel()
+				run(t, g)
+			})
+		}
+	})
+
+	for _, g := range groups {
+		if !g.Serial {
+			continue
+		}
+
+		g := g
+		t.Run(g.Name, func(t *testing.T) {
+			run(t, g)
 		})
 	}
 }
 
 // RunUpgradeTests runs the shared upgrade tests for a given [ContainerManager].
+// The tables of the cases before the upgrade are read after it, so the cases
+// run with the first of the [QueryExecModes] only.
 func RunUpgradeTests(t *testing.T, ctx context.Context, cm DockerComposeManager) {
 	t.Cleanup(func() {
 		cm.TerminateCompose(t, ctx, true)
 	})
 
 	t.Run("Before Upgrade", func(t *testing.T) {
+		planCases(t, BeforeUpgradeCases)
 		cm.StartCompose(t, ctx, cm.UpgradeFromImage(), false)
+		runCasesOnce(t, ctx, cm.PGPool(), BeforeUpgradeCases)
+		cm.TerminateCompose(t, ctx, false)
+	})
 
-		for _, c := range BeforeUpgradeCases {
-			c := c
+	t.Run("After Upgrade", func(t *testing.T) {
+		planCases(t, AfterUpgradeCases)
+		cm.StartCompose(t, ctx, cm.Image(), false)
+		runCasesOnce(t, ctx, cm.PGPool(), AfterUpgradeCases)
+	})
+}
 
-			pool := cm.PGPool()
-			ver := QueryPGVersion(t, ctx, pool)
+// RunPersistenceTests runs the shared persistence tests for a given
+// [ContainerManager]. The container is killed and removed between the two
+// phases, so the manager must keep its data directory on an external volume,
+// e.g. with [ContainerOptions.DataVolume]. As for [RunUpgradeTests], the cases
+// run with the first of the [QueryExecModes] only.
+func RunPersistenceTests(t *testing.T, ctx context.Context, cm DockerComposeManager) {
+	t.Cleanup(func() {
+		cm.TerminateCompose(t, ctx, true)
+	})
 
-			t.Run(c.Name, func(t *testing.T) {
-				checkSkipTest(t, c, ver)
+	t.Run("Before Restart", func(t *testing.T) {
+		planCases(t, BeforeRestartCases)
+		cm.StartCompose(t, ctx, cm.Image(), false)
+		runCasesOnce(t, ctx, cm.PGPool(), BeforeRestartCases)
+		cm.TerminateCompose(t, ctx, true)
+	})
 
-				ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-				defer cancel()
+	t.Run("After Restart", func(t *testing.T) {
+		planCases(t, AfterRestartCases)
+		cm.StartCompose(t, ctx, cm.Image(), false)
+		runCasesOnce(t, ctx, cm.PGPool(), AfterRestartCases)
+	})
+}
 
-				if val := c.Validate; val == nil {
-					if _, err := pool.Exec(ctx, c.SQL); err != nil {
-						t.Errorf("unable to execute %s: %s", c.SQL, err)
-					}
-				} else {
-					val(t, pool.QueryRow(ctx, c.SQL))
-				}
-			})
-		}
+// RunCases runs cases against pool, e.g. a pool connected through
+// [StartPgBouncer] rather than the pool of a [DockerComposeManager], with the
+// [Hooks] of ctx, see [WithHooks].
+func RunCases(t *testing.T, ctx context.Context, pool *pgxpool.Pool, cases ...Case) {
+	runSuiteHooks(t, ctx, pool)
+	runCases(t, ctx, pool, cases)
+}
 
-		cm.TerminateCompose(t, ctx, false)
+// RunPreparedAcceptanceTests is like [RunAcceptanceTests] but runs the cases
+// as prepared statements, see [RunPreparedCases].
+func RunPreparedAcceptanceTests(t *testing.T, ctx context.Context, cm DockerComposeManager, additionalCases ...Case) {
+	t.Cleanup(func() {
+		cm.TerminateCompose(t, ctx, true)
 	})
+	cases := append(AcceptanceCases(), additionalCases...)
+	planCases(t, cases)
+	cm.StartCompose(t, ctx, cm.Image(), true)
 
-	t.Run("After Upgrade", func(t *testing.T) {
-		cm.StartCompose(t, ctx, cm.Image(), false)
+	ctx = withManagerHooks(ctx, cm)
+	runSuiteHooks(t, ctx, cm.PGPool())
+	runCasesWith(t, ctx, cm.PGPool(), cases, runPreparedCase)
+}
+
+// RunPreparedCases runs cases against pool with the extended query protocol
+// by explicitly preparing the SQL of each case and executing the prepared
+// statement, with plan_cache_mode forcing a generic plan as a cached statement
+// is planned after a few executions. Cases of more than one statement cannot
+// be prepared and are run as usual.
+func RunPreparedCases(t *testing.T, ctx context.Context, pool *pgxpool.Pool, cases ...Case) {
+	runSuiteHooks(t, ctx, pool)
+	runCasesWith(t, ctx, pool, cases, runPreparedCase)
+}
+
+// runCases runs each case as a subtest against pool, once for every mode of
+// [QueryExecModes]. The cases create their tables again for every mode, so
+// the modes after the first run in a schema of their own, see [PoolForSchema].
+func runCases(t *testing.T, ctx context.Context, pool *pgxpool.Pool, cases []Case) {
+	if len(QueryExecModes) == 0 {
+		runCasesWith(t, ctx, pool, cases, func(t *testing.T, ctx context.Context, pool *pgxpool.Pool, c Case) {
+			runCase(t, ctx, pool, c)
+		})
+		return
+	}
+
+	for i, mode := range QueryExecModes {
+		i, mode := i, mode
+		t.Run(strings.ReplaceAll(mode.String(), " ", "_"), func(t *testing.T) {
+			modePool := pool
+			if i > 0 {
+				modePool, _ = PoolForSchema(t, ctx, pool)
+			}
+
+			runCasesWith(t, ctx, modePool, cases, func(t *testing.T, ctx context.Context, pool *pgxpool.Pool, c Case) {
+				runCase(t, ctx, pool, c, mode)
+			})
+		})
+	}
+}
 
-		for _, c := range AfterUpgradeCases {
-			c := c
+// runCasesOnce is like runCases but runs the cases with the first mode of
+// [QueryExecModes] only, for cases whose tables must outlive the test, e.g.
+// as they are read again after an upgrade, or that create database-wide
+// objects.
+func runCasesOnce(t *testing.T, ctx context.Context, pool *pgxpool.Pool, cases []Case) {
+	var mode []pgx.QueryExecMode
+	if len(QueryExecModes) > 0 {
+		mode = QueryExecModes[:1]
+	}
 
-			pool := cm.PGPool()
-			ver := QueryPGVersion(t, ctx, pool)
+	runCasesWith(t, ctx, pool, cases, func(t *testing.T, ctx context.Context, pool *pgxpool.Pool, c Case) {
+		runCase(t, ctx, pool, c, mode...)
+	})
+}
 
-			t.Run(c.Name, func(t *testing.T) {
-				checkSkipTest(t, c, ver)
+// runCasesWith runs each case as a subtest against pool with run, and with the
+// case [Hooks] of ctx.
+func runCasesWith(t *testing.T, ctx context.Context, pool *pgxpool.Pool, cases []Case, run func(t *testing.T, ctx context.Context, pool *pgxpool.Pool, c Case)) {
+	ver := QueryPGVersion(t, ctx, pool)
 
-				ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-				defer cancel()
+	for _, c := range cases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			checkSkipTest(t, c, ver)
+			CheckLeaks(t, pool)
 
-				if val := c.Validate; val == nil {
-					if _, err := pool.Exec(ctx, c.SQL); err != nil {
-						t.Errorf("unable to execute %s: %s", c.SQL, err)
-					}
-				} else {
-					val(t, pool.QueryRow(ctx, c.SQL))
+			ctx, cancel := WithOperation(ctx, "case "+t.Name(), TimeoutsFor(t).Query)
+			defer cancel()
+			defer func() {
+				// the errors of pgx only tell that the context was done
+				if t.Failed() && ctx.Err() != nil {
+					t.Logf("case context done: %s", context.Cause(ctx))
 				}
-			})
+			}()
+
+			runCaseHooks(t, ctx, pool, c)
+			if c.Run != nil {
+				c.Run(t, ctx, pool)
+				return
+			}
+			run(t, ctx, pool, c)
+		})
+	}
+}
+
+// runCase runs c against pool, with the protocol of mode if included.
+func runCase(t *testing.T, ctx context.Context, pool *pgxpool.Pool, c Case, mode ...pgx.QueryExecMode) {
+	if len(c.Settings) > 0 {
+		runCaseWithSettings(t, ctx, pool, c, mode...)
+		return
+	}
+
+	if val := c.Validate; val == nil {
+		if err := execCase(ctx, pool, c.SQL, mode...); err != nil {
+			t.Errorf("unable to execute %s: %s", c.SQL, err)
 		}
+	} else {
+		val(t, pool.QueryRow(ctx, c.SQL, modeArgs(mode)...))
+	}
+}
+
+// A querier is a pool, connection or transaction.
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// execCase executes sql with q using the protocol of mode if included. pgx
+// always uses the simple protocol to execute SQL without arguments, so it is
+// sent as a query for the other modes. SQL of more than one statement can only
+// be sent with the simple protocol and falls back to it.
+func execCase(ctx context.Context, q querier, sql string, mode ...pgx.QueryExecMode) error {
+	if len(mode) == 0 || mode[0] == pgx.QueryExecModeSimpleProtocol {
+		_, err := q.Exec(ctx, sql)
+		return err
+	}
+
+	rows, err := q.Query(ctx, sql, mode[0])
+	if err == nil {
+		rows.Close()
+		err = rows.Err()
+	}
+	if isMultiStatementError(err) {
+		_, err = q.Exec(ctx, sql)
+	}
+
+	return err
+}
+
+// isMultiStatementError reports whether err is the error of sending more than
+// one statement with the extended protocol. This is a syntax_error, so actual
+// syntax errors are reported as well, which then also fail with the simple
+// protocol.
+func isMultiStatementError(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "42601"
+}
+
+// modeArgs returns mode as the arguments of a query.
+func modeArgs(mode []pgx.QueryExecMode) []any {
+	var args []any
+	for _, m := range mode {
+		args = append(args, m)
+	}
+
+	return args
+}
+
+// preparedCaseStatement is the name c is prepared as by runPreparedCase.
+const preparedCaseStatement = "acceptance_case"
+
+// runPreparedCase runs c against a connection of pool as a prepared
+// statement. Settings are applied for the session and restored afterwards, as
+// some statements cannot run in a transaction.
+func runPreparedCase(t *testing.T, ctx context.Context, pool *pgxpool.Pool, c Case) {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("unable to acquire connection: %s", err)
+	}
+	defer conn.Release()
+
+	settings := map[string]string{"plan_cache_mode": "force_generic_plan"}
+	for name, value := range c.Settings {
+		settings[name] = value
+	}
+	// the previous values are restored rather than reset, as they may have
+	// been set once connected, e.g. the search_path of PoolForSchema
+	previous := make(map[string]*string, len(settings))
+	defer func() {
+		for name, value := range previous {
+			// the case context may already be done if the case timed out
+			var err error
+			if value == nil {
+				_, err = conn.Exec(context.Background(), "RESET "+qualifiedIdent(name))
+			} else {
+				_, err = conn.Exec(context.Background(), "SELECT set_config($1, $2, false)", name, *value)
+			}
+			if err != nil {
+				t.Errorf("unable to restore %s: %s", name, err)
+			}
+		}
+	}()
+	for name, value := range settings {
+		var current *string
+		if err := conn.QueryRow(ctx, "SELECT current_setting($1, true)", name).Scan(&current); err != nil {
+			t.Fatalf("unable to query %s: %s", name, err)
+		}
+		previous[name] = current
+
+		if _, err := conn.Exec(ctx, "SELECT set_config($1, $2, false)", name, value); err != nil {
+			t.Fatalf("unable to set %s: %s", name, err)
+		}
+	}
+
+	// executing the name of a prepared statement executes the statement
+	sql := preparedCaseStatement
+	_, err = conn.Conn().Prepare(ctx, preparedCaseStatement, c.SQL)
+	switch {
+	case isMultiStatementError(err):
+		sql = c.SQL
+	case err != nil:
+		t.Fatalf("unable to prepare %s: %s", c.SQL, err)
+	default:
+		defer func() {
+			if err := conn.Conn().Deallocate(context.Background(), preparedCaseStatement); err != nil {
+				t.Errorf("unable to deallocate %s: %s", preparedCaseStatement, err)
+			}
+		}()
+	}
+
+	if val := c.Validate; val == nil {
+		if _, err := conn.Exec(ctx, sql); err != nil {
+			t.Errorf("unable to execute %s: %s", c.SQL, err)
+		}
+	} else {
+		val(t, conn.QueryRow(ctx, sql))
+	}
+}
+
+// runCaseWithSettings runs c in a transaction that applies its settings, see
+// inTxWithSettings.
+func runCaseWithSettings(t *testing.T, ctx context.Context, pool *pgxpool.Pool, c Case, mode ...pgx.QueryExecMode) {
+	err := inTxWithSettings(ctx, pool, c.Settings, func(tx pgx.Tx) error {
+		if val := c.Validate; val == nil {
+			if err := execCase(ctx, tx, c.SQL, mode...); err != nil {
+				return fmt.Errorf("unable to execute %s: %w", c.SQL, err)
+			}
+		} else {
+			val(t, tx.QueryRow(ctx, c.SQL, modeArgs(mode)...))
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// inTxWithSettings runs fn in a transaction on pool that applies settings with
+// SET LOCAL, so that they do not leak to other users of the connection. The
+// transaction is committed if fn succeeds and rolled back otherwise.
+func inTxWithSettings(ctx context.Context, pool *pgxpool.Pool, settings map[string]string, fn func(tx pgx.Tx) error) error {
+	return pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		for name, value := range settings {
+			if _, err := tx.Exec(ctx, "SELECT set_config($1, $2, true)", name, value); err != nil {
+				return fmt.Errorf("unable to set %s: %w", name, err)
+			}
+		}
+
+		return fn(tx)
 	})
 }
 
+// withConn runs fn with a connection of pool, e.g. for queryTextRows.
+func withConn(t *testing.T, ctx context.Context, pool *pgxpool.Pool, fn func(conn *pgx.Conn)) {
+	t.Helper()
+
+	if err := pool.AcquireFunc(ctx, func(conn *pgxpool.Conn) error {
+		fn(conn.Conn())
+		return nil
+	}); err != nil {
+		t.Fatalf("unable to acquire connection: %s", err)
+	}
+}
+
 func checkSkipTest(t *testing.T, c Case, ver PGVersion) {
 	if c.Skip {
 		t.Skip("Test skipped")
@@ -129,4 +503,12 @@ func checkSkipTest(t *testing.T, c Case, ver PGVersion) {
 	if len(c.TargetPGVersions) > 0 && !slices.Contains(c.TargetPGVersions, ver) {
 		t.Skip("Skipping test due to unsupported PG version")
 	}
+
+	if reason := SelectedCases.skip(c.Name, ver); reason != "" {
+		t.Skip(reason)
+	}
+
+	if !CaseTagFilter.Match(c.Tags) {
+		t.Skipf("Skipping test with tags %s not selected by %s", strings.Join(c.Tags, ","), CaseTagFilter)
+	}
 }
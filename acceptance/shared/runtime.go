@@ -0,0 +1,221 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// composeProjectLabel is the label compose sets on containers to record the
+// project they belong to.
+const composeProjectLabel = "com.docker.compose.project"
+
+// ErrUnknownContainerRuntime is used when a container runtime name does not
+// match any of the supported runtimes.
+var ErrUnknownContainerRuntime = errors.New("unknown container runtime")
+
+// A ContainerRuntime manages the lifecycle of the Docker Compose projects used
+// during acceptance testing. Projects are identified by name and services by
+// the name used in the compose file.
+type ContainerRuntime interface {
+	// Name returns the name of the runtime, e.g. docker.
+	Name() string
+	// Start starts the project described by composeFile in the background.
+	Start(ctx context.Context, project, composeFile string) error
+	// Stop stops all the containers in the project, waiting up to timeout for
+	// them to exit before they are killed.
+	Stop(ctx context.Context, project string, timeout time.Duration) error
+	// Kill sends SIGKILL to all the containers in the project.
+	Kill(ctx context.Context, project string) error
+	// Remove removes the containers and networks of the project. If
+	// removeVolumes is true the volumes of the project are also removed.
+	Remove(ctx context.Context, project string, removeVolumes bool) error
+	// Logs returns the logs of a service in the project.
+	Logs(ctx context.Context, project, service string) ([]byte, error)
+	// Exec runs cmd in the running container of a service in the project and
+	// returns the combined output.
+	Exec(ctx context.Context, project, service string, cmd ...string) ([]byte, error)
+	// State returns the state of the container of a service in the project,
+	// which may be running or stopped.
+	State(ctx context.Context, project, service string) (ContainerState, error)
+	// ContainerID returns the ID of the container of a service in the project,
+	// which may be running or stopped.
+	ContainerID(ctx context.Context, project, service string) (string, error)
+	// Command returns a command that runs the runtime's Docker compatible CLI
+	// with args, for operations on containers, networks and volumes that are
+	// not part of this interface.
+	Command(ctx context.Context, args ...string) *exec.Cmd
+	// FindProject returns the name of the project of a running container that
+	// has all of the given labels, or an empty string if there is none.
+	FindProject(ctx context.Context, labels map[string]string) (string, error)
+}
+
+// A ContainerState describes the state of a container.
+type ContainerState struct {
+	Status    string // e.g. running or exited
+	Health    string // HEALTHCHECK status, empty if there is no healthcheck
+	ExitCode  int    // exit code of the main process once exited
+	OOMKilled bool   // whether the container was killed for running out of memory
+}
+
+// NewContainerRuntime returns the [ContainerRuntime] with the given name. The
+// supported runtimes are docker, podman, nerdctl and testcontainers. nerdctl
+// runs the projects on containerd, which does not run healthchecks, so its
+// services are reported ready as soon as they are running.
+func NewContainerRuntime(name string) (ContainerRuntime, error) {
+	switch name {
+	case "docker":
+		return cliRuntime{bin: "docker"}, nil
+	case "podman":
+		return cliRuntime{bin: "podman"}, nil
+	case "nerdctl":
+		return cliRuntime{bin: "nerdctl"}, nil
+	case "testcontainers":
+		return newTestcontainersRuntime(), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownContainerRuntime, name)
+	}
+}
+
+// cliRuntime implements ContainerRuntime by shelling out to a Docker compatible
+// CLI that provides a compose subcommand.
+type cliRuntime struct {
+	bin string
+}
+
+func (r cliRuntime) Name() string {
+	return r.bin
+}
+
+func (r cliRuntime) Start(ctx context.Context, project, composeFile string) error {
+	return r.run(ctx, "compose", "--project-name", project, "--file", composeFile, "up", "--detach")
+}
+
+func (r cliRuntime) Stop(ctx context.Context, project string, timeout time.Duration) error {
+	// compose takes whole seconds, and a timeout of 0 kills the containers
+	seconds := int(math.Ceil(timeout.Seconds()))
+	return r.run(ctx, "compose", "--project-name", project, "stop", "--timeout", strconv.Itoa(seconds))
+}
+
+func (r cliRuntime) Remove(ctx context.Context, project string, removeVolumes bool) error {
+	args := []string{"compose", "--project-name", project, "down"}
+	if removeVolumes {
+		args = append(args, "--volumes")
+	}
+
+	return r.run(ctx, args...)
+}
+
+func (r cliRuntime) Kill(ctx context.Context, project string) error {
+	return r.run(ctx, "compose", "--project-name", project, "kill")
+}
+
+func (r cliRuntime) Logs(ctx context.Context, project, service string) ([]byte, error) {
+	return r.output(ctx, "compose", "--project-name", project, "logs", "--no-color", service)
+}
+
+func (r cliRuntime) Exec(ctx context.Context, project, service string, cmd ...string) ([]byte, error) {
+	args := append([]string{"compose", "--project-name", project, "exec", "-T", service}, cmd...)
+	return r.output(ctx, args...)
+}
+
+func (r cliRuntime) State(ctx context.Context, project, service string) (ContainerState, error) {
+	id, err := r.ContainerID(ctx, project, service)
+	if err != nil {
+		return ContainerState{}, err
+	}
+
+	output, err := r.output(ctx, "inspect", "--format", "{{json .State}}", id)
+	if err != nil {
+		return ContainerState{}, err
+	}
+
+	var state struct {
+		Status    string
+		ExitCode  int
+		OOMKilled bool
+		Health    *struct {
+			Status string
+		}
+	}
+	if err := json.Unmarshal(output, &state); err != nil {
+		return ContainerState{}, fmt.Errorf("unable to parse state of %s: %w", service, err)
+	}
+
+	cs := ContainerState{
+		Status:    state.Status,
+		ExitCode:  state.ExitCode,
+		OOMKilled: state.OOMKilled,
+	}
+	if state.Health != nil {
+		cs.Health = state.Health.Status
+	}
+
+	return cs, nil
+}
+
+func (r cliRuntime) ContainerID(ctx context.Context, project, service string) (string, error) {
+	output, err := r.output(ctx, "compose", "--project-name", project, "ps", "--all", "--quiet", service)
+	if err != nil {
+		return "", err
+	}
+
+	id := string(bytes.TrimSpace(output))
+	if id == "" {
+		return "", fmt.Errorf("no container for service %s", service)
+	}
+
+	return id, nil
+}
+
+func (r cliRuntime) Command(ctx context.Context, args ...string) *exec.Cmd {
+	logCommand(r.bin, args)
+	return exec.CommandContext(ctx, r.bin, args...)
+}
+
+func (r cliRuntime) FindProject(ctx context.Context, labels map[string]string) (string, error) {
+	args := []string{"ps", "--quiet"}
+	for k, v := range labels {
+		args = append(args, "--filter", fmt.Sprintf("label=%s=%s", k, v))
+	}
+
+	ids, err := r.output(ctx, args...)
+	if err != nil {
+		return "", err
+	}
+
+	fields := strings.Fields(string(ids))
+	if len(fields) == 0 {
+		return "", nil
+	}
+
+	project, err := r.output(ctx, "inspect", "--format", fmt.Sprintf("{{index .Config.Labels %q}}", composeProjectLabel), fields[0])
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes.TrimSpace(project)), nil
+}
+
+func (r cliRuntime) run(ctx context.Context, args ...string) error {
+	_, err := r.output(ctx, args...)
+	return err
+}
+
+// output runs the CLI with args and returns its combined output. If the
+// command fails the output is included in the returned error.
+func (r cliRuntime) output(ctx context.Context, args ...string) ([]byte, error) {
+	output, err := r.Command(ctx, args...).CombinedOutput()
+	if err != nil {
+		return output, fmt.Errorf("%s %s: %w: %s", r.bin, args[0], err, output)
+	}
+
+	return output, nil
+}
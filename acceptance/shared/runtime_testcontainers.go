@@ -0,0 +1,311 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	osexec "os/exec"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/compose"
+)
+
+// composeServiceLabel is the label compose sets on containers to record the
+// service they run.
+const composeServiceLabel = "com.docker.compose.service"
+
+// testcontainersRuntime implements ContainerRuntime using the compose module of
+// testcontainers-go. It talks to the Docker API directly rather than parsing
+// CLI output, and Start blocks until every service is running and, when a
+// healthcheck is defined, healthy.
+//
+// The containers of a project are looked up by their compose labels, so that
+// projects started by another process, e.g. reused or garbage collected ones,
+// can be managed too. The compose module does not register stacks with the
+// ryuk reaper, so projects must still be stopped explicitly.
+type testcontainersRuntime struct{}
+
+func newTestcontainersRuntime() *testcontainersRuntime {
+	return &testcontainersRuntime{}
+}
+
+func (r *testcontainersRuntime) Name() string {
+	return "testcontainers"
+}
+
+func (r *testcontainersRuntime) Start(ctx context.Context, project, composeFile string) error {
+	Logger.Debug("compose up", "project", project, "file", composeFile)
+	stack, err := compose.NewDockerComposeWith(compose.WithStackFiles(composeFile), compose.StackIdentifier(project))
+	if err != nil {
+		return fmt.Errorf("failed to construct compose stack: %w", err)
+	}
+
+	if err := stack.Up(ctx, compose.Wait(true)); err != nil {
+		return fmt.Errorf("failed to start compose stack: %w", err)
+	}
+
+	return nil
+}
+
+func (r *testcontainersRuntime) Stop(ctx context.Context, project string, timeout time.Duration) error {
+	seconds := int(math.Ceil(timeout.Seconds()))
+
+	return r.eachContainer(ctx, project, func(client *testcontainers.DockerClient, c types.Container) error {
+		if err := client.ContainerStop(ctx, c.ID, container.StopOptions{Timeout: &seconds}); err != nil {
+			return fmt.Errorf("failed to stop %s: %w", c.Labels[composeServiceLabel], err)
+		}
+
+		return nil
+	})
+}
+
+func (r *testcontainersRuntime) Remove(ctx context.Context, project string, removeVolumes bool) error {
+	client, err := testcontainers.NewDockerClientWithOpts(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to construct docker client: %w", err)
+	}
+	defer client.Close()
+
+	containers, err := projectContainers(ctx, client, project, "")
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		if err := client.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true, RemoveVolumes: removeVolumes}); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", c.Labels[composeServiceLabel], err)
+		}
+	}
+
+	networks, err := client.NetworkList(ctx, types.NetworkListOptions{Filters: projectFilter(project)})
+	if err != nil {
+		return fmt.Errorf("failed to list networks: %w", err)
+	}
+	for _, n := range networks {
+		if err := client.NetworkRemove(ctx, n.ID); err != nil {
+			return fmt.Errorf("failed to remove network %s: %w", n.Name, err)
+		}
+	}
+
+	if !removeVolumes {
+		return nil
+	}
+
+	volumes, err := client.VolumeList(ctx, volume.ListOptions{Filters: projectFilter(project)})
+	if err != nil {
+		return fmt.Errorf("failed to list volumes: %w", err)
+	}
+	for _, v := range volumes.Volumes {
+		if err := client.VolumeRemove(ctx, v.Name, true); err != nil {
+			return fmt.Errorf("failed to remove volume %s: %w", v.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *testcontainersRuntime) Kill(ctx context.Context, project string) error {
+	return r.eachContainer(ctx, project, func(client *testcontainers.DockerClient, c types.Container) error {
+		if err := client.ContainerKill(ctx, c.ID, "KILL"); err != nil {
+			return fmt.Errorf("failed to kill %s: %w", c.Labels[composeServiceLabel], err)
+		}
+
+		return nil
+	})
+}
+
+func (r *testcontainersRuntime) Logs(ctx context.Context, project, service string) ([]byte, error) {
+	client, id, err := r.serviceContainer(ctx, project, service)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	logs, err := client.ContainerLogs(ctx, id, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch logs for %s: %w", service, err)
+	}
+	defer logs.Close()
+
+	var out bytes.Buffer
+	if _, err := stdcopy.StdCopy(&out, &out, logs); err != nil {
+		return nil, fmt.Errorf("failed to read logs for %s: %w", service, err)
+	}
+
+	return out.Bytes(), nil
+}
+
+func (r *testcontainersRuntime) Exec(ctx context.Context, project, service string, cmd ...string) ([]byte, error) {
+	client, id, err := r.serviceContainer(ctx, project, service)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	created, err := client.ContainerExecCreate(ctx, id, types.ExecConfig{Cmd: cmd, AttachStdout: true, AttachStderr: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to exec in %s: %w", service, err)
+	}
+
+	attached, err := client.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to exec in %s: %w", service, err)
+	}
+	defer attached.Close()
+
+	var output bytes.Buffer
+	if _, err := stdcopy.StdCopy(&output, &output, attached.Reader); err != nil {
+		return nil, fmt.Errorf("failed to read exec output from %s: %w", service, err)
+	}
+
+	inspect, err := client.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect exec in %s: %w", service, err)
+	}
+
+	if inspect.ExitCode != 0 {
+		return output.Bytes(), fmt.Errorf("exec in %s exited with code %d: %s", service, inspect.ExitCode, output.Bytes())
+	}
+
+	return output.Bytes(), nil
+}
+
+func (r *testcontainersRuntime) State(ctx context.Context, project, service string) (ContainerState, error) {
+	client, id, err := r.serviceContainer(ctx, project, service)
+	if err != nil {
+		return ContainerState{}, err
+	}
+	defer client.Close()
+
+	inspect, err := client.ContainerInspect(ctx, id)
+	if err != nil {
+		return ContainerState{}, fmt.Errorf("failed to inspect %s: %w", service, err)
+	}
+
+	state := inspect.State
+	cs := ContainerState{
+		Status:    state.Status,
+		ExitCode:  state.ExitCode,
+		OOMKilled: state.OOMKilled,
+	}
+	if state.Health != nil {
+		cs.Health = state.Health.Status
+	}
+
+	return cs, nil
+}
+
+func (r *testcontainersRuntime) ContainerID(ctx context.Context, project, service string) (string, error) {
+	client, id, err := r.serviceContainer(ctx, project, service)
+	if err != nil {
+		return "", err
+	}
+	client.Close()
+
+	return id, nil
+}
+
+// Command uses the docker CLI, which talks to the same daemon as
+// testcontainers-go.
+func (r *testcontainersRuntime) Command(ctx context.Context, args ...string) *osexec.Cmd {
+	logCommand("docker", args)
+	return osexec.CommandContext(ctx, "docker", args...)
+}
+
+func (r *testcontainersRuntime) FindProject(ctx context.Context, labels map[string]string) (string, error) {
+	client, err := testcontainers.NewDockerClientWithOpts(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to construct docker client: %w", err)
+	}
+	defer client.Close()
+
+	args := filters.NewArgs()
+	for k, v := range labels {
+		args.Add("label", fmt.Sprintf("%s=%s", k, v))
+	}
+
+	containers, err := client.ContainerList(ctx, types.ContainerListOptions{Filters: args})
+	if err != nil {
+		return "", fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	if len(containers) == 0 {
+		return "", nil
+	}
+
+	return containers[0].Labels[composeProjectLabel], nil
+}
+
+// projectFilter returns the filter of the resources of the compose project.
+func projectFilter(project string) filters.Args {
+	return filters.NewArgs(filters.Arg("label", composeProjectLabel+"="+project))
+}
+
+// projectContainers returns the containers of the compose project, running or
+// not, only those of service unless it is empty.
+func projectContainers(ctx context.Context, client *testcontainers.DockerClient, project, service string) ([]types.Container, error) {
+	args := projectFilter(project)
+	if service != "" {
+		args.Add("label", composeServiceLabel+"="+service)
+	}
+
+	containers, err := client.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: args})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	return containers, nil
+}
+
+// eachContainer calls fn for each container of the compose project, which
+// must have at least one.
+func (r *testcontainersRuntime) eachContainer(ctx context.Context, project string, fn func(client *testcontainers.DockerClient, c types.Container) error) error {
+	client, err := testcontainers.NewDockerClientWithOpts(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to construct docker client: %w", err)
+	}
+	defer client.Close()
+
+	containers, err := projectContainers(ctx, client, project, "")
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("compose project %s has no containers", project)
+	}
+
+	for _, c := range containers {
+		if err := fn(client, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// serviceContainer returns the ID of the container of service of the compose
+// project, and the client to manage it, which the caller must close.
+func (r *testcontainersRuntime) serviceContainer(ctx context.Context, project, service string) (*testcontainers.DockerClient, string, error) {
+	client, err := testcontainers.NewDockerClientWithOpts(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to construct docker client: %w", err)
+	}
+
+	containers, err := projectContainers(ctx, client, project, service)
+	if err != nil {
+		client.Close()
+		return nil, "", err
+	}
+	if len(containers) == 0 {
+		client.Close()
+		return nil, "", fmt.Errorf("failed to find container for %s of compose project %s", service, project)
+	}
+
+	return client, containers[0].ID, nil
+}
@@ -0,0 +1,97 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// CreateSchema creates the schema name using pool and drops it with everything
+// in it when the test completes.
+func CreateSchema(t *testing.T, ctx context.Context, pool *pgxpool.Pool, name string) {
+	t.Helper()
+
+	ident := pgx.Identifier{name}.Sanitize()
+	if _, err := pool.Exec(ctx, "CREATE SCHEMA "+ident); err != nil {
+		t.Fatalf("unable to create schema %s: %s", name, err)
+	}
+
+	t.Cleanup(func() {
+		// the test context may already be done during cleanup
+		if _, err := pool.Exec(context.Background(), "DROP SCHEMA IF EXISTS "+ident+" CASCADE"); err != nil {
+			t.Errorf("unable to drop schema %s: %s", name, err)
+		}
+	})
+}
+
+// uniqueIdent returns UniqueName(t, prefix) with underscores rather than
+// dashes, a lower case identifier that needs no quoting. With a single
+// character prefix it is at most 63 bytes, the limit of identifiers, so that
+// it is not truncated in statements.
+func uniqueIdent(t *testing.T, prefix string) string {
+	t.Helper()
+
+	return strings.ReplaceAll(UniqueName(t, prefix), "-", "_")
+}
+
+// uniqueTable creates a table named after the test with the statements of
+// ddl, a format with %[1]s the table, e.g. to create and load it, and returns
+// the name of the table, which needs no quoting, see uniqueIdent. The
+// statements run with settings applied to their transaction, see
+// execWithSettings. The table is dropped when the test completes.
+func uniqueTable(t *testing.T, ctx context.Context, pool *pgxpool.Pool, prefix, ddl string, settings map[string]string) string {
+	t.Helper()
+
+	table := uniqueIdent(t, prefix)
+	if err := execWithSettings(ctx, pool, fmt.Sprintf(ddl, table), settings); err != nil {
+		t.Fatalf("unable to create %s: %s", table, err)
+	}
+
+	t.Cleanup(func() {
+		// the test context may already be done during cleanup
+		if _, err := pool.Exec(context.Background(), "DROP TABLE IF EXISTS "+table); err != nil {
+			t.Errorf("unable to drop %s: %s", table, err)
+		}
+	})
+
+	return table
+}
+
+// PoolForSchema creates a schema named after the test using pool and returns
+// it together with a pool connected like pool whose search_path starts with
+// the schema, followed by the search_path the connections of pool start with.
+// The search_path is set once connected rather than as a startup parameter,
+// which e.g. PgBouncer rejects. Unqualified tables are created in the schema,
+// so tests using cases with the same table names can run in parallel against
+// one container. The schema is dropped and the pool closed when the test
+// completes.
+func PoolForSchema(t *testing.T, ctx context.Context, pool *pgxpool.Pool) (*pgxpool.Pool, string) {
+	t.Helper()
+
+	name := uniqueIdent(t, "t")
+	CreateSchema(t, ctx, pool, name)
+
+	config := pool.Config()
+	afterConnect := config.AfterConnect
+	config.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		if afterConnect != nil {
+			if err := afterConnect(ctx, conn); err != nil {
+				return err
+			}
+		}
+
+		_, err := conn.Exec(ctx, "SELECT set_config('search_path', $1 || ', ' || current_setting('search_path'), false)", pgx.Identifier{name}.Sanitize())
+		return err
+	}
+
+	schemaPool, err := createPGPool(t, ctx, config, RetryPolicy{Attempts: 1})
+	if err != nil {
+		t.Fatalf("unable to connect with schema %s: %s", name, err)
+	}
+
+	return schemaPool, name
+}
@@ -0,0 +1,78 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidCaseSelection is used when an entry of a case selection is empty.
+var ErrInvalidCaseSelection = errors.New("invalid case selection")
+
+// A CaseSelection selects shared cases by name, e.g. to quarantine a case
+// that is known to be broken without editing its definition. Each entry is
+// the Name of a case, optionally followed by @ and a major version of Postgres
+// to only apply to that version, e.g. "partition@16".
+type CaseSelection struct {
+	Only []string // cases to run, every case if empty
+	Skip []string // cases to skip
+}
+
+// SelectedCases selects the shared cases that are run, together with the
+// [CaseTagFilter]. The zero value runs every case.
+var SelectedCases CaseSelection
+
+// ParseCaseSelection parses the entries of the Only and Skip lists of a
+// [CaseSelection], as set by ONLY_CASES and SKIP_CASES.
+func ParseCaseSelection(only, skip []string) (CaseSelection, error) {
+	var err error
+	var s CaseSelection
+	if s.Only, err = parseCaseEntries(only); err != nil {
+		return CaseSelection{}, err
+	}
+	if s.Skip, err = parseCaseEntries(skip); err != nil {
+		return CaseSelection{}, err
+	}
+
+	return s, nil
+}
+
+func parseCaseEntries(entries []string) ([]string, error) {
+	var parsed []string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		name, version, hasVersion := strings.Cut(entry, "@")
+		if name == "" || hasVersion && version == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCaseSelection, entry)
+		}
+		parsed = append(parsed, entry)
+	}
+
+	return parsed, nil
+}
+
+// skip returns why the case name is not selected on ver, or an empty string
+// if it is.
+func (s CaseSelection) skip(name string, ver PGVersion) string {
+	if matchCaseEntries(s.Skip, name, ver) {
+		return "Skipping test listed in SKIP_CASES"
+	}
+	if len(s.Only) > 0 && !matchCaseEntries(s.Only, name, ver) {
+		return "Skipping test not listed in ONLY_CASES"
+	}
+
+	return ""
+}
+
+// matchCaseEntries reports whether any of entries selects the case name on
+// ver.
+func matchCaseEntries(entries []string, name string, ver PGVersion) bool {
+	for _, entry := range entries {
+		entryName, version, ok := strings.Cut(entry, "@")
+		if entryName == name && (!ok || PGVersion(version) == ver) {
+			return true
+		}
+	}
+
+	return false
+}
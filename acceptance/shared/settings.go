@@ -0,0 +1,312 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A ColumnarSetting is a documented change of behavior of columnar tables
+// when a setting of the columnar extension is flipped. The query, with %[1]s
+// the table of the [ColumnarSettingCases], runs with the setting On and Off,
+// together with the common Settings, and must then pass the checks of its
+// plan, by EXPLAIN ANALYZE if Analyze is set, and return the rows, if any.
+type ColumnarSetting struct {
+	Name     string
+	Setting  string            // e.g. columnar.enable_custom_scan
+	On, Off  string            // values of the setting, e.g. on and off
+	Settings map[string]string // settings of the query in both cases, e.g. enable_seqscan
+	Query    string
+	Analyze  bool
+
+	WhenOn, WhenOff []PlanCheck
+	RowsOn, RowsOff []string // rows of the query in the text format of queryTextRows, unchecked if nil
+
+	TargetPGVersions []PGVersion // versions that support the setting, all if empty, see Case
+}
+
+// Case returns s as a [Case] that runs it with [RunColumnarSetting].
+func (s ColumnarSetting) Case() Case {
+	return Case{
+		Name:             "columnar setting " + s.Name,
+		Tags:             []string{TagColumnar},
+		TargetPGVersions: s.TargetPGVersions,
+		Run: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+			RunColumnarSetting(t, ctx, pool, s)
+		},
+	}
+}
+
+// columnarSettingTable is the columnar table of the [ColumnarSettingCases],
+// with chunk groups and stripes of 1000 rows, sorted ids, uncorrelated
+// groups and the last 100 rows deleted.
+const columnarSettingTable = "setting_columnar"
+
+// ColumnarSettings are the settings covered by the [ColumnarSettingCases].
+// Without the custom scan of columnar tables, neither qual pushdown, its
+// correlation threshold, vectorization nor parallel execution take effect.
+// The column cache only changes where the chunks are read from, so that its
+// cases check that the rows are the same. columnar.max_custom_scan_paths and
+// columnar.planner_debug_level are left out: the first only limits the
+// parameterized paths considered for joins, which does not show in the plans
+// that win, and the second only changes the level of the debug messages of
+// the planner.
+var ColumnarSettings = []ColumnarSetting{
+	{
+		Name:    "custom scan",
+		Setting: "columnar.enable_custom_scan",
+		On:      "on",
+		Off:     "off",
+		Query:   "SELECT count(*) FROM %[1]s WHERE id < 500",
+		WhenOn:  []PlanCheck{UsesColumnarScan(columnarSettingTable), NoSeqScan()},
+		WhenOff: []PlanCheck{NoNode("ColumnarScan"), UsesNode("Seq Scan")},
+		RowsOn:  []string{"499"},
+		RowsOff: []string{"499"},
+	},
+	{
+		Name:     "qual pushdown",
+		Setting:  "columnar.enable_qual_pushdown",
+		On:       "on",
+		Off:      "off",
+		Settings: map[string]string{"columnar.enable_vectorization": "off"},
+		Query:    "SELECT count(*) FROM %[1]s WHERE id < 500",
+		Analyze:  true,
+		WhenOn:   []PlanCheck{HasProperty("Columnar Chunk Group Filters"), ChunkGroupsRemovedAtLeast(18)},
+		WhenOff:  []PlanCheck{UsesColumnarScan(columnarSettingTable), NoProperty("Columnar Chunk Group Filters")},
+		RowsOn:   []string{"499"},
+		RowsOff:  []string{"499"},
+	},
+	{
+		// the groups are uncorrelated with the order of the rows, whose
+		// correlation is about 0, so that their quals are only pushed down
+		// with a threshold of 0
+		Name:     "qual pushdown correlation threshold",
+		Setting:  "columnar.qual_pushdown_correlation_threshold",
+		On:       "0",
+		Off:      "0.9",
+		Settings: map[string]string{"columnar.enable_vectorization": "off"},
+		Query:    "SELECT count(*) FROM %[1]s WHERE grp = 7",
+		WhenOn:   []PlanCheck{HasProperty("Columnar Chunk Group Filters")},
+		WhenOff:  []PlanCheck{UsesColumnarScan(columnarSettingTable), NoProperty("Columnar Chunk Group Filters")},
+		RowsOn:   []string{"199"},
+		RowsOff:  []string{"199"},
+	},
+	{
+		Name:     "vectorized filter",
+		Setting:  "columnar.enable_vectorization",
+		On:       "on",
+		Off:      "off",
+		Settings: map[string]string{"columnar.enable_parallel_execution": "off"},
+		Query:    "SELECT id FROM %[1]s WHERE grp < 2 AND id < 1000",
+		WhenOn:   []PlanCheck{HasProperty("Columnar Vectorized Filter")},
+		WhenOff:  []PlanCheck{UsesColumnarScan(columnarSettingTable), NoProperty("Columnar Vectorized Filter")},
+	},
+	{
+		Name:             "vectorized aggregate",
+		Setting:          "columnar.enable_vectorization",
+		On:               "on",
+		Off:              "off",
+		Settings:         map[string]string{"columnar.enable_parallel_execution": "off"},
+		Query:            "SELECT count(*), sum(grp) FROM %[1]s",
+		WhenOn:           []PlanCheck{UsesNode("VectorAggNode")},
+		WhenOff:          []PlanCheck{NoNode("VectorAggNode"), UsesNode("Aggregate")},
+		RowsOn:           []string{"19900\t985050"},
+		RowsOff:          []string{"19900\t985050"},
+		TargetPGVersions: []PGVersion{PGVersion14, PGVersion15, PGVersion16},
+	},
+	{
+		Name:    "parallel execution",
+		Setting: "columnar.enable_parallel_execution",
+		On:      "on",
+		Off:     "off",
+		Settings: map[string]string{
+			"max_parallel_workers_per_gather": "4",
+			"parallel_setup_cost":             "0",
+			"parallel_tuple_cost":             "0",
+			"min_parallel_table_scan_size":    "0",
+		},
+		Query:   "SELECT count(*) FROM %[1]s",
+		WhenOn:  []PlanCheck{UsesNode("Gather"), UsesColumnarScan(columnarSettingTable)},
+		WhenOff: []PlanCheck{NoNode("Gather"), UsesColumnarScan(columnarSettingTable)},
+		RowsOn:  []string{"19900"},
+		RowsOff: []string{"19900"},
+	},
+	{
+		// with the leader participating, a single process leaves no worker
+		Name:    "min parallel processes",
+		Setting: "columnar.min_parallel_processes",
+		On:      "4",
+		Off:     "1",
+		Settings: map[string]string{
+			"max_parallel_workers_per_gather": "4",
+			"parallel_setup_cost":             "0",
+			"parallel_tuple_cost":             "0",
+			"min_parallel_table_scan_size":    "0",
+		},
+		Query:   "SELECT count(*) FROM %[1]s",
+		WhenOn:  []PlanCheck{UsesNode("Gather"), UsesColumnarScan(columnarSettingTable)},
+		WhenOff: []PlanCheck{NoNode("Gather"), UsesColumnarScan(columnarSettingTable)},
+		RowsOn:  []string{"19900"},
+		RowsOff: []string{"19900"},
+	},
+	{
+		Name:    "column cache",
+		Setting: "columnar.enable_column_cache",
+		On:      "on",
+		Off:     "off",
+		Query:   "SELECT count(*), sum(grp) FROM %[1]s WHERE id < 5000",
+		RowsOn:  []string{"4999\t247500"},
+		RowsOff: []string{"4999\t247500"},
+	},
+	{
+		// the smallest cache evicts the chunks read before
+		Name:     "column cache size",
+		Setting:  "columnar.column_cache_size",
+		On:       "20MB",
+		Off:      "200MB",
+		Settings: map[string]string{"columnar.enable_column_cache": "on"},
+		Query:    "SELECT count(*), sum(grp), count(DISTINCT val) FROM %[1]s",
+		RowsOn:   []string{"19900\t985050\t19900"},
+		RowsOff:  []string{"19900\t985050\t19900"},
+	},
+	{
+		Name:    "columnar index scan",
+		Setting: "columnar.enable_columnar_index_scan",
+		On:      "on",
+		Off:     "off",
+		Settings: map[string]string{
+			"enable_seqscan":              "off",
+			"enable_bitmapscan":           "off",
+			"columnar.enable_custom_scan": "off",
+		},
+		Query:            "SELECT id, grp FROM %[1]s WHERE id = 4242",
+		WhenOn:           []PlanCheck{UsesNode("ColumnarIndexScan")},
+		WhenOff:          []PlanCheck{NoNode("ColumnarIndexScan"), UsesNode("Index Scan")},
+		RowsOn:           []string{"4242\t42"},
+		RowsOff:          []string{"4242\t42"},
+		TargetPGVersions: []PGVersion{PGVersion14, PGVersion15, PGVersion16},
+	},
+	{
+		// the deleted rows are only masked by the row masks read with DML
+		// enabled
+		Name:    "dml",
+		Setting: "columnar.enable_dml",
+		On:      "on",
+		Off:     "off",
+		Query:   "SELECT count(*), max(id) FROM %[1]s",
+		RowsOn:  []string{"19900\t19900"},
+		RowsOff: []string{"20000\t20000"},
+	},
+}
+
+// ColumnarSettingCases returns a case that creates the table of the
+// [ColumnarSettings], followed by a case for each of settings, and a case
+// verifying that the storage settings, e.g. columnar.compression, are the
+// options of the tables created with them.
+func ColumnarSettingCases(settings ...ColumnarSetting) []Case {
+	cases := []Case{
+		{
+			Name: "create columnar table for settings",
+			Tags: []string{TagColumnar},
+			SQL: fmt.Sprintf(`
+CREATE TABLE %[1]s (id INT8, grp INT4, val TEXT) USING columnar;
+INSERT INTO %[1]s SELECT i, i %% 100, md5(i::text) FROM generate_series(1, 20000) i;
+CREATE INDEX %[1]s_id ON %[1]s (id);
+DELETE FROM %[1]s WHERE id > 19900;
+ANALYZE %[1]s;
+				`, columnarSettingTable),
+			Settings: map[string]string{
+				"columnar.stripe_row_limit":      "1000",
+				"columnar.chunk_group_row_limit": "1000",
+			},
+		},
+	}
+
+	cases = append(cases, casesOf(settings...)...)
+
+	cases = append(cases, Case{
+		Name: "columnar setting table options",
+		Tags: []string{TagColumnar},
+		Run:  checkOptionSettings,
+	})
+
+	return cases
+}
+
+// RunColumnarSetting runs the query of s with its setting on and off.
+func RunColumnarSetting(t *testing.T, ctx context.Context, pool *pgxpool.Pool, s ColumnarSetting) {
+	t.Helper()
+
+	sql := fmt.Sprintf(s.Query, pgx.Identifier{columnarSettingTable}.Sanitize())
+	options := "COSTS OFF"
+	if s.Analyze {
+		options = "ANALYZE, COSTS OFF, TIMING OFF"
+	}
+
+	for _, flip := range []struct {
+		value  string
+		checks []PlanCheck
+		rows   []string
+	}{
+		{s.On, s.WhenOn, s.RowsOn},
+		{s.Off, s.WhenOff, s.RowsOff},
+	} {
+		settings := map[string]string{s.Setting: flip.value}
+		for name, value := range s.Settings {
+			settings[name] = value
+		}
+
+		queryWithSettings(t, ctx, pool, settings, func(conn *pgx.Conn) {
+			if len(flip.checks) > 0 {
+				plan := Explain(t, ctx, conn, options, sql)
+				for _, check := range flip.checks {
+					if err := check(plan); err != nil {
+						t.Errorf("plan of %s with %s = %s should pass the check: %s: %s", sql, s.Setting, flip.value, err, plan.text)
+					}
+				}
+			}
+
+			if flip.rows != nil {
+				if diff := diffRows(flip.rows, queryTextRows(t, ctx, conn, sql)); diff != "" {
+					t.Errorf("%s with %s = %s should return the rows: %s", sql, s.Setting, flip.value, diff)
+				}
+			}
+		})
+	}
+}
+
+// checkOptionSettings creates columnar tables with and without the storage
+// settings and verifies that they are the options of the table created with
+// them, rather than the defaults.
+func checkOptionSettings(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+	t.Helper()
+
+	const options = "SELECT concat_ws(',', compression, compression_level, stripe_row_limit, chunk_group_row_limit) FROM columnar.options WHERE regclass = $1::text::regclass"
+
+	var defaults string
+	table := uniqueTable(t, ctx, pool, "s", "CREATE TABLE %s (id INT8) USING columnar", nil)
+	if err := pool.QueryRow(ctx, options, table).Scan(&defaults); err != nil {
+		t.Fatalf("unable to query the options of %s: %s", table, err)
+	}
+
+	set := uniqueTable(t, ctx, pool, "o", "CREATE TABLE %s (id INT8) USING columnar", map[string]string{
+		"columnar.compression":           "pglz",
+		"columnar.compression_level":     "7",
+		"columnar.stripe_row_limit":      "12345",
+		"columnar.chunk_group_row_limit": "2345",
+	})
+
+	var got string
+	if err := pool.QueryRow(ctx, options, set).Scan(&got); err != nil {
+		t.Fatalf("unable to query the options of %s: %s", set, err)
+	}
+	if want := "pglz,7,12345,2345"; want != got {
+		t.Errorf("options of %s should match the settings it was created with: want=%s got=%s", set, want, got)
+	}
+	if got == defaults {
+		t.Errorf("options of %s should differ from the defaults %s", set, defaults)
+	}
+}
@@ -6,44 +6,88 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// ErrPgPoolConnect is used when pgxpool cannot connect to a database.
-var ErrPgPoolConnect = errors.New("pgxpool did not connect")
+var (
+	// ErrPgPoolConnect is used when pgxpool cannot connect to a database.
+	ErrPgPoolConnect = errors.New("pgxpool did not connect")
+	// ErrPgConnConnect is used when pgx cannot connect to a database.
+	ErrPgConnConnect = errors.New("pgx did not connect")
+)
+
+// CreatePGPool calls pgxpool.New for spec and then sends a Ping to the
+// database to ensure it is running. If the ping fails it retries according to
+// the [RetryPolicy] of spec, and returns the error of the last attempt, a
+// wrapped ErrPgPoolConnect, once the attempts or the deadline are exhausted.
+// Specs of [NewConnSpec] make a single attempt, whereas the zero policy of a
+// ConnSpec literal retries until ctx is done. The pool is closed when the test
+// completes.
+func CreatePGPool(t *testing.T, ctx context.Context, spec ConnSpec) (*pgxpool.Pool, error) {
+	t.Helper()
+
+	dsn, err := spec.DSN()
+	if err != nil {
+		return nil, err
+	}
 
-// MustHaveValidArtifactDir ensures that if a artifact directory is
-// present it is has an absolute path as go tests cannot determine the directory
-// that they are running from.
-func MustHaveValidArtifactDir(dir string) {
-	if dir != "" && !filepath.IsAbs(dir) {
-		log.Fatalf("the artifact dir must be absolute, got %s", dir)
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse connection string: %w", err)
 	}
+	config.ConnConfig.Tracer = sqlTracer{}
+	spec.Pool.apply(config)
+
+	return createPGPool(t, ctx, config, spec.Retry)
 }
 
-// CreatePGPool calls pgxpool.New and then sends a Ping to the database to
-// ensure it is running. If the ping fails it returns a wrapped
-// ErrPgPoolConnect.
-func CreatePGPool(t *testing.T, ctx context.Context, username, password string, port int) (*pgxpool.Pool, error) {
-	t.Helper()
+// A RetryPolicy configures how [CreatePGPool] retries connecting, e.g. while a
+// cold-started container is initializing. The delay between attempts starts
+// at InitialBackoff and doubles after every attempt up to MaxBackoff. Zero
+// fields take their value from [DefaultRetryPolicy].
+type RetryPolicy struct {
+	Attempts       int           // maximum number of attempts, unlimited if zero
+	AttemptTimeout time.Duration // time an attempt may take, the Connect timeout of the test if zero, see [TimeoutsFor]
+	Deadline       time.Duration // time to keep retrying for, only bounded by the context if zero
+	InitialBackoff time.Duration // delay before the first retry
+	MaxBackoff     time.Duration // maximum delay between retries
+}
 
-	ctx, cancel := context.WithTimeout(ctx, time.Second)
-	defer cancel()
+// DefaultRetryPolicy provides the defaults of a [RetryPolicy].
+var DefaultRetryPolicy = RetryPolicy{
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+}
 
-	pool, err := pgxpool.New(ctx, fmt.Sprintf("postgres://%s:%s@127.0.0.1:%d", username, password, port))
-	if err != nil {
-		return nil, fmt.Errorf("failed to construct new pool: %w", err)
+func (p RetryPolicy) withDefaults(t *testing.T) RetryPolicy {
+	if p.AttemptTimeout == 0 {
+		p.AttemptTimeout = TimeoutsFor(t).Connect
+	}
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = DefaultRetryPolicy.MaxBackoff
 	}
 
-	if err := pool.Ping(ctx); err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrPgPoolConnect, err)
+	return p
+}
+
+// createPGPool connects with config, retrying according to policy.
+func createPGPool(t *testing.T, ctx context.Context, config *pgxpool.Config, policy RetryPolicy) (*pgxpool.Pool, error) {
+	t.Helper()
+
+	policy = policy.withDefaults(t)
+	pool, err := retryConnect(ctx, policy, ErrPgPoolConnect, func(ctx context.Context) (*pgxpool.Pool, error) {
+		return connectPGPool(ctx, config, policy.AttemptTimeout)
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	t.Cleanup(func() {
@@ -53,49 +97,151 @@ func CreatePGPool(t *testing.T, ctx context.Context, username, password string,
 	return pool, nil
 }
 
-// TerminateDockerComposeProject terminates a running docker compose project. If logDir is
-// included then the container logs are saved to that directory before it is
-// terminated. If killAndCleanup is false docker stop is used, otherwise docker compose
-// kill is used and volumes are also deleted.
-func TerminateDockerComposeProject(t *testing.T, ctx context.Context, project, logDir string, killAndCleanup bool) {
-	if project == "" {
-		return
+// CreatePGConn is like [CreatePGPool] but returns a single connection, e.g.
+// for tests of session state such as temporary tables, session settings or
+// advisory locks. If connecting fails it returns a wrapped ErrPgConnConnect.
+// The connection is closed when the test completes.
+func CreatePGConn(t *testing.T, ctx context.Context, spec ConnSpec) (*pgx.Conn, error) {
+	t.Helper()
+
+	dsn, err := spec.DSN()
+	if err != nil {
+		return nil, err
 	}
 
-	writeDockerComposeLogs(t, ctx, project, logDir)
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse connection string: %w", err)
+	}
+	config.Tracer = sqlTracer{}
+
+	policy := spec.Retry.withDefaults(t)
+	conn, err := retryConnect(ctx, policy, ErrPgConnConnect, func(ctx context.Context) (*pgx.Conn, error) {
+		return connectPGConn(ctx, config, policy.AttemptTimeout)
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	if killAndCleanup {
-		termCmd := exec.CommandContext(ctx, "docker", "compose", "--project-name", project, "kill")
-		if output, err := termCmd.CombinedOutput(); err != nil {
-			t.Fatalf("unable to terminate docker compose %s: %s", err, output)
+	t.Cleanup(func() {
+		// the test context may already be done during cleanup
+		_ = conn.Close(context.Background())
+	})
+
+	return conn, nil
+}
+
+// retryConnect calls connect with exponential backoff according to policy,
+// with its defaults applied, until it succeeds or fails with an error other than errConnect.
+func retryConnect[T any](ctx context.Context, policy RetryPolicy, errConnect error, connect func(context.Context) (T, error)) (T, error) {
+	if policy.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = WithOperation(ctx, "retry connecting", policy.Deadline)
+		defer cancel()
+	}
+
+	backoff := policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		c, err := connect(ctx)
+		if err == nil {
+			return c, nil
+		}
+		if !errors.Is(err, errConnect) || attempt == policy.Attempts {
+			return c, err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return c, fmt.Errorf("%w after %d attempts: %w", errConnect, attempt, ContextError(ctx, dialError(err, errConnect)))
 		}
+
+		backoff = min(2*backoff, policy.MaxBackoff)
 	}
+}
 
-	// always run docker compose down to clean up the containers and network
-	downCmdConfig := []string{"compose", "--project-name", project, "down", "--timeout", "30"}
-	if killAndCleanup {
-		// but only remove the volumes if killing the containers
-		downCmdConfig = append(downCmdConfig, "--volumes")
+// dialError returns the error of the dial that err, an errConnect of
+// connectPGPool or connectPGConn, wraps along with errConnect, or err itself.
+func dialError(err, errConnect error) error {
+	if wrapped, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range wrapped.Unwrap() {
+			if e != errConnect {
+				return e
+			}
+		}
 	}
 
-	downCmd := exec.CommandContext(ctx, "docker", downCmdConfig...)
-	if output, err := downCmd.CombinedOutput(); err != nil {
-		t.Fatalf("unable to stop docker compose %s: %s", err, output)
+	return err
+}
+
+// CreateSocketDir creates a directory for the Unix socket of Postgres that is
+// removed when the test completes, see [ContainerOptions.SocketDir] and
+// [WithSocketDir]. It is writable by the user Postgres runs as inside the
+// container, and kept short as socket paths are limited to about 100
+// characters.
+func CreateSocketDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "hydra-")
+	if err != nil {
+		t.Fatalf("unable to create socket directory: %s", err)
 	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatalf("unable to change the mode of %s: %s", dir, err)
+	}
+
+	return dir
 }
 
-func writeDockerComposeLogs(t *testing.T, ctx context.Context, project, logDir string) {
-	if logDir == "" {
-		return
+// connectPGPool creates a pool with config and pings the database within
+// timeout.
+func connectPGPool(ctx context.Context, config *pgxpool.Config, timeout time.Duration) (*pgxpool.Pool, error) {
+	// an attempt timing out is expected while the server starts, so it is
+	// not an operation
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// a config may only be used for a single pool
+	pool, err := pgxpool.NewWithConfig(ctx, config.Copy())
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct new pool: %w", err)
+	}
+
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("%w: %w", ErrPgPoolConnect, err)
 	}
 
-	logCmd := exec.CommandContext(ctx, "docker", "compose", "--project-name", project, "logs", "--no-color", "hydra")
-	logOutput, err := logCmd.CombinedOutput()
+	return pool, nil
+}
+
+// connectPGConn connects with config within timeout.
+func connectPGConn(ctx context.Context, config *pgx.ConnConfig, timeout time.Duration) (*pgx.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := pgx.ConnectConfig(ctx, config.Copy())
 	if err != nil {
-		t.Fatalf("unable to fetch docker compose log %s: %s", err, logOutput)
+		return nil, fmt.Errorf("%w: %w", ErrPgConnConnect, err)
 	}
 
-	if err := os.WriteFile(filepath.Join(logDir, fmt.Sprintf("%s-%s.log", project, time.Now().Format(time.RFC3339))), logOutput, 0644); err != nil {
-		t.Fatalf("unable to write docker compose log: %s", err)
+	return conn, nil
+}
+
+// TerminateDockerComposeProject terminates a running docker compose project
+// using rt. If opts include [WithLogDir] then the hydra container logs are
+// saved to that directory. Unless opts include [WithKill] the containers are
+// stopped within the [WithStopTimeout], otherwise they are killed and volumes
+// are also deleted. Use a [ComposeStack] directly to verify the shutdown. The
+// errors are returned as by [ComposeStack.Down].
+func TerminateDockerComposeProject(t *testing.T, ctx context.Context, rt ContainerRuntime, project string, opts ...Option) error {
+	if project == "" {
+		return nil
 	}
+
+	return NewComposeStack(rt, project, "", "hydra").Down(t, ctx, opts...)
 }
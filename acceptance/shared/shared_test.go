@@ -0,0 +1,143 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryConnectDeadline(t *testing.T) {
+	errDial := errors.New("connection refused")
+	policy := RetryPolicy{
+		Deadline:       50 * time.Millisecond,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+	}
+
+	attempts := 0
+	_, err := retryConnect(context.Background(), policy, ErrPgPoolConnect, func(ctx context.Context) (struct{}, error) {
+		attempts++
+		return struct{}{}, fmt.Errorf("%w: %w", ErrPgPoolConnect, errDial)
+	})
+	if err == nil {
+		t.Fatal("retrying past the deadline should fail")
+	}
+
+	for _, want := range []error{ErrPgPoolConnect, errDial, ErrOperationTimeout} {
+		if !errors.Is(err, want) {
+			t.Errorf("error should wrap %q: %s", want, err)
+		}
+	}
+
+	msg := err.Error()
+	want := fmt.Sprintf("%s after %d attempts: %s", ErrPgPoolConnect, attempts, errDial)
+	if !strings.HasPrefix(msg, want) {
+		t.Errorf("error should start with %q, got %q", want, msg)
+	}
+	if !strings.Contains(msg, "retry connecting took longer than") {
+		t.Errorf("error should name the operation that timed out, got %q", msg)
+	}
+	if strings.Contains(msg, "%!") {
+		t.Errorf("error should be formatted, got %q", msg)
+	}
+}
+
+func TestRetryConnectAttempts(t *testing.T) {
+	errDial := errors.New("connection refused")
+	policy := RetryPolicy{
+		Attempts:       3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	}
+
+	attempts := 0
+	_, err := retryConnect(context.Background(), policy, ErrPgConnConnect, func(ctx context.Context) (struct{}, error) {
+		attempts++
+		return struct{}{}, fmt.Errorf("%w: %w", ErrPgConnConnect, errDial)
+	})
+	if attempts != 3 {
+		t.Errorf("connect should be attempted 3 times, got %d", attempts)
+	}
+	if !errors.Is(err, ErrPgConnConnect) || !errors.Is(err, errDial) {
+		t.Errorf("error should wrap %q and %q: %s", ErrPgConnConnect, errDial, err)
+	}
+}
+
+func TestScheduleGroupsSerial(t *testing.T) {
+	groups := []CaseGroup{
+		{Name: "a"},
+		{Name: "restart", Serial: true},
+		{Name: "b"},
+		{Name: "crash", Serial: true},
+		{Name: "c"},
+	}
+
+	var (
+		mu      sync.Mutex
+		running []string
+		ran     []string
+	)
+	scheduleGroups(t, groups, func(t *testing.T, g CaseGroup) {
+		mu.Lock()
+		if g.Serial && len(running) > 0 {
+			t.Errorf("serial group %s should run alone, got it running with %v", g.Name, running)
+		}
+		for _, name := range running {
+			if name == "restart" || name == "crash" {
+				t.Errorf("group %s should not run while the serial group %s runs", g.Name, name)
+			}
+		}
+		running = append(running, g.Name)
+		mu.Unlock()
+
+		// long enough for the parallel groups to overlap
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		for i, name := range running {
+			if name == g.Name {
+				running = append(running[:i], running[i+1:]...)
+				break
+			}
+		}
+		ran = append(ran, g.Name)
+		mu.Unlock()
+	})
+
+	if len(ran) != len(groups) {
+		t.Fatalf("every group should run, got %v", ran)
+	}
+	if want, got := "restart,crash", strings.Join(ran[3:], ","); want != got {
+		t.Errorf("serial groups should run in order after the others: want=%s got=%s", want, got)
+	}
+}
+
+func TestValidateRunID(t *testing.T) {
+	for _, tc := range []struct {
+		id    string
+		valid bool
+	}{
+		{id: "", valid: true},
+		{id: "a", valid: true},
+		{id: "ci-1234.retry_2", valid: true},
+		{id: strings.Repeat("a", 63), valid: true},
+		{id: strings.Repeat("a", 64)},
+		{id: "-a"},
+		{id: "a-"},
+		{id: "a."},
+		{id: "_"},
+		{id: "a/b"},
+	} {
+		err := ValidateRunID("RUN_ID", tc.id)
+		if tc.valid && err != nil {
+			t.Errorf("run ID %q should be valid: %s", tc.id, err)
+		}
+		if !tc.valid && !errors.Is(err, ErrInvalidRunID) {
+			t.Errorf("run ID %q should be invalid, got %v", tc.id, err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// shutdownLogLine is logged by Postgres once it has completed a clean shutdown.
+var shutdownLogLine = []byte("database system is shut down")
+
+// VerifyCleanShutdown asserts that the stopped Postgres container of a service
+// in a compose project shut down cleanly: the container exited with code 0 and
+// Postgres logged that the database system was shut down. A failure usually
+// means the stop timeout expired and Postgres had to be killed.
+func VerifyCleanShutdown(t *testing.T, ctx context.Context, rt ContainerRuntime, project, service string) {
+	t.Helper()
+
+	state, err := rt.State(ctx, project, service)
+	if err != nil {
+		t.Fatalf("unable to inspect %s: %s", service, err)
+	}
+
+	if state.Status != "exited" {
+		t.Errorf("%s should have exited, got %s", service, state.Status)
+	}
+	if state.ExitCode != 0 {
+		t.Errorf("%s should exit with code 0, got %d (oom killed: %t)", service, state.ExitCode, state.OOMKilled)
+	}
+
+	ctx, cancel := WithOperation(ctx, "fetch logs of "+service, TimeoutsFor(t).LogFetch)
+	defer cancel()
+
+	logs, err := rt.Logs(ctx, project, service)
+	if err != nil {
+		t.Fatalf("unable to fetch logs for %s: %s", service, ContextError(ctx, err))
+	}
+
+	if !bytes.Contains(logs, shutdownLogLine) {
+		t.Errorf("%s logs should contain %q, postgres did not shut down cleanly", service, shutdownLogLine)
+	}
+}
@@ -0,0 +1,170 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Limits of columnar.stripe_row_limit and columnar.chunk_group_row_limit, as
+// enforced by the columnar extension.
+const (
+	minRowLimit = 1000
+	maxRowLimit = 100000000
+)
+
+// A Sizing is the stripe and chunk group row limits of columnar tables, set by
+// columnar.stripe_row_limit and columnar.chunk_group_row_limit.
+type Sizing struct {
+	StripeRowLimit     int
+	ChunkGroupRowLimit int
+}
+
+// Name returns the name of the sizing, e.g. stripe_1000_chunk_1000, which is
+// also the suffix of its table in the [SizingCases].
+func (s Sizing) Name() string {
+	return fmt.Sprintf("stripe_%d_chunk_%d", s.StripeRowLimit, s.ChunkGroupRowLimit)
+}
+
+// Sizings are the sizings of columnar tables covered by the [SizingCases], the
+// defaults and the extremes of each limit.
+var Sizings = []Sizing{
+	{StripeRowLimit: 150000, ChunkGroupRowLimit: 10000},
+	{StripeRowLimit: minRowLimit, ChunkGroupRowLimit: minRowLimit},
+	{StripeRowLimit: minRowLimit, ChunkGroupRowLimit: maxRowLimit},
+	// the writer allocates the buffers of every chunk group of a stripe
+	// upfront, tens of MB
+	{StripeRowLimit: maxRowLimit, ChunkGroupRowLimit: minRowLimit},
+	{StripeRowLimit: maxRowLimit, ChunkGroupRowLimit: maxRowLimit},
+	// the dataset does not divide into whole stripes and chunk groups
+	{StripeRowLimit: 30000, ChunkGroupRowLimit: 7000},
+}
+
+// SizingCases returns cases for each of sizings that load the columnar dataset
+// into a columnar table created with the sizing and verify that the rows read
+// back match the dataset, that columnar.options records the limits and that
+// the stripes of columnar.stats are split by them. The last case verifies that
+// limits out of range are rejected.
+func SizingCases(sizings ...Sizing) []Case {
+	var cases []Case
+	for _, sizing := range sizings {
+		sizing := sizing
+		table := pgx.Identifier{"sizing_" + sizing.Name()}.Sanitize()
+
+		cases = append(cases,
+			Case{
+				Name: "columnar sizing " + sizing.Name(),
+				Tags: []string{TagColumnar},
+				Settings: map[string]string{
+					"columnar.stripe_row_limit":      strconv.Itoa(sizing.StripeRowLimit),
+					"columnar.chunk_group_row_limit": strconv.Itoa(sizing.ChunkGroupRowLimit),
+				},
+				SQL: fmt.Sprintf("CREATE TABLE %s USING columnar AS %s", table, columnarDataset),
+			},
+			Case{
+				Name:     "columnar sizing " + sizing.Name() + " rows",
+				Tags:     []string{TagColumnar},
+				SQL:      datasetCheckSQL(table, ""),
+				Validate: validateDataset(table),
+			},
+			Case{
+				Name: "columnar sizing " + sizing.Name() + " options",
+				Tags: []string{TagColumnar},
+				SQL:  fmt.Sprintf("SELECT stripe_row_limit, chunk_group_row_limit FROM columnar.options WHERE regclass = %s::regclass", quoteLiteral(table)),
+				Validate: func(t *testing.T, row pgx.Row) {
+					var stripeLimit, chunkLimit int
+					if err := row.Scan(&stripeLimit, &chunkLimit); err != nil {
+						t.Fatalf("unable to query the options of %s: %s", table, err)
+					}
+					if stripeLimit != sizing.StripeRowLimit || chunkLimit != sizing.ChunkGroupRowLimit {
+						t.Errorf("options of %s should match: want=%d,%d got=%d,%d", table, sizing.StripeRowLimit, sizing.ChunkGroupRowLimit, stripeLimit, chunkLimit)
+					}
+				},
+			},
+			Case{
+				Name: "columnar sizing " + sizing.Name() + " stripes",
+				Tags: []string{TagColumnar},
+				// every stripe is full but the last, and split into chunk
+				// groups of the limit but the last
+				SQL: fmt.Sprintf(`
+SELECT coalesce(sum(rowcount), 0),
+       count(*),
+       count(*) FILTER (WHERE rowcount = %[2]d),
+       count(*) FILTER (WHERE chunkcount = ceil(rowcount::numeric / %[3]d))
+FROM columnar.stats(%[1]s::regclass)`, quoteLiteral(table), sizing.StripeRowLimit, sizing.ChunkGroupRowLimit),
+				Validate: func(t *testing.T, row pgx.Row) {
+					var rows, stripes, full, chunked int64
+					if err := row.Scan(&rows, &stripes, &full, &chunked); err != nil {
+						t.Fatalf("unable to query the stripes of %s: %s", table, err)
+					}
+
+					limit := int64(sizing.StripeRowLimit)
+					if wantStripes := (rows + limit - 1) / limit; stripes != wantStripes {
+						t.Errorf("stripe count of %s should match: want=%d got=%d", table, wantStripes, stripes)
+					}
+					if wantFull := rows / limit; full != wantFull {
+						t.Errorf("full stripe count of %s should match: want=%d got=%d", table, wantFull, full)
+					}
+					if chunked != stripes {
+						t.Errorf("every stripe of %s should be split into chunk groups of %d rows, %d of %d are", table, sizing.ChunkGroupRowLimit, chunked, stripes)
+					}
+				},
+			},
+		)
+	}
+
+	cases = append(cases, Case{
+		Name: "columnar sizing limits out of range",
+		Tags: []string{TagColumnar},
+		Run:  rejectSizingOutOfRange,
+	})
+
+	return cases
+}
+
+// rejectSizingOutOfRange verifies that the row limits just outside of their
+// range are rejected by alter_columnar_table_set and the settings.
+func rejectSizingOutOfRange(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+	t.Helper()
+
+	table := pgx.Identifier{"sizing_out_of_range"}.Sanitize()
+	if _, err := pool.Exec(ctx, fmt.Sprintf("CREATE TABLE %s (i INT8) USING columnar", table)); err != nil {
+		t.Fatalf("unable to create %s: %s", table, err)
+	}
+
+	options := func() [2]int {
+		t.Helper()
+
+		var limits [2]int
+		if err := pool.QueryRow(ctx, "SELECT stripe_row_limit, chunk_group_row_limit FROM columnar.options WHERE regclass = $1::text::regclass", table).Scan(&limits[0], &limits[1]); err != nil {
+			t.Fatalf("unable to query the options of %s: %s", table, err)
+		}
+
+		return limits
+	}
+	before := options()
+
+	for _, option := range []string{"stripe_row_limit", "chunk_group_row_limit"} {
+		for _, limit := range []int{minRowLimit - 1, maxRowLimit + 1} {
+			sql := fmt.Sprintf("SELECT columnar.alter_columnar_table_set($1::text::regclass, %s => $2)", option)
+			if _, err := pool.Exec(ctx, sql, table, limit); err == nil {
+				t.Errorf("%s of %d should be rejected by alter_columnar_table_set", option, limit)
+			}
+
+			err := inTxWithSettings(ctx, pool, map[string]string{"columnar." + option: strconv.Itoa(limit)}, func(tx pgx.Tx) error {
+				return nil
+			})
+			if err == nil {
+				t.Errorf("columnar.%s of %d should be rejected", option, limit)
+			}
+		}
+	}
+
+	if after := options(); after != before {
+		t.Errorf("options of %s should not change: want=%v got=%v", table, before, after)
+	}
+}
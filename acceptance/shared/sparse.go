@@ -0,0 +1,221 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// sparseRows is the number of rows of the tables of the [SparseCases].
+const sparseRows = 100000
+
+// A Sparsity is the share of NULLs in the columns of a columnar table.
+type Sparsity struct {
+	NullPercent int // from 0 to 100
+}
+
+// Name returns the name of the sparsity, e.g. nulls_99, which is also the
+// suffix of its table in the [SparseCases].
+func (s Sparsity) Name() string {
+	return "nulls_" + strconv.Itoa(s.NullPercent)
+}
+
+// isNull returns whether the values of row id are NULL with the sparsity, as
+// generated by sparseDataset.
+func (s Sparsity) isNull(id int64) bool {
+	return id%100 < int64(s.NullPercent)
+}
+
+// Sparsities are the sparsities of columnar tables covered by the
+// [SparseCases], from dense to entirely NULL columns.
+var Sparsities = []Sparsity{
+	{NullPercent: 0},
+	{NullPercent: 50},
+	{NullPercent: 99},
+	{NullPercent: 100},
+}
+
+// sparseDataset creates the columnar table %[1]s of a sparsity whose num and
+// hash columns are NULL in the rows whose id modulo 100 is less than %[2]d.
+const sparseDataset = `
+CREATE TABLE %[1]s USING columnar AS
+SELECT i AS id,
+       CASE WHEN i %% 100 >= %[2]d THEN i END AS num,
+       CASE WHEN i %% 100 >= %[2]d THEN md5(i::text) END AS hash
+FROM generate_series(1, %[3]d) i`
+
+// sparseAggregates returns the aggregates and the NULL handling predicates of
+// the table of a sparsity checked by the [SparseCases].
+const sparseAggregates = `
+SELECT count(*),
+       count(num),
+       count(hash),
+       count(*) FILTER (WHERE num IS NULL),
+       count(*) FILTER (WHERE hash IS NOT NULL),
+       count(*) FILTER (WHERE num IS DISTINCT FROM id),
+       count(*) FILTER (WHERE num > 50000),
+       count(*) FILTER (WHERE (num IS NULL) <> (hash IS NULL)),
+       sum(num)::int8,
+       min(num),
+       max(num),
+       sum(coalesce(num, -1))::int8,
+       count(DISTINCT hash)
+FROM %s`
+
+// SparseCases returns cases for each of sparsities that load a columnar table
+// with columns NULL in that share of the rows and verify their aggregates and
+// NULL handling predicates. The last case verifies that the
+// data of the tables shrinks as their share of NULLs grows, as columnar
+// chunks store NULLs as a bitmap rather than as values, and logs their size.
+func SparseCases(sparsities ...Sparsity) []Case {
+	var cases []Case
+	for _, sparsity := range sparsities {
+		table := pgx.Identifier{"sparse_" + sparsity.Name()}.Sanitize()
+		cases = append(cases,
+			Case{
+				Name: "columnar sparse columns " + sparsity.Name(),
+				Tags: []string{TagColumnar},
+				SQL:  fmt.Sprintf(sparseDataset, table, sparsity.NullPercent, sparseRows),
+			},
+			Case{
+				Name:     "columnar sparse columns " + sparsity.Name() + " aggregates",
+				Tags:     []string{TagColumnar},
+				SQL:      fmt.Sprintf(sparseAggregates, table),
+				Validate: validateSparseAggregates(table, sparsity),
+			},
+		)
+	}
+
+	cases = append(cases, Case{
+		Name: "columnar sparse columns sizes",
+		Tags: []string{TagColumnar},
+		Run: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+			compareSparseSizes(t, ctx, pool, sparsities)
+		},
+	})
+
+	return cases
+}
+
+// validateSparseAggregates returns a validation of the sparseAggregates of
+// table, the table of sparsity, against those computed from the generated
+// rows.
+func validateSparseAggregates(table string, sparsity Sparsity) func(t *testing.T, row pgx.Row) {
+	return func(t *testing.T, row pgx.Row) {
+		var want, got struct {
+			rows, nums, hashes, nullNums, hashesNotNull, distinct, above, mismatched, sum, coalesced, distinctHashes int64
+			min, max                                                                                                 *int64
+		}
+		want.rows = sparseRows
+		for id := int64(1); id <= sparseRows; id++ {
+			if sparsity.isNull(id) {
+				want.nullNums++
+				want.coalesced--
+				continue
+			}
+
+			want.nums++
+			want.sum += id
+			want.coalesced += id
+			if id > 50000 {
+				want.above++
+			}
+			if want.min == nil {
+				first := id
+				want.min = &first
+			}
+			last := id
+			want.max = &last
+		}
+		want.hashes = want.nums
+		want.hashesNotNull = want.nums
+		want.distinct = want.nullNums
+		want.distinctHashes = want.nums
+
+		// sum(num) of no values is NULL, as opposed to sum(coalesce(num, -1))
+		var sum *int64
+		err := row.Scan(
+			&got.rows, &got.nums, &got.hashes, &got.nullNums, &got.hashesNotNull, &got.distinct, &got.above,
+			&got.mismatched, &sum, &got.min, &got.max, &got.coalesced, &got.distinctHashes,
+		)
+		if err != nil {
+			t.Fatalf("unable to aggregate %s: %s", table, err)
+		}
+		if sum != nil {
+			got.sum = *sum
+		}
+
+		counts := []struct {
+			name      string
+			want, got int64
+		}{
+			{"count(*)", want.rows, got.rows},
+			{"count(num)", want.nums, got.nums},
+			{"count(hash)", want.hashes, got.hashes},
+			{"num IS NULL", want.nullNums, got.nullNums},
+			{"hash IS NOT NULL", want.hashesNotNull, got.hashesNotNull},
+			{"num IS DISTINCT FROM id", want.distinct, got.distinct},
+			{"num > 50000", want.above, got.above},
+			{"NULLs of num and hash apart", want.mismatched, got.mismatched},
+			{"sum(num)", want.sum, got.sum},
+			{"sum(coalesce(num, -1))", want.coalesced, got.coalesced},
+			{"count(DISTINCT hash)", want.distinctHashes, got.distinctHashes},
+		}
+		for _, c := range counts {
+			if c.want != c.got {
+				t.Errorf("%s of %s should match: want=%d got=%d", c.name, table, c.want, c.got)
+			}
+		}
+		if want.nums == 0 && sum != nil {
+			t.Errorf("sum(num) of %s should be NULL, got %d", table, *sum)
+		}
+		if !equalInt64(want.min, got.min) || !equalInt64(want.max, got.max) {
+			t.Errorf("min(num) and max(num) of %s should match: want=%s, %s got=%s, %s", table, formatInt64(want.min), formatInt64(want.max), formatInt64(got.min), formatInt64(got.max))
+		}
+	}
+}
+
+// compareSparseSizes logs the size of the data of the table of every
+// sparsity, and fails the test if a table with more NULLs than another is
+// larger, given sparsities in ascending order of their share of NULLs.
+func compareSparseSizes(t *testing.T, ctx context.Context, pool *pgxpool.Pool, sparsities []Sparsity) {
+	t.Helper()
+
+	var previous int64
+	for i, sparsity := range sparsities {
+		table := pgx.Identifier{"sparse_" + sparsity.Name()}.Sanitize()
+
+		var size int64
+		if err := pool.QueryRow(ctx, "SELECT coalesce(sum(datalength), 0)::int8 FROM columnar.stats($1::text::regclass)", table).Scan(&size); err != nil {
+			t.Fatalf("unable to query the size of %s: %s", table, err)
+		}
+		t.Logf("sparse columns %s: %d bytes of data", sparsity.Name(), size)
+
+		if i > 0 && size > previous {
+			t.Errorf("data of %s should not be larger than with fewer NULLs: want<=%d got=%d", table, previous, size)
+		}
+		previous = size
+	}
+}
+
+// equalInt64 returns whether a and b are both NULL or the same value.
+func equalInt64(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	return *a == *b
+}
+
+// formatInt64 formats v, or NULL if it is nil.
+func formatInt64(v *int64) string {
+	if v == nil {
+		return "NULL"
+	}
+
+	return strconv.FormatInt(*v, 10)
+}
@@ -0,0 +1,83 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A StatsSample is the resource usage of a container at a point in time, as
+// reported by the stats command of the runtime, e.g. CPUPerc, MemUsage and
+// BlockIO.
+type StatsSample struct {
+	Time  time.Time
+	Stats json.RawMessage
+}
+
+// SampleStats records the resource usage of the container name every interval
+// while the test runs. If the test failed the samples are written to path as
+// JSON lines when it completes, to diagnose OOM kills and CPU starvation.
+// Samples are skipped while the container is not running.
+func SampleStats(t *testing.T, ctx context.Context, rt ContainerRuntime, name, path string, interval time.Duration) {
+	t.Helper()
+
+	var (
+		mu      sync.Mutex
+		samples []StatsSample
+		done    = make(chan struct{})
+		stopped = make(chan struct{})
+	)
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				output, err := rt.Command(ctx, "stats", "--no-stream", "--format", "{{json .}}", name).Output()
+				if err != nil {
+					continue
+				}
+
+				mu.Lock()
+				samples = append(samples, StatsSample{Time: time.Now(), Stats: bytes.TrimSpace(output)})
+				mu.Unlock()
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	t.Cleanup(func() {
+		close(done)
+		<-stopped
+
+		if !t.Failed() {
+			return
+		}
+
+		f, err := os.Create(path)
+		if err != nil {
+			t.Errorf("unable to write stats of %s: %s", name, err)
+			return
+		}
+		defer f.Close()
+
+		enc := json.NewEncoder(f)
+		for _, sample := range samples {
+			if err := enc.Encode(sample); err != nil {
+				t.Errorf("unable to write stats of %s: %s", name, err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A SuiteFile describes the configurations to run the suites under, e.g. in a
+// hydra-acceptance.yaml:
+//
+//	configurations:
+//	  - name: pg14
+//	    image: ghcr.io/hydradatabase/hydra:latest
+//	    postgres_version: "14"
+//	    settings:
+//	      columnar.compression: pglz
+//	    suites: [postgres, kind]
+//
+// so that the same test binaries run many configurations without code edits.
+type SuiteFile struct {
+	Configurations []SuiteConfig `yaml:"configurations"`
+}
+
+// A SuiteConfig is a configuration of a [SuiteFile]. Empty fields keep the
+// value from the environment of the suite.
+type SuiteConfig struct {
+	Name             string            `yaml:"name"`               // name of the sub-test the configuration runs in
+	Image            string            `yaml:"image"`              // image to test
+	UpgradeFromImage string            `yaml:"upgrade_from_image"` // image to upgrade from in upgrade tests
+	PostgresVersion  string            `yaml:"postgres_version"`   // major version of Postgres the image runs
+	Settings         map[string]string `yaml:"settings"`           // settings of Postgres, from its start where supported, see [ContainerOptions], otherwise of the sessions, see [PoolWithGUCs]
+	Suites           []string          `yaml:"suites"`             // suites to run the configuration in, e.g. postgres or spilo, all if empty
+}
+
+// LoadSuiteFile parses the [SuiteFile] at path. Unknown keys are rejected so
+// that misspelled settings do not go unnoticed.
+func LoadSuiteFile(path string) (*SuiteFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+
+	var file SuiteFile
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalidConfig, path, err)
+	}
+
+	if err := file.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s:\n%w", ErrInvalidConfig, path, err)
+	}
+
+	return &file, nil
+}
+
+// Validate reports whether every configuration has a unique name and valid
+// images.
+func (f *SuiteFile) Validate() error {
+	var errs []error
+
+	names := make(map[string]bool)
+	for i, c := range f.Configurations {
+		if c.Name == "" {
+			errs = append(errs, fmt.Errorf("configuration %d must have a name", i))
+		} else if names[c.Name] {
+			errs = append(errs, fmt.Errorf("configuration %s is defined more than once", c.Name))
+		}
+		names[c.Name] = true
+
+		for field, image := range map[string]string{"image": c.Image, "upgrade_from_image": c.UpgradeFromImage} {
+			if image == "" {
+				continue
+			}
+			if err := ValidateImage(c.Name+"."+field, image); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ConfigurationsFor returns the configurations enabled for suite.
+func (f *SuiteFile) ConfigurationsFor(suite string) []SuiteConfig {
+	var configs []SuiteConfig
+	for _, c := range f.Configurations {
+		if len(c.Suites) == 0 || slices.Contains(c.Suites, suite) {
+			configs = append(configs, c)
+		}
+	}
+
+	return configs
+}
+
+// RunConfigurations runs fn in a sub-test for every configuration of file
+// enabled for suite. If file is nil or has none for suite fn runs once with
+// the zero SuiteConfig, i.e. the configuration from the environment.
+func RunConfigurations(t *testing.T, file *SuiteFile, suite string, fn func(t *testing.T, c SuiteConfig)) {
+	var configs []SuiteConfig
+	if file != nil {
+		configs = file.ConfigurationsFor(suite)
+	}
+
+	if len(configs) == 0 {
+		fn(t, SuiteConfig{})
+		return
+	}
+
+	for _, c := range configs {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			fn(t, c)
+		})
+	}
+}
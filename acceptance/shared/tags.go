@@ -0,0 +1,95 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Tags group the shared cases so that a subset of them can be run, see
+// [CaseTagFilter].
+const (
+	TagSmoke       = "smoke"       // quick checks of the image, e.g. on every pull request
+	TagColumnar    = "columnar"    // cases of the columnar extension
+	TagSlow        = "slow"        // cases that load or scan large amounts of data
+	TagDestructive = "destructive" // cases that replace or upgrade the container or its data
+)
+
+// ErrInvalidTagFilter is used when an expression of a tag filter is empty.
+var ErrInvalidTagFilter = errors.New("invalid tag filter")
+
+// A TagFilter selects cases by their tags. A case matches if it has any of the
+// Include tags, or Include is empty, and none of the Exclude tags.
+type TagFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// CaseTagFilter selects the shared cases that are run. Cases that do not match
+// are skipped. The zero value runs every case.
+var CaseTagFilter TagFilter
+
+// ParseTagFilter parses expressions of a tag filter for [CaseTagFilter]. Each
+// expression is a tag to include or, prefixed with !, a tag to exclude, e.g.
+// smoke to run the smoke cases only or !destructive to run everything else.
+func ParseTagFilter(exprs []string) (TagFilter, error) {
+	var f TagFilter
+	for _, expr := range exprs {
+		expr = strings.TrimSpace(expr)
+		exclude := strings.HasPrefix(expr, "!")
+		tag := strings.TrimPrefix(expr, "!")
+		if tag == "" {
+			return TagFilter{}, fmt.Errorf("%w: empty tag in %q", ErrInvalidTagFilter, expr)
+		}
+
+		if exclude {
+			f.Exclude = append(f.Exclude, tag)
+		} else {
+			f.Include = append(f.Include, tag)
+		}
+	}
+
+	return f, nil
+}
+
+// Match reports whether tags are selected by the filter.
+func (f TagFilter) Match(tags []string) bool {
+	for _, tag := range f.Exclude {
+		if slices.Contains(tags, tag) {
+			return false
+		}
+	}
+
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, tag := range f.Include {
+		if slices.Contains(tags, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String renders the filter as its expressions, e.g. smoke;!slow.
+func (f TagFilter) String() string {
+	exprs := slices.Clone(f.Include)
+	for _, tag := range f.Exclude {
+		exprs = append(exprs, "!"+tag)
+	}
+
+	return strings.Join(exprs, ";")
+}
+
+// SkipUnlessTagged skips a test that is not made of shared cases, e.g. an
+// upgrade, unless tags are selected by [CaseTagFilter].
+func SkipUnlessTagged(t *testing.T, tags ...string) {
+	t.Helper()
+
+	if !CaseTagFilter.Match(tags) {
+		t.Skipf("Skipping test with tags %s not selected by %s", strings.Join(tags, ","), CaseTagFilter)
+	}
+}
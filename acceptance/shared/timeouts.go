@@ -0,0 +1,106 @@
+package shared
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Timeouts bound the phases of a test. They are set for the whole run with
+// [SetTimeouts], e.g. from the environment on slow CI machines, and can be
+// overridden for a test and its subtests with [OverrideTimeouts].
+type Timeouts struct {
+	Startup  time.Duration // time for a container to start accepting connections
+	Connect  time.Duration // time an attempt to connect may take, see [RetryPolicy]
+	Query    time.Duration // time a shared case may run
+	Shutdown time.Duration // time to wait for containers to stop before killing them
+	LogFetch time.Duration // time to fetch the logs of a container
+}
+
+// DefaultTimeouts are the Timeouts used for the fields that are not set.
+var DefaultTimeouts = Timeouts{
+	Startup:  60 * time.Second,
+	Connect:  time.Second,
+	Query:    5 * time.Second,
+	Shutdown: 30 * time.Second,
+	LogFetch: 30 * time.Second,
+}
+
+var (
+	timeoutsMu sync.Mutex
+	timeouts   = DefaultTimeouts
+	// overrides by test name, see OverrideTimeouts
+	timeoutOverrides = map[string]Timeouts{}
+)
+
+// SetTimeouts sets the timeouts of the test run. Zero fields take their value
+// from [DefaultTimeouts]. It is called from TestMain of each suite.
+func SetTimeouts(t Timeouts) {
+	timeoutsMu.Lock()
+	defer timeoutsMu.Unlock()
+
+	timeouts = DefaultTimeouts.merge(t)
+}
+
+// OverrideTimeouts overrides the non-zero fields of timeouts for t and its
+// subtests until t completes, e.g. for a test that loads a large table.
+func OverrideTimeouts(t *testing.T, timeouts Timeouts) {
+	t.Helper()
+
+	timeoutsMu.Lock()
+	defer timeoutsMu.Unlock()
+
+	name := t.Name()
+	previous, ok := timeoutOverrides[name]
+	timeoutOverrides[name] = previous.merge(timeouts)
+
+	t.Cleanup(func() {
+		timeoutsMu.Lock()
+		defer timeoutsMu.Unlock()
+
+		if ok {
+			timeoutOverrides[name] = previous
+		} else {
+			delete(timeoutOverrides, name)
+		}
+	})
+}
+
+// TimeoutsFor returns the timeouts of t: those of the run, overridden by those
+// of the parents of t and then of t itself.
+func TimeoutsFor(t *testing.T) Timeouts {
+	timeoutsMu.Lock()
+	defer timeoutsMu.Unlock()
+
+	result := timeouts
+	parts := strings.Split(t.Name(), "/")
+	for i := range parts {
+		if o, ok := timeoutOverrides[strings.Join(parts[:i+1], "/")]; ok {
+			result = result.merge(o)
+		}
+	}
+
+	return result
+}
+
+// merge returns t with the non-zero fields of o.
+func (t Timeouts) merge(o Timeouts) Timeouts {
+	if o.Startup != 0 {
+		t.Startup = o.Startup
+	}
+	if o.Connect != 0 {
+		t.Connect = o.Connect
+	}
+	if o.Query != 0 {
+		t.Query = o.Query
+	}
+	if o.Shutdown != 0 {
+		t.Shutdown = o.Shutdown
+	}
+	if o.LogFetch != 0 {
+		t.LogFetch = o.LogFetch
+	}
+
+	return t
+}
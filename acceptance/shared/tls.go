@@ -0,0 +1,196 @@
+package shared
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TLSOptions configure how a pool connects over TLS, see the libpq
+// documentation of the parameters with the same name.
+type TLSOptions struct {
+	SSLMode     string // e.g. require or verify-full, the libpq default of prefer if empty
+	SSLRootCert string // path of the CA certificate to verify the server with
+	SSLCert     string // path of the client certificate
+	SSLKey      string // path of the key of the client certificate
+}
+
+// params returns the options as connection string parameters.
+func (o TLSOptions) params() map[string]string {
+	params := map[string]string{}
+	for k, v := range map[string]string{
+		"sslmode":     o.SSLMode,
+		"sslrootcert": o.SSLRootCert,
+		"sslcert":     o.SSLCert,
+		"sslkey":      o.SSLKey,
+	} {
+		if v != "" {
+			params[k] = v
+		}
+	}
+
+	return params
+}
+
+// TLSCertificates are the paths of PEM encoded certificates and keys created
+// by [GenerateCertificates].
+type TLSCertificates struct {
+	CACert     string
+	ServerCert string
+	ServerKey  string
+	ClientCert string
+	ClientKey  string
+}
+
+// GenerateCertificates creates a CA and a server and a client certificate
+// signed by it in a temporary directory that is removed when the test
+// completes. The server certificate is valid for hosts, IP addresses or DNS
+// names, and the client certificate has clientUser as its common name for cert
+// authentication.
+func GenerateCertificates(t *testing.T, clientUser string, hosts ...string) TLSCertificates {
+	t.Helper()
+
+	dir := t.TempDir()
+	certs := TLSCertificates{
+		CACert:     filepath.Join(dir, "ca.crt"),
+		ServerCert: filepath.Join(dir, "server.crt"),
+		ServerKey:  filepath.Join(dir, "server.key"),
+		ClientCert: filepath.Join(dir, "client.crt"),
+		ClientKey:  filepath.Join(dir, "client.key"),
+	}
+
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "hydra acceptance CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caKey := writeCertificate(t, ca, nil, nil, certs.CACert, "")
+
+	server := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "hydra"},
+		NotBefore:    ca.NotBefore,
+		NotAfter:     ca.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			server.IPAddresses = append(server.IPAddresses, ip)
+		} else {
+			server.DNSNames = append(server.DNSNames, host)
+		}
+	}
+	writeCertificate(t, server, ca, caKey, certs.ServerCert, certs.ServerKey)
+
+	client := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: clientUser},
+		NotBefore:    ca.NotBefore,
+		NotAfter:     ca.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	writeCertificate(t, client, ca, caKey, certs.ClientCert, certs.ClientKey)
+
+	return certs
+}
+
+// writeCertificate creates a key for cert, signs cert with parent and
+// parentKey, or self-signs it if parent is nil, and writes both to certPath
+// and keyPath if included. It returns the key.
+func writeCertificate(t *testing.T, cert, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, certPath, keyPath string) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s", err)
+	}
+
+	if parent == nil {
+		parent, parentKey = cert, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, cert, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("unable to create certificate %s: %s", cert.Subject.CommonName, err)
+	}
+
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("unable to write %s: %s", certPath, err)
+	}
+
+	if keyPath != "" {
+		keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatalf("unable to marshal key: %s", err)
+		}
+
+		// libpq refuses keys that are readable by others
+		if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+			t.Fatalf("unable to write %s: %s", keyPath, err)
+		}
+	}
+
+	return key
+}
+
+// serverTLSDir is the directory in the container the server certificate is
+// provisioned to.
+const serverTLSDir = "/etc/ssl/hydra"
+
+// EnableTLS provisions the server certificate and key of certs into the
+// running container name and enables TLS in Postgres with ALTER SYSTEM using
+// pool, which must be connected as a superuser. Client certificates signed by
+// the CA of certs are accepted. osUser is the operating system user Postgres
+// runs as, e.g. postgres, which must own the key.
+func EnableTLS(t *testing.T, ctx context.Context, rt ContainerRuntime, pool *pgxpool.Pool, name, osUser string, certs TLSCertificates) {
+	t.Helper()
+
+	if output, err := rt.Command(ctx, "exec", "--user", "root", name, "mkdir", "-p", serverTLSDir).CombinedOutput(); err != nil {
+		t.Fatalf("unable to create %s in %s: %s: %s", serverTLSDir, name, err, output)
+	}
+
+	for _, f := range []string{certs.CACert, certs.ServerCert, certs.ServerKey} {
+		CopyToContainer(t, ctx, rt, f, name, path.Join(serverTLSDir, filepath.Base(f)))
+	}
+
+	// Postgres refuses a key that is not owned by it or readable by others
+	chown := "chown -R " + osUser + " " + serverTLSDir + " && chmod 600 " + serverTLSDir + "/server.key"
+	if output, err := rt.Command(ctx, "exec", "--user", "root", name, "sh", "-c", chown).CombinedOutput(); err != nil {
+		t.Fatalf("unable to change the owner of %s in %s: %s: %s", serverTLSDir, name, err, output)
+	}
+
+	for setting, value := range map[string]string{
+		"ssl":           "on",
+		"ssl_ca_file":   serverTLSDir + "/ca.crt",
+		"ssl_cert_file": serverTLSDir + "/server.crt",
+		"ssl_key_file":  serverTLSDir + "/server.key",
+	} {
+		// ALTER SYSTEM does not accept parameters, the values are constants
+		if _, err := pool.Exec(ctx, "ALTER SYSTEM SET "+setting+" = '"+value+"'"); err != nil {
+			t.Fatalf("unable to set %s: %s", setting, err)
+		}
+	}
+
+	if _, err := pool.Exec(ctx, "SELECT pg_reload_conf()"); err != nil {
+		t.Fatalf("unable to reload the configuration: %s", err)
+	}
+}
@@ -0,0 +1,219 @@
+package shared
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A Trigger creates triggers on the columnar table {schema}.trigger_columnar
+// of ids 1 to 3, with val v1 to v3, and runs Statements on it. The triggers
+// execute the functions of the triggerSchema, which log the events they fire
+// on to {schema}.trigger_log, in the order they fired. Triggers that columnar
+// tables do not support, e.g. AFTER ... FOR EACH ROW, must fail to be created
+// instead.
+type Trigger struct {
+	Name       string
+	Triggers   []string // CREATE TRIGGER statements, with {schema} the schema of the table
+	SQLState   string   // SQLSTATE the last of Triggers must fail with, e.g. SQLStateFeatureNotSupported
+	Statements []string // statements firing the triggers, with {schema} the schema of the table
+	Log        []string // events logged by the triggers, in the order they fired, see triggerSchema
+	Rows       []string // ids and vals of the table after Statements, in the text format of queryTextRows
+}
+
+// Case returns tr as a [Case] that runs it with [RunTrigger].
+func (tr Trigger) Case() Case {
+	return Case{
+		Name: "columnar trigger " + tr.Name,
+		Tags: []string{TagColumnar},
+		Run: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+			RunTrigger(t, ctx, pool, tr)
+		},
+	}
+}
+
+// TriggerCases returns a case for each of triggers.
+func TriggerCases(triggers ...Trigger) []Case {
+	return casesOf(triggers...)
+}
+
+// triggerSchema creates the table of a [Trigger] and the functions of its
+// triggers: log_trigger logs the name, timing, level and operation of the
+// trigger and the OLD and NEW rows of row triggers, upper_trigger uppercases
+// the val of NEW rows without logging, and log_new_rows logs the rows of the
+// transition table new_rows of statement triggers.
+const triggerSchema = `
+CREATE TABLE {schema}.trigger_columnar (id INT8, val TEXT) USING columnar;
+INSERT INTO {schema}.trigger_columnar SELECT i, 'v' || i FROM generate_series(1, 3) i;
+CREATE TABLE {schema}.trigger_log (seq BIGSERIAL, event TEXT) USING heap;
+
+CREATE FUNCTION {schema}.log_trigger() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+  INSERT INTO {schema}.trigger_log (event)
+  VALUES (concat_ws(' ', TG_NAME, TG_WHEN, TG_LEVEL, TG_OP, 'old=' || OLD::text, 'new=' || NEW::text));
+  RETURN coalesce(NEW, OLD);
+END;
+$$;
+
+CREATE FUNCTION {schema}.upper_trigger() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+  NEW.val := upper(NEW.val);
+  RETURN NEW;
+END;
+$$;
+
+CREATE FUNCTION {schema}.log_new_rows() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+  INSERT INTO {schema}.trigger_log (event)
+  SELECT concat_ws(' ', TG_NAME, TG_WHEN, TG_LEVEL, TG_OP, string_agg(r::text, ',' ORDER BY r.id)) FROM new_rows r;
+  RETURN NULL;
+END;
+$$;
+`
+
+// Triggers are the triggers covered by the [TriggerCases].
+var Triggers = []Trigger{
+	{
+		Name: "insert fires statement and row triggers in order",
+		Triggers: []string{
+			"CREATE TRIGGER statement_before BEFORE INSERT ON {schema}.trigger_columnar FOR EACH STATEMENT EXECUTE FUNCTION {schema}.log_trigger()",
+			"CREATE TRIGGER row_before BEFORE INSERT ON {schema}.trigger_columnar FOR EACH ROW EXECUTE FUNCTION {schema}.log_trigger()",
+			"CREATE TRIGGER statement_after AFTER INSERT ON {schema}.trigger_columnar FOR EACH STATEMENT EXECUTE FUNCTION {schema}.log_trigger()",
+		},
+		Statements: []string{"INSERT INTO {schema}.trigger_columnar VALUES (4, 'v4'), (5, 'v5')"},
+		Log: []string{
+			"statement_before BEFORE STATEMENT INSERT",
+			"row_before BEFORE ROW INSERT new=(4,v4)",
+			"row_before BEFORE ROW INSERT new=(5,v5)",
+			"statement_after AFTER STATEMENT INSERT",
+		},
+		Rows: []string{"1\tv1", "2\tv2", "3\tv3", "4\tv4", "5\tv5"},
+	},
+	{
+		// triggers of the same event fire in the order of their names, so
+		// that the log sees the rows as changed by the upper trigger
+		Name: "before row triggers change new rows in name order",
+		Triggers: []string{
+			"CREATE TRIGGER b_log BEFORE INSERT OR UPDATE ON {schema}.trigger_columnar FOR EACH ROW EXECUTE FUNCTION {schema}.log_trigger()",
+			"CREATE TRIGGER a_upper BEFORE INSERT OR UPDATE ON {schema}.trigger_columnar FOR EACH ROW EXECUTE FUNCTION {schema}.upper_trigger()",
+		},
+		Statements: []string{
+			"INSERT INTO {schema}.trigger_columnar VALUES (4, 'v4')",
+			"UPDATE {schema}.trigger_columnar SET val = 'updated' WHERE id = 1",
+		},
+		Log: []string{
+			"b_log BEFORE ROW INSERT new=(4,V4)",
+			"b_log BEFORE ROW UPDATE old=(1,v1) new=(1,UPDATED)",
+		},
+		Rows: []string{"1\tUPDATED", "2\tv2", "3\tv3", "4\tV4"},
+	},
+	{
+		Name: "before row delete triggers see old rows",
+		Triggers: []string{
+			"CREATE TRIGGER row_before BEFORE DELETE ON {schema}.trigger_columnar FOR EACH ROW EXECUTE FUNCTION {schema}.log_trigger()",
+		},
+		Statements: []string{"DELETE FROM {schema}.trigger_columnar WHERE id = 2"},
+		Log:        []string{"row_before BEFORE ROW DELETE old=(2,v2)"},
+		Rows:       []string{"1\tv1", "3\tv3"},
+	},
+	{
+		Name: "after statement triggers see transition tables",
+		Triggers: []string{
+			"CREATE TRIGGER new_rows AFTER INSERT ON {schema}.trigger_columnar REFERENCING NEW TABLE AS new_rows FOR EACH STATEMENT EXECUTE FUNCTION {schema}.log_new_rows()",
+		},
+		Statements: []string{"INSERT INTO {schema}.trigger_columnar VALUES (4, 'v4'), (5, 'v5')"},
+		Log:        []string{"new_rows AFTER STATEMENT INSERT (4,v4),(5,v5)"},
+		Rows:       []string{"1\tv1", "2\tv2", "3\tv3", "4\tv4", "5\tv5"},
+	},
+	{
+		Name: "truncate fires statement triggers",
+		Triggers: []string{
+			"CREATE TRIGGER truncate_before BEFORE TRUNCATE ON {schema}.trigger_columnar FOR EACH STATEMENT EXECUTE FUNCTION {schema}.log_trigger()",
+			"CREATE TRIGGER truncate_after AFTER TRUNCATE ON {schema}.trigger_columnar FOR EACH STATEMENT EXECUTE FUNCTION {schema}.log_trigger()",
+		},
+		Statements: []string{"TRUNCATE {schema}.trigger_columnar"},
+		Log: []string{
+			"truncate_before BEFORE STATEMENT TRUNCATE",
+			"truncate_after AFTER STATEMENT TRUNCATE",
+		},
+		Rows: []string{},
+	},
+	{
+		Name: "after row triggers are not supported",
+		Triggers: []string{
+			"CREATE TRIGGER row_after AFTER INSERT ON {schema}.trigger_columnar FOR EACH ROW EXECUTE FUNCTION {schema}.log_trigger()",
+		},
+		SQLState:   SQLStateFeatureNotSupported,
+		Statements: []string{"INSERT INTO {schema}.trigger_columnar VALUES (4, 'v4')"},
+		Log:        []string{},
+		Rows:       []string{"1\tv1", "2\tv2", "3\tv3", "4\tv4"},
+	},
+	{
+		// constraint triggers are always AFTER ... FOR EACH ROW triggers
+		Name: "constraint triggers are not supported",
+		Triggers: []string{
+			"CREATE CONSTRAINT TRIGGER row_constraint AFTER UPDATE ON {schema}.trigger_columnar DEFERRABLE INITIALLY DEFERRED FOR EACH ROW EXECUTE FUNCTION {schema}.log_trigger()",
+		},
+		SQLState: SQLStateFeatureNotSupported,
+		Log:      []string{},
+		Rows:     []string{"1\tv1", "2\tv2", "3\tv3"},
+	},
+}
+
+// RunTrigger runs tr against the database of pool, in a schema of its own
+// that is dropped when the test completes.
+func RunTrigger(t *testing.T, ctx context.Context, pool *pgxpool.Pool, tr Trigger) {
+	t.Helper()
+
+	name := uniqueIdent(t, "g")
+	CreateSchema(t, ctx, pool, name)
+	schema := pgx.Identifier{name}.Sanitize()
+	inSchema := func(sql string) string {
+		return strings.ReplaceAll(sql, "{schema}", schema)
+	}
+
+	if _, err := pool.Exec(ctx, inSchema(triggerSchema), pgx.QueryExecModeSimpleProtocol); err != nil {
+		t.Fatalf("unable to create the table and trigger functions: %s", err)
+	}
+
+	for i, sql := range tr.Triggers {
+		_, err := pool.Exec(ctx, inSchema(sql))
+		if i == len(tr.Triggers)-1 && tr.SQLState != "" {
+			if err == nil {
+				t.Fatalf("%s should fail with SQLSTATE %s", sql, tr.SQLState)
+			}
+			AssertSQLState(t, err, tr.SQLState)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unable to create the trigger with %s: %s", sql, err)
+		}
+	}
+
+	for _, sql := range tr.Statements {
+		if _, err := pool.Exec(ctx, inSchema(sql)); err != nil {
+			t.Fatalf("unable to run %s: %s", sql, err)
+		}
+	}
+
+	withConn(t, ctx, pool, func(conn *pgx.Conn) {
+		rows, err := conn.Query(ctx, inSchema("SELECT event FROM {schema}.trigger_log ORDER BY seq"))
+		if err != nil {
+			t.Fatalf("unable to query the trigger log: %s", err)
+		}
+		log, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			t.Fatalf("unable to query the trigger log: %s", err)
+		}
+		if want, got := strings.Join(tr.Log, "\n"), strings.Join(log, "\n"); want != got {
+			t.Errorf("triggers should fire in order:\nwant:\n%s\ngot:\n%s", want, got)
+		}
+
+		if diff := diffRows(tr.Rows, queryTextRows(t, ctx, conn, inSchema("SELECT id, val FROM {schema}.trigger_columnar"))); diff != "" {
+			t.Errorf("trigger_columnar should have the rows changed by the triggers: %s", diff)
+		}
+	})
+}
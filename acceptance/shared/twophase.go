@@ -0,0 +1,148 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A PreparedWrite is a transaction writing into the columnar table of
+// [RunTwoPhaseCommit], with %[1]s the table, that is prepared with PREPARE
+// TRANSACTION and then committed or rolled back after a restart. At most one
+// of them may delete or update rows, as columnar tables lock the whole table
+// for those until the transaction ends.
+type PreparedWrite struct {
+	Name   string
+	SQL    string
+	Commit bool // whether the transaction is committed rather than rolled back
+}
+
+// PreparedWrites are the transactions covered by [RunTwoPhaseCommit], of the
+// table of ids 1 to 1000 and val md5(id).
+var PreparedWrites = []PreparedWrite{
+	{
+		Name:   "insert",
+		SQL:    "INSERT INTO %[1]s SELECT i, md5(i::text) FROM generate_series(1001, 2000) i",
+		Commit: true,
+	},
+	{
+		Name:   "delete and update",
+		SQL:    "DELETE FROM %[1]s WHERE id <= 100; UPDATE %[1]s SET val = upper(val) WHERE id %% 7 = 0",
+		Commit: true,
+	},
+	{
+		Name: "rolled back insert",
+		SQL:  "INSERT INTO %[1]s SELECT i, md5(i::text) FROM generate_series(2001, 3000) i",
+	},
+}
+
+// twoPhaseRows are the rows of the table of [RunTwoPhaseCommit] once the
+// [PreparedWrites] are committed or rolled back.
+const twoPhaseRows = `
+SELECT i AS id, CASE WHEN i <= 1000 AND i % 7 = 0 THEN upper(md5(i::text)) ELSE md5(i::text) END AS val
+FROM generate_series(101, 2000) i`
+
+// RunTwoPhaseCommit prepares the [PreparedWrites] on a columnar table of its
+// own, which must not be visible until they are finished, and restarts the
+// container name, which must run with max_prepared_transactions. The
+// transactions must be prepared still after the restart, and once committed
+// or rolled back, the table must have the rows of those committed only, with
+// consistent metadata.
+func RunTwoPhaseCommit(t *testing.T, ctx context.Context, rt ContainerRuntime, pool *pgxpool.Pool, name string) {
+	t.Helper()
+
+	var maxPrepared int
+	if err := pool.QueryRow(ctx, "SELECT current_setting('max_prepared_transactions')::int").Scan(&maxPrepared); err != nil {
+		t.Fatalf("unable to query max_prepared_transactions: %s", err)
+	}
+	if maxPrepared < len(PreparedWrites) {
+		t.Fatalf("max_prepared_transactions should be at least %d, got %d", len(PreparedWrites), maxPrepared)
+	}
+
+	table := uniqueTable(t, ctx, pool, "p", `
+CREATE TABLE %[1]s (id INT8, val TEXT) USING columnar;
+INSERT INTO %[1]s SELECT i, md5(i::text) FROM generate_series(1, 1000) i;
+		`, nil)
+
+	// the transactions left prepared by a failed test hold the locks of the
+	// table, and are rolled back before it is dropped
+	prefix := UniqueName(t, "2pc")
+	t.Cleanup(func() {
+		rows, err := pool.Query(context.Background(), "SELECT gid FROM pg_prepared_xacts WHERE gid LIKE $1 || '%'", prefix)
+		if err != nil {
+			t.Errorf("unable to query the prepared transactions: %s", err)
+			return
+		}
+		gids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			t.Errorf("unable to query the prepared transactions: %s", err)
+			return
+		}
+		for _, gid := range gids {
+			if _, err := pool.Exec(context.Background(), fmt.Sprintf("ROLLBACK PREPARED '%s'", gid)); err != nil {
+				t.Errorf("unable to roll back %s: %s", gid, err)
+			}
+		}
+	})
+	gid := func(w PreparedWrite) string {
+		return prefix + "-" + strings.ReplaceAll(w.Name, " ", "-")
+	}
+
+	for _, w := range PreparedWrites {
+		sql := fmt.Sprintf("BEGIN; %s; PREPARE TRANSACTION '%s'", fmt.Sprintf(w.SQL, table), gid(w))
+		if _, err := pool.Exec(ctx, sql, pgx.QueryExecModeSimpleProtocol); err != nil {
+			t.Fatalf("unable to prepare %s: %s", w.Name, err)
+		}
+	}
+
+	checkPrepared := func(when string) {
+		t.Helper()
+
+		var prepared int
+		if err := pool.QueryRow(ctx, "SELECT count(*) FROM pg_prepared_xacts WHERE gid LIKE $1 || '%'", prefix).Scan(&prepared); err != nil {
+			t.Fatalf("unable to query the prepared transactions: %s", err)
+		}
+		if prepared != len(PreparedWrites) {
+			t.Errorf("%d transactions should be prepared %s, got %d", len(PreparedWrites), when, prepared)
+		}
+	}
+	checkPrepared("before the restart")
+
+	// the prepared writes are not visible, before and after the restart
+	RestartContainer(t, ctx, rt, pool, name, TimeoutsFor(t).Startup,
+		RowCountCheck(table),
+		ChecksumCheck(table, "id"),
+	)
+	checkPrepared("after the restart")
+
+	var count int64
+	if err := pool.QueryRow(ctx, "SELECT count(*) FROM "+table).Scan(&count); err != nil {
+		t.Fatalf("unable to count the rows of %s: %s", table, err)
+	}
+	if count != 1000 {
+		t.Errorf("%s should only have the committed rows while the writes are prepared, got %d rows", table, count)
+	}
+
+	for _, w := range PreparedWrites {
+		finish := "ROLLBACK PREPARED"
+		if w.Commit {
+			finish = "COMMIT PREPARED"
+		}
+		if _, err := pool.Exec(ctx, fmt.Sprintf("%s '%s'", finish, gid(w))); err != nil {
+			t.Fatalf("unable to finish %s with %s: %s", w.Name, finish, err)
+		}
+	}
+
+	withConn(t, ctx, pool, func(conn *pgx.Conn) {
+		want := queryTextRows(t, ctx, conn, twoPhaseRows)
+		if diff := diffRows(want, queryTextRows(t, ctx, conn, "SELECT id, val FROM "+table)); diff != "" {
+			t.Errorf("%s should have the rows of the committed writes only: %s", table, diff)
+		}
+	})
+
+	CheckColumnarMetadata(t, ctx, pool, table)
+}
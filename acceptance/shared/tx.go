@@ -0,0 +1,32 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// WithTx runs fn in a transaction on pool that is always rolled back, even if
+// fn fails the test, so that sub-tests can modify a shared fixture without
+// affecting each other. DDL is transactional in Postgres, so tables created by
+// fn are rolled back as well. fn must not commit the transaction.
+func WithTx(t *testing.T, ctx context.Context, pool *pgxpool.Pool, fn func(t *testing.T, tx pgx.Tx)) {
+	t.Helper()
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("unable to begin transaction: %s", err)
+	}
+
+	defer func() {
+		// the context may be done if fn failed the test
+		if err := tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			t.Errorf("unable to roll back transaction: %s", err)
+		}
+	}()
+
+	fn(t, tx)
+}
@@ -0,0 +1,144 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A Reclamation checks that space of a columnar table is reclaimed after
+// churn. The table is loaded with the columnar dataset, after which Churn runs,
+// then Reclaim must reduce pg_relation_size of the table, to at most MaxRatio
+// of its size if set, while the rows of the dataset that match Remaining are
+// left intact.
+type Reclamation struct {
+	Name      string
+	Settings  map[string]string // settings of the transaction creating the table, e.g. columnar.stripe_row_limit
+	Churn     []string          // statements run after loading, with %[1]s the table
+	Reclaim   []string          // statements reclaiming space, with %[1]s the table
+	Remaining string            // condition of the rows of the dataset left by Churn, every row if empty
+	MaxRatio  float64           // size after Reclaim relative to the size before it, at most, e.g. 0.2 to catch space leaks
+	Tags      []string          // tags of the case in addition to TagColumnar, see Case
+}
+
+// Case returns r as a [Case] that runs it with [RunReclamation].
+func (r Reclamation) Case() Case {
+	return Case{
+		Name: r.Name,
+		Tags: append([]string{TagColumnar}, r.Tags...),
+		Run: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+			RunReclamation(t, ctx, pool, r)
+		},
+	}
+}
+
+// VacuumCases returns the [Reclamations] as cases.
+func VacuumCases() []Case {
+	return casesOf(Reclamations...)
+}
+
+// Reclamations are the reclamations covered by the [VacuumCases].
+var Reclamations = []Reclamation{
+	{
+		// VACUUM only combines and truncates the stripes at the end of the
+		// table
+		Name:      "vacuum truncates deleted tail stripes",
+		Settings:  map[string]string{"columnar.stripe_row_limit": "10000"},
+		Churn:     []string{"DELETE FROM %[1]s WHERE id > 50000"},
+		Reclaim:   []string{"VACUUM %[1]s"},
+		Remaining: "id <= 50000",
+	},
+	{
+		// 9 of the 10 stripes are deleted, so that little more than the pages
+		// of the remaining stripe are left
+		Name:      "vacuum truncates mass deleted tail stripes",
+		Settings:  map[string]string{"columnar.stripe_row_limit": "10000"},
+		Churn:     []string{"DELETE FROM %[1]s WHERE id > 10000"},
+		Reclaim:   []string{"VACUUM %[1]s"},
+		Remaining: "id <= 10000",
+		MaxRatio:  0.2,
+	},
+	{
+		Name:      "vacuum full after mass delete",
+		Settings:  map[string]string{"columnar.stripe_row_limit": "10000"},
+		Churn:     []string{"DELETE FROM %[1]s WHERE id %% 10 <> 0"},
+		Reclaim:   []string{"VACUUM FULL %[1]s"},
+		Remaining: "id % 10 = 0",
+		MaxRatio:  0.2,
+	},
+	{
+		Name:     "vacuum full after churn",
+		Settings: map[string]string{"columnar.stripe_row_limit": "10000"},
+		Churn: []string{
+			// every update writes the rows anew and deletes the old ones
+			"UPDATE %[1]s SET grp = grp",
+			"UPDATE %[1]s SET grp = grp",
+			"UPDATE %[1]s SET grp = grp",
+			"DELETE FROM %[1]s WHERE id %% 2 = 0",
+		},
+		Reclaim:   []string{"VACUUM FULL %[1]s"},
+		Remaining: "id % 2 = 1",
+		Tags:      []string{TagSlow},
+	},
+	{
+		Name:      "columnar.vacuum combines stripes with deleted rows",
+		Settings:  map[string]string{"columnar.stripe_row_limit": "10000"},
+		Churn:     []string{"DELETE FROM %[1]s WHERE id %% 5 <> 0"},
+		Reclaim:   []string{"SELECT columnar.vacuum('%[1]s')"},
+		Remaining: "id % 5 = 0",
+	},
+	{
+		Name:     "recompression with vacuum full",
+		Settings: map[string]string{"columnar.compression": "none"},
+		Churn:    []string{"SELECT columnar.alter_columnar_table_set('%[1]s', compression => 'zstd')"},
+		Reclaim:  []string{"VACUUM FULL %[1]s"},
+	},
+}
+
+// RunReclamation runs r against the database of pool, with a table of its own
+// that is dropped when the test completes.
+func RunReclamation(t *testing.T, ctx context.Context, pool *pgxpool.Pool, r Reclamation) {
+	t.Helper()
+
+	table := uniqueTable(t, ctx, pool, "r", createColumnar, r.Settings)
+
+	for _, sql := range r.Churn {
+		if _, err := pool.Exec(ctx, fmt.Sprintf(sql, table)); err != nil {
+			t.Fatalf("unable to run %s: %s", fmt.Sprintf(sql, table), err)
+		}
+	}
+
+	before := relationSize(t, ctx, pool, table)
+	for _, sql := range r.Reclaim {
+		if _, err := pool.Exec(ctx, fmt.Sprintf(sql, table)); err != nil {
+			t.Fatalf("unable to run %s: %s", fmt.Sprintf(sql, table), err)
+		}
+	}
+	after := relationSize(t, ctx, pool, table)
+
+	if after >= before {
+		t.Errorf("%s should reclaim space: before=%d bytes after=%d bytes", strings.Join(r.Reclaim, "; "), before, after)
+	}
+	if r.MaxRatio > 0 && float64(after) > r.MaxRatio*float64(before) {
+		t.Errorf("%s should shrink the table to at most %.0f%% of its size: before=%d bytes after=%d bytes", strings.Join(r.Reclaim, "; "), r.MaxRatio*100, before, after)
+	}
+	t.Logf("reclaimed %d of %d bytes", before-after, before)
+
+	checkDataset(t, ctx, pool, table, r.Remaining)
+}
+
+// relationSize returns pg_relation_size of table, the size of its data without
+// the columnar metadata.
+func relationSize(t *testing.T, ctx context.Context, pool *pgxpool.Pool, table string) int64 {
+	t.Helper()
+
+	var size int64
+	if err := pool.QueryRow(ctx, "SELECT pg_relation_size($1::text::regclass)", table).Scan(&size); err != nil {
+		t.Fatalf("unable to query the size of %s: %s", table, err)
+	}
+
+	return size
+}
@@ -0,0 +1,34 @@
+package shared
+
+import (
+	"context"
+	"testing"
+)
+
+// CreateVolume creates the named volume and removes it when the test
+// completes. Pass it as [ContainerOptions.DataVolume] to keep the Postgres data
+// directory across containers.
+func CreateVolume(t *testing.T, ctx context.Context, rt ContainerRuntime, name string) {
+	t.Helper()
+
+	args := append([]string{"volume", "create"}, labelArgs()...)
+	if output, err := rt.Command(ctx, append(args, name)...).CombinedOutput(); err != nil {
+		t.Fatalf("unable to create volume %s: %s: %s", name, err, output)
+	}
+
+	t.Cleanup(func() {
+		// the test context may already be done during cleanup, and the volume
+		// may have been removed already
+		_ = rt.Command(context.Background(), "volume", "rm", "--force", name).Run()
+	})
+}
+
+// RemoveVolume removes the named volume. The volume must not be in use by any
+// container.
+func RemoveVolume(t *testing.T, ctx context.Context, rt ContainerRuntime, name string) {
+	t.Helper()
+
+	if output, err := rt.Command(ctx, "volume", "rm", name).CombinedOutput(); err != nil {
+		t.Fatalf("unable to remove volume %s: %s: %s", name, err, output)
+	}
+}
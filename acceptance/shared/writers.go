@@ -0,0 +1,205 @@
+package shared
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Methods of the writers of a [ConcurrentWrite].
+const (
+	WriteInsert = "insert" // INSERT of a batch of rows with generate_series
+	WriteCopy   = "copy"   // COPY of a batch of rows with the binary protocol
+)
+
+// A ConcurrentWrite describes writers loading rows into the same columnar table
+// at the same time, each from a connection of its own. Writer w loads Batches
+// batches of BatchRows rows, each batch in a transaction of its own, with
+// Methods[w % len(Methods)]. Once every writer completed, the rows of the
+// table must match the rows the writers loaded column by column, and the
+// stripes of the table must account for every row.
+type ConcurrentWrite struct {
+	Name      string
+	Writers   int
+	Batches   int
+	BatchRows int
+	Methods   []string          // WriteInsert or WriteCopy
+	Settings  map[string]string // settings of the transaction creating the table, e.g. columnar.stripe_row_limit
+	Tags      []string          // tags of the case in addition to TagColumnar, see Case
+}
+
+// Case returns w as a [Case] that runs it with [RunConcurrentWrite].
+func (w ConcurrentWrite) Case() Case {
+	return Case{
+		Name: w.Name,
+		Tags: append([]string{TagColumnar}, w.Tags...),
+		Run: func(t *testing.T, ctx context.Context, pool *pgxpool.Pool) {
+			RunConcurrentWrite(t, ctx, pool, w)
+		},
+	}
+}
+
+// ConcurrentWriteCases returns the [ConcurrentWrites] as cases.
+func ConcurrentWriteCases() []Case {
+	return casesOf(ConcurrentWrites...)
+}
+
+// ConcurrentWrites are the concurrent writes covered by the
+// [ConcurrentWriteCases].
+var ConcurrentWrites = []ConcurrentWrite{
+	{
+		Name:      "concurrent inserts into columnar table",
+		Writers:   16,
+		Batches:   10,
+		BatchRows: 1000,
+		Methods:   []string{WriteInsert},
+	},
+	{
+		Name:      "concurrent copies into columnar table",
+		Writers:   16,
+		Batches:   10,
+		BatchRows: 1000,
+		Methods:   []string{WriteCopy},
+	},
+	{
+		// batches span stripes, so that writers flush stripes at the same time
+		Name:      "concurrent inserts and copies into columnar table with small stripes",
+		Writers:   8,
+		Batches:   5,
+		BatchRows: 2500,
+		Methods:   []string{WriteInsert, WriteCopy},
+		Settings: map[string]string{
+			"columnar.stripe_row_limit":      "1000",
+			"columnar.chunk_group_row_limit": "1000",
+		},
+		Tags: []string{TagSlow},
+	},
+}
+
+// concurrentRow returns the expressions of the columns of the row seq of
+// writer in SQL with writer and seq the expressions of their values. Writers
+// that COPY compute the same columns in Go, see concurrentValues.
+func concurrentRow(writer, seq string) string {
+	return fmt.Sprintf("%[1]s, %[2]s, md5(%[1]s || ':' || %[2]s), %[1]s::int8 * 1000000 + %[2]s", writer, seq)
+}
+
+// concurrentValues returns the values of the columns of the row seq of writer,
+// see concurrentRow.
+func concurrentValues(writer, seq int) []any {
+	sum := md5.Sum([]byte(fmt.Sprintf("%d:%d", writer, seq)))
+	return []any{int32(writer), int32(seq), hex.EncodeToString(sum[:]), int64(writer)*1000000 + int64(seq)}
+}
+
+// RunConcurrentWrite runs w against the database of pool, with a table of its
+// own that is dropped when the test completes. The writers connect with a pool
+// of their own, with the configuration of pool, so that they are not limited
+// by its size.
+func RunConcurrentWrite(t *testing.T, ctx context.Context, pool *pgxpool.Pool, w ConcurrentWrite) {
+	t.Helper()
+
+	table := uniqueTable(t, ctx, pool, "w", "CREATE TABLE %s (writer INT4, seq INT4, val TEXT, big INT8) USING columnar", w.Settings)
+
+	config := pool.Config()
+	config.MaxConns = int32(w.Writers)
+	writers, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		t.Fatalf("unable to create the pool of the writers: %s", err)
+	}
+	defer writers.Close()
+
+	// the writers start together once each is connected
+	start := make(chan struct{})
+	errs := make(chan error, w.Writers)
+	var ready sync.WaitGroup
+	for writer := 0; writer < w.Writers; writer++ {
+		ready.Add(1)
+		go func(writer int) {
+			conn, err := writers.Acquire(ctx)
+			ready.Done()
+			if err != nil {
+				errs <- fmt.Errorf("writer %d unable to connect: %w", writer, err)
+				return
+			}
+			defer conn.Release()
+
+			<-start
+			errs <- writeBatches(ctx, conn, table, w, writer)
+		}(writer)
+	}
+	ready.Wait()
+	close(start)
+
+	for writer := 0; writer < w.Writers; writer++ {
+		if err := <-errs; err != nil {
+			t.Errorf("writer failed: %s", err)
+		}
+	}
+	if t.Failed() {
+		return
+	}
+
+	rows := w.Batches * w.BatchRows
+	expected := fmt.Sprintf("(SELECT %s FROM generate_series(0, %d) w, generate_series(1, %d) s) expected (writer, seq, val, big)", concurrentRow("w", "s"), w.Writers-1, rows)
+	const checksums = `
+SELECT count(*),
+       count(DISTINCT (writer, seq)),
+       coalesce(sum(writer), 0),
+       coalesce(sum(seq), 0),
+       coalesce(md5(string_agg(val, ',' ORDER BY writer, seq)), ''),
+       coalesce(sum(big), 0)
+FROM %s`
+	var want, got [6]any
+	for from, dest := range map[string]*[6]any{expected: &want, table: &got} {
+		var count, distinct, writer, seq, big int64
+		var val string
+		if err := pool.QueryRow(ctx, fmt.Sprintf(checksums, from)).Scan(&count, &distinct, &writer, &seq, &val, &big); err != nil {
+			t.Fatalf("unable to checksum %s: %s", from, err)
+		}
+		*dest = [6]any{count, distinct, writer, seq, val, big}
+	}
+	for i, column := range []string{"row count", "distinct rows", "writer", "seq", "val", "big"} {
+		if want[i] != got[i] {
+			t.Errorf("%s of %s should match: want=%v got=%v", column, table, want[i], got[i])
+		}
+	}
+
+	var stripeRows int64
+	if err := pool.QueryRow(ctx, "SELECT coalesce(sum(rowcount), 0) FROM columnar.stats($1::text::regclass)", table).Scan(&stripeRows); err != nil {
+		t.Fatalf("unable to query the stripes of %s: %s", table, err)
+	}
+	if want := int64(w.Writers * rows); stripeRows != want {
+		t.Errorf("stripes of %s should account for every row: want=%d got=%d", table, want, stripeRows)
+	}
+}
+
+// writeBatches loads the batches of writer into table on conn.
+func writeBatches(ctx context.Context, conn *pgxpool.Conn, table string, w ConcurrentWrite, writer int) error {
+	method := w.Methods[writer%len(w.Methods)]
+	for batch := 0; batch < w.Batches; batch++ {
+		first := batch*w.BatchRows + 1
+
+		var err error
+		switch method {
+		case WriteInsert:
+			sql := fmt.Sprintf("INSERT INTO %s SELECT %s FROM generate_series($2::int4, $3::int4) s", pgx.Identifier{table}.Sanitize(), concurrentRow("$1::int4", "s"))
+			_, err = conn.Exec(ctx, sql, writer, first, first+w.BatchRows-1)
+		case WriteCopy:
+			_, err = conn.CopyFrom(ctx, pgx.Identifier{table}, []string{"writer", "seq", "val", "big"}, pgx.CopyFromSlice(w.BatchRows, func(i int) ([]any, error) {
+				return concurrentValues(writer, first+i), nil
+			}))
+		default:
+			return fmt.Errorf("unknown method %q of writer %d", method, writer)
+		}
+		if err != nil {
+			return fmt.Errorf("writer %d unable to %s batch %d: %w", writer, method, batch, err)
+		}
+	}
+
+	return nil
+}
@@ -3,61 +3,68 @@ package spilo_test
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
+	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
-	"text/template"
 	"time"
 
 	"github.com/hydradatabase/hydra/acceptance/shared"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/joeshaw/envdecode"
-	"github.com/rs/xid"
 )
 
 type dockerComposeData struct {
 	Image               string
-	PostgresVersion     string
 	PostgresUser        string
-	PostgresPassword    string
 	PostgresPort        int
 	ReadinessPort       int
 	MySQLFixtureSQLPath string
 	StartEverything     bool
+	Labels              map[string]string
+	ResourceLabels      map[string]string
+	Environment         map[string]string
+	Options             shared.ContainerOptions
 }
 
 var (
-	dockerComposeTmpl = template.Must(template.New("docker-compose.yml").Parse(`
+	dockerComposeTmpl = shared.NewComposeTemplate("docker-compose.yml", `
 version: "3.9"
 services:
   hydra:
     image: {{ .Image }}
+    {{- template "labels" .Labels }}
+    {{- template "containerOptions" .Options }}
     {{- if .StartEverything }}
     depends_on:
       mysql:
         condition: service_healthy
     {{- end }}
-    environment:
-      PGUSER_SUPERUSER: {{ .PostgresUser }}
-      PGPASSWORD_SUPERUSER: {{ .PostgresPassword }}
-      PGVERSION: {{ .PostgresVersion }}
-      PGROOT: /home/postgres/pgroot
-      PGDATA: /home/postgres/pgroot/pgdata
+    {{- template "environment" .Environment }}
     ports:
       - "{{ .PostgresPort }}:5432"
       - "{{ .ReadinessPort }}:8008"
+    {{- if .Options.DataTmpfs }}
+    tmpfs:
+      - /home/postgres/pgroot/pgdata:size={{ .Options.DataTmpfs }}
+    {{- else }}
     volumes:
       - pg_data:/home/postgres/pgroot/pgdata
+    {{- end }}
+    healthcheck:
+      test: ["CMD", "pg_isready", "-h", "127.0.0.1", "-U", "{{ .PostgresUser }}"]
+      interval: 5s
+      timeout: 5s
+      retries: 12
 {{- if .StartEverything }}
   mysql:
     image: mysql:8.0.31
+    {{- template "labels" .ResourceLabels }}
     environment:
       MYSQL_USER: mysql
       MYSQL_PASSWORD: mysql
@@ -67,7 +74,7 @@ services:
       - {{ .MySQLFixtureSQLPath }}:/docker-entrypoint-initdb.d/mysql.sql
     {{- end}}
     ports:
-      - "3306:3306"
+      - "3306"
     healthcheck:
       test: ["CMD", "mysqladmin" ,"ping", "-h", "localhost"]
       timeout: 10s
@@ -75,48 +82,199 @@ services:
 {{- end }}
 volumes:
   pg_data:
-`))
+    {{- if .Options.DataVolume }}
+    {{- template "dataVolume" .Options }}
+    {{- else }}
+    {{- template "labels" .ResourceLabels }}
+    {{- end }}
+networks:
+  default:
+    {{- template "labels" .ResourceLabels }}
+`)
 )
 
 type Config struct {
-	Image                string        `env:"SPILO_IMAGE,required"`
-	UpgradeFromImage     string        `env:"SPILO_UPGRADE_FROM_IMAGE,required"`
-	ArtifactDir          string        `env:"ARTIFACT_DIR,default="`
-	WaitForStartTimeout  time.Duration `env:"WAIT_FOR_START_TIMEOUT,default=60s"`
-	WaitForStartInterval time.Duration `env:"WAIT_FOR_START_INTERVAL,default=5s"`
-	PostgresVersion      string        `env:"SPILO_POSTGRES_VERSION,default=13"`
-	PostgresPort         int           `env:"POSTGRES_PORT,default=5432"`
-	ReadinessPort        int           `env:"READINESS_PORT,default=8008"`
+	shared.EnvConfig
+
+	Image                  string        `env:"SPILO_IMAGE,required"`
+	UpgradeFromImage       string        `env:"SPILO_UPGRADE_FROM_IMAGE,required"`
+	WaitForStartTimeout    time.Duration `env:"WAIT_FOR_START_TIMEOUT,default=60s"`
+	WaitForStartInterval   time.Duration `env:"WAIT_FOR_START_INTERVAL,default=5s"`
+	PostgresVersion        string        `env:"SPILO_POSTGRES_VERSION,default=13"`
+	PostgresHost           string        `env:"POSTGRES_HOST,default="`
+	ReadinessPort          int           `env:"READINESS_PORT,default=0"`
+	ContainerRuntime       string        `env:"CONTAINER_RUNTIME,default=docker"`
+	ReuseContainers        bool          `env:"REUSE_CONTAINERS,default=false"`
+	StopTimeout            time.Duration `env:"STOP_TIMEOUT,default=30s"`
+	VerifyCleanShutdown    bool          `env:"VERIFY_CLEAN_SHUTDOWN,default=false"`
+	StreamLogs             bool          `env:"STREAM_LOGS,default=false"`
+	StatsInterval          time.Duration `env:"STATS_INTERVAL,default=0s"`
+	DataTmpfs              string        `env:"DATA_TMPFS,default="`
+	Platform               string        `env:"PLATFORM,default="`
+	PullImages             bool          `env:"PULL_IMAGES,default=false"`
+	ImageDigest            string        `env:"SPILO_IMAGE_DIGEST,default="`
+	UpgradeFromImageDigest string        `env:"SPILO_UPGRADE_FROM_IMAGE_DIGEST,default="`
+	BuildDir               string        `env:"SPILO_BUILD_DIR,default="`
+	BuildArgs              []string      `env:"SPILO_BUILD_ARGS,default="`
+	Dockerfile             string        `env:"SPILO_DOCKERFILE,default="`
+}
+
+// Validate reports every setting of c that is invalid.
+func (c Config) Validate() error {
+	return errors.Join(
+		c.EnvConfig.Validate(),
+		shared.ValidateImage("SPILO_IMAGE", c.Image),
+		shared.ValidateImage("SPILO_UPGRADE_FROM_IMAGE", c.UpgradeFromImage),
+		shared.ValidateTimeout("WAIT_FOR_START_TIMEOUT", c.WaitForStartTimeout),
+		shared.ValidateTimeout("WAIT_FOR_START_INTERVAL", c.WaitForStartInterval),
+		shared.ValidateTimeout("STOP_TIMEOUT", c.StopTimeout),
+		shared.ValidatePort("READINESS_PORT", c.ReadinessPort),
+		shared.ValidateContainerRuntime("CONTAINER_RUNTIME", c.ContainerRuntime),
+	)
 }
 
-var config Config
+// with returns c with the images and Postgres version of sc, if set.
+func (c Config) with(sc shared.SuiteConfig) Config {
+	if sc.Image != "" {
+		c.Image = sc.Image
+	}
+	if sc.UpgradeFromImage != "" {
+		c.UpgradeFromImage = sc.UpgradeFromImage
+	}
+	if sc.PostgresVersion != "" {
+		c.PostgresVersion = sc.PostgresVersion
+	}
+
+	return c
+}
+
+var (
+	config           Config
+	containerRuntime shared.ContainerRuntime
+	suiteFile        *shared.SuiteFile
+)
 
 const (
 	pgusername = "postgres"
 	pgpassword = "hydra"
 )
 
+// allocatePort returns port, or a free port if port is 0.
+func allocatePort(t *testing.T, port int) int {
+	if port == 0 {
+		return shared.FreePort(t)
+	}
+
+	return port
+}
+
 func TestMain(m *testing.M) {
-	if err := envdecode.StrictDecode(&config); err != nil {
+	if err := shared.ConfigFromEnv(&config); err != nil {
+		log.Fatal(err)
+	}
+
+	level, err := shared.ParseLogLevel(config.LogLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	shared.SetLogLevel(level)
+
+	modes, err := shared.ParseQueryExecModes(config.QueryExecModes)
+	if err != nil {
 		log.Fatal(err)
 	}
+	shared.QueryExecModes = modes
 
-	shared.MustHaveValidArtifactDir(config.ArtifactDir)
+	tags, err := shared.ParseTagFilter(config.CaseTags)
+	if err != nil {
+		log.Fatal(err)
+	}
+	shared.CaseTagFilter = tags
+
+	selected, err := shared.ParseCaseSelection(config.OnlyCases, config.SkipCases)
+	if err != nil {
+		log.Fatal(err)
+	}
+	shared.SelectedCases = selected
+
+	shared.InitSeed(config.Seed)
+	shared.InitRunID(config.RunID)
+	shared.InitRunContext(config.RunTimeout)
+	shared.SetTimeouts(shared.Timeouts{
+		Startup:  config.WaitForStartTimeout,
+		Connect:  config.ConnectTimeout,
+		Query:    config.QueryTimeout,
+		Shutdown: config.StopTimeout,
+		LogFetch: config.LogFetchTimeout,
+	})
+
+	if config.SuiteFile != "" {
+		if suiteFile, err = shared.LoadSuiteFile(config.SuiteFile); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	os.Exit(m.Run())
+	rt, err := shared.NewContainerRuntime(config.ContainerRuntime)
+	if err != nil {
+		log.Fatal(err)
+	}
+	containerRuntime = rt
+
+	// nothing is started in a dry run, so there is nothing to collect
+	if config.DryRun {
+		shared.DryRun = true
+		containerRuntime = shared.NewDryRunRuntime(rt)
+		os.Exit(m.Run())
+	}
+
+	// reused projects are left running on purpose, so they must not be
+	// collected
+	if config.ReuseContainers {
+		os.Exit(m.Run())
+	}
+
+	os.Exit(shared.RunWithGC(m, containerRuntime))
 }
 
 type spiloAcceptanceCompose struct {
-	config Config
-
-	project string
-	pool    *pgxpool.Pool
+	config   Config
+	options  shared.ContainerOptions
+	settings map[string]string
+
+	project       string
+	port          int
+	readinessPort int
+	pool          *pgxpool.Pool
+	appPool       *pgxpool.Pool
 }
 
 func (c *spiloAcceptanceCompose) StartCompose(t *testing.T, ctx context.Context, img string, startEverything bool) {
-	// Only set the project on first start
+	// Only set the project and ports on first start
 	if c.project == "" {
-		c.project = fmt.Sprintf("spilo-%s", xid.New())
+		c.project = shared.UniqueName(t, "spilo")
+		c.port = allocatePort(t, c.config.PostgresPort)
+		c.readinessPort = allocatePort(t, c.config.ReadinessPort)
+	}
+
+	options := c.options
+	if options.Platform == "" {
+		options.Platform = c.config.Platform
+	}
+	if shared.IsEmulated(t, ctx, containerRuntime, options.Platform) {
+		t.Logf("Running %s images under emulation, expect it to be slow", options.Platform)
+	}
+
+	// the image under test is built from source if a build directory is
+	// configured, e.g. the repository root
+	if c.config.BuildDir != "" && img == c.config.Image {
+		shared.BuildImage(t, ctx, containerRuntime, c.config.BuildDir, img, shared.BuildOptions{
+			Dockerfile: c.config.Dockerfile,
+			Platform:   options.Platform,
+			BuildArgs:  c.config.BuildArgs,
+			LogDir:     string(c.config.ArtifactDir),
+		})
+	} else if c.config.PullImages {
+		shared.PullImage(t, ctx, containerRuntime, img, options.Platform, c.expectedDigest(img))
 	}
 
 	pwd, err := os.Getwd()
@@ -124,22 +282,57 @@ func (c *spiloAcceptanceCompose) StartCompose(t *testing.T, ctx context.Context,
 		t.Fatal(err)
 	}
 
-	dockerCompose := bytes.NewBuffer(nil)
-	if err := dockerComposeTmpl.Execute(dockerCompose, dockerComposeData{
+	data := dockerComposeData{
 		Image:               img,
-		PostgresVersion:     c.config.PostgresVersion,
 		PostgresUser:        pgusername,
-		PostgresPassword:    pgpassword,
-		PostgresPort:        c.config.PostgresPort,
-		ReadinessPort:       c.config.ReadinessPort,
+		PostgresPort:        c.port,
+		ReadinessPort:       c.readinessPort,
 		StartEverything:     startEverything,
 		MySQLFixtureSQLPath: filepath.Join(pwd, "..", "fixtures", "mysql.sql"),
-	}); err != nil {
+		Options:             options,
+		Environment: options.Environment(map[string]string{
+			"PGUSER_SUPERUSER":     pgusername,
+			"PGPASSWORD_SUPERUSER": pgpassword,
+			"PGVERSION":            c.config.PostgresVersion,
+			"PGROOT":               "/home/postgres/pgroot",
+			"PGDATA":               "/home/postgres/pgroot/pgdata",
+		}),
+	}
+
+	// ports are allocated per run so they do not identify a reusable project
+	reuseKey := data
+	reuseKey.PostgresPort = 0
+	reuseKey.ReadinessPort = 0
+	data.Labels = shared.ReuseLabels(img, reuseKey)
+
+	if c.config.ReuseContainers {
+		project, err := shared.FindReusableProject(ctx, containerRuntime, data.Labels)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if project != "" {
+			t.Logf("Reusing docker compose %s", project)
+			c.project = project
+			c.port = shared.PublishedPort(t, ctx, containerRuntime, c.hydraContainerID(t, ctx), 5432)
+			c.readinessPort = shared.PublishedPort(t, ctx, containerRuntime, c.hydraContainerID(t, ctx), 8008)
+			c.WaitForContainerReady(t, ctx)
+			return
+		}
+	}
+
+	data.ResourceLabels = shared.ResourceLabels()
+	for k, v := range data.ResourceLabels {
+		data.Labels[k] = v
+	}
+
+	dockerCompose := bytes.NewBuffer(nil)
+	if err := dockerComposeTmpl.Execute(dockerCompose, data); err != nil {
 		t.Fatal(err)
 	}
 
 	// ArtifactDir may be empty, in which case the system tmp directory is used
-	f, err := os.CreateTemp(c.config.ArtifactDir, "docker-compose.yml")
+	f, err := os.CreateTemp(shared.TestArtifactDir(t, string(c.config.ArtifactDir)), "docker-compose.yml")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -151,66 +344,82 @@ func (c *spiloAcceptanceCompose) StartCompose(t *testing.T, ctx context.Context,
 		t.Fatal(err)
 	}
 
-	runCmd := exec.CommandContext(ctx, "docker", "compose", "--project-name", c.project, "--file", f.Name(), "up", "--detach")
-
-	t.Logf("Starting docker compose %s with %s", c.project, f.Name())
-	if o, err := runCmd.CombinedOutput(); err != nil {
-		t.Fatalf("unable to start docker compose %s: %s", err, o)
-		return
-	}
+	c.composeStack(f.Name()).Up(t, ctx)
 
 	c.WaitForContainerReady(t, ctx)
 }
 
 func (c *spiloAcceptanceCompose) WaitForContainerReady(t *testing.T, ctx context.Context) {
-	done := make(chan bool, 1)
-	timeout := time.After(c.config.WaitForStartTimeout)
-	ticker := time.NewTicker(c.config.WaitForStartInterval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-done:
-			return
-		case <-ticker.C:
-			req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("http://127.0.0.1:%d", c.config.ReadinessPort), nil)
-			if err != nil {
-				t.Fatal(err)
-			}
-
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				t.Logf("waiting for spilo to be ready: %s", err)
-				continue
-			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode != 200 {
-				body, err := io.ReadAll(resp.Body)
-				if err != nil {
-					t.Logf("unable to read readiness body: %s", err)
-					continue
-				}
-
-				t.Logf("waiting for spilo to be ready %d: %s", resp.StatusCode, body)
-				continue
-			}
-
-			pool, err := shared.CreatePGPool(t, ctx, pgusername, pgpassword, c.config.PostgresPort)
-			if err != nil {
-				t.Fatalf("unable to create PG Pool: %s", err)
-			}
-
-			c.pool = pool
-			done <- true
-		case <-timeout:
-			t.Fatalf("timed out waiting for container to start after %s", c.config.WaitForStartTimeout)
-		}
+	host, err := c.ConnSpec().HostAddress()
+	if err != nil {
+		t.Fatal(err)
 	}
+
+	readinessURL := fmt.Sprintf("http://%s", net.JoinHostPort(host, strconv.Itoa(c.readinessPort)))
+	shared.WaitForReadiness(t, ctx, shared.HTTPProbe(readinessURL), shared.TimeoutsFor(t).Startup, c.config.WaitForStartInterval)
+
+	pool, err := shared.CreatePGPool(t, ctx, c.ConnSpec().With(shared.WithRetry(shared.RetryPolicy{
+		Deadline:   shared.TimeoutsFor(t).Startup,
+		MaxBackoff: c.config.WaitForStartInterval,
+	})))
+	if err != nil {
+		t.Fatalf("unable to create PG Pool: %s", err)
+	}
+
+	c.pool = shared.PoolWithGUCs(t, ctx, pool, c.settings)
+	c.appPool = shared.CreateAppPool(t, ctx, pool, c.ConnSpec())
 }
 
 func (c spiloAcceptanceCompose) TerminateCompose(t *testing.T, ctx context.Context, kill bool) {
-	shared.TerminateDockerComposeProject(t, ctx, c.project, c.config.ArtifactDir, kill)
+	// leave the project running so that the next run can reuse it
+	if c.config.ReuseContainers && kill {
+		t.Logf("Leaving docker compose %s running for reuse", c.project)
+		return
+	}
+
+	if c.project == "" {
+		return
+	}
+
+	if err := c.composeStack("").Down(t, ctx, shared.WithKill(kill)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c spiloAcceptanceCompose) hydraContainerID(t *testing.T, ctx context.Context) string {
+	id, err := containerRuntime.ContainerID(ctx, c.project, "hydra")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func (c spiloAcceptanceCompose) composeStack(file string) *shared.ComposeStack {
+	stack := shared.NewComposeStack(containerRuntime, c.project, file, "hydra")
+	stack.LogDir = string(c.config.ArtifactDir)
+	stack.CrashCheck = map[string]string{"hydra": "/home/postgres/pgroot/pgdata"}
+	stack.StatsInterval = c.config.StatsInterval
+	if c.config.VerifyCleanShutdown {
+		stack.VerifyShutdown = []string{"hydra"}
+	}
+	if c.config.StreamLogs {
+		stack.StreamLogs = true
+	}
+
+	return stack
+}
+
+// expectedDigest returns the digest configured for img, if any.
+func (c spiloAcceptanceCompose) expectedDigest(img string) string {
+	switch img {
+	case c.config.Image:
+		return c.config.ImageDigest
+	case c.config.UpgradeFromImage:
+		return c.config.UpgradeFromImageDigest
+	default:
+		return ""
+	}
 }
 
 func (c spiloAcceptanceCompose) Image() string {
@@ -225,62 +434,86 @@ func (c spiloAcceptanceCompose) PGPool() *pgxpool.Pool {
 	return c.pool
 }
 
+func (c spiloAcceptanceCompose) AppPool() *pgxpool.Pool {
+	return c.appPool
+}
+
+func (c spiloAcceptanceCompose) ConnSpec() shared.ConnSpec {
+	// the docker host unless configured otherwise
+	return shared.NewConnSpec(pgusername, pgpassword, c.port, shared.WithHost(c.config.PostgresHost))
+}
+
 func Test_SpiloAcceptance(t *testing.T) {
-	shared.RunAcceptanceTests(
-		t,
-		context.Background(),
-		&spiloAcceptanceCompose{config: config},
-		shared.Case{
-			Name: "no timescaledb ext",
-			SQL: `
+	shared.RunConfigurations(t, suiteFile, "spilo", func(t *testing.T, sc shared.SuiteConfig) {
+		cfg := config.with(sc)
+
+		shared.RunAcceptanceTests(
+			t,
+			shared.Context(t),
+			&spiloAcceptanceCompose{
+				config:   cfg,
+				options:  shared.ContainerOptions{DataTmpfs: cfg.DataTmpfs},
+				settings: sc.Settings,
+			},
+			shared.Case{
+				Name: "no timescaledb ext",
+				SQL: `
 SELECT count(1) FROM pg_available_extensions WHERE name = 'timescaledb';
 			`,
-			Validate: func(t *testing.T, row pgx.Row) {
-				var count int
-				if err := row.Scan(&count); err != nil {
-					t.Fatal(err)
-				}
-
-				if want, got := 0, count; want != got {
-					t.Errorf("timescaledb ext should not exist")
-				}
+				Validate: func(t *testing.T, row pgx.Row) {
+					var count int
+					if err := row.Scan(&count); err != nil {
+						t.Fatal(err)
+					}
+
+					if want, got := 0, count; want != got {
+						t.Errorf("timescaledb ext should not exist")
+					}
+				},
 			},
-		},
-		shared.Case{
-			Name: "cron should use worker processes",
-			SQL:  `SHOW cron.use_background_workers;`,
-			Validate: func(t *testing.T, row pgx.Row) {
-				var settingValue string
-				if err := row.Scan(&settingValue); err != nil {
-					t.Fatal(err)
-				}
-
-				if want, got := "on", settingValue; want != got {
-					t.Errorf("cron.use_background_workers not set to 'on'")
-				}
+			shared.Case{
+				Name: "cron should use worker processes",
+				SQL:  `SHOW cron.use_background_workers;`,
+				Validate: func(t *testing.T, row pgx.Row) {
+					var settingValue string
+					if err := row.Scan(&settingValue); err != nil {
+						t.Fatal(err)
+					}
+
+					if want, got := "on", settingValue; want != got {
+						t.Errorf("cron.use_background_workers not set to 'on'")
+					}
+				},
 			},
-		},
-		shared.Case{
-			Name: fmt.Sprintf("spilo started the expected postgres version %s", config.PostgresVersion),
-			SQL:  `SHOW server_version;`,
-			Validate: func(t *testing.T, row pgx.Row) {
-				var version string
-				if err := row.Scan(&version); err != nil {
-					t.Fatal(err)
-				}
-
-				if !strings.HasPrefix(version, config.PostgresVersion) {
-					t.Errorf("incorrect postgres version, got %s, expected major version %s", version, config.PostgresVersion)
-				}
+			shared.Case{
+				Name: fmt.Sprintf("spilo started the expected postgres version %s", cfg.PostgresVersion),
+				SQL:  `SHOW server_version;`,
+				Validate: func(t *testing.T, row pgx.Row) {
+					var version string
+					if err := row.Scan(&version); err != nil {
+						t.Fatal(err)
+					}
+
+					if !strings.HasPrefix(version, cfg.PostgresVersion) {
+						t.Errorf("incorrect postgres version, got %s, expected major version %s", version, cfg.PostgresVersion)
+					}
+				},
 			},
-		},
-	)
+		)
+	})
 }
 
 func Test_SpiloUpgrade(t *testing.T) {
-	c := spiloAcceptanceCompose{
-		config: config,
-	}
+	shared.SkipUnlessTagged(t, shared.TagColumnar, shared.TagDestructive)
+
+	shared.RunConfigurations(t, suiteFile, "spilo", func(t *testing.T, sc shared.SuiteConfig) {
+		cfg := config.with(sc)
+
+		c := spiloAcceptanceCompose{
+			config:   cfg,
+			settings: sc.Settings,
+		}
 
-	shared.RunUpgradeTests(t, context.Background(), &c)
+		shared.RunUpgradeTests(t, shared.Context(t), &c)
+	})
 }